@@ -0,0 +1,104 @@
+// Package anonymize is the stable API for embedding the anonymizer in other
+// Go programs. It anonymizes one DICOM file at a time, deciding between
+// metadata-only and ultrasound processing itself, without the folder
+// walker, mapping file or progress tracking of the command line tool and
+// GUI, so the caller owns patient IDs.
+//
+// The types are aliases of the internal anonymizer package, which other
+// modules cannot import, so their fields and methods are documented there
+// and in the README.
+package anonymize
+
+import (
+	"dicom-anonymizer/internal/anonymizer"
+	"dicom-anonymizer/internal/logging"
+)
+
+// Config configures AnonymizeFile. AnonID is required and written as the
+// PatientID; the folder, mapping and run settings are ignored. Start from
+// DefaultConfig: in a bare Config the KeepSex, KeepInstitutionName and
+// KeepStudyDescription options are false, which clears those tags.
+type Config = anonymizer.Config
+
+// DefaultConfig returns the defaults of the command line tool: private
+// tags and overlays removed, and PatientSex, InstitutionName and
+// StudyDescription kept
+func DefaultConfig() Config {
+	return anonymizer.DefaultConfig()
+}
+
+// Method is how a file was handled by AnonymizeFile
+type Method = anonymizer.Method
+
+const (
+	MethodMetadata          = anonymizer.MethodMetadata          // Metadata anonymized (CT/MRI/X-Ray)
+	MethodUltrasound        = anonymizer.MethodUltrasound        // Metadata anonymized and burned-in pixels redacted
+	MethodMetadataFallback  = anonymizer.MethodMetadataFallback  // Ultrasound with pixels NOT redacted (see Config.AllowMetadataOnlyFallback)
+	MethodSkipped           = anonymizer.MethodSkipped           // ProcessMetadata or ProcessUltrasound is off for this file
+	MethodSkippedModality   = anonymizer.MethodSkippedModality   // Modality not in Config.Modalities
+	MethodSkippedAnonymized = anonymizer.MethodSkippedAnonymized // Already marked PatientIdentityRemoved=YES (see Config.Force)
+	MethodSkippedSize       = anonymizer.MethodSkippedSize       // Larger than Config.MaxFileSize
+)
+
+// DatePolicy controls how date tags are anonymized
+type DatePolicy = anonymizer.DatePolicy
+
+const (
+	DatePolicyTruncateMonth = anonymizer.DatePolicyTruncateMonth // Truncate to YYYYMM01 (default)
+	DatePolicyShiftDays     = anonymizer.DatePolicyShiftDays     // Shift by a stable offset derived from AnonID and Salt
+	DatePolicyRemove        = anonymizer.DatePolicyRemove        // Clear all dates
+)
+
+// RetainOption is a PS3.15 option layered on Config.ConfidentialityProfile
+type RetainOption = anonymizer.RetainOption
+
+const (
+	RetainDates                  = anonymizer.RetainDates
+	RetainUIDs                   = anonymizer.RetainUIDs
+	RetainDevice                 = anonymizer.RetainDevice
+	RetainPatientCharacteristics = anonymizer.RetainPatientCharacteristics
+	RetainInstitution            = anonymizer.RetainInstitution
+	RetainSafePrivate            = anonymizer.RetainSafePrivate
+)
+
+// TagProfile lists the tags to clear, hash and date-handle
+type TagProfile = anonymizer.TagProfile
+
+// DefaultTagProfile returns the profile used when Config.Profile is nil
+func DefaultTagProfile() *TagProfile {
+	return anonymizer.DefaultTagProfile()
+}
+
+// TextDetector finds burned-in text in ultrasound frames (Config.TextDetector)
+type TextDetector = anonymizer.TextDetector
+
+// Logger receives warnings and diagnostics (Config.Logger)
+type Logger = logging.Logger
+
+// FailureCategory groups the errors of AnonymizeFile, see CategoryOf
+type FailureCategory = anonymizer.FailureCategory
+
+const (
+	FailureDcmtkMissing = anonymizer.FailureDcmtkMissing // JPEG-LS file and dcmtk is not installed
+	FailureParse        = anonymizer.FailureParse        // Not readable as DICOM
+	FailureDecompress   = anonymizer.FailureDecompress   // Decompression failed
+	FailureNoPixelData  = anonymizer.FailureNoPixelData  // Ultrasound file without usable pixel data
+	FailureRedaction    = anonymizer.FailureRedaction    // Text detection or pixel redaction failed
+	FailureWrite        = anonymizer.FailureWrite        // Re-compression or writing the output failed
+	FailureOther        = anonymizer.FailureOther        // Anything else
+)
+
+// CategoryOf returns the FailureCategory of an error returned by
+// AnonymizeFile
+func CategoryOf(err error) FailureCategory {
+	return anonymizer.CategoryOf(err)
+}
+
+// AnonymizeFile anonymizes the DICOM file in and writes it to out, which is
+// always overwritten. Files skipped by cfg are not written and return one
+// of the MethodSkipped methods. UIDs and shifted dates are derived from
+// cfg.Salt exactly as a folder run of the command line tool with the same
+// salt derives them.
+func AnonymizeFile(in, out string, cfg Config) (Method, error) {
+	return anonymizer.AnonymizeFile(in, out, cfg)
+}
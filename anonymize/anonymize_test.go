@@ -0,0 +1,65 @@
+package anonymize_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/anonymize"
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestAnonymizeFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.dcm")
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t     tag.Tag
+		value string
+	}{
+		{tag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"},
+		{tag.MediaStorageSOPInstanceUID, "1.2.3.4.5.6"},
+		{tag.TransferSyntaxUID, "1.2.840.10008.1.2.1"},
+		{tag.PatientName, "SMITH^JOHN"},
+		{tag.PatientID, "MRN123"},
+		{tag.SOPInstanceUID, "1.2.3.4.5.6"},
+		{tag.Modality, "CT"},
+	} {
+		elem, err := dicom.NewElement(e.t, []string{e.value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	if err := (&dcm.Dataset{Data: dicom.Dataset{Elements: elems}}).Save(in); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	cfg := anonymize.Config{
+		AnonID:          "STUDY-0042",
+		Salt:            "secret",
+		ProcessMetadata: true,
+		DatePolicy:      anonymize.DatePolicyShiftDays,
+	}
+	out := filepath.Join(dir, "out.dcm")
+	if method, err := anonymize.AnonymizeFile(in, out, cfg); err != nil || method != anonymize.MethodMetadata {
+		t.Fatalf("AnonymizeFile = %q, %v; want %q", method, err, anonymize.MethodMetadata)
+	}
+	ds, err := dcm.ReadDicom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.GetPatientID(); got != "STUDY-0042" {
+		t.Errorf("PatientID = %q, want STUDY-0042", got)
+	}
+	if got := ds.GetPatientName(); got != "" {
+		t.Errorf("PatientName = %q, want cleared", got)
+	}
+
+	cfg.Modalities = []string{"MR"}
+	if method, err := anonymize.AnonymizeFile(in, out, cfg); err != nil || method != anonymize.MethodSkippedModality {
+		t.Errorf("AnonymizeFile with other modality = %q, %v; want %q", method, err, anonymize.MethodSkippedModality)
+	}
+}
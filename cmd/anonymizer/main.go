@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"dicom-anonymizer/internal/cli"
 	"dicom-anonymizer/internal/gui"
@@ -11,28 +12,105 @@ import (
 
 func main() {
 	// Define flags
-	input := flag.String("input", "", "Input folder containing DICOM files")
-	inputShort := flag.String("i", "", "Input folder (shorthand)")
+	var inputs cli.StringsFlag
+	flag.Var(&inputs, "input", "Input folder containing DICOM files, or a single file (repeatable or comma-separated)")
+	flag.Var(&inputs, "i", "Input folder (shorthand)")
+
+	output := flag.String("output", "", "Output folder (default: {input}/anonymized); output file for -merge-mapping")
+	outputShort := flag.String("o", "", "Output (shorthand)")
 
 	key := flag.String("key", "", "Secret key for pseudonymization")
 	keyShort := flag.String("k", "", "Secret key (shorthand)")
 
 	mapping := flag.String("mapping", "", "Patient mapping file path")
 	mappingShort := flag.String("m", "", "Mapping file (shorthand)")
+	idPrefix := flag.String("id-prefix", "", "Prefix of new anonymous IDs (default: ANON-)")
+	idFormat := flag.String("id-format", "", "Number format of new anonymous IDs, e.g. %07d (default: %06d)")
+	uidRoot := flag.String("uid-root", "", "Organization UID root for generated UIDs, e.g. 1.2.840.99999 (default: 2.25)")
+	fuzzyNames := flag.Bool("fuzzy-names", false, "Match patient names ignoring middle initials and nicknames")
+	nicknames := flag.String("nicknames", "", "JSON nickname table for -fuzzy-names (default: built-in)")
+	unidentified := flag.String("unidentified", "new", "IDs of files without PatientID or Name+DOB: new, sop-uid, content-hash, or bucket")
+	exportCSV := flag.String("export-csv", "", "Write the mapping as CSV to this path after processing")
+	report := flag.String("report", "", "Write a JSON run report to this path after processing")
+	manifest := flag.Bool("manifest", false, "Write manifest.json with output file checksums after processing")
+	encryptMapping := flag.Bool("encrypt-mapping", false, "Encrypt the mapping file with a key derived from the secret key")
 
 	redactRows := flag.Int("redact-rows", 75, "Rows to redact from ultrasound images")
 
-	recursive := flag.Bool("recursive", true, "Search subdirectories")
-	recursiveShort := flag.Bool("r", true, "Recursive (shorthand)")
+	var redactRegions cli.RegionFlag
+	flag.Var(&redactRegions, "redact-region", "Pixel rectangle x,y,w,h to redact from ultrasound images (repeatable)")
+	ocr := flag.Bool("ocr", false, "Also redact text found by OCR in ultrasound images (builds with -tags tesseract)")
+	allowMetadataOnly := flag.Bool("allow-metadata-only", false, "Without dcmtk, write JPEG-LS ultrasound files with pixels NOT redacted instead of failing them")
+
+	var include, exclude, scrub cli.StringsFlag
+	flag.Var(&include, "include", "Only process files whose relative path matches this glob (repeatable)")
+	flag.Var(&exclude, "exclude", "Skip files whose relative path matches this glob (repeatable)")
+
+	flag.Bool("recursive", true, "Search subdirectories")
+	flag.Bool("r", true, "Recursive (shorthand)")
+	maxDepth := flag.Int("max-depth", 0, "Directory levels below the input folder to search (0 = unlimited)")
+	followSymlinks := flag.Bool("follow-symlinks", false, "Also search symlinked directories")
 
 	retry := flag.Bool("retry", false, "Retry previously failed files")
+	force := flag.Bool("force", false, "Also process files already marked PatientIdentityRemoved=YES")
+	inPlace := flag.Bool("in-place", false, "DANGEROUS: overwrite the original files with their anonymized versions (needs --i-understand)")
+	inPlaceBackup := flag.Bool("in-place-backup", false, "With --in-place, keep each original as <name>.orig")
+	iUnderstand := flag.Bool("i-understand", false, "Confirm --in-place")
+	onExisting := flag.String("on-existing", "overwrite", "When an output file exists: overwrite, skip, error, or rename")
+	filenames := flag.String("filenames", "preserve", "Output file names: preserve (input path), sop-uid, or sequential")
+	maxFileSize := flag.Int("max-file-size", 0, "Skip files larger than this many MB (0 = no limit)")
+	maxFrameSize := flag.Int("max-frame-size", 256, "Warn about ultrasound frames larger than this many MB (0 = never)")
+
+	contentHash := flag.Bool("content-hash", false, "Detect processed files by content hash instead of size+mtime")
+	tempDir := flag.String("temp-dir", "", "Folder for dcmtk temporary files (default: system temp)")
+	checkpoint := flag.String("checkpoint-interval", "", "Save progress every N files or every duration, e.g. 100 or 30s (default: every 100 files or 30s)")
+
+	profile := flag.String("profile", "", "JSON tag profile file (default: built-in profile)")
+
+	keepSex := flag.Bool("keep-sex", true, "Keep PatientSex (false clears it)")
+	keepInstitution := flag.Bool("keep-institution", true, "Keep InstitutionName (false clears it)")
+	keepStudyDesc := flag.Bool("keep-study-description", true, "Keep StudyDescription (false clears it)")
+	confidentiality := flag.Bool("confidentiality-profile", false, "Apply the DICOM PS3.15 Basic Application Level Confidentiality Profile")
+	retain := flag.String("retain", "", "PS3.15 retain options: dates, uids, device, patient, institution, safe-private")
+	preserveCalibration := flag.Bool("preserve-calibration", false, "Keep pixel spacing, rescale, window and ultrasound region calibration under any profile")
+	scrubNames := flag.Bool("scrub-names", false, "Redact the patient's name from kept free text such as StudyDescription")
+	flag.Var(&scrub, "scrub", "Regular expression to redact from kept free text (repeatable)")
+	removeOverlays := flag.Bool("remove-overlays", true, "Remove overlay planes (groups 60xx) that may hold burned-in annotations")
+	removePrivate := flag.Bool("remove-private-tags", true, "Remove private (odd group) tags except known-safe vendor blocks")
+
+	dates := flag.String("dates", "truncate", "Date handling: truncate, shift, or remove")
+
+	workers := flag.Int("workers", 0, "Number of files to process concurrently (default: number of CPUs)")
 
 	metadata := flag.Bool("metadata", true, "Process CT/MRI/X-Ray (metadata only)")
 	ultrasound := flag.Bool("ultrasound", true, "Process ultrasound (metadata + pixel redaction)")
+	modality := flag.String("modality", "", "Only process these DICOM modalities, comma-separated, e.g. CT,MR")
 
 	dryRun := flag.Bool("dry-run", false, "Preview only, no files modified")
+	explain := flag.Int("explain", 0, "With -dry-run, list the tag changes for the first N files")
+	previewTSV := flag.String("preview-tsv", "", "With -dry-run, write the planned patients as TSV to this path")
 	dryRunShort := flag.Bool("n", false, "Dry run (shorthand)")
 
+	verbose := flag.Bool("verbose", false, "Log each patient and file instead of a progress bar")
+	verboseShort := flag.Bool("v", false, "Verbose (shorthand)")
+	quiet := flag.Bool("quiet", false, "Only print warnings, errors and the final summary")
+	quietShort := flag.Bool("q", false, "Quiet (shorthand)")
+
+	deanonymize := flag.String("deanonymize", "", "Print original identifiers for an anonymous ID")
+	restore := flag.String("restore", "", "Restore original identifiers into a copy of an anonymized file")
+
+	mergeMapping := flag.String("merge-mapping", "", "Comma-separated mapping files to merge (with -o)")
+	auditCollisions := flag.Bool("audit-collisions", false, "List anonymous IDs of the mapping (-m) with more than one identity hash")
+	validate := flag.String("validate", "", "Check an anonymized folder for remaining identifying data")
+	scan := flag.Bool("scan", false, "List the modalities, transfer syntaxes and identifiers of the input files without processing")
+	flag.BoolVar(scan, "list-modalities", false, "Same as -scan")
+
+	failOnError := flag.Bool("fail-on-error", false, "Exit with status 2 if any file failed")
+	printConfig := flag.Bool("print-config", false, "Print the resolved run configuration as JSON and exit without processing")
+
+	version := flag.Bool("version", false, "Print version and build information")
+	selfTest := flag.Bool("selftest", false, "Check dcmtk, the temp folder, DICOM reading/writing and the JPEG-LS encoder")
+
 	help := flag.Bool("help", false, "Show help message")
 	helpShort := flag.Bool("h", false, "Help (shorthand)")
 
@@ -49,10 +127,34 @@ func main() {
 		return
 	}
 
+	// Handle version flag (-v is verbose, so there is no shorthand)
+	if *version {
+		cli.PrintVersion()
+		return
+	}
+
+	if *selfTest {
+		if err := cli.SelfTest(*tempDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// -i may be repeated or list several folders
+	var inputFolders []string
+	for _, value := range inputs {
+		for _, folder := range strings.Split(value, ",") {
+			if folder = strings.TrimSpace(folder); folder != "" {
+				inputFolders = append(inputFolders, folder)
+			}
+		}
+	}
+
 	// Merge short and long flags (prefer long if both specified)
-	inputFolder := *input
-	if inputFolder == "" {
-		inputFolder = *inputShort
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = *outputShort
 	}
 
 	secretKey := *key
@@ -65,15 +167,84 @@ func main() {
 		mappingFile = *mappingShort
 	}
 
-	isRecursive := *recursive
-	if !*recursiveShort {
-		isRecursive = false
-	}
+	// Default true, so only a flag given on the command line may turn it off
+	isRecursive := cli.BoolFlag(flag.CommandLine, "recursive", "r")
 
 	isDryRun := *dryRun || *dryRunShort
 
+	// De-anonymization mode
+	if *deanonymize != "" || *restore != "" {
+		if err := cli.Deanonymize(cli.DeanonymizeOptions{
+			AnonID:      *deanonymize,
+			MappingFile: mappingFile,
+			SecretKey:   secretKey,
+			RestoreFile: *restore,
+			ProfileFile: *profile,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Mapping merge mode
+	if *mergeMapping != "" {
+		if err := cli.MergeMappings(cli.MergeOptions{
+			MappingFiles: strings.Split(*mergeMapping, ","),
+			OutputFile:   outputPath,
+			SecretKey:    secretKey,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *auditCollisions {
+		if err := cli.AuditCollisions(mappingFile, secretKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Output validation mode
+	if *validate != "" {
+		if err := cli.Validate(cli.ValidateOptions{
+			Folder:            *validate,
+			Recursive:         isRecursive,
+			ProfileFile:       *profile,
+			DatePolicy:        *dates,
+			RemovePrivateTags: *removePrivate,
+			KeepSex:           *keepSex,
+			KeepInstitution:   *keepInstitution,
+			KeepStudyDesc:     *keepStudyDesc,
+			KeepCalibration:   *preserveCalibration,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(cli.ExitCode(err))
+		}
+		return
+	}
+
+	// Input scan mode
+	if *scan {
+		if err := cli.Scan(cli.ScanOptions{
+			InputFolders:   inputFolders,
+			Recursive:      isRecursive,
+			MaxDepth:       *maxDepth,
+			FollowSymlinks: *followSymlinks,
+			Include:        include,
+			Exclude:        exclude,
+		}); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// No input folder specified = GUI mode
-	if inputFolder == "" {
+	if len(inputFolders) == 0 && !*printConfig {
 		app := gui.NewApp()
 		app.Run()
 		return
@@ -81,19 +252,75 @@ func main() {
 
 	// CLI mode
 	opts := cli.Options{
-		InputFolder:       inputFolder,
+		InputFolders:      inputFolders,
+		OutputFolder:      outputPath,
 		SecretKey:         secretKey,
 		MappingFile:       mappingFile,
 		RedactRows:        *redactRows,
+		RedactRegions:     redactRegions,
+		OCR:               *ocr,
+		AllowMetadataOnly: *allowMetadataOnly,
 		Recursive:         isRecursive,
+		MaxDepth:          *maxDepth,
+		FollowSymlinks:    *followSymlinks,
+		Include:           include,
+		Exclude:           exclude,
 		RetryFailed:       *retry,
+		Force:             *force,
+		InPlace:           *inPlace,
+		InPlaceBackup:     *inPlaceBackup,
+		IUnderstand:       *iUnderstand,
+		OnExisting:        *onExisting,
+		Filenames:         *filenames,
+		MaxFileSizeMB:     *maxFileSize,
+		MaxFrameSizeMB:    *maxFrameSize,
+		ContentHash:       *contentHash,
+		Checkpoint:        *checkpoint,
+		TempDir:           *tempDir,
+		EncryptMapping:    *encryptMapping,
+		ExportCSV:         *exportCSV,
+		ReportFile:        *report,
+		Manifest:          *manifest,
+		FuzzyNames:        *fuzzyNames,
+		IDPrefix:          *idPrefix,
+		IDFormat:          *idFormat,
+		UIDRoot:           *uidRoot,
+		KeepSex:           *keepSex,
+		RemovePrivateTags: *removePrivate,
+		RemoveOverlays:    *removeOverlays,
+		Confidentiality:   *confidentiality,
+		Retain:            *retain,
+		KeepCalibration:   *preserveCalibration,
+		Scrub:             scrub,
+		ScrubNames:        *scrubNames,
+		KeepInstitution:   *keepInstitution,
+		KeepStudyDesc:     *keepStudyDesc,
+		NicknameFile:      *nicknames,
+		Unidentified:      *unidentified,
 		ProcessMetadata:   *metadata,
 		ProcessUltrasound: *ultrasound,
+		Modalities:        *modality,
 		DryRun:            isDryRun,
+		Explain:           *explain,
+		PreviewTSV:        *previewTSV,
+		Verbose:           *verbose || *verboseShort,
+		Quiet:             *quiet || *quietShort,
+		Workers:           *workers,
+		DatePolicy:        *dates,
+		ProfileFile:       *profile,
+		FailOnError:       *failOnError,
+	}
+
+	if *printConfig {
+		if err := cli.PrintConfig(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if err := cli.Run(opts); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(cli.ExitCode(err))
 	}
 }
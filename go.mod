@@ -5,6 +5,7 @@ go 1.21
 require (
 	fyne.io/fyne/v2 v2.4.4
 	github.com/suyashkumar/dicom v1.0.7
+	golang.org/x/crypto v0.14.0
 )
 
 require (
@@ -1,13 +1,19 @@
 package anonymizer
 
 import (
+	"context"
 	"fmt"
+	"image"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
 
 	dcm "dicom-anonymizer/internal/dicom"
 	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/logging"
 	"dicom-anonymizer/internal/progress"
 )
 
@@ -19,204 +25,352 @@ const (
 	ModalityUltrasound Modality = "ultrasound" // Ultrasound (metadata + pixel redaction)
 )
 
-// Config holds the anonymization configuration
+// ParseModalities splits a comma-separated list of DICOM Modality values
+// such as "ct, MR" into upper-case codes, dropping empty entries.
+func ParseModalities(s string) []string {
+	var modalities []string
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			modalities = append(modalities, m)
+		}
+	}
+	return modalities
+}
+
+// modalitySelected reports whether a file with the given DICOM Modality
+// should be processed. An empty Modalities list selects every modality.
+func (c Config) modalitySelected(modality string) bool {
+	if len(c.Modalities) == 0 {
+		return true
+	}
+	modality = strings.ToUpper(strings.TrimSpace(modality))
+	for _, m := range c.Modalities {
+		if strings.EqualFold(m, modality) {
+			return true
+		}
+	}
+	return false
+}
+
+// DatePolicy controls how date tags are anonymized
+type DatePolicy string
+
+const (
+	DatePolicyTruncateMonth DatePolicy = "truncate" // Truncate to YYYYMM01 (default)
+	DatePolicyShiftDays     DatePolicy = "shift"    // Shift by a stable per-patient offset
+	DatePolicyRemove        DatePolicy = "remove"   // Clear all dates
+)
+
+// Config holds the anonymization configuration. Fields tagged json:"-"
+// are never written to presets (see WritePreset).
 type Config struct {
-	InputFolder       string
+	InputFolder       string // A folder, or a single file processed without searching
+	OutputFolder      string // Where anonymized files go (empty = {InputFolder}/anonymized)
 	MappingFile       string
-	Salt              string
+	Salt              string `json:"-"`
 	Modality          Modality
 	RedactRows        int
+	RedactRegions     []image.Rectangle // Extra ultrasound areas to redact
 	DryRun            bool
 	RetryFailed       bool
 	Recursive         bool
-	OutputWriter      func(string) // For GUI output
-	ProcessMetadata   bool         // Process CT/MRI/X-Ray (metadata only)
-	ProcessUltrasound bool         // Process Ultrasound (metadata + pixel redaction)
+	MaxDepth          int               // With Recursive, directory levels below InputFolder searched (0 = unlimited)
+	FollowSymlinks    bool              // Walk into symlinked directories (each real directory once)
+	OutputWriter      func(string)      `json:"-"` // For GUI output
+	Logger            logging.Logger    `json:"-"` // Warnings and diagnostics (nil = Info and above to OutputWriter)
+	ProcessMetadata   bool              // Process CT/MRI/X-Ray (metadata only)
+	ProcessUltrasound bool              // Process Ultrasound (metadata + pixel redaction)
+	Workers           int               // Number of files processed concurrently (0 = runtime.NumCPU())
+	DatePolicy        DatePolicy        // How dates are anonymized (empty = DatePolicyTruncateMonth)
+	Profile           *TagProfile       // Tags to clear, hash and date-handle (nil = DefaultTagProfile)
+	HashMode          progress.HashMode // How processed files are fingerprinted for resume (empty = quick)
+	EncryptMapping    bool              // Encrypt the mapping file with a key derived from Salt
+	FuzzyNameMatching bool              // Match names ignoring initials and nicknames (new patients only)
+	Nicknames         map[string]string // Nickname table for fuzzy matching (nil = identity.DefaultNicknames)
+	IDPrefix          string            // Anonymous ID prefix (empty = identity.DefaultIDPrefix)
+	IDFormat          string            // Anonymous ID number format with one integer verb (empty = identity.DefaultIDFormat)
+	UIDRoot           string            // Organization root of generated UIDs, recorded in the mapping (empty = identity.DefaultUIDRoot)
+	ReportFile        string            // Write a JSON run report here on completion (empty = none, not written for dry runs)
+	Pauser            *Pauser           `json:"-"` // Pauses processing between files (nil = never paused)
+	IncludePatterns   []string          // Only process files whose path relative to InputFolder matches one of these globs
+	ExcludePatterns   []string          // Skip files whose relative path matches one of these globs
+	Modalities        []string          // DICOM Modality values to process, e.g. "CT", "MR" (empty = all)
+	RemovePrivateTags bool              // Drop private (odd group) elements; the CLI and GUI enable this by default
+	PrivateCreators   []string          // Private creators whose blocks are kept (nil = SafePrivateCreators)
+	RemoveOverlays    bool              // Drop overlay planes (groups 60xx) that may hold burned-in annotations
+
+	// Apply the DICOM PS3.15 Basic Application Level Confidentiality Profile
+	// to the complete attribute set instead of Profile, and mark files with
+	// PatientIdentityRemoved and DeidentificationMethod
+	ConfidentialityProfile bool
+	RetainOptions          []RetainOption // PS3.15 options layered on the profile, e.g. RetainUIDs
+
+	// Keep pixel spacing, rescale, window and ultrasound region calibration
+	// (CalibrationTags) unchanged under any profile, for quantitative research
+	PreserveCalibration bool
+
+	// Regular expressions whose matches in kept free text (ScrubVRs, e.g.
+	// StudyDescription) are replaced with ScrubReplacement, and whether the
+	// tokens of each file's own PatientName are redacted too
+	ScrubPatterns    []string
+	ScrubPatientName bool
+
+	ExplainFiles int // With DryRun, list the tag edits for this many files (0 = none)
+
+	Checkpoint progress.Checkpoint // How often progress is saved (zero = progress.DefaultCheckpoint)
+
+	// Finds burned-in text in ultrasound frames, which is redacted along
+	// with RedactRows and RedactRegions. Dry runs list the frames with text.
+	TextDetector TextDetector `json:"-"` // nil = none
+
+	// Clinical context kept by the default profile. Set to false to clear
+	// the tag instead; true leaves the profile unchanged. DefaultConfig
+	// and ReadPreset set all three.
+	KeepSex              bool
+	KeepInstitutionName  bool
+	KeepStudyDescription bool
+
+	// How files without a valid Name+DOB or a PatientID get their
+	// anonymous ID (empty = UnidentifiedNew)
+	UnidentifiedPolicy UnidentifiedPolicy
+
+	// What to do when an output file already exists (empty =
+	// ExistingOverwrite)
+	OnExisting ExistingPolicy
+
+	// How output files are named below their patient folder (empty =
+	// FilenamePreserveRelative)
+	FilenamePolicy FilenamePolicy
+
+	// Files larger than MaxFileSize bytes are skipped instead of being
+	// read into memory whole (0 = no limit). Ultrasound files with a frame
+	// larger than MaxFrameSize bytes are processed with a warning (0 = no
+	// warning).
+	MaxFileSize  int64
+	MaxFrameSize int64
+
+	// Reprocess files already marked PatientIdentityRemoved=YES, which are
+	// skipped by default so rerunning on output never anonymizes twice
+	Force bool
+
+	// Write JPEG-LS ultrasound files that cannot be decompressed because
+	// dcmtk is not installed with their metadata anonymized but burned-in
+	// pixels NOT redacted, instead of failing them. Such files are counted
+	// in Stats.PixelsNotRedacted and marked in DeidentificationMethod.
+	AllowMetadataOnlyFallback bool
+
+	// AnonymizeFile only: the anonymous PatientID written to the file.
+	// ProcessFolder assigns IDs from the mapping file instead.
+	AnonID string `json:"-"`
+
+	// Write ManifestFileName to the output folder after a completed run,
+	// listing every anonymized file with its SHA-256, size and anonymous ID
+	WriteManifest bool
+
+	// DANGEROUS: overwrite each original with its anonymized version
+	// instead of writing to the output folder, which then only holds the
+	// progress file and error log. Each file is written to a temporary
+	// file and renamed over the original. With InPlaceBackup the original
+	// is kept as {name}InPlaceBackupSuffix, next to the anonymized file.
+	// Cannot be combined with Force or WriteManifest, and never saved in
+	// presets, so loading one cannot turn it on.
+	InPlace       bool `json:"-"`
+	InPlaceBackup bool `json:"-"`
 }
 
-// Stats holds processing statistics
-type Stats struct {
-	Success         int
-	Failed          int
-	Skipped         int
-	IdentityMatched int
-	PIDMatched      int
-	TotalPatients   int
+// DefaultConfig returns a Config with the defaults of the CLI and GUI:
+// private tags and overlays removed and the clinical context of the
+// default profile kept. Callers set the input, output and salt.
+func DefaultConfig() Config {
+	return Config{
+		RemovePrivateTags:    true,
+		RemoveOverlays:       true,
+		KeepSex:              true,
+		KeepInstitutionName:  true,
+		KeepStudyDescription: true,
+	}
 }
 
-// PatientGroup represents files grouped by patient
-type PatientGroup struct {
-	Key   string
-	Name  string
-	DOB   string
-	PID   string
-	Files []string
+// OutputDir returns the configured output folder or the default
+// {InputFolder}/anonymized, next to the file when InputFolder is a file.
+func (cfg Config) OutputDir() string {
+	if cfg.OutputFolder != "" {
+		return cfg.OutputFolder
+	}
+	return filepath.Join(InputDir(cfg.InputFolder), dcm.DefaultOutputDirName)
 }
 
-// ProcessFolder processes all DICOM files in a folder.
-func ProcessFolder(cfg Config) (*Stats, error) {
-	output := cfg.OutputWriter
-	if output == nil {
-		output = func(s string) { fmt.Print(s) }
+// InputDir returns path, or the folder holding it when path is a file
+func InputDir(path string) string {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return filepath.Dir(path)
 	}
+	return path
+}
 
-	inputFolder := cfg.InputFolder
-	outputFolder := filepath.Join(inputFolder, "anonymized")
-
-	progressFile := filepath.Join(outputFolder, ".progress.json")
-	logFile := filepath.Join(outputFolder, "errors.log")
-
-	// Initialize components
-	mapper := identity.NewPseudonymizationMapper(cfg.MappingFile, cfg.Salt)
-
-	var tracker *progress.Tracker
-	var errorLogger *progress.ErrorLogger
-	var err error
-
-	if !cfg.DryRun {
-		tracker = progress.NewTracker(progressFile)
-		errorLogger, err = progress.NewErrorLogger(logFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not create error logger: %w", err)
-		}
-		defer errorLogger.Close()
-
-		if cfg.RetryFailed {
-			tracker.ClearFailed()
-		}
+// clearedContextTags returns the clinical context tags the config removes
+func (cfg Config) clearedContextTags() []tag.Tag {
+	var tags []tag.Tag
+	if !cfg.KeepSex {
+		tags = append(tags, tag.PatientSex)
 	}
-
-	// Find all DICOM files
-	files, err := dcm.FindDicomFiles(inputFolder, cfg.Recursive)
-	if err != nil {
-		return nil, fmt.Errorf("could not find DICOM files: %w", err)
+	if !cfg.KeepInstitutionName {
+		tags = append(tags, tag.InstitutionName)
+	}
+	if !cfg.KeepStudyDescription {
+		tags = append(tags, tag.StudyDescription)
 	}
+	return tags
+}
 
-	if len(files) == 0 {
-		output(fmt.Sprintf("No DICOM files found in %s\n", inputFolder))
-		return &Stats{}, nil
+// tagProfile returns the configured profile with the clinical context tags
+// the config removes, and the calibration tags it preserves
+func (cfg Config) tagProfile() *TagProfile {
+	profile := cfg.Profile
+	if profile == nil {
+		profile = DefaultTagProfile()
 	}
+	profile = profile.withCleared(cfg.clearedContextTags()...)
+	if cfg.PreserveCalibration {
+		profile = profile.withKept(CalibrationTags...)
+	}
+	return profile
+}
 
-	output(fmt.Sprintf("Found %d DICOM file(s) in %s\n", len(files), inputFolder))
+// fileOptions returns the per-file settings for a patient's files. mapper
+// may be nil for files anonymized outside a folder run.
+func (cfg Config) fileOptions(anonID string, profile *TagProfile, uids *identity.UIDMapper,
+	mapper *identity.PseudonymizationMapper) FileOptions {
+	opts := FileOptions{
+		PatientID: anonID,
+		UIDs:      uids,
+		Dates:     DateHandling{Policy: cfg.DatePolicy},
+		Profile:   profile,
+		Salt:      cfg.Salt,
 
-	// Group files by patient identity (Name+DOB) or PatientID
-	patients := groupFilesByPatient(files, cfg.Salt, output)
-	output(fmt.Sprintf("Found %d unique patient(s)\n", len(patients)))
+		RemovePrivateTags: cfg.RemovePrivateTags,
+		PrivateCreators:   cfg.PrivateCreators,
+		RemoveOverlays:    cfg.RemoveOverlays,
 
-	if cfg.DryRun {
-		return dryRun(patients, mapper, output)
-	}
+		Confidentiality: cfg.ConfidentialityProfile,
+		Retain:          cfg.RetainOptions,
 
-	// Process each patient
-	stats := &Stats{}
-	var mu sync.Mutex
+		PreserveCalibration: cfg.PreserveCalibration,
 
-	for i, patient := range patients {
-		anonID, method := mapper.GetAnonID(patient.PID, patient.Name, patient.DOB)
+		TextDetector: cfg.TextDetector,
+		Logger:       cfg.Logger,
 
-		if method == identity.MatchIdentity {
-			stats.IdentityMatched++
+		MetadataOnlyFallback: cfg.AllowMetadataOnlyFallback,
+	}
+	// Patterns are checked by ProcessFolder and AnonymizeFile
+	opts.Scrub, _ = NewScrubber(cfg.ScrubPatterns, cfg.ScrubPatientName)
+	if cfg.DatePolicy == DatePolicyShiftDays {
+		// Without a mapper, use the offset the mapper would record
+		if mapper != nil {
+			opts.Dates.ShiftDays = mapper.GetDateShift(anonID)
 		} else {
-			stats.PIDMatched++
-		}
-
-		patientFolder := filepath.Join(outputFolder, anonID)
-
-		output(fmt.Sprintf("\nProcessing Patient %d/%d\n", i+1, len(patients)))
-		if method == identity.MatchIdentity {
-			output(fmt.Sprintf("  Name: %s\n", patient.Name))
-			output(fmt.Sprintf("  DOB: %s\n", patient.DOB))
+			opts.Dates.ShiftDays = identity.CreateDateShift(anonID, cfg.Salt)
 		}
-		output(fmt.Sprintf("  Original PID: %s\n", patient.PID))
-		output(fmt.Sprintf("  Anon ID: %s (%s match)\n", anonID, method))
-		output(fmt.Sprintf("  Files: %d\n", len(patient.Files)))
-
-		for _, filePath := range patient.Files {
-			if tracker != nil && tracker.IsProcessed(filePath) {
-				mu.Lock()
-				stats.Skipped++
-				mu.Unlock()
-				continue
-			}
-
-			// Determine output path
-			relPath, err := filepath.Rel(inputFolder, filePath)
-			if err != nil {
-				relPath = filepath.Base(filePath)
-			}
-			outputPath := filepath.Join(patientFolder, relPath)
+	}
+	return opts
+}
 
-			// Process the file - determine method based on modality
-			var processErr error
+// Stats holds processing statistics
+type Stats struct {
+	Success           int
+	Failed            int
+	Skipped           int
+	SkippedModality   int // Of Skipped, files whose Modality is not in Config.Modalities
+	SkippedAnonymized int // Of Skipped, files already marked PatientIdentityRemoved=YES
+	SkippedExisting   int // Of Skipped, files whose output already existed (ExistingSkip)
+	SkippedSize       int // Of Skipped, files larger than Config.MaxFileSize
+	SkippedResumed    int // Of Skipped, files processed successfully by an earlier run
+	PixelsNotRedacted int // Of Success, ultrasound files written by Config.AllowMetadataOnlyFallback
+	LinkedGroups      int // Patient groups merged into others sharing a StudyInstanceUID
+	IdentityMatched   int
+	PIDMatched        int
+	TotalPatients     int
+
+	Failures []progress.ErrorEntry // Files that failed in this run, in the order they failed
+	ErrorLog string                // Path of the error log file (empty for dry runs)
+
+	Explanations []FileExplanation   // Dry runs with ExplainFiles: the tag edits per file
+	DetectedText []FileText          // Dry runs with a TextDetector: ultrasound files checked for text
+	Preview      []PatientPreviewRow // Dry runs: the planned patients
+
+	// Dry runs over an output folder with saved progress: how many files a
+	// run would process as new, retry or reprocess because they changed,
+	// and how many it would skip as already done (nil = no saved progress)
+	Resume *progress.WorkSummary
+}
 
-			// Check if this is an ultrasound file
-			isUS := false
-			if cfg.ProcessUltrasound {
-				ds, readErr := dcm.ReadDicomMetadataOnly(filePath)
-				if readErr == nil {
-					isUS = ds.IsUltrasound()
-				}
-			}
+// PatientGroup represents files grouped by patient
+type PatientGroup struct {
+	Key   string
+	Name  string
+	DOB   string
+	PID   string
+	Files []string
 
-			if isUS && cfg.ProcessUltrasound {
-				processErr = AnonymizeUltrasound(filePath, outputPath, cfg.RedactRows, anonID)
-			} else if cfg.ProcessMetadata {
-				processErr = AnonymizeMetadata(filePath, outputPath, anonID)
-			} else {
-				// Skip files that don't match selected modality
-				mu.Lock()
-				stats.Skipped++
-				mu.Unlock()
-				continue
-			}
+	// For groups keyed by PatientID, why the Name+DOB of their first file
+	// could not be used
+	Reason identity.IdentityReason
 
-			mu.Lock()
-			if processErr != nil {
-				stats.Failed++
-				errMsg := processErr.Error()
-				if tracker != nil {
-					tracker.MarkError(filePath, errMsg)
-				}
-				if errorLogger != nil {
-					errorLogger.Log(filePath, errMsg)
-				}
-				output(fmt.Sprintf("  Error: %s: %s\n", filepath.Base(filePath), errMsg))
-			} else {
-				stats.Success++
-				if tracker != nil {
-					tracker.MarkSuccess(filePath, outputPath)
-				}
-			}
-			mu.Unlock()
-		}
-	}
+	// Set for files grouped by Config.UnidentifiedPolicy: a mapper file
+	// key such as "sop:<uid>", or UnidentifiedAnonID
+	FileKey string
 
-	stats.TotalPatients = len(patients)
+	// Groups merged into this one because they share a StudyInstanceUID
+	// (their Files are part of Files)
+	Linked []*PatientGroup
 
-	// Print summary
-	output(fmt.Sprintf("\n%s\n", strings.Repeat("=", 50)))
-	output(fmt.Sprintf("Complete! %d succeeded, %d failed, %d skipped\n",
-		stats.Success, stats.Failed, stats.Skipped))
-	output(fmt.Sprintf("Matching: %d by Name+DOB, %d by PatientID\n",
-		stats.IdentityMatched, stats.PIDMatched))
-	if errorLogger != nil {
-		output(fmt.Sprintf("  %s\n", errorLogger.Summary()))
-	}
-	output(fmt.Sprintf("Output: %s\n", outputFolder))
-	if cfg.MappingFile != "" {
-		output(fmt.Sprintf("Mapping: %s\n", cfg.MappingFile))
-	}
+	// Metadata of the files read while grouping, shared by all groups of
+	// one groupFilesByPatient call
+	metadata map[string]fileMetadata
+}
 
-	return stats, nil
+// ProcessFolder processes all DICOM files in a folder.
+func ProcessFolder(cfg Config) (*Stats, error) {
+	return ProcessFolderWithProgress(cfg, nil)
 }
 
-// groupFilesByPatient groups DICOM files by patient identity or ID
-func groupFilesByPatient(files []string, salt string, output func(string)) []*PatientGroup {
+// groupFilesByPatient groups DICOM files by patient identity or ID. Files
+// with neither are grouped by cfg.UnidentifiedPolicy. Groups sharing a
+// StudyInstanceUID are then merged (see linkStudies). Files already marked
+// PatientIdentityRemoved are returned separately unless cfg.Force is set,
+// so their anonymous IDs never reach the mapping.
+func groupFilesByPatient(files []string, cfg Config, output func(string)) ([]*PatientGroup, []string) {
+	salt, policy := cfg.Salt, cfg.UnidentifiedPolicy
 	patients := make(map[string]*PatientGroup)
+	var anonymized []string
+	studies := make(map[string]string) // file -> StudyInstanceUID
+	metadata := make(map[string]fileMetadata, len(files))
+
+	// addUnidentified groups a file by its policy key, reporting false
+	// when the policy leaves it to the default grouping
+	addUnidentified := func(filePath string, ds *dcm.Dataset) bool {
+		key := unidentifiedKey(policy, filePath, ds)
+		if key == "" {
+			return false
+		}
+		if patients["FILE:"+key] == nil {
+			patients["FILE:"+key] = &PatientGroup{Key: "FILE:" + key, FileKey: key}
+		}
+		patients["FILE:"+key].Files = append(patients["FILE:"+key].Files, filePath)
+		return true
+	}
 
 	for _, filePath := range files {
-		ds, err := dcm.ReadDicomMetadataOnly(filePath)
-		if err != nil {
+		meta, ds := loadFileMetadata(filePath)
+		metadata[filePath] = meta
+		if meta.IdentityRemoved && !cfg.Force {
+			anonymized = append(anonymized, filePath)
+			continue
+		}
+		if ds == nil {
+			if addUnidentified(filePath, nil) {
+				continue
+			}
 			// Add to UNKNOWN group
 			if patients["UNKNOWN"] == nil {
 				patients["UNKNOWN"] = &PatientGroup{Key: "UNKNOWN"}
@@ -225,256 +379,304 @@ func groupFilesByPatient(files []string, salt string, output func(string)) []*Pa
 			continue
 		}
 
+		if study := strings.TrimSpace(ds.GetString(tag.StudyInstanceUID)); study != "" {
+			studies[filePath] = study
+		}
+
 		name := ds.GetPatientName()
 		dob := ds.GetPatientBirthDate()
 		pid := ds.GetPatientID()
-		if pid == "" {
-			pid = "UNKNOWN"
+		if strings.TrimSpace(pid) == "" && !identity.IsValidIdentity(name, dob) && addUnidentified(filePath, ds) {
+			continue
 		}
 
-		// Create grouping key
+		// Create grouping key. Patients with an identity but no PatientID
+		// must not share the placeholder PID, or the mapper would link
+		// them to each other through it.
 		var key string
 		if identity.IsValidIdentity(name, dob) {
 			key = identity.CreateIdentityHash(name, dob, salt)
 		} else {
+			if pid == "" {
+				pid = "UNKNOWN"
+			}
 			key = "PID:" + pid
 		}
 
 		if patients[key] == nil {
 			patients[key] = &PatientGroup{
-				Key:  key,
-				Name: name,
-				DOB:  dob,
-				PID:  pid,
+				Key:    key,
+				Name:   name,
+				DOB:    dob,
+				PID:    pid,
+				Reason: meta.IdentityReason,
 			}
 		}
 		patients[key].Files = append(patients[key].Files, filePath)
 	}
 
-	// Convert map to slice
+	result, linked, conflicts := linkStudies(patients, studies)
+	for _, p := range result {
+		p.metadata = metadata
+	}
+	if linked > 0 {
+		output(fmt.Sprintf("Merged %d patient group(s) into others sharing a StudyInstanceUID\n", linked))
+	}
+	for _, study := range conflicts {
+		output(fmt.Sprintf("Warning: study %s holds patients with different birth dates; they were not merged and keep separate anonymous IDs\n", study))
+	}
+	return result, anonymized
+}
+
+// weakGroup reports whether a group has neither an identity nor a real
+// PatientID: unreadable files, the placeholder PID UNKNOWN, or groups of
+// Config.UnidentifiedPolicy
+func weakGroup(p *PatientGroup) bool {
+	return p.FileKey != "" || p.Key == "UNKNOWN" || p.Key == "PID:UNKNOWN"
+}
+
+// linkStudies merges patient groups that share a StudyInstanceUID, so the
+// files of one study never get different anonymous IDs. Groups with an
+// identity or PatientID are merged whole, keeping the identity of the
+// group with a valid Name+DOB and the most files; the others are recorded
+// in Linked. Groups whose valid identities have different birth dates are
+// distinct patients and never merged; their shared studies are returned
+// as conflicts. Files of weak groups are moved one by one to the group of
+// their study, if there is one, so unrelated files sharing a weak group
+// are not merged with each other. Returns the groups, how many were
+// merged into others and the conflicting studies.
+func linkStudies(patients map[string]*PatientGroup, studies map[string]string) ([]*PatientGroup, int, []string) {
+	keys := make([]string, 0, len(patients))
+	for key := range patients {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Union the strong groups that share a study
+	parent := make(map[string]string)
+	var find func(key string) string
+	find = func(key string) string {
+		if parent[key] == "" || parent[key] == key {
+			return key
+		}
+		parent[key] = find(parent[key])
+		return parent[key]
+	}
+	dobs := make(map[string]string) // Root -> DOB of the valid identities in its set
+	for _, key := range keys {
+		if p := patients[key]; !weakGroup(p) && identity.IsValidIdentity(p.Name, p.DOB) {
+			dobs[key] = p.DOB
+		}
+	}
+	owner := make(map[string]string) // StudyInstanceUID -> group key
+	conflicting := make(map[string]bool)
+	for _, key := range keys {
+		p := patients[key]
+		if weakGroup(p) {
+			continue
+		}
+		for _, f := range p.Files {
+			study := studies[f]
+			if study == "" {
+				continue
+			}
+			if other, ok := owner[study]; !ok {
+				owner[study] = key
+			} else if a, b := find(other), find(key); a != b {
+				if dobs[a] != "" && dobs[b] != "" && dobs[a] != dobs[b] {
+					conflicting[study] = true
+					continue
+				}
+				parent[b] = a
+				if dobs[a] == "" {
+					dobs[a] = dobs[b]
+				}
+			}
+		}
+	}
+	conflicts := make([]string, 0, len(conflicting))
+	for study := range conflicting {
+		conflicts = append(conflicts, study)
+	}
+	sort.Strings(conflicts)
+
+	// Pick the group whose identity each merged set keeps
+	members := make(map[string][]*PatientGroup)
+	for _, key := range keys {
+		if !weakGroup(patients[key]) {
+			root := find(key)
+			members[root] = append(members[root], patients[key])
+		}
+	}
+	merged := 0
+	heads := make(map[string]*PatientGroup) // root -> kept group
+	for root, groups := range members {
+		head := groups[0]
+		for _, p := range groups[1:] {
+			if betterGroup(p, head) {
+				head = p
+			}
+		}
+		for _, p := range groups {
+			if p != head {
+				head.Files = append(head.Files, p.Files...)
+				head.Linked = append(head.Linked, p)
+				p.Files = nil
+				merged++
+			}
+		}
+		heads[root] = head
+	}
+
+	// Move files of weak groups to the group of their study
+	for _, key := range keys {
+		p := patients[key]
+		if !weakGroup(p) {
+			continue
+		}
+		kept := p.Files[:0]
+		for _, f := range p.Files {
+			if other, ok := owner[studies[f]]; ok {
+				head := heads[find(other)]
+				head.Files = append(head.Files, f)
+			} else {
+				kept = append(kept, f)
+			}
+		}
+		p.Files = kept
+	}
+
 	result := make([]*PatientGroup, 0, len(patients))
-	for _, p := range patients {
-		result = append(result, p)
+	for _, key := range keys {
+		if p := patients[key]; len(p.Files) > 0 {
+			result = append(result, p)
+		}
 	}
+	return result, merged, conflicts
+}
 
-	return result
+// betterGroup reports whether a should keep its identity over b when
+// they are merged: a valid Name+DOB first, then more files, then the
+// lower key
+func betterGroup(a, b *PatientGroup) bool {
+	aIdentity, bIdentity := identity.IsValidIdentity(a.Name, a.DOB), identity.IsValidIdentity(b.Name, b.DOB)
+	if aIdentity != bIdentity {
+		return aIdentity
+	}
+	if len(a.Files) != len(b.Files) {
+		return len(a.Files) > len(b.Files)
+	}
+	return a.Key < b.Key
 }
 
-// dryRun performs a dry run, showing what would be processed
-func dryRun(patients []*PatientGroup, mapper *identity.PseudonymizationMapper, output func(string)) (*Stats, error) {
+// dryRun prints a plan, showing what would be processed
+func dryRun(patients []PlannedPatient, output func(string)) (*Stats, error) {
 	output("\n[DRY RUN] Would process:\n")
 
 	identityCount := 0
 	pidCount := 0
 	totalFiles := 0
+	reasons := make(map[string]int) // PID fallback reason -> patients
 
-	for _, patient := range patients {
-		anonID, method := mapper.GetAnonID(patient.PID, patient.Name, patient.DOB)
+	rows := PreviewRows(patients)
+	for i, patient := range patients {
 		totalFiles += len(patient.Files)
 
-		if method == identity.MatchIdentity {
+		switch {
+		case patient.Method == identity.MatchIdentity:
 			identityCount++
-			output(fmt.Sprintf("  %s <- '%s' + DOB (%d files) [identity match]\n",
-				anonID, patient.Name, len(patient.Files)))
-		} else {
+		case patient.FileKey == "" && patient.Reason != identity.ReasonValid:
+			pidCount++
+			reasons[string(patient.Reason)]++
+		default:
 			pidCount++
-			output(fmt.Sprintf("  %s <- PID '%s' (%d files) [PID fallback]\n",
-				anonID, patient.PID, len(patient.Files)))
 		}
+		output("  " + rows[i].String() + "\n")
 	}
 
 	output(fmt.Sprintf("\nMatching method: %d by identity, %d by PID\n", identityCount, pidCount))
+	if len(reasons) > 0 {
+		output(fmt.Sprintf("PID fallback reasons: %s\n", formatCounts(reasons)))
+	}
 
 	return &Stats{
 		Skipped:         totalFiles,
 		IdentityMatched: identityCount,
 		PIDMatched:      pidCount,
 		TotalPatients:   len(patients),
+		Preview:         rows,
 	}, nil
 }
 
-// ProgressCallback is called during processing to report progress
-type ProgressCallback func(current, total int, filename, status string)
-
-// ProcessFolderWithProgress processes all DICOM files with progress callbacks
-func ProcessFolderWithProgress(cfg Config, progressCb ProgressCallback) (*Stats, error) {
-	output := cfg.OutputWriter
-	if output == nil {
-		output = func(s string) { fmt.Print(s) }
+// ResumeSummary loads the progress saved in the output folder of cfg by
+// earlier runs and counts what a run would do with files, or returns nil
+// when none of them were recorded. The progress file is not written.
+func ResumeSummary(cfg Config, files []string) *progress.WorkSummary {
+	progressFile := filepath.Join(cfg.OutputDir(), ".progress.json")
+	if _, err := os.Stat(progressFile); err != nil {
+		return nil
 	}
-
-	inputFolder := cfg.InputFolder
-	outputFolder := filepath.Join(inputFolder, "anonymized")
-
-	progressFile := filepath.Join(outputFolder, ".progress.json")
-	logFile := filepath.Join(outputFolder, "errors.log")
-
-	// Initialize components
-	mapper := identity.NewPseudonymizationMapper(cfg.MappingFile, cfg.Salt)
-
-	var tracker *progress.Tracker
-	var errorLogger *progress.ErrorLogger
-	var err error
-
-	if !cfg.DryRun {
-		tracker = progress.NewTracker(progressFile)
-		errorLogger, err = progress.NewErrorLogger(logFile)
-		if err != nil {
-			return nil, fmt.Errorf("could not create error logger: %w", err)
-		}
-		defer errorLogger.Close()
-
-		if cfg.RetryFailed {
-			tracker.ClearFailed()
-		}
-	}
-
-	// Find all DICOM files
-	files, err := dcm.FindDicomFiles(inputFolder, cfg.Recursive)
-	if err != nil {
-		return nil, fmt.Errorf("could not find DICOM files: %w", err)
-	}
-
-	if len(files) == 0 {
-		output(fmt.Sprintf("No DICOM files found in %s\n", inputFolder))
-		return &Stats{}, nil
+	tracker := progress.NewTrackerWithLogger(progressFile, cfg.HashMode, logging.Discard())
+	summary := tracker.Summarize(files)
+	if summary.Retry+summary.Changed+summary.Done == 0 {
+		return nil
 	}
+	return &summary
+}
 
-	output(fmt.Sprintf("Found %d DICOM file(s) in %s\n", len(files), inputFolder))
-
-	// Group files by patient identity (Name+DOB) or PatientID
-	patients := groupFilesByPatient(files, cfg.Salt, output)
-	output(fmt.Sprintf("Found %d unique patient(s)\n", len(patients)))
-
-	if cfg.DryRun {
-		return dryRun(patients, mapper, output)
-	}
-
-	// Count total files for progress
-	totalFiles := 0
-	for _, patient := range patients {
-		totalFiles += len(patient.Files)
-	}
-
-	// Process each patient
-	stats := &Stats{}
-	var mu sync.Mutex
-	fileIndex := 0
-
-	for i, patient := range patients {
-		anonID, method := mapper.GetAnonID(patient.PID, patient.Name, patient.DOB)
-
-		if method == identity.MatchIdentity {
-			stats.IdentityMatched++
+// splitBySize separates the files larger than maxSize bytes. Files that
+// cannot be stated are kept and fail later with a real error.
+func splitBySize(files []string, maxSize int64) (kept, tooLarge []string) {
+	for _, filePath := range files {
+		if info, err := os.Stat(filePath); err == nil && info.Size() > maxSize {
+			tooLarge = append(tooLarge, filePath)
 		} else {
-			stats.PIDMatched++
+			kept = append(kept, filePath)
 		}
+	}
+	return kept, tooLarge
+}
 
-		patientFolder := filepath.Join(outputFolder, anonID)
-
-		output(fmt.Sprintf("\nProcessing Patient %d/%d\n", i+1, len(patients)))
-		if method == identity.MatchIdentity {
-			output(fmt.Sprintf("  Name: %s\n", patient.Name))
-			output(fmt.Sprintf("  DOB: %s\n", patient.DOB))
+// formatCounts formats counts as "2 placeholder-name, 1 short-name", most
+// frequent first
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
 		}
-		output(fmt.Sprintf("  Original PID: %s\n", patient.PID))
-		output(fmt.Sprintf("  Anon ID: %s (%s match)\n", anonID, method))
-		output(fmt.Sprintf("  Files: %d\n", len(patient.Files)))
-
-		for _, filePath := range patient.Files {
-			fileIndex++
-
-			if tracker != nil && tracker.IsProcessed(filePath) {
-				mu.Lock()
-				stats.Skipped++
-				mu.Unlock()
-				if progressCb != nil {
-					progressCb(fileIndex, totalFiles, filepath.Base(filePath), "skipped")
-				}
-				continue
-			}
-
-			// Report progress - processing
-			if progressCb != nil {
-				progressCb(fileIndex, totalFiles, filepath.Base(filePath), "processing")
-			}
-
-			// Determine output path
-			relPath, err := filepath.Rel(inputFolder, filePath)
-			if err != nil {
-				relPath = filepath.Base(filePath)
-			}
-			outputPath := filepath.Join(patientFolder, relPath)
-
-			// Process the file - determine method based on modality
-			var processErr error
+		return keys[i] < keys[j]
+	})
 
-			// Check if this is an ultrasound file
-			isUS := false
-			if cfg.ProcessUltrasound {
-				ds, readErr := dcm.ReadDicomMetadataOnly(filePath)
-				if readErr == nil {
-					isUS = ds.IsUltrasound()
-				}
-			}
-
-			if isUS && cfg.ProcessUltrasound {
-				processErr = AnonymizeUltrasound(filePath, outputPath, cfg.RedactRows, anonID)
-			} else if cfg.ProcessMetadata {
-				processErr = AnonymizeMetadata(filePath, outputPath, anonID)
-			} else {
-				// Skip files that don't match selected modality
-				mu.Lock()
-				stats.Skipped++
-				mu.Unlock()
-				if progressCb != nil {
-					progressCb(fileIndex, totalFiles, filepath.Base(filePath), "skipped")
-				}
-				continue
-			}
-
-			mu.Lock()
-			if processErr != nil {
-				stats.Failed++
-				errMsg := processErr.Error()
-				if tracker != nil {
-					tracker.MarkError(filePath, errMsg)
-				}
-				if errorLogger != nil {
-					errorLogger.Log(filePath, errMsg)
-				}
-				output(fmt.Sprintf("  Error: %s: %s\n", filepath.Base(filePath), errMsg))
-				if progressCb != nil {
-					progressCb(fileIndex, totalFiles, filepath.Base(filePath), "failed")
-				}
-			} else {
-				stats.Success++
-				if tracker != nil {
-					tracker.MarkSuccess(filePath, outputPath)
-				}
-				if progressCb != nil {
-					progressCb(fileIndex, totalFiles, filepath.Base(filePath), "success")
-				}
-			}
-			mu.Unlock()
-		}
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%d %s", counts[key], key)
 	}
+	return strings.Join(parts, ", ")
+}
 
-	stats.TotalPatients = len(patients)
+// ProgressCallback is called during processing to report progress. status
+// is "processing" when a file starts and "success", "failed" or "skipped"
+// when it is done; while a file is re-encoded as JPEG-LS it is
+// "compressing N%" with current unchanged.
+type ProgressCallback func(current, total int, filename, status string)
 
-	// Print summary
-	output(fmt.Sprintf("\n%s\n", strings.Repeat("=", 50)))
-	output(fmt.Sprintf("Complete! %d succeeded, %d failed, %d skipped\n",
-		stats.Success, stats.Failed, stats.Skipped))
-	output(fmt.Sprintf("Matching: %d by Name+DOB, %d by PatientID\n",
-		stats.IdentityMatched, stats.PIDMatched))
-	if errorLogger != nil {
-		output(fmt.Sprintf("  %s\n", errorLogger.Summary()))
-	}
-	output(fmt.Sprintf("Output: %s\n", outputFolder))
-	if cfg.MappingFile != "" {
-		output(fmt.Sprintf("Mapping: %s\n", cfg.MappingFile))
-	}
+// ProcessFolderWithProgress processes all DICOM files with progress callbacks
+func ProcessFolderWithProgress(cfg Config, progressCb ProgressCallback) (*Stats, error) {
+	return ProcessFolderWithContext(context.Background(), cfg, progressCb)
+}
 
-	return stats, nil
+// ProcessFolderWithContext processes all DICOM files with progress callbacks,
+// stopping between files once ctx is cancelled. Files already finished are
+// recorded in the tracker, so a cancelled run can be resumed later. On
+// cancellation the partial stats are returned together with ctx.Err().
+func ProcessFolderWithContext(ctx context.Context, cfg Config, progressCb ProgressCallback) (*Stats, error) {
+	plan, err := BuildPlan(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return ExecutePlanWithContext(ctx, plan, cfg, progressCb)
 }
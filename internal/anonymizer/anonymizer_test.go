@@ -0,0 +1,344 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/progress"
+)
+
+// groupFiles writes one test file per entry and groups them
+func groupFiles(t *testing.T, files map[string]map[tag.Tag]string) map[string]*PatientGroup {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for name, values := range files {
+		path := filepath.Join(dir, name)
+		writeTestFile(t, path, values)
+		paths = append(paths, path)
+	}
+
+	// Group by file name, each listing the names of its files
+	groups := map[string]*PatientGroup{}
+	patients, _ := groupFilesByPatient(paths, Config{Salt: "secret"}, func(string) {})
+	for _, p := range patients {
+		var names []string
+		for _, f := range p.Files {
+			names = append(names, filepath.Base(f))
+		}
+		sort.Strings(names)
+		p.Files = names
+		for _, name := range names {
+			groups[name] = p
+		}
+	}
+	return groups
+}
+
+func TestGroupFilesByPatientMergesSharedStudy(t *testing.T) {
+	// The same patient with a mistyped name and a new PatientID in a
+	// follow-up series of the same study
+	groups := groupFiles(t, map[string]map[tag.Tag]string{
+		"a1.dcm": {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN1", tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.1"},
+		"a2.dcm": {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN1", tag.StudyInstanceUID: "1.2.2", tag.SOPInstanceUID: "1.3.2"},
+		"b1.dcm": {tag.PatientName: "SMYTH^JOHN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN9", tag.StudyInstanceUID: "1.2.2", tag.SOPInstanceUID: "1.3.3"},
+		"c1.dcm": {tag.PatientName: "DOE^JANE", tag.PatientBirthDate: "19700101", tag.PatientID: "MRN2", tag.StudyInstanceUID: "1.2.3", tag.SOPInstanceUID: "1.3.4"},
+	})
+
+	merged := groups["a1.dcm"]
+	if groups["b1.dcm"] != merged || groups["a2.dcm"] != merged {
+		t.Fatalf("study 1.2.2 split: a1 %v, b1 %v", merged.Files, groups["b1.dcm"].Files)
+	}
+	if merged.Name != "SMITH^JOHN" || merged.PID != "MRN1" {
+		t.Errorf("merged group kept %s/%s, want the group with more files", merged.Name, merged.PID)
+	}
+	if len(merged.Linked) != 1 || merged.Linked[0].PID != "MRN9" {
+		t.Errorf("Linked = %+v, want the MRN9 group", merged.Linked)
+	}
+	if groups["c1.dcm"] == merged {
+		t.Error("unrelated patient merged")
+	}
+
+	// The merged group's identity and PatientID map to its ID from now on
+	mapper, err := identity.NewPseudonymizationMapper("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonID, _ := merged.anonID(mapper)
+	if got, _ := mapper.GetAnonID("MRN9", "SMYTH^JOHN", "19800101"); got != anonID {
+		t.Errorf("linked patient maps to %s, want %s", got, anonID)
+	}
+}
+
+func TestGroupFilesByPatientKeepsConflictingIdentities(t *testing.T) {
+	// Distinct patients filed under one study by mistake
+	groups := groupFiles(t, map[string]map[tag.Tag]string{
+		"a.dcm": {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN1", tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.1"},
+		"b.dcm": {tag.PatientName: "DOE^JANE", tag.PatientBirthDate: "19700101", tag.PatientID: "MRN2", tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.2"},
+		"c.dcm": {tag.PatientID: "MRN3", tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.3"},
+	})
+
+	if groups["a.dcm"] == groups["b.dcm"] {
+		t.Fatalf("patients with different birth dates merged: %v", groups["a.dcm"].Files)
+	}
+	if len(groups["a.dcm"].Linked)+len(groups["b.dcm"].Linked) != 1 {
+		t.Errorf("the PatientID-only group should join one of them: %v, %v", groups["a.dcm"].Files, groups["b.dcm"].Files)
+	}
+}
+
+func TestGroupFilesByPatientBlankPatientID(t *testing.T) {
+	groups := groupFiles(t, map[string]map[tag.Tag]string{
+		"a.dcm":    {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19800101", tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.1"},
+		"b.dcm":    {tag.PatientName: "DOE^JANE", tag.PatientBirthDate: "19700101", tag.StudyInstanceUID: "1.2.2", tag.SOPInstanceUID: "1.3.2"},
+		"weak.dcm": {tag.StudyInstanceUID: "1.2.1", tag.SOPInstanceUID: "1.3.3"},
+		"x.dcm":    {tag.StudyInstanceUID: "1.2.8", tag.SOPInstanceUID: "1.3.4"},
+		"y.dcm":    {tag.StudyInstanceUID: "1.2.9", tag.SOPInstanceUID: "1.3.5"},
+	})
+
+	// Patients without PatientID do not share the placeholder PID
+	if groups["a.dcm"] == groups["b.dcm"] || groups["a.dcm"].PID != "" {
+		t.Errorf("patients without PatientID grouped as %+v and %+v", groups["a.dcm"], groups["b.dcm"])
+	}
+
+	// mapper links through the PID otherwise
+	mapper, err := identity.NewPseudonymizationMapper("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := groups["a.dcm"].anonID(mapper)
+	b, _ := groups["b.dcm"].anonID(mapper)
+	if a == b {
+		t.Errorf("both patients mapped to %s", a)
+	}
+
+	// A file without identity joins the patient of its study only
+	if groups["weak.dcm"] != groups["a.dcm"] {
+		t.Errorf("weak.dcm grouped with %v, want with a.dcm", groups["weak.dcm"].Files)
+	}
+	if groups["x.dcm"] == groups["a.dcm"] || groups["x.dcm"] != groups["y.dcm"] {
+		t.Errorf("x.dcm grouped with %v, want the UNKNOWN group with y.dcm", groups["x.dcm"].Files)
+	}
+	if len(groups["a.dcm"].Linked) != 0 {
+		t.Errorf("weak group recorded as linked: %+v", groups["a.dcm"].Linked)
+	}
+}
+
+func TestDryRunShowsPIDFallbackReason(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	for name, values := range map[string]map[tag.Tag]string{
+		"a.dcm": {tag.PatientName: "UNKNOWN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"},
+		"b.dcm": {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19000101", tag.PatientID: "MRN2", tag.SOPInstanceUID: "1.3.2"},
+		"c.dcm": {tag.PatientName: "DOE^JANE", tag.PatientBirthDate: "19700101", tag.PatientID: "MRN3", tag.SOPInstanceUID: "1.3.3"},
+	} {
+		writeTestFile(t, filepath.Join(input, name), values)
+	}
+
+	var output strings.Builder
+	if _, err := ProcessFolder(Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		DryRun:          true,
+		OutputWriter:    func(s string) { output.WriteString(s) },
+	}); err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"PID 'MRN1' (1 files) [PID fallback: placeholder-name]",
+		"PID 'MRN2' (1 files) [PID fallback: placeholder-dob]",
+		"'DOE^JANE' + DOB (1 files) [identity match]",
+		"PID fallback reasons: 1 placeholder-dob, 1 placeholder-name",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("dry run output missing %q:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestDryRunShowsResumeSummary(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFile(t, filepath.Join(input, "a.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+	writeTestFile(t, filepath.Join(input, "b.dcm"), map[tag.Tag]string{tag.PatientID: "MRN2", tag.SOPInstanceUID: "1.3.2"})
+
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		OutputWriter:    func(string) {},
+	}
+	if _, err := ProcessFolder(cfg); err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	writeTestFile(t, filepath.Join(input, "c.dcm"), map[tag.Tag]string{tag.PatientID: "MRN3", tag.SOPInstanceUID: "1.3.3"})
+
+	var output strings.Builder
+	cfg.DryRun = true
+	cfg.OutputWriter = func(s string) { output.WriteString(s) }
+	stats, err := ProcessFolder(cfg)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	if stats.Resume == nil || *stats.Resume != (progress.WorkSummary{New: 1, Done: 2}) {
+		t.Errorf("Resume = %+v, want 1 new and 2 done", stats.Resume)
+	}
+	if want := "Resume: 1 new, 0 to retry, 0 changed, 2 already done"; !strings.Contains(output.String(), want) {
+		t.Errorf("dry run output missing %q:\n%s", want, output.String())
+	}
+}
+
+func TestProcessSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	file := filepath.Join(input, "sub", "IM0001.dcm")
+	writeTestFile(t, file, map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+	writeTestFile(t, filepath.Join(input, "sub", "IM0002.dcm"), map[tag.Tag]string{tag.PatientID: "MRN2", tag.SOPInstanceUID: "1.3.2"})
+
+	cfg := Config{
+		InputFolder:     file,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		OutputWriter:    func(string) {},
+	}
+	stats, err := ProcessFolder(cfg)
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	if stats.Success != 1 {
+		t.Errorf("Success = %d, want only the input file", stats.Success)
+	}
+
+	outputDir := filepath.Join(input, "sub", dcm.DefaultOutputDirName)
+	if got := cfg.OutputDir(); got != outputDir {
+		t.Errorf("OutputDir() = %q, want %q", got, outputDir)
+	}
+	written, err := filepath.Glob(filepath.Join(outputDir, "ANON-*", "*.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || filepath.Base(written[0]) != "IM0001.dcm" {
+		t.Errorf("wrote %v, want one ANON-*/IM0001.dcm", written)
+	}
+}
+
+func TestProcessFolderSkipsAnonymizedFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(input, "a.dcm"), map[tag.Tag]string{
+		tag.PatientID:      "MRN123",
+		tag.StudyDate:      "20240315",
+		tag.SOPInstanceUID: "1.2.3.4",
+	})
+
+	mappingFile := filepath.Join(dir, "patient_mapping.json")
+	run := func(input, output string, force bool) *Stats {
+		t.Helper()
+		stats, err := ProcessFolder(Config{
+			InputFolder:     input,
+			OutputFolder:    output,
+			MappingFile:     mappingFile,
+			Salt:            "secret",
+			ProcessMetadata: true,
+			DatePolicy:      DatePolicyShiftDays,
+			Force:           force,
+			Recursive:       true,
+			OutputWriter:    func(string) {},
+		})
+		if err != nil {
+			t.Fatalf("ProcessFolder failed: %v", err)
+		}
+		return stats
+	}
+
+	first := filepath.Join(dir, "first")
+	if stats := run(input, first, false); stats.Success != 1 {
+		t.Fatalf("first run = %+v, want 1 success", stats)
+	}
+	ds, err := dcm.ReadDicom(filepath.Join(first, "ANON-000001", "a.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.GetString(tag.PatientIdentityRemoved); got != "YES" {
+		t.Errorf("PatientIdentityRemoved = %q, want YES", got)
+	}
+
+	// Rerunning on the output skips it without adding ANON-000001 as a patient
+	stats := run(first, filepath.Join(dir, "second"), false)
+	if stats.Success != 0 || stats.Skipped != 1 || stats.SkippedAnonymized != 1 {
+		t.Errorf("rerun = %+v, want 1 already anonymized file skipped", stats)
+	}
+	mapper, err := identity.NewPseudonymizationMapper(mappingFile, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := mapper.GetStats().TotalPatients; got != 1 {
+		t.Errorf("mapping has %d patients after rerun, want 1", got)
+	}
+
+	if stats := run(first, filepath.Join(dir, "forced"), true); stats.Success != 1 {
+		t.Errorf("forced rerun = %+v, want 1 success", stats)
+	}
+}
+
+func TestProcessFolderProgressCountsRemainingWork(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writePatientFiles(t, input, 2, 2)
+
+	run := func() (*Stats, []int) {
+		t.Helper()
+		var totals []int
+		stats, err := ProcessFolderWithProgress(Config{
+			InputFolder:     input,
+			OutputFolder:    filepath.Join(dir, "output"),
+			MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+			Salt:            "secret",
+			ProcessMetadata: true,
+			OutputWriter:    func(string) {},
+		}, func(current, total int, filename, status string) {
+			if status != "processing" {
+				totals = append(totals, total)
+			}
+		})
+		if err != nil {
+			t.Fatalf("ProcessFolder failed: %v", err)
+		}
+		return stats, totals
+	}
+
+	if stats, totals := run(); stats.Success != 4 || len(totals) != 4 || totals[0] != 4 {
+		t.Fatalf("first run = %+v with totals %v, want 4 files", stats, totals)
+	}
+
+	// The resumed run only reports the new file
+	writeTestFile(t, filepath.Join(input, "new.dcm"), map[tag.Tag]string{
+		tag.PatientID:      "MRN9",
+		tag.SOPInstanceUID: "1.2.3.9",
+	})
+	stats, totals := run()
+	if len(totals) != 1 || totals[0] != 1 {
+		t.Errorf("resumed run reported totals %v, want [1]", totals)
+	}
+	if stats.Success != 1 || stats.Skipped != 4 || stats.SkippedResumed != 4 {
+		t.Errorf("resumed run = %+v, want 1 success and 4 resumed", stats)
+	}
+}
@@ -0,0 +1,271 @@
+package anonymizer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+//go:embed profiles/ps3.15-basic.json
+var basicConfidentialityJSON []byte
+
+// Action is a de-identification action code of DICOM PS3.15 Table E.1-1
+type Action string
+
+const (
+	ActionRemove Action = "X" // Remove the attribute
+	ActionZero   Action = "Z" // Replace with a zero-length value
+	ActionDummy  Action = "D" // Replace with a non-identifying dummy value
+	ActionKeep   Action = "K" // Keep the value
+	ActionUID    Action = "U" // Replace with a consistent pseudonymous UID
+)
+
+// RetainOption is a PS3.15 profile option that keeps a class of attributes
+// the Basic Profile would otherwise remove
+type RetainOption string
+
+const (
+	RetainDates                  RetainOption = "dates"        // Retain Longitudinal Temporal Information with Full Dates
+	RetainUIDs                   RetainOption = "uids"         // Retain UIDs
+	RetainDevice                 RetainOption = "device"       // Retain Device Identity
+	RetainPatientCharacteristics RetainOption = "patient"      // Retain Patient Characteristics
+	RetainInstitution            RetainOption = "institution"  // Retain Institution Identity
+	RetainSafePrivate            RetainOption = "safe-private" // Retain Safe Private (keeps SafePrivateCreators)
+)
+
+// retainOptionNames are the DeidentificationMethod values of the options,
+// the code meanings of DICOM CID 7050 (at most 64 characters, as LO requires)
+var retainOptionNames = map[RetainOption]string{
+	RetainDates:                  "Retain Longitudinal Temporal Information Full Dates Option",
+	RetainUIDs:                   "Retain UIDs Option",
+	RetainDevice:                 "Retain Device Identity Option",
+	RetainPatientCharacteristics: "Retain Patient Characteristics Option",
+	RetainInstitution:            "Retain Institution Identity Option",
+	RetainSafePrivate:            "Retain Safe Private Option",
+}
+
+// ParseRetainOptions splits a comma-separated list of retain options such
+// as "uids,dates" and rejects unknown names.
+func ParseRetainOptions(s string) ([]RetainOption, error) {
+	var options []RetainOption
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		option := RetainOption(name)
+		if _, ok := retainOptionNames[option]; !ok {
+			return nil, fmt.Errorf("unknown retain option %q (use dates, uids, device, patient, institution or safe-private)", name)
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// ConfidentialityAttribute is one row of the action table. Action may be a
+// composite code such as "X/Z/D", which leaves the choice to the
+// implementation depending on the attribute type in the IOD.
+type ConfidentialityAttribute struct {
+	Tag    string       `json:"tag"` // "gggg,eeee" (hex) or a DICOM keyword
+	Name   string       `json:"name"`
+	Action string       `json:"action"`
+	Retain RetainOption `json:"retain,omitempty"` // Option that keeps the attribute (empty = none)
+
+	tag tag.Tag
+}
+
+// ConfidentialityTable is the attribute action table of a de-identification
+// profile. The built-in table is BasicConfidentialityTable.
+type ConfidentialityTable struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description,omitempty"`
+	Attributes  []ConfidentialityAttribute `json:"attributes"`
+}
+
+// BasicConfidentialityTable returns the PS3.15 Table E.1-1 Basic
+// Application Level Confidentiality Profile.
+func BasicConfidentialityTable() *ConfidentialityTable {
+	t, err := ParseConfidentialityTable(basicConfidentialityJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded confidentiality table: %v", err))
+	}
+	return t
+}
+
+// ParseConfidentialityTable parses a JSON action table and resolves its tags.
+func ParseConfidentialityTable(data []byte) (*ConfidentialityTable, error) {
+	var t ConfidentialityTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+
+	for i := range t.Attributes {
+		a := &t.Attributes[i]
+		var err error
+		if a.tag, err = parseTag(a.Tag); err != nil {
+			return nil, err
+		}
+		if resolveAction(a.Action) == "" {
+			return nil, fmt.Errorf("%s: invalid action %q", a.Tag, a.Action)
+		}
+		if _, ok := retainOptionNames[a.Retain]; a.Retain != "" && !ok {
+			return nil, fmt.Errorf("%s: unknown retain option %q", a.Tag, a.Retain)
+		}
+	}
+	return &t, nil
+}
+
+// resolveAction picks a single action from a table code. Composite codes
+// resolve to the least destructive choice that is valid for any attribute
+// type: a dummy value satisfies Type 1, a zero-length value Type 2.
+// "U*" (replace UIDs inside the sequence) keeps the sequence for remapping.
+func resolveAction(code string) Action {
+	choices := strings.Split(code, "/")
+	for _, preferred := range []Action{ActionUID, ActionDummy, ActionZero, ActionRemove, ActionKeep} {
+		for _, c := range choices {
+			if Action(strings.TrimSuffix(strings.TrimSpace(c), "*")) == preferred {
+				return preferred
+			}
+		}
+	}
+	return ""
+}
+
+// without returns a copy of the table without the rows of tags, which the
+// profile then leaves as they are.
+func (t *ConfidentialityTable) without(tags []tag.Tag) *ConfidentialityTable {
+	c := *t
+	c.Attributes = nil
+	for _, a := range t.Attributes {
+		if !containsTag(tags, a.tag) {
+			c.Attributes = append(c.Attributes, a)
+		}
+	}
+	return &c
+}
+
+// retains reports whether an option is selected
+func retains(retain []RetainOption, option RetainOption) bool {
+	for _, r := range retain {
+		if r == option {
+			return true
+		}
+	}
+	return false
+}
+
+// CleanPixelDataMethod is the DeidentificationMethod value of files whose
+// burned-in text was redacted under the PS3.15 profile
+const CleanPixelDataMethod = "Clean Pixel Data Option"
+
+// methodCodes are the DICOM CID 7050 code values of the
+// DeidentificationMethod values written under the PS3.15 profile
+var methodCodes = map[string]string{
+	"Basic Application Confidentiality Profile":                      "113100",
+	CleanPixelDataMethod:                                             "113101",
+	"Retain Longitudinal Temporal Information Full Dates Option":     "113106",
+	"Retain Longitudinal Temporal Information Modified Dates Option": "113107",
+	"Retain Patient Characteristics Option":                          "113108",
+	"Retain Device Identity Option":                                  "113109",
+	"Retain UIDs Option":                                             "113110",
+	"Retain Safe Private Option":                                     "113111",
+	"Retain Institution Identity Option":                             "113112",
+}
+
+// MethodCodes returns the DeidentificationMethodCodeSequence items of the
+// DeidentificationMethod values that are CID 7050 code meanings, in order
+func MethodCodes(methods []string) []dcm.CodeItem {
+	var codes []dcm.CodeItem
+	for _, method := range methods {
+		if value, ok := methodCodes[method]; ok {
+			codes = append(codes, dcm.CodeItem{Value: value, Scheme: "DCM", Meaning: method})
+		}
+	}
+	return codes
+}
+
+// ConfidentialityMethod returns the DeidentificationMethod values describing
+// the profile and options applied, e.g. "Basic Application Confidentiality
+// Profile" and "Retain UIDs Option".
+func ConfidentialityMethod(retain []RetainOption, dates DatePolicy) []string {
+	method := []string{"Basic Application Confidentiality Profile"}
+	for _, r := range retain {
+		method = append(method, retainOptionNames[r])
+	}
+	if dates == DatePolicyShiftDays && !retains(retain, RetainDates) {
+		method = append(method, "Retain Longitudinal Temporal Information Modified Dates Option")
+	}
+	return method
+}
+
+// apply de-identifies every attribute of the table, including those inside
+// sequence items, removes private tags and overlays at every level (only
+// the numbers of the safe private blocks are retained), and records the method
+// in PatientIdentityRemoved, DeidentificationMethod and its code sequence.
+// Dates are shifted
+// instead of removed with DatePolicyShiftDays (the Modified Dates Option);
+// UIDs are kept when uids is nil.
+func (t *ConfidentialityTable) apply(ds *dcm.Dataset, retain []RetainOption, dates DateHandling, uids *identity.UIDMapper) {
+	t.applyElements(ds, retain, dates, uids)
+	ds.WalkSequences(func(item *dcm.Dataset) {
+		t.applyElements(item, retain, dates, uids)
+	})
+
+	// Private attributes and overlays are X in Table E.1-1
+	var creators []string
+	if retains(retain, RetainSafePrivate) {
+		creators = SafePrivateCreators
+	}
+	ds.RemovePrivateTags(creators)
+	ds.RemovePrivateText(creators)
+	ds.RemoveOverlays()
+
+	ds.PutString(tag.PatientIdentityRemoved, "YES")
+	method := ConfidentialityMethod(retain, dates.Policy)
+	ds.WriteDeidentificationMethod(method, MethodCodes(method))
+}
+
+// applyElements applies the table actions to the top-level elements of ds.
+// Sequences with "U*" are kept; the UIDs in their items are replaced when
+// the items are visited.
+func (t *ConfidentialityTable) applyElements(ds *dcm.Dataset, retain []RetainOption, dates DateHandling, uids *identity.UIDMapper) {
+	for _, a := range t.Attributes {
+		action := resolveAction(a.Action)
+		if a.Retain != "" && retains(retain, a.Retain) {
+			action = ActionKeep
+		}
+
+		elem, err := ds.Data.FindElementByTag(a.tag)
+		if err != nil {
+			continue
+		}
+
+		if a.Retain == RetainDates && action != ActionKeep && dates.Policy == DatePolicyShiftDays &&
+			elem.RawValueRepresentation == "DA" {
+			ds.ShiftDate(a.tag, dates.ShiftDays)
+			continue
+		}
+
+		switch action {
+		case ActionRemove:
+			ds.RemoveTag(a.tag)
+		case ActionZero:
+			ds.ZeroTag(a.tag)
+		case ActionDummy:
+			ds.DummyTag(a.tag)
+		case ActionUID:
+			if uids == nil || elem.ValueRepresentation == tag.VRSequence {
+				continue
+			}
+			if original := ds.GetString(a.tag); original != "" {
+				ds.SetString(a.tag, uids.Map(original))
+			}
+		}
+	}
+}
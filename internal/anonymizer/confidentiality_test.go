@@ -0,0 +1,160 @@
+package anonymizer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+func TestBasicConfidentialityTable(t *testing.T) {
+	table := BasicConfidentialityTable()
+	if len(table.Attributes) < 200 {
+		t.Errorf("table has %d attributes, want the full Table E.1-1 set", len(table.Attributes))
+	}
+
+	for code, want := range map[string]Action{"X": ActionRemove, "X/Z": ActionZero, "Z/D": ActionDummy, "X/Z/U*": ActionUID, "Q": ""} {
+		if got := resolveAction(code); got != want {
+			t.Errorf("resolveAction(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestConfidentialityProfile(t *testing.T) {
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t     tag.Tag
+		value string
+	}{
+		{tag.SOPInstanceUID, "1.2.3.4"},
+		{tag.StudyDate, "20240315"},
+		{tag.AccessionNumber, "ACC123"},
+		{tag.InstitutionName, "General Hospital"},
+		{tag.StationName, "CT01"},
+		{tag.OperatorsName, "TECH^TOM"},
+		{tag.PatientName, "SMITH^JOHN"},
+		{tag.PatientID, "MRN123"},
+		{tag.PatientAddress, "1 Main St"},
+	} {
+		elem, err := dicom.NewElement(e.t, []string{e.value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+
+	uids := identity.NewUIDMapper(filepath.Join(t.TempDir(), "mapping.json"), "secret", "")
+	FileOptions{
+		PatientID:       "ANON-000001",
+		UIDs:            uids,
+		Dates:           DateHandling{Policy: DatePolicyShiftDays, ShiftDays: 10},
+		Confidentiality: true,
+		Retain:          []RetainOption{RetainInstitution},
+	}.applyTo(ds)
+
+	for tg, want := range map[tag.Tag]string{
+		tag.PatientName:            "",
+		tag.PatientID:              "ANON-000001",
+		tag.AccessionNumber:        "",
+		tag.StudyDate:              "20240325",
+		tag.InstitutionName:        "General Hospital",
+		tag.StationName:            "ANONYMOUS",
+		tag.OperatorsName:          "ANONYMOUS",
+		tag.PatientIdentityRemoved: "YES",
+	} {
+		if got := ds.GetString(tg); got != want {
+			t.Errorf("%v = %q, want %q", tg, got, want)
+		}
+	}
+	if _, err := ds.Data.FindElementByTag(tag.PatientAddress); err == nil {
+		t.Error("PatientAddress was not removed")
+	}
+	if got := ds.GetString(tag.SOPInstanceUID); got == "1.2.3.4" || got == "" {
+		t.Errorf("SOPInstanceUID = %q, want a remapped UID", got)
+	}
+
+	elem, err := ds.Data.FindElementByTag(tag.DeidentificationMethod)
+	if err != nil {
+		t.Fatal("DeidentificationMethod not written")
+	}
+	method := strings.Join(elem.Value.GetValue().([]string), "\\")
+	for _, want := range []string{"Basic Application Confidentiality Profile", "Retain Institution Identity Option", "Modified Dates Option"} {
+		if !strings.Contains(method, want) {
+			t.Errorf("DeidentificationMethod = %q, missing %q", method, want)
+		}
+	}
+	var codes []string
+	ds.WalkSequences(func(item *dcm.Dataset) {
+		codes = append(codes, item.GetString(tag.CodeValue))
+	})
+	if got := strings.Join(codes, ","); got != "113100,113112,113107" {
+		t.Errorf("DeidentificationMethodCodeSequence codes = %s, want 113100,113112,113107", got)
+	}
+
+	// Added elements keep the dataset in tag order
+	for i := 1; i < len(ds.Data.Elements); i++ {
+		a, b := ds.Data.Elements[i-1].Tag, ds.Data.Elements[i].Tag
+		if a.Group > b.Group || (a.Group == b.Group && a.Element > b.Element) {
+			t.Errorf("elements out of order: %v before %v", a, b)
+		}
+	}
+}
+
+func TestConfidentialityProfileCleansSequenceItems(t *testing.T) {
+	text := func(tg tag.Tag, value string) *dicom.Element {
+		v, err := dicom.NewValue([]string{value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dicom.Element{Tag: tg, ValueRepresentation: tag.VRString, RawValueRepresentation: "LO", Value: v}
+	}
+	overlay, err := dicom.NewValue([]int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ReferencedImageSequence > item > an ACME block, a kept block with a
+	// number and a name, and an overlay
+	newSequence := func() *dicom.Element {
+		sequence, err := dicom.NewElement(tag.ReferencedImageSequence, [][]*dicom.Element{{
+			text(tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT"),
+			text(tag.Tag{Group: 0x0009, Element: 0x1001}, "SMITH^JOHN"),
+			text(tag.Tag{Group: 0x0019, Element: 0x0010}, "GEMS_ACQU_01"),
+			text(tag.Tag{Group: 0x0019, Element: 0x1023}, "1.5"),
+			text(tag.Tag{Group: 0x0019, Element: 0x1024}, "SMITH HEAD COIL"),
+			{Tag: tag.Tag{Group: 0x6000, Element: 0x0010}, ValueRepresentation: tag.VRUInt16List, RawValueRepresentation: "US", Value: overlay},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sequence
+	}
+
+	for _, tt := range []struct {
+		retain []RetainOption
+		want   []tag.Tag
+	}{
+		{nil, nil},
+		{[]RetainOption{RetainSafePrivate}, []tag.Tag{{Group: 0x0019, Element: 0x0010}, {Group: 0x0019, Element: 0x1023}}},
+	} {
+		ds := &dcm.Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{newSequence()}}}
+		FileOptions{PatientID: "ANON-000001", Confidentiality: true, Retain: tt.retain}.applyTo(ds)
+
+		var left []tag.Tag
+		ds.WalkSequences(func(item *dcm.Dataset) {
+			for _, elem := range item.Data.Elements {
+				if elem.Tag.Group%2 == 1 || elem.Tag.Group == 0x6000 {
+					left = append(left, elem.Tag)
+				}
+			}
+		})
+		if len(left) != len(tt.want) || (len(left) == 2 && (left[0] != tt.want[0] || left[1] != tt.want[1])) {
+			t.Errorf("retain %v: private and overlay tags left in items = %v, want %v", tt.retain, left, tt.want)
+		}
+	}
+}
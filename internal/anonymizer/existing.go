@@ -0,0 +1,69 @@
+package anonymizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExistingPolicy controls what ProcessFolder does when the output file of
+// an input already exists, e.g. from a partial run without progress
+type ExistingPolicy string
+
+const (
+	ExistingOverwrite ExistingPolicy = "overwrite" // Replace the existing file (default)
+	ExistingSkip      ExistingPolicy = "skip"      // Leave it and count the input as skipped
+	ExistingError     ExistingPolicy = "error"     // Leave it and fail the input
+	ExistingRename    ExistingPolicy = "rename"    // Write IM0001_1.dcm, IM0001_2.dcm, ... instead
+)
+
+// ErrOutputExists is returned for files whose output already exists with
+// ExistingError
+var ErrOutputExists = errors.New("output file already exists")
+
+// ParseExistingPolicy validates a policy name (empty = ExistingOverwrite)
+func ParseExistingPolicy(s string) (ExistingPolicy, error) {
+	switch policy := ExistingPolicy(s); policy {
+	case "":
+		return ExistingOverwrite, nil
+	case ExistingOverwrite, ExistingSkip, ExistingError, ExistingRename:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid existing output policy %q (use overwrite, skip, error, or rename)", s)
+}
+
+// existingOutput applies policy to out: it returns the path to write, or
+// "" when the input is to be skipped, or an error wrapping
+// ErrOutputExists. ExistingRename reserves the name it returns by creating
+// it empty and exclusively, so that workers renaming outputs at the same
+// time never pick the same name; the caller removes it if writing fails.
+func existingOutput(out string, policy ExistingPolicy) (string, error) {
+	if policy == "" || policy == ExistingOverwrite {
+		return out, nil
+	}
+	if _, err := os.Lstat(out); os.IsNotExist(err) {
+		return out, nil
+	}
+
+	switch policy {
+	case ExistingSkip:
+		return "", nil
+	case ExistingRename:
+		ext := filepath.Ext(out)
+		base := strings.TrimSuffix(out, ext)
+		for i := 1; ; i++ {
+			renamed := fmt.Sprintf("%s_%d%s", base, i, ext)
+			file, err := os.OpenFile(renamed, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err == nil {
+				file.Close()
+				return renamed, nil
+			}
+			if !os.IsExist(err) {
+				return "", fail(FailureWrite, "could not reserve %s: %w", renamed, err)
+			}
+		}
+	}
+	return "", fail(FailureWrite, "%w: %s", ErrOutputExists, out)
+}
@@ -0,0 +1,137 @@
+package anonymizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func TestOnExisting(t *testing.T) {
+	for _, tt := range []struct {
+		policy      ExistingPolicy
+		success     int
+		skipped     int
+		failed      int
+		prior       bool   // The existing output is left unchanged
+		renamedFile string // File written instead of the existing one
+	}{
+		{policy: ExistingOverwrite, success: 1},
+		{policy: ExistingSkip, skipped: 1, prior: true},
+		{policy: ExistingError, failed: 1, prior: true},
+		{policy: ExistingRename, success: 1, prior: true, renamedFile: "IM0001_2.dcm"},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input")
+			writeTestFile(t, filepath.Join(input, "IM0001.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+
+			cfg := Config{
+				InputFolder:     input,
+				MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+				Salt:            "secret",
+				ProcessMetadata: true,
+				OnExisting:      tt.policy,
+				OutputWriter:    func(string) {},
+			}
+
+			// A prior run wrote the output, then its progress was lost
+			if _, err := ProcessFolder(cfg); err != nil {
+				t.Fatalf("first run failed: %v", err)
+			}
+			written, _ := filepath.Glob(filepath.Join(cfg.OutputDir(), "ANON-*", "IM0001.dcm"))
+			if len(written) != 1 {
+				t.Fatalf("first run wrote %v, want one IM0001.dcm", written)
+			}
+			existing := written[0]
+			if err := os.WriteFile(existing, []byte("prior output"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Taken, so the renamed file gets the next free number
+			if err := os.WriteFile(filepath.Join(filepath.Dir(existing), "IM0001_1.dcm"), []byte("other"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(filepath.Join(cfg.OutputDir(), ".progress.json")); err != nil {
+				t.Fatal(err)
+			}
+
+			stats, err := ProcessFolder(cfg)
+			if err != nil {
+				t.Fatalf("second run failed: %v", err)
+			}
+			if stats.Success != tt.success || stats.Skipped != tt.skipped || stats.Failed != tt.failed {
+				t.Errorf("got %d succeeded, %d skipped, %d failed; want %d, %d, %d",
+					stats.Success, stats.Skipped, stats.Failed, tt.success, tt.skipped, tt.failed)
+			}
+			if tt.policy == ExistingSkip && stats.SkippedExisting != 1 {
+				t.Errorf("SkippedExisting = %d, want 1", stats.SkippedExisting)
+			}
+			if tt.policy == ExistingError && (len(stats.Failures) != 1 || stats.Failures[0].Category != string(FailureWrite)) {
+				t.Errorf("Failures = %+v, want one write failure", stats.Failures)
+			}
+
+			data, err := os.ReadFile(existing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(data) == "prior output"; got != tt.prior {
+				t.Errorf("existing output kept = %v, want %v", got, tt.prior)
+			}
+			if tt.renamedFile != "" {
+				if _, err := os.Stat(filepath.Join(filepath.Dir(existing), tt.renamedFile)); err != nil {
+					t.Errorf("renamed output not written: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestExistingOutputError(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "IM0001.dcm")
+	if err := os.WriteFile(out, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := existingOutput(out, ExistingError); !errors.Is(err, ErrOutputExists) {
+		t.Errorf("existingOutput() error = %v, want ErrOutputExists", err)
+	}
+	if _, err := ParseExistingPolicy("replace"); err == nil {
+		t.Error("ParseExistingPolicy accepted an unknown policy")
+	}
+}
+
+func TestExistingOutputRenameReservesNames(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "IM0001.dcm")
+	if err := os.WriteFile(out, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Workers renaming at the same time each get a name of their own
+	names := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name, err := existingOutput(out, ExistingRename)
+			if err != nil {
+				t.Errorf("existingOutput() error = %v", err)
+			}
+			names[i] = name
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			t.Errorf("%s returned twice", name)
+		}
+		seen[name] = true
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s not reserved: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package anonymizer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// TagChange is one edit the anonymizer would make to a file. Values are
+// never included, only whether they are present and how long they are.
+type TagChange struct {
+	Tag    string // Keyword, with the enclosing sequences for nested tags
+	Before string // e.g. "present (10 chars)"
+	Change string // "removed", "cleared", "truncated", "shifted", "hashed", "remapped", "replaced", "added"
+}
+
+// String formats the change as "PatientName: present (10 chars) → cleared"
+func (c TagChange) String() string {
+	return fmt.Sprintf("%s: %s → %s", c.Tag, c.Before, c.Change)
+}
+
+// FileExplanation lists the tag edits for one file
+type FileExplanation struct {
+	Path    string
+	Changes []TagChange
+	Error   string // Why the file could not be explained (empty = ok)
+}
+
+// ExplainFiles reports the tag edits cfg would make to the first limit
+// files without writing anything. The mapping files are read but never
+// saved, so new patients get the IDs they would get in a real run.
+func ExplainFiles(cfg Config, files []string, limit int) ([]FileExplanation, error) {
+	mapper, err := identity.NewPseudonymizationMapperWithLogger(cfg.MappingFile, cfg.Salt, cfg.Logger)
+	if err != nil {
+		return nil, err
+	}
+	if err := mapper.SetIDFormat(cfg.IDPrefix, cfg.IDFormat); err != nil {
+		return nil, err
+	}
+	if err := mapper.SetUIDRoot(cfg.UIDRoot); err != nil {
+		return nil, err
+	}
+	if cfg.FuzzyNameMatching {
+		mapper.EnableFuzzyNames(cfg.Nicknames)
+	}
+	mapper.SetDeferSave(true) // Never flushed
+
+	if limit < len(files) {
+		files = files[:limit]
+	}
+	patients, _ := groupFilesByPatient(files, cfg, func(string) {})
+	return explainPatients(cfg, patients, mapper, len(files)), nil
+}
+
+// explainPatients explains up to limit files of the patients. UIDs are
+// remapped with a mapper that is never saved.
+func explainPatients(cfg Config, patients []*PatientGroup, mapper *identity.PseudonymizationMapper, limit int) []FileExplanation {
+	uids := identity.NewUIDMapperWithLogger(identity.UIDMappingFile(cfg.MappingFile), cfg.Salt, mapper.UIDRoot(), cfg.Logger)
+	profile := cfg.tagProfile()
+
+	var explanations []FileExplanation
+	for _, patient := range patients {
+		anonID, _ := patient.anonID(mapper)
+		opts := cfg.fileOptions(anonID, profile, uids, mapper)
+		for _, path := range patient.Files {
+			if len(explanations) >= limit {
+				return explanations
+			}
+			explanation := FileExplanation{Path: path}
+			changes, err := ExplainFile(path, opts)
+			if err != nil {
+				explanation.Error = err.Error()
+			}
+			explanation.Changes = changes
+			explanations = append(explanations, explanation)
+		}
+	}
+	return explanations
+}
+
+// ExplainFile applies opts to the metadata of a file in memory and returns
+// the tags that changed, in file order.
+func ExplainFile(path string, opts FileOptions) ([]TagChange, error) {
+	ds, err := dcm.ReadDicomMetadataOnly(path)
+	if err != nil {
+		return nil, err
+	}
+
+	before := snapshotElements(ds.Data.Elements, "")
+	opts.applyTo(ds)
+	after := snapshotElements(ds.Data.Elements, "")
+
+	afterByPath := make(map[string]elementSnapshot, len(after))
+	for _, s := range after {
+		afterByPath[s.path] = s
+	}
+	beforeByPath := make(map[string]bool, len(before))
+	for _, s := range before {
+		beforeByPath[s.path] = true
+	}
+
+	var changes []TagChange
+	for _, b := range before {
+		a, ok := afterByPath[b.path]
+		if ok && a.value == b.value {
+			continue
+		}
+		change := TagChange{Tag: b.path, Before: b.describe()}
+		switch {
+		case !ok:
+			change.Change = "removed"
+		case a.empty():
+			change.Change = "cleared"
+		default:
+			change.Change = opts.describeRewrite(b)
+		}
+		changes = append(changes, change)
+	}
+	for _, a := range after {
+		if !beforeByPath[a.path] {
+			changes = append(changes, TagChange{Tag: a.path, Before: "absent", Change: "added"})
+		}
+	}
+	return changes, nil
+}
+
+// describeRewrite names the kind of rewrite applied to a changed value
+func (o FileOptions) describeRewrite(s elementSnapshot) string {
+	profile := o.Profile
+	if profile == nil {
+		profile = DefaultTagProfile()
+	}
+
+	switch {
+	case s.tag == tag.PatientID:
+		return "replaced with anonymous ID"
+	case s.vr == "UI":
+		return "remapped"
+	case !o.Confidentiality && containsTag(profile.HashTags(), s.tag):
+		return "hashed"
+	case s.vr == "DA" && o.Dates.Policy == DatePolicyShiftDays:
+		return "shifted"
+	case s.vr == "DA" && !o.Confidentiality:
+		return "truncated"
+	}
+	return "replaced"
+}
+
+// elementSnapshot is the value of one element, flattened with its path
+type elementSnapshot struct {
+	path  string
+	tag   tag.Tag
+	vr    string
+	value string // Comparable rendering of the value
+	size  int    // Characters, bytes or items
+	unit  string
+}
+
+// empty reports whether the value is zero-length
+func (s elementSnapshot) empty() bool {
+	return s.size == 0
+}
+
+// describe returns a length indicator that does not reveal the value
+func (s elementSnapshot) describe() string {
+	if s.size == 0 {
+		return "empty"
+	}
+	unit := s.unit
+	if s.size == 1 {
+		unit = strings.TrimSuffix(unit, "s")
+	}
+	return fmt.Sprintf("present (%d %s)", s.size, unit)
+}
+
+// snapshotElements flattens elements and the items of their sequences.
+// Nested paths look like "ReferencedPatientSequence[0] > PatientName".
+func snapshotElements(elements []*dicom.Element, prefix string) []elementSnapshot {
+	var snapshots []elementSnapshot
+	for _, elem := range elements {
+		if elem.Tag == tag.PixelData {
+			continue
+		}
+		path := prefix + tagName(elem.Tag)
+		s := elementSnapshot{path: path, tag: elem.Tag, vr: elem.RawValueRepresentation}
+
+		var items []*dicom.SequenceItemValue
+		if elem.Value != nil {
+			switch v := elem.Value.GetValue().(type) {
+			case []string:
+				joined := strings.Join(v, "\\")
+				s.value, s.size, s.unit = joined, len(strings.TrimSpace(joined)), "chars"
+			case []byte:
+				s.value, s.size, s.unit = string(v), len(v), "bytes"
+			case []*dicom.SequenceItemValue:
+				items = v
+				s.value, s.size, s.unit = fmt.Sprintf("%d items", len(v)), len(v), "items"
+			default:
+				s.value = fmt.Sprintf("%v", v)
+				s.size, s.unit = len(s.value), "chars"
+			}
+		}
+		snapshots = append(snapshots, s)
+
+		for i, item := range items {
+			if itemElems, ok := item.GetValue().([]*dicom.Element); ok {
+				snapshots = append(snapshots, snapshotElements(itemElems, fmt.Sprintf("%s[%d] > ", path, i))...)
+			}
+		}
+	}
+	return snapshots
+}
+
+// tagName returns the DICOM keyword of a tag, or "(gggg,eeee)" if unknown
+func tagName(t tag.Tag) string {
+	if info, err := tag.Find(t); err == nil && info.Name != "" {
+		return info.Name
+	}
+	return fmt.Sprintf("(%04X,%04X)", t.Group, t.Element)
+}
+
+// formatExplanations renders explanations for the dry run output
+func formatExplanations(explanations []FileExplanation) string {
+	var b strings.Builder
+	for _, e := range explanations {
+		fmt.Fprintf(&b, "\n  %s\n", filepath.Base(e.Path))
+		if e.Error != "" {
+			fmt.Fprintf(&b, "    Error: %s\n", e.Error)
+			continue
+		}
+		if len(e.Changes) == 0 {
+			b.WriteString("    (no changes)\n")
+		}
+		for _, c := range e.Changes {
+			fmt.Fprintf(&b, "    %s\n", c)
+		}
+	}
+	return b.String()
+}
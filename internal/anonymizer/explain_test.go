@@ -0,0 +1,44 @@
+package anonymizer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func TestExplainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.dcm")
+	writeTestFile(t, path, map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.StudyDate:      "20240315",
+		tag.SOPInstanceUID: "1.2.3.4",
+	})
+
+	changes, err := ExplainFile(path, FileOptions{PatientID: "ANON-000001"})
+	if err != nil {
+		t.Fatalf("ExplainFile failed: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, c := range changes {
+		got[c.Tag] = c.String()
+		if strings.Contains(c.String(), "SMITH") || strings.Contains(c.String(), "MRN123") {
+			t.Errorf("change %q reveals the original value", c)
+		}
+	}
+	for name, want := range map[string]string{
+		"PatientName": "PatientName: present (10 chars) → cleared",
+		"PatientID":   "PatientID: present (6 chars) → replaced with anonymous ID",
+		"StudyDate":   "StudyDate: present (8 chars) → truncated",
+	} {
+		if got[name] != want {
+			t.Errorf("%s change = %q, want %q", name, got[name], want)
+		}
+	}
+	if _, ok := got["SOPInstanceUID"]; ok {
+		t.Error("SOPInstanceUID reported as changed without a UID mapper")
+	}
+}
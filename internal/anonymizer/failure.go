@@ -0,0 +1,71 @@
+package anonymizer
+
+import (
+	"errors"
+	"fmt"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/progress"
+)
+
+// FailureCategory classifies why a file could not be anonymized, so the
+// error log and summary can tell e.g. a missing dcmtk from corrupt files
+type FailureCategory string
+
+const (
+	FailureDcmtkMissing FailureCategory = "dcmtk-missing" // JPEG-LS file and dcmtk is not installed
+	FailureParse        FailureCategory = "parse"         // Not readable as DICOM
+	FailureDecompress   FailureCategory = "decompress"    // JPEG-LS (dcmtk) or RLE decompression failed, or a compression that cannot be decoded
+	FailureNoPixelData  FailureCategory = "no-pixel-data" // Ultrasound file without usable pixel data
+	FailureRedaction    FailureCategory = "redaction"     // Text detection or pixel redaction failed
+	FailureWrite        FailureCategory = "write"         // Re-compression or writing the output failed
+	FailureOther        FailureCategory = "other"         // Anything not returned by the anonymizer itself
+)
+
+// FileError is an error anonymizing a file with its FailureCategory
+type FileError struct {
+	Category FailureCategory
+	Err      error
+}
+
+func (e *FileError) Error() string { return e.Err.Error() }
+func (e *FileError) Unwrap() error { return e.Err }
+
+// fail returns a FileError of category with the formatted message. A
+// FileError wrapped with %w keeps its own, more specific category.
+func fail(category FailureCategory, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	var inner *FileError
+	if errors.As(err, &inner) {
+		category = inner.Category
+	}
+	return &FileError{Category: category, Err: err}
+}
+
+// CategoryOf returns the FailureCategory of err. Errors caused by a missing
+// dcmtk are FailureDcmtkMissing whatever step they came from.
+func CategoryOf(err error) FailureCategory {
+	if errors.Is(err, dcm.ErrDcmtkNotInstalled) {
+		return FailureDcmtkMissing
+	}
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		return fileErr.Category
+	}
+	return FailureOther
+}
+
+// DcmtkFailureHint returns how many of failures installing dcmtk would fix
+// and how to install it, or "" if none failed for lack of dcmtk
+func DcmtkFailureHint(failures []progress.ErrorEntry) string {
+	missing := 0
+	for _, entry := range failures {
+		if entry.Category == string(FailureDcmtkMissing) {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Installing dcmtk would fix %d of %d failures: %s", missing, len(failures), dcm.DcmtkInstallHint())
+}
@@ -0,0 +1,56 @@
+package anonymizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/progress"
+)
+
+func TestFailureCategories(t *testing.T) {
+	dir := t.TempDir()
+	notDicom := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notDicom, []byte("not a DICOM file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	noPixels := filepath.Join(dir, "nopixels.dcm")
+	writeTestFile(t, noPixels, map[tag.Tag]string{tag.Modality: "US"})
+	opts := FileOptions{PatientID: "ANON-000001"}
+
+	tests := []struct {
+		name string
+		err  error
+		want FailureCategory
+	}{
+		{"metadata parse", AnonymizeMetadata(notDicom, filepath.Join(dir, "out1.dcm"), opts), FailureParse},
+		{"ultrasound parse", AnonymizeUltrasound(notDicom, filepath.Join(dir, "out2.dcm"), 75, nil, opts), FailureParse},
+		{"no pixel data", AnonymizeUltrasound(noPixels, filepath.Join(dir, "out3.dcm"), 75, nil, opts), FailureNoPixelData},
+		{"dcmtk missing", fail(FailureDecompress, "JPEG-LS decompression failed: %w", fmt.Errorf("%w. hint", dcm.ErrDcmtkNotInstalled)), FailureDcmtkMissing},
+		{"untyped", fmt.Errorf("context canceled"), FailureOther},
+	}
+	for _, tt := range tests {
+		if got := CategoryOf(tt.err); got != tt.want {
+			t.Errorf("%s: CategoryOf(%v) = %q, want %q", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestDcmtkFailureHint(t *testing.T) {
+	failures := []progress.ErrorEntry{
+		{File: "a.dcm", Category: string(FailureDcmtkMissing)},
+		{File: "b.dcm", Category: string(FailureDcmtkMissing)},
+		{File: "c.dcm", Category: string(FailureParse)},
+	}
+	if hint := DcmtkFailureHint(failures); !strings.HasPrefix(hint, "Installing dcmtk would fix 2 of 3 failures") {
+		t.Errorf("hint = %q", hint)
+	}
+	if hint := DcmtkFailureHint(failures[2:]); hint != "" {
+		t.Errorf("hint without dcmtk failures = %q", hint)
+	}
+}
@@ -0,0 +1,161 @@
+package anonymizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// Method is how a file was handled by AnonymizeFile
+type Method string
+
+const (
+	MethodMetadata          Method = "metadata"           // Metadata anonymized (CT/MRI/X-Ray)
+	MethodUltrasound        Method = "ultrasound"         // Metadata anonymized and burned-in pixels redacted
+	MethodMetadataFallback  Method = "metadata-fallback"  // Ultrasound with metadata anonymized but pixels NOT redacted (see Config.AllowMetadataOnlyFallback)
+	MethodSkipped           Method = "skipped"            // ProcessMetadata or ProcessUltrasound is off for this file
+	MethodSkippedModality   Method = "skipped-modality"   // Modality not in Config.Modalities
+	MethodSkippedAnonymized Method = "skipped-anonymized" // Already marked PatientIdentityRemoved=YES (see Config.Force)
+	MethodSkippedExisting   Method = "skipped-existing"   // Output already exists (see Config.OnExisting)
+	MethodSkippedSize       Method = "skipped-size"       // Larger than Config.MaxFileSize
+)
+
+// AnonymizeFile anonymizes the DICOM file in and writes it to out. It is
+// the stable API for embedding the anonymizer in other Go programs, which
+// call it through package anonymize. It is an entry point parallel to
+// ExecutePlan: both run anonymizeFile, which decides between metadata-only
+// and ultrasound processing and honours the tag, date, private tag and
+// redaction settings of cfg. ExecutePlan calls anonymizeFile directly, as
+// it supplies per-patient settings from the mapping file and the metadata
+// read while grouping; AnonymizeFile never walks folders or reads or
+// writes the mapping, UID mapping or progress files.
+//
+// cfg.AnonID is written as the PatientID and is required; callers manage
+// their own IDs. out is always overwritten; cfg.OnExisting only applies to
+// ProcessFolder. UIDs are replaced with UIDs derived from cfg.Salt and
+// shifted dates use the offset derived from cfg.AnonID and cfg.Salt, so
+// the file is the same as ProcessFolder with that salt writes for a
+// patient given the same ID. Files skipped by cfg are not written and
+// return MethodSkipped, MethodSkippedModality, MethodSkippedAnonymized or
+// MethodSkippedSize. With cfg.AllowMetadataOnlyFallback, ultrasound files
+// that could not be redacted return MethodMetadataFallback.
+func AnonymizeFile(in, out string, cfg Config) (Method, error) {
+	if cfg.AnonID == "" {
+		return "", fmt.Errorf("AnonID is required")
+	}
+
+	if cfg.UIDRoot != "" {
+		if err := identity.ValidateUIDRoot(cfg.UIDRoot); err != nil {
+			return "", err
+		}
+	}
+	if _, err := NewScrubber(cfg.ScrubPatterns, cfg.ScrubPatientName); err != nil {
+		return "", err
+	}
+	uids := identity.NewUIDMapperWithLogger("", cfg.Salt, cfg.UIDRoot, cfg.Logger)
+	opts := cfg.fileOptions(cfg.AnonID, cfg.tagProfile(), uids, nil)
+	cfg.OnExisting = ExistingOverwrite
+	_, method, err := anonymizeFile(in, out, cfg, opts, nil)
+	return method, err
+}
+
+// readMetadata parses the metadata of a file. Benchmarks replace it to
+// count parses.
+var readMetadata = dcm.ReadDicomMetadataOnly
+
+// fileMetadata is what grouping learned about a file, so processing does
+// not parse it again
+type fileMetadata struct {
+	Readable        bool // False if the metadata could not be parsed
+	Modality        string
+	Ultrasound      bool
+	IdentityRemoved bool // PatientIdentityRemoved is YES
+	SOPInstanceUID  string
+	FrameSize       int64 // Bytes of one uncompressed frame
+
+	// Why the file's Name+DOB cannot identify the patient (ReasonValid if
+	// it can)
+	IdentityReason identity.IdentityReason
+}
+
+// loadFileMetadata reads the fileMetadata of a file
+func loadFileMetadata(path string) (fileMetadata, *dcm.Dataset) {
+	ds, err := readMetadata(path)
+	if err != nil {
+		return fileMetadata{}, nil
+	}
+	_, reason := identity.CheckIdentity(ds.GetPatientName(), ds.GetPatientBirthDate())
+	return fileMetadata{
+		Readable:        true,
+		Modality:        ds.GetModality(),
+		Ultrasound:      ds.IsUltrasound(),
+		IdentityRemoved: strings.EqualFold(strings.TrimSpace(ds.GetString(tag.PatientIdentityRemoved)), "YES"),
+		SOPInstanceUID:  strings.TrimSpace(ds.GetString(tag.SOPInstanceUID)),
+		FrameSize:       ds.FrameSize(),
+		IdentityReason:  reason,
+	}, ds
+}
+
+// anonymizeFile processes one file with the per-patient opts and returns
+// the path written, which differs from out with ExistingRename. Ultrasound
+// gets pixel redaction, and files over cfg.MaxFileSize, outside
+// cfg.Modalities, already anonymized or with an existing output under
+// ExistingSkip are skipped.
+// Unreadable files fall through and fail in the anonymizer with a real
+// error. meta is read from the file when nil.
+func anonymizeFile(in, out string, cfg Config, opts FileOptions, meta *fileMetadata) (string, Method, error) {
+	if cfg.MaxFileSize > 0 {
+		if info, err := os.Stat(in); err == nil && info.Size() > cfg.MaxFileSize {
+			return "", MethodSkippedSize, nil
+		}
+	}
+	isUS := false
+	if cfg.ProcessUltrasound || len(cfg.Modalities) > 0 || !cfg.Force {
+		if meta == nil {
+			loaded, _ := loadFileMetadata(in)
+			meta = &loaded
+		}
+		if meta.Readable {
+			if meta.IdentityRemoved && !cfg.Force {
+				return "", MethodSkippedAnonymized, nil
+			}
+			if !cfg.modalitySelected(meta.Modality) {
+				return "", MethodSkippedModality, nil
+			}
+			isUS = meta.Ultrasound
+		}
+	}
+
+	if !(isUS && cfg.ProcessUltrasound) && !cfg.ProcessMetadata {
+		return "", MethodSkipped, nil
+	}
+	path, err := existingOutput(out, cfg.OnExisting)
+	if err != nil {
+		return "", "", err
+	}
+	if path == "" {
+		return "", MethodSkippedExisting, nil
+	}
+
+	method := MethodMetadata
+	if isUS && cfg.ProcessUltrasound {
+		method = MethodUltrasound
+		err = AnonymizeUltrasound(in, path, cfg.RedactRows, cfg.RedactRegions, opts)
+		if errors.Is(err, ErrPixelsNotRedacted) {
+			method, err = MethodMetadataFallback, nil
+		}
+	} else {
+		err = AnonymizeMetadata(in, path, opts)
+	}
+	// Do not leave the name reserved by ExistingRename behind empty
+	if err != nil && path != out {
+		os.Remove(path)
+	}
+	return path, method, err
+}
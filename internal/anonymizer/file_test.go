@@ -0,0 +1,263 @@
+package anonymizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+func TestAnonymizeFile(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.dcm")
+	writeTestFile(t, in, map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.StudyDate:      "20240315",
+		tag.SOPInstanceUID: "1.2.3.4.5.6",
+		tag.Modality:       "CT",
+	})
+
+	cfg := Config{
+		AnonID:          "STUDY-0042",
+		Salt:            "secret",
+		ProcessMetadata: true,
+		DatePolicy:      DatePolicyShiftDays,
+	}
+	out := filepath.Join(dir, "out", "a.dcm")
+	method, err := AnonymizeFile(in, out, cfg)
+	if err != nil || method != MethodMetadata {
+		t.Fatalf("AnonymizeFile = %q, %v; want %q", method, err, MethodMetadata)
+	}
+
+	ds, err := dcm.ReadDicom(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ds.GetPatientID(); got != "STUDY-0042" {
+		t.Errorf("PatientID = %q, want STUDY-0042", got)
+	}
+	if got := ds.GetPatientName(); got != "" {
+		t.Errorf("PatientName = %q, want cleared", got)
+	}
+	if got, want := ds.GetString(tag.SOPInstanceUID), identity.DeriveUID("1.2.3.4.5.6", "secret", identity.DefaultUIDRoot); got != want {
+		t.Errorf("SOPInstanceUID = %q, want %q", got, want)
+	}
+	shift := identity.CreateDateShift("STUDY-0042", "secret")
+	want := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC).AddDate(0, 0, shift).Format("20060102")
+	if got := ds.GetString(tag.StudyDate); got != want {
+		t.Errorf("StudyDate = %q, want %q", got, want)
+	}
+
+	// No mapping, UID mapping or progress files
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries, want only in.dcm and out", len(entries))
+	}
+
+	cfg.Modalities = []string{"MR"}
+	skipped := filepath.Join(dir, "skipped.dcm")
+	if method, err := AnonymizeFile(in, skipped, cfg); err != nil || method != MethodSkippedModality {
+		t.Errorf("AnonymizeFile with other modality = %q, %v; want %q", method, err, MethodSkippedModality)
+	}
+	if _, err := os.Stat(skipped); !os.IsNotExist(err) {
+		t.Error("skipped file was written")
+	}
+
+	cfg.Modalities = nil
+	again := filepath.Join(dir, "again.dcm")
+	if method, err := AnonymizeFile(out, again, cfg); err != nil || method != MethodSkippedAnonymized {
+		t.Errorf("AnonymizeFile on its output = %q, %v; want %q", method, err, MethodSkippedAnonymized)
+	}
+	cfg.Force = true
+	if method, err := AnonymizeFile(out, again, cfg); err != nil || method != MethodMetadata {
+		t.Errorf("AnonymizeFile with Force = %q, %v; want %q", method, err, MethodMetadata)
+	}
+
+	cfg.AnonID = ""
+	if _, err := AnonymizeFile(in, out, cfg); err == nil {
+		t.Error("AnonymizeFile without AnonID succeeded")
+	}
+}
+
+func TestAnonymizeFileMatchesProcessFolder(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	in := filepath.Join(input, "in.dcm")
+	writeTestFile(t, in, map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.StudyDate:      "20240315",
+		tag.SOPInstanceUID: "1.2.3.4.5.6",
+		tag.Modality:       "CT",
+	})
+
+	cfg := DefaultConfig()
+	cfg.InputFolder = input
+	cfg.OutputFolder = filepath.Join(dir, "output")
+	cfg.MappingFile = filepath.Join(dir, "patient_mapping.json")
+	cfg.Salt = "secret"
+	cfg.ProcessMetadata = true
+	cfg.DatePolicy = DatePolicyShiftDays
+	cfg.OutputWriter = func(string) {}
+	if stats, err := ProcessFolder(cfg); err != nil || stats.Success != 1 {
+		t.Fatalf("ProcessFolder = %+v, %v; want 1 file", stats, err)
+	}
+	var folderOut string
+	filepath.Walk(cfg.OutputFolder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".dcm" {
+			folderOut = path
+		}
+		return err
+	})
+	ds, err := dcm.ReadDicom(folderOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.AnonID = ds.GetPatientID()
+	out := filepath.Join(dir, "out.dcm")
+	if method, err := AnonymizeFile(in, out, cfg); err != nil || method != MethodMetadata {
+		t.Fatalf("AnonymizeFile = %q, %v; want %q", method, err, MethodMetadata)
+	}
+	want, err := os.ReadFile(folderOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("AnonymizeFile with anonymous ID %q wrote %d bytes that differ from the %d bytes of ProcessFolder", cfg.AnonID, len(got), len(want))
+	}
+}
+
+// countMetadataReads counts readMetadata calls until the test ends
+func countMetadataReads(tb testing.TB) *int64 {
+	var reads int64
+	orig := readMetadata
+	readMetadata = func(path string) (*dcm.Dataset, error) {
+		atomic.AddInt64(&reads, 1)
+		return orig(path)
+	}
+	tb.Cleanup(func() { readMetadata = orig })
+	return &reads
+}
+
+// writePatientFiles writes files for patients with filesEach files each
+func writePatientFiles(tb testing.TB, dir string, patients, filesEach int) {
+	for p := 0; p < patients; p++ {
+		for f := 0; f < filesEach; f++ {
+			writeTestFile(tb, filepath.Join(dir, fmt.Sprintf("p%d_%d.dcm", p, f)), map[tag.Tag]string{
+				tag.PatientID:      fmt.Sprintf("MRN%d", p),
+				tag.SOPInstanceUID: fmt.Sprintf("1.2.3.%d.%d", p, f),
+				tag.Modality:       "CT",
+			})
+		}
+	}
+}
+
+func TestProcessFolderReadsMetadataOnce(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writePatientFiles(t, input, 3, 4)
+
+	reads := countMetadataReads(t)
+	stats, err := ProcessFolder(Config{
+		InputFolder:       input,
+		OutputFolder:      filepath.Join(dir, "output"),
+		MappingFile:       filepath.Join(dir, "patient_mapping.json"),
+		Salt:              "secret",
+		ProcessMetadata:   true,
+		ProcessUltrasound: true,
+		OutputWriter:      func(string) {},
+	})
+	if err != nil || stats.Success != 12 {
+		t.Fatalf("ProcessFolder = %+v, %v; want 12 files", stats, err)
+	}
+	if *reads != 12 {
+		t.Errorf("metadata read %d times for 12 files, want once per file", *reads)
+	}
+}
+
+func BenchmarkProcessFolderMetadataReads(b *testing.B) {
+	const files = 300
+	input := filepath.Join(b.TempDir(), "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		b.Fatal(err)
+	}
+	writePatientFiles(b, input, 30, files/30)
+
+	reads := countMetadataReads(b)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out := b.TempDir()
+		_, err := ProcessFolder(Config{
+			InputFolder:       input,
+			OutputFolder:      filepath.Join(out, "output"),
+			MappingFile:       filepath.Join(out, "patient_mapping.json"),
+			Salt:              "secret",
+			ProcessMetadata:   true,
+			ProcessUltrasound: true,
+			OutputWriter:      func(string) {},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(reads))/float64(b.N*files), "parses/file")
+}
+
+func TestMaxFileSizeSkipsOversizedFiles(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFile(t, filepath.Join(input, "small.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+
+	// A valid header followed by 4 GB of zeros, kept sparse on disk.
+	// Parsing it would read the whole file.
+	huge := filepath.Join(input, "huge.dcm")
+	writeTestFile(t, huge, map[tag.Tag]string{tag.PatientID: "MRN2", tag.SOPInstanceUID: "1.3.2"})
+	if err := os.Truncate(huge, 4<<30); err != nil {
+		t.Skipf("cannot create a sparse file: %v", err)
+	}
+
+	reads := countMetadataReads(t)
+	stats, err := ProcessFolder(Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		MaxFileSize:     1 << 20,
+		OutputWriter:    func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	if stats.Success != 1 || stats.SkippedSize != 1 || stats.Failed != 0 {
+		t.Errorf("got %d succeeded, %d skipped for size, %d failed; want 1, 1, 0", stats.Success, stats.SkippedSize, stats.Failed)
+	}
+	if n := atomic.LoadInt64(reads); n != 1 {
+		t.Errorf("metadata read %d times, want only the small file", n)
+	}
+
+	method, err := AnonymizeFile(huge, filepath.Join(dir, "out.dcm"), Config{
+		AnonID: "ANON-000001", Salt: "secret", ProcessMetadata: true, MaxFileSize: 1 << 20,
+	})
+	if err != nil || method != MethodSkippedSize {
+		t.Errorf("AnonymizeFile = %q, %v; want %q", method, err, MethodSkippedSize)
+	}
+}
@@ -0,0 +1,77 @@
+package anonymizer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"dicom-anonymizer/internal/identity"
+)
+
+// FilenamePolicy controls the names of output files below their patient
+// folder. Input file and folder names can hold patient names or accession
+// numbers; the policies other than FilenamePreserveRelative drop them.
+type FilenamePolicy string
+
+const (
+	FilenamePreserveRelative FilenamePolicy = "preserve"   // Mirror the path below the input folder (default)
+	FilenameBySOPUID         FilenamePolicy = "sop-uid"    // <SOPInstanceUID>.dcm, the remapped UID unless UIDs are retained
+	FilenameSequential       FilenamePolicy = "sequential" // 00001.dcm, 00002.dcm, ... in the order of the patient's files
+)
+
+// ParseFilenamePolicy validates a policy name (empty = FilenamePreserveRelative)
+func ParseFilenamePolicy(s string) (FilenamePolicy, error) {
+	switch policy := FilenamePolicy(s); policy {
+	case "":
+		return FilenamePreserveRelative, nil
+	case FilenamePreserveRelative, FilenameBySOPUID, FilenameSequential:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid filename policy %q (use preserve, sop-uid, or sequential)", s)
+}
+
+// outputName returns the path of an output file below its patient folder.
+// relPath is the input file relative to the input folder, number its
+// FilenameSequential number and sopUID its original SOPInstanceUID. Files
+// without a SOPInstanceUID are named by a salted hash of relPath under
+// FilenameBySOPUID.
+func (cfg Config) outputName(relPath string, number int, sopUID string, uids *identity.UIDMapper) string {
+	switch cfg.FilenamePolicy {
+	case FilenameSequential:
+		return fmt.Sprintf("%05d.dcm", number)
+	case FilenameBySOPUID:
+		sopUID = strings.TrimSpace(sopUID)
+		if sopUID == "" {
+			return identity.CreateValueHash(relPath, cfg.Salt) + ".dcm"
+		}
+		if uids != nil && !(cfg.ConfidentialityProfile && retains(cfg.RetainOptions, RetainUIDs)) {
+			sopUID = uids.Map(sopUID)
+		}
+		return sopUID + ".dcm"
+	}
+	return filepath.Clean(relPath)
+}
+
+// highestSequentialNumber returns the highest FilenameSequential number in
+// folder, 0 when it holds none, so that a later run adding files to a
+// patient numbers them after the outputs of earlier runs instead of
+// overwriting them
+func highestSequentialNumber(folder string) int {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return 0
+	}
+	highest := 0
+	for _, entry := range entries {
+		digits, ok := strings.CutSuffix(entry.Name(), ".dcm")
+		if !ok || len(digits) < 5 || strings.TrimLeft(digits, "0123456789") != "" {
+			continue
+		}
+		if n, err := strconv.Atoi(digits); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest
+}
@@ -0,0 +1,168 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestFilenamePolicies(t *testing.T) {
+	for _, tt := range []struct {
+		policy FilenamePolicy
+		want   []string // Output names, sorted; nil = check against the SOPInstanceUIDs
+	}{
+		{policy: FilenameSequential, want: []string{"00001.dcm", "00002.dcm", "00003.dcm"}},
+		{policy: FilenameBySOPUID},
+	} {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input")
+			for name, uid := range map[string]string{
+				"SMITH_JOHN/ACC12345_1.dcm": "1.3.1",
+				"SMITH_JOHN/ACC12345_2.dcm": "1.3.2",
+				"SMITH_JOHN/ACC67890.dcm":   "",
+			} {
+				values := map[tag.Tag]string{tag.PatientID: "MRN1"}
+				if uid != "" {
+					values[tag.SOPInstanceUID] = uid
+				}
+				writeTestFile(t, filepath.Join(input, name), values)
+			}
+
+			cfg := Config{
+				InputFolder:     input,
+				Recursive:       true,
+				MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+				Salt:            "secret",
+				ProcessMetadata: true,
+				FilenamePolicy:  tt.policy,
+				OutputWriter:    func(string) {},
+			}
+			stats, err := ProcessFolder(cfg)
+			if err != nil {
+				t.Fatalf("ProcessFolder failed: %v", err)
+			}
+			if stats.Success != 3 {
+				t.Fatalf("Success = %d, want 3", stats.Success)
+			}
+
+			var names []string
+			err = filepath.Walk(cfg.OutputDir(), func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || !strings.HasSuffix(path, ".dcm") {
+					return err
+				}
+				rel, _ := filepath.Rel(cfg.OutputDir(), path)
+				for _, leak := range []string{"SMITH", "JOHN", "ACC"} {
+					if strings.Contains(rel, leak) {
+						t.Errorf("output %s contains %q from the input path", rel, leak)
+					}
+				}
+				if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) != 2 {
+					t.Errorf("output %s is not directly in its patient folder", rel)
+				}
+				names = append(names, filepath.Base(path))
+
+				if tt.policy == FilenameBySOPUID {
+					ds, err := dcm.ReadDicomMetadataOnly(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if uid := ds.GetString(tag.SOPInstanceUID); uid != "" && filepath.Base(path) != uid+".dcm" {
+						t.Errorf("output %s holds SOPInstanceUID %s", rel, uid)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(names)
+			if tt.want != nil && strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("output names = %v, want %v", names, tt.want)
+			}
+			if len(names) != 3 {
+				t.Errorf("wrote %d files, want 3: %v", len(names), names)
+			}
+		})
+	}
+}
+
+func TestSequentialNamesContinueAcrossRuns(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFile(t, filepath.Join(input, "b.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.2"})
+	writeTestFile(t, filepath.Join(input, "c.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.3"})
+
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		FilenamePolicy:  FilenameSequential,
+		OutputWriter:    func(string) {},
+	}
+	if _, err := ProcessFolder(cfg); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+
+	// A file sorting first must not take over 00001.dcm
+	writeTestFile(t, filepath.Join(input, "a.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+	stats, err := ProcessFolder(cfg)
+	if err != nil {
+		t.Fatalf("second run failed: %v", err)
+	}
+	if stats.Success != 1 {
+		t.Fatalf("Success = %d, want 1 (the others were resumed)", stats.Success)
+	}
+
+	outputs, _ := filepath.Glob(filepath.Join(cfg.OutputDir(), "*", "*.dcm"))
+	uids := make(map[string]string)
+	for _, path := range outputs {
+		ds, err := dcm.ReadDicomMetadataOnly(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids[filepath.Base(path)] = ds.GetString(tag.SOPInstanceUID)
+	}
+	if len(uids) != 3 {
+		t.Fatalf("wrote %v, want 3 files", uids)
+	}
+	if uids["00001.dcm"] == uids["00003.dcm"] || uids["00002.dcm"] == uids["00003.dcm"] {
+		t.Errorf("second run overwrote an earlier output: %v", uids)
+	}
+}
+
+func TestSOPUIDNamesDoNotCollide(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	for _, name := range []string{"a.dcm", "b.dcm"} {
+		writeTestFile(t, filepath.Join(input, name), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+	}
+
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		FilenamePolicy:  FilenameBySOPUID,
+		OutputWriter:    func(string) {},
+	}
+	stats, err := ProcessFolder(cfg)
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	if stats.Success != 2 {
+		t.Fatalf("Success = %d, want 2", stats.Success)
+	}
+	outputs, _ := filepath.Glob(filepath.Join(cfg.OutputDir(), "*", "*.dcm"))
+	if len(outputs) != 2 {
+		t.Errorf("wrote %v, want 2 files", outputs)
+	}
+}
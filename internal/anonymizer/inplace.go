@@ -0,0 +1,82 @@
+package anonymizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dicom-anonymizer/internal/fsutil"
+)
+
+// InPlaceBackupSuffix is appended to the name of the original kept by
+// Config.InPlaceBackup, e.g. IM0001.dcm.orig
+const InPlaceBackupSuffix = ".orig"
+
+// inPlaceTempSuffix ends the temporary files written next to originals
+// before they are renamed over them
+const inPlaceTempSuffix = ".anon-tmp"
+
+// checkInPlace rejects the settings that are unsafe with Config.InPlace
+func (cfg Config) checkInPlace() error {
+	if !cfg.InPlace {
+		return nil
+	}
+	if cfg.Force {
+		return errors.New("in-place anonymization cannot be combined with Force: anonymized files would be anonymized again")
+	}
+	if cfg.WriteManifest {
+		return errors.New("in-place anonymization cannot write a manifest: there is no output folder of anonymized files")
+	}
+	return nil
+}
+
+// withoutInPlaceFiles drops the backups and temporary files of earlier
+// in-place runs, which are DICOM files too but must never be overwritten
+func withoutInPlaceFiles(files []string) []string {
+	kept := files[:0]
+	for _, filePath := range files {
+		if !strings.HasSuffix(filePath, InPlaceBackupSuffix) && !strings.HasSuffix(filePath, inPlaceTempSuffix) {
+			kept = append(kept, filePath)
+		}
+	}
+	return kept
+}
+
+// anonymizeInPlace anonymizes in over itself for Config.InPlace. The
+// output goes to a temporary file next to in that is renamed over in only
+// once complete, so a crash leaves either the original or the anonymized
+// file. The tracker fingerprints the file after the rename, so a rerun
+// sees it as done; files skipped for being anonymized already keep the
+// run idempotent when the progress file is lost.
+func anonymizeInPlace(in string, cfg Config, opts FileOptions, meta *fileMetadata) (string, Method, error) {
+	info, err := os.Stat(in)
+	if err != nil {
+		return "", "", fail(FailureParse, "%w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(in), "."+filepath.Base(in)+".*"+inPlaceTempSuffix)
+	if err != nil {
+		return "", "", fail(FailureWrite, "%w", err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	cfg.OnExisting = ExistingOverwrite
+	out, method, err := anonymizeFile(in, tmpPath, cfg, opts, meta)
+	if err != nil || out == "" {
+		return "", method, err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return "", method, fail(FailureWrite, "%w", err)
+	}
+
+	var backup string
+	if cfg.InPlaceBackup {
+		backup = in + InPlaceBackupSuffix
+	}
+	if err := fsutil.ReplaceFile(in, tmpPath, backup); err != nil {
+		return "", method, fail(FailureWrite, "could not replace the original: %w", err)
+	}
+	return in, method, nil
+}
@@ -0,0 +1,81 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	file := filepath.Join(input, "a.dcm")
+	writeTestFile(t, file, map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		InPlace:         true,
+		InPlaceBackup:   true,
+		OutputWriter:    func(string) {},
+	}
+	stats, err := ProcessFolder(cfg)
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	if stats.Success != 1 {
+		t.Fatalf("Success = %d, want 1", stats.Success)
+	}
+
+	ds, err := dcm.ReadDicom(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := ds.GetString(tag.PatientID); !strings.HasPrefix(pid, "ANON-") {
+		t.Errorf("PatientID = %q, want the original overwritten with an anonymous ID", pid)
+	}
+	backup, err := dcm.ReadDicom(file + InPlaceBackupSuffix)
+	if err != nil {
+		t.Fatalf("no backup: %v", err)
+	}
+	if pid := backup.GetString(tag.PatientID); pid != "MRN1" {
+		t.Errorf("backup PatientID = %q, want the original MRN1", pid)
+	}
+	entries, _ := os.ReadDir(input)
+	if len(entries) != 3 { // a.dcm, its backup and the output folder with the progress file
+		t.Errorf("input has %d entries, want no temporary files left", len(entries))
+	}
+	if written, _ := filepath.Glob(filepath.Join(cfg.OutputDir(), "ANON-*")); len(written) != 0 {
+		t.Errorf("wrote patient folders %v in place", written)
+	}
+
+	// Reruns leave the anonymized file and its backup alone, also without
+	// the progress file
+	for _, clean := range []bool{false, true} {
+		if clean {
+			os.Remove(filepath.Join(cfg.OutputDir(), ".progress.json"))
+		}
+		stats, err := ProcessFolder(cfg)
+		if err != nil {
+			t.Fatalf("rerun failed: %v", err)
+		}
+		if stats.Success != 0 || stats.Failed != 0 {
+			t.Errorf("rerun (progress removed: %v): %d succeeded, %d failed, want nothing processed", clean, stats.Success, stats.Failed)
+		}
+	}
+	if backup, _ := dcm.ReadDicom(file + InPlaceBackupSuffix); backup == nil || backup.GetString(tag.PatientID) != "MRN1" {
+		t.Error("rerun changed the backup")
+	}
+
+	cfg.Force = true
+	if _, err := ProcessFolder(cfg); err == nil {
+		t.Error("InPlace with Force was accepted")
+	}
+}
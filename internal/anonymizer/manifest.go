@@ -0,0 +1,150 @@
+package anonymizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dicom-anonymizer/internal/identity"
+)
+
+// ManifestFileName is the manifest written to the output folder with
+// Config.WriteManifest
+const ManifestFileName = "manifest.json"
+
+// ManifestFormatVersion is bumped whenever a field of Manifest is renamed
+// or removed. Added fields do not change it.
+const ManifestFormatVersion = 1
+
+// Manifest lists the anonymized files of an output folder with their
+// checksums, so recipients can verify them without the mapping file. It
+// only holds output paths and anonymous IDs, never original identifiers.
+type Manifest struct {
+	FormatVersion int            `json:"format_version"`
+	ToolVersion   string         `json:"tool_version"`
+	Created       string         `json:"created"` // RFC 3339
+	Files         []ManifestFile `json:"files"`   // Sorted by path
+}
+
+// ManifestFile is one anonymized file. Its Path is relative to the output
+// folder, with forward slashes. Below the patient folder it is the file's
+// name when FilenameSequential or FilenameBySOPUID wrote it; any other
+// name, e.g. one mirroring input folders under FilenamePreserveRelative,
+// is replaced by a salted hash of it, so such files are found by SHA256.
+type ManifestFile struct {
+	Path   string `json:"path"`
+	AnonID string `json:"anon_id"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // Hex encoded
+}
+
+// BuildManifest checksums the anonymized files in outputFolder, including
+// those written by earlier runs. Anonymized files live in one folder per
+// anonymous ID; files directly in outputFolder, such as errors.log, and
+// hidden files are left out. salt hashes the names that may hold original
+// identifiers (see ManifestFile).
+func BuildManifest(outputFolder, salt string) (*Manifest, error) {
+	manifest := &Manifest{
+		FormatVersion: ManifestFormatVersion,
+		ToolVersion:   Version,
+		Created:       time.Now().Format(time.RFC3339),
+		Files:         []ManifestFile{},
+	}
+
+	err := filepath.WalkDir(outputFolder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != outputFolder {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(outputFolder, path)
+		if err != nil {
+			return err
+		}
+		anonID, name, nested := strings.Cut(filepath.ToSlash(rel), "/")
+		if !nested {
+			return nil
+		}
+		if !anonymousName(name) {
+			name = identity.CreateValueHash(name, salt) + ".dcm"
+		}
+
+		size, sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, ManifestFile{
+			Path:   anonID + "/" + name,
+			AnonID: anonID,
+			Size:   size,
+			SHA256: sum,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build manifest: %w", err)
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
+}
+
+// anonymousName reports whether name, the path of a file below its patient
+// folder, is one FilenameSequential or FilenameBySOPUID writes: digits and
+// dots, or a 16 character value hash, followed by .dcm
+func anonymousName(name string) bool {
+	base, ok := strings.CutSuffix(name, ".dcm")
+	if !ok || base == "" {
+		return false
+	}
+	if strings.TrimLeft(base, "0123456789.") == "" {
+		return true
+	}
+	return len(base) == 16 && strings.TrimLeft(base, "0123456789ABCDEF") == ""
+}
+
+// fileChecksum returns the size and hex SHA-256 of a file
+func fileChecksum(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteManifest writes manifest as indented JSON to path.
+func WriteManifest(path string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	return nil
+}
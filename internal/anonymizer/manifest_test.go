@@ -0,0 +1,109 @@
+package anonymizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/internal/identity"
+)
+
+func TestProcessFolderWritesManifest(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.MkdirAll(filepath.Join(input, "SMITH_JOHN"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(input, "SMITH_JOHN", "a.dcm"), map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.SOPInstanceUID: "1.2.3.4.5.6",
+	})
+
+	cfg := Config{
+		InputFolder:     input,
+		OutputFolder:    filepath.Join(dir, "output"),
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		Recursive:       true,
+		WriteManifest:   true,
+		OutputWriter:    func(string) {},
+	}
+	if _, err := ProcessFolder(cfg); err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cfg.OutputFolder, ManifestFileName))
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	for _, original := range []string{"SMITH", "MRN123", "1.2.3.4.5.6"} {
+		if strings.Contains(string(data), original) {
+			t.Errorf("manifest contains original identifier %q", original)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if manifest.FormatVersion != ManifestFormatVersion || len(manifest.Files) != 1 {
+		t.Fatalf("manifest = %+v, want one file", manifest)
+	}
+	// The preserved path names the patient, so only its hash is listed
+	file := manifest.Files[0]
+	want := "ANON-000001/" + identity.CreateValueHash("SMITH_JOHN/a.dcm", "secret") + ".dcm"
+	if file.Path != want || file.AnonID != "ANON-000001" {
+		t.Errorf("file = %+v, want %s", file, want)
+	}
+
+	output, err := os.ReadFile(filepath.Join(cfg.OutputFolder, "ANON-000001", "SMITH_JOHN", "a.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(output)
+	if file.SHA256 != hex.EncodeToString(sum[:]) || file.Size != int64(len(output)) {
+		t.Errorf("checksum/size = %s/%d, want %x/%d", file.SHA256, file.Size, sum, len(output))
+	}
+}
+
+func TestManifestHashesOriginalNames(t *testing.T) {
+	output := t.TempDir()
+	names := map[string]string{
+		"00001.dcm":            "ANON-000001/00001.dcm",
+		"2.25.1234.dcm":        "ANON-000001/2.25.1234.dcm",
+		"3FA85F6457174562.dcm": "ANON-000001/3FA85F6457174562.dcm",
+		"SMITH_JOHN.dcm":       "ANON-000001/" + identity.CreateValueHash("SMITH_JOHN.dcm", "secret") + ".dcm",
+		"visit_2024/00002.dcm": "ANON-000001/" + identity.CreateValueHash("visit_2024/00002.dcm", "secret") + ".dcm",
+	}
+	for name := range names {
+		path := filepath.Join(output, "ANON-000001", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest, err := BuildManifest(output, "secret")
+	if err != nil {
+		t.Fatalf("BuildManifest failed: %v", err)
+	}
+	got := map[string]bool{}
+	for _, file := range manifest.Files {
+		got[file.Path] = true
+	}
+	for name, want := range names {
+		if !got[want] {
+			t.Errorf("%s: %s not in manifest %+v", name, want, manifest.Files)
+		}
+	}
+}
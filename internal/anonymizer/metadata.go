@@ -4,29 +4,189 @@ import (
 	"github.com/suyashkumar/dicom/pkg/tag"
 
 	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/logging"
 )
 
+// DateHandling describes how a patient's date tags are rewritten
+type DateHandling struct {
+	Policy    DatePolicy
+	ShiftDays int // Offset used with DatePolicyShiftDays
+}
+
+// apply rewrites the given date tags according to the policy.
+func (h DateHandling) apply(ds *dcm.Dataset, tags []tag.Tag) {
+	for _, t := range tags {
+		switch h.Policy {
+		case DatePolicyShiftDays:
+			ds.ShiftDate(t, h.ShiftDays)
+		case DatePolicyRemove:
+			ds.ClearTag(t)
+		default:
+			ds.TruncateDate(t)
+		}
+	}
+}
+
+// FileOptions holds the per-file settings shared by AnonymizeMetadata and
+// AnonymizeUltrasound
+type FileOptions struct {
+	PatientID string              // Anonymous ID written to PatientID
+	UIDs      *identity.UIDMapper // Remaps study/series/instance UIDs (nil = keep UIDs)
+	Dates     DateHandling
+	Profile   *TagProfile // Tags to clear, hash and date-handle (nil = DefaultTagProfile)
+	Salt      string      // Salt for hashed tags
+
+	RemovePrivateTags bool     // Drop private (odd group) elements
+	PrivateCreators   []string // Private blocks kept by RemovePrivateTags (nil = SafePrivateCreators)
+	RemoveOverlays    bool     // Drop overlay planes (groups 60xx)
+
+	// Apply the PS3.15 Basic Profile instead of Profile, layering the
+	// Retain options. Private tags and overlays are always removed.
+	Confidentiality bool
+	Retain          []RetainOption
+
+	// Keep CalibrationTags under either profile, even where a custom
+	// profile clears them
+	PreserveCalibration bool
+
+	// Redacts names and other patterns in free text the profile keeps
+	// (nil = none)
+	Scrub *Scrubber
+
+	TextDetector TextDetector   // Finds burned-in text to redact in ultrasound frames (nil = none)
+	Logger       logging.Logger // Warnings about the file, e.g. values truncated to their VR (nil = none)
+
+	// AnonymizeUltrasound writes JPEG-LS files without redaction when
+	// dcmtk is missing (see Config.AllowMetadataOnlyFallback)
+	MetadataOnlyFallback bool
+
+	pixelsNotRedacted bool // Adds DeidentificationMethodPixelsNotRedacted
+	pixelsCleaned     bool // Adds CleanPixelDataMethod under the PS3.15 profile
+
+	// Reports JPEG-LS re-encoding progress; returning false aborts the
+	// write (see dcm.SaveOptions.Progress)
+	encodeProgress func(done, total int) bool
+}
+
+// DeidentificationMethodProfile is the DeidentificationMethod of files
+// anonymized with a tag profile rather than the PS3.15 profile
+const DeidentificationMethodProfile = "dicom-anonymizer tag profile"
+
+// DeidentificationMethodPixelsNotRedacted is added to the
+// DeidentificationMethod of files whose burned-in pixels were left as they
+// are by the metadata-only fallback
+const DeidentificationMethodPixelsNotRedacted = "burned-in pixels not redacted"
+
+// deidentificationMethod returns the DeidentificationMethod values of the
+// file given those of the profile applied
+func (o FileOptions) deidentificationMethod(method ...string) []string {
+	if o.pixelsNotRedacted {
+		method = append(method, DeidentificationMethodPixelsNotRedacted)
+	}
+	return method
+}
+
+// identityRemoved returns the PatientIdentityRemoved value of the file.
+// Burned-in pixels left by the metadata-only fallback may still show the
+// patient, so those files are marked NO and later runs do not skip them
+// as already anonymized.
+func (o FileOptions) identityRemoved() string {
+	if o.pixelsNotRedacted {
+		return "NO"
+	}
+	return "YES"
+}
+
+// applyTo rewrites the identifying metadata of a dataset.
+func (o FileOptions) applyTo(ds *dcm.Dataset) {
+	ds.Logger = o.Logger
+
+	// Before the profile, which clears the name and writes the anonymous
+	// ID and DeidentificationMethod, values a pattern must not touch
+	o.Scrub.scrub(ds, ds.GetPatientName())
+
+	if o.Confidentiality {
+		table := BasicConfidentialityTable()
+		if o.PreserveCalibration {
+			table = table.without(CalibrationTags)
+		}
+		table.apply(ds, o.Retain, o.Dates, o.UIDs)
+		ds.PutString(tag.PatientIdentityRemoved, o.identityRemoved())
+		// The anonymous ID is the profile's dummy PatientID
+		ds.PutString(tag.PatientID, o.PatientID)
+		method := ConfidentialityMethod(o.Retain, o.Dates.Policy)
+		if o.pixelsCleaned {
+			method = append(method, CleanPixelDataMethod)
+		}
+		method = o.deidentificationMethod(method...)
+		ds.WriteDeidentificationMethod(method, MethodCodes(method))
+		return
+	}
+
+	// Set anonymized patient ID
+	ds.SetString(tag.PatientID, o.PatientID)
+
+	// Replace UIDs that link back to the source study
+	remapUIDs(ds, o.UIDs)
+
+	// Clear/hash PII tags and truncate, shift or remove dates
+	profile := o.Profile
+	if profile == nil {
+		profile = DefaultTagProfile()
+	}
+	if o.PreserveCalibration {
+		profile = profile.withKept(CalibrationTags...)
+	}
+	profile.apply(ds, o.Dates, o.Salt)
+
+	// Vendors routinely copy patient data into private tags, also inside
+	// sequence items. Of the built-in safe blocks only numbers are kept;
+	// blocks the caller lists are kept whole.
+	if o.RemovePrivateTags {
+		creators := o.PrivateCreators
+		if creators == nil {
+			creators = SafePrivateCreators
+			ds.RemovePrivateText(creators)
+		}
+		ds.RemovePrivateTags(creators)
+	}
+	if o.RemoveOverlays {
+		ds.RemoveOverlays()
+	}
+
+	// Lets later runs skip the file (see Config.Force)
+	ds.PutString(tag.PatientIdentityRemoved, o.identityRemoved())
+	// The tag profile is no standard profile, so there are no codes to claim
+	ds.WriteDeidentificationMethod(o.deidentificationMethod(DeidentificationMethodProfile), nil)
+}
+
 // AnonymizeMetadata anonymizes metadata in a DICOM file without modifying pixels.
-func AnonymizeMetadata(inputPath, outputPath, patientID string) error {
+func AnonymizeMetadata(inputPath, outputPath string, opts FileOptions) error {
 	// Read the DICOM file
 	ds, err := dcm.ReadDicom(inputPath)
 	if err != nil {
-		return err
+		return fail(FailureParse, "%w", err)
 	}
 
-	// Set anonymized patient ID
-	ds.SetString(tag.PatientID, patientID)
+	opts.applyTo(ds)
 
-	// Clear all PII tags
-	for _, t := range PIITagsToClear {
-		ds.ClearTag(t)
+	// Save anonymized file
+	if err := ds.Save(outputPath); err != nil {
+		return fail(FailureWrite, "%w", err)
 	}
+	return nil
+}
 
-	// Truncate dates to year-month only (YYYYMM01)
-	for _, t := range DateTagsToTruncate {
-		ds.TruncateDate(t)
+// remapUIDs rewrites UIDTagsToRemap using the UID mapper.
+func remapUIDs(ds *dcm.Dataset, uids *identity.UIDMapper) {
+	if uids == nil {
+		return
 	}
 
-	// Save anonymized file
-	return ds.Save(outputPath)
+	for _, t := range UIDTagsToRemap {
+		if original := ds.GetString(t); original != "" {
+			ds.SetString(t, uids.Map(original))
+		}
+	}
 }
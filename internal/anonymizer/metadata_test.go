@@ -0,0 +1,276 @@
+package anonymizer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestAnonymizeMetadataRemovesOverlays(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.dcm")
+	output := filepath.Join(dir, "out.dcm")
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.7"}},
+		{tag.MediaStorageSOPInstanceUID, []string{"1.2.3.4"}},
+		{tag.TransferSyntaxUID, []string{"1.2.840.10008.1.2.1"}},
+		{tag.PatientName, []string{"SMITH^JOHN"}},
+		{tag.PatientID, []string{"MRN123"}},
+		{tag.SOPInstanceUID, []string{"1.2.3.4"}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+
+	// The dictionary has no entries for the repeating 60xx groups
+	overlay := []byte{0xFF, 0x81, 0x81, 0x81, 0x81, 0x81, 0x81, 0xFF}
+	for _, e := range []struct {
+		t     tag.Tag
+		vr    string
+		kind  tag.VRKind
+		value interface{}
+	}{
+		{tag.Tag{Group: 0x6000, Element: 0x0010}, "US", tag.VRUInt16List, []int{8}},
+		{tag.Tag{Group: 0x6000, Element: 0x0011}, "US", tag.VRUInt16List, []int{8}},
+		{tag.Tag{Group: 0x6000, Element: 0x3000}, "OW", tag.VRBytes, overlay},
+	} {
+		value, err := dicom.NewValue(e.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elems = append(elems, &dicom.Element{Tag: e.t, ValueRepresentation: e.kind, RawValueRepresentation: e.vr, Value: value})
+	}
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+	if err := ds.Save(input); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if err := AnonymizeMetadata(input, output, FileOptions{PatientID: "ANON-000001", RemoveOverlays: true}); err != nil {
+		t.Fatalf("AnonymizeMetadata failed: %v", err)
+	}
+
+	result, err := dcm.ReadDicom(output)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	for _, elem := range result.Data.Elements {
+		if elem.Tag.Group == 0x6000 {
+			t.Errorf("overlay element %v survived anonymization", elem.Tag)
+		}
+	}
+	if got := result.GetPatientID(); got != "ANON-000001" {
+		t.Errorf("PatientID = %q, want ANON-000001", got)
+	}
+}
+
+func TestAnonymizeMetadataClearsNestedTags(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.dcm")
+	output := filepath.Join(dir, "out.dcm")
+
+	newElement := func(tg tag.Tag, data interface{}) *dicom.Element {
+		elem, err := dicom.NewElement(tg, data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", tg, err)
+		}
+		return elem
+	}
+
+	// ReferencedPatientSequence > item > PatientName, StudyDate and a
+	// nested ReferencedStudySequence > item > AccessionNumber
+	nested := newElement(tag.ReferencedStudySequence, [][]*dicom.Element{{
+		newElement(tag.AccessionNumber, []string{"ACC123"}),
+	}})
+	sequence := newElement(tag.ReferencedPatientSequence, [][]*dicom.Element{{
+		newElement(tag.StudyDate, []string{"20240315"}),
+		nested,
+		newElement(tag.PatientName, []string{"SMITH^JOHN"}),
+	}})
+
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{
+		newElement(tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.7"}),
+		newElement(tag.MediaStorageSOPInstanceUID, []string{"1.2.3.4"}),
+		newElement(tag.TransferSyntaxUID, []string{"1.2.840.10008.1.2.1"}),
+		newElement(tag.SOPInstanceUID, []string{"1.2.3.4"}),
+		sequence,
+		newElement(tag.PatientName, []string{"SMITH^JOHN"}),
+		newElement(tag.PatientID, []string{"MRN123"}),
+	}}}
+	if err := ds.Save(input); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if err := AnonymizeMetadata(input, output, FileOptions{PatientID: "ANON-000001"}); err != nil {
+		t.Fatalf("AnonymizeMetadata failed: %v", err)
+	}
+
+	result, err := dcm.ReadDicom(output)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	values := map[tag.Tag]string{}
+	result.WalkSequences(func(item *dcm.Dataset) {
+		for _, tg := range []tag.Tag{tag.PatientName, tag.StudyDate, tag.AccessionNumber} {
+			if _, err := item.Data.FindElementByTag(tg); err == nil {
+				values[tg] = item.GetString(tg)
+			}
+		}
+	})
+	for tg, want := range map[tag.Tag]string{tag.PatientName: "", tag.StudyDate: "20240301", tag.AccessionNumber: ""} {
+		got, ok := values[tg]
+		if !ok {
+			t.Errorf("nested %v missing from output", tg)
+		} else if got != want {
+			t.Errorf("nested %v = %q, want %q", tg, got, want)
+		}
+	}
+}
+
+func TestCalibrationTagsNotInProfiles(t *testing.T) {
+	profile := DefaultTagProfile()
+	for _, tags := range [][]tag.Tag{profile.ClearTags(), profile.DateTags(), profile.HashTags()} {
+		for _, tg := range tags {
+			if containsTag(CalibrationTags, tg) {
+				t.Errorf("default profile changes calibration tag %v", tg)
+			}
+		}
+	}
+	for _, a := range BasicConfidentialityTable().Attributes {
+		if containsTag(CalibrationTags, a.tag) {
+			t.Errorf("PS3.15 table changes calibration tag %s", a.Name)
+		}
+	}
+}
+
+func TestPreserveCalibration(t *testing.T) {
+	newDataset := func() *dcm.Dataset {
+		deltaX, err := dicom.NewElement(tag.PhysicalDeltaX, []float64{0.0125})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var elems []*dicom.Element
+		for _, e := range []struct {
+			t    tag.Tag
+			data interface{}
+		}{
+			{tag.PatientName, []string{"SMITH^JOHN"}},
+			{tag.SequenceOfUltrasoundRegions, [][]*dicom.Element{{deltaX}}},
+			{tag.PixelSpacing, []string{"0.5", "0.5"}},
+			{tag.WindowCenter, []string{"40"}},
+			{tag.WindowWidth, []string{"400"}},
+			{tag.RescaleIntercept, []string{"-1024"}},
+			{tag.RescaleSlope, []string{"1"}},
+		} {
+			elem, err := dicom.NewElement(e.t, e.data)
+			if err != nil {
+				t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+			}
+			elems = append(elems, elem)
+		}
+		return &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+	}
+	values := func(ds *dcm.Dataset) map[tag.Tag]string {
+		v := map[tag.Tag]string{}
+		for _, elem := range ds.Data.Elements {
+			v[elem.Tag] = elem.Value.String()
+		}
+		return v
+	}
+	want := values(newDataset())
+
+	// A custom profile that clears calibration along with the name
+	custom, err := ParseTagProfile([]byte(`{"name": "strict", "clear": ["PatientName", "PixelSpacing", "RescaleSlope", "SequenceOfUltrasoundRegions"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts FileOptions
+	}{
+		{"default profile", FileOptions{PreserveCalibration: true}},
+		{"PS3.15 profile", FileOptions{Confidentiality: true, PreserveCalibration: true}},
+		{"custom profile", FileOptions{Profile: custom, PreserveCalibration: true}},
+	} {
+		ds := newDataset()
+		tt.opts.PatientID = "ANON-000001"
+		tt.opts.applyTo(ds)
+
+		got := values(ds)
+		for _, tg := range CalibrationTags {
+			if got[tg] != want[tg] {
+				t.Errorf("%s: %v = %s, want %s", tt.name, tg, got[tg], want[tg])
+			}
+		}
+		if ds.GetPatientName() != "" {
+			t.Errorf("%s: PatientName was not cleared", tt.name)
+		}
+	}
+
+	// Without the guard, the custom profile clears them
+	ds := newDataset()
+	FileOptions{PatientID: "ANON-000001", Profile: custom}.applyTo(ds)
+	if got := values(ds); got[tag.SequenceOfUltrasoundRegions] == want[tag.SequenceOfUltrasoundRegions] {
+		t.Error("custom profile left SequenceOfUltrasoundRegions unchanged without PreserveCalibration")
+	}
+}
+
+func TestApplyToRemovesNestedPrivateTagsAndOverlays(t *testing.T) {
+	text := func(tg tag.Tag, value string) *dicom.Element {
+		v, err := dicom.NewValue([]string{value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dicom.Element{Tag: tg, ValueRepresentation: tag.VRString, RawValueRepresentation: "LO", Value: v}
+	}
+	overlay, err := dicom.NewValue([]int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReferencedImageSequence > item > nested sequence > item > an ACME
+	// block, a safe block with a number and a name, and an overlay
+	nested, err := dicom.NewElement(tag.ReferencedSeriesSequence, [][]*dicom.Element{{
+		text(tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT"),
+		text(tag.Tag{Group: 0x0009, Element: 0x1001}, "SMITH^JOHN"),
+		text(tag.Tag{Group: 0x0019, Element: 0x0010}, "GEMS_ACQU_01"),
+		text(tag.Tag{Group: 0x0019, Element: 0x1023}, "1.5"),
+		text(tag.Tag{Group: 0x0019, Element: 0x1024}, "SMITH HEAD COIL"),
+		{Tag: tag.Tag{Group: 0x6000, Element: 0x0010}, ValueRepresentation: tag.VRUInt16List, RawValueRepresentation: "US", Value: overlay},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequence, err := dicom.NewElement(tag.ReferencedImageSequence, [][]*dicom.Element{{nested}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{sequence}}}
+
+	FileOptions{PatientID: "ANON-000001", RemovePrivateTags: true, RemoveOverlays: true}.applyTo(ds)
+
+	var left []tag.Tag
+	ds.WalkSequences(func(item *dcm.Dataset) {
+		for _, elem := range item.Data.Elements {
+			if elem.Tag.Group%2 == 1 || elem.Tag.Group == 0x6000 {
+				left = append(left, elem.Tag)
+			}
+		}
+	})
+	want := []tag.Tag{{Group: 0x0019, Element: 0x0010}, {Group: 0x0019, Element: 0x1023}}
+	if len(left) != len(want) || left[0] != want[0] || left[1] != want[1] {
+		t.Errorf("private and overlay tags left in nested items = %v, want %v", left, want)
+	}
+}
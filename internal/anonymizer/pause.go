@@ -0,0 +1,68 @@
+package anonymizer
+
+import (
+	"context"
+	"sync"
+)
+
+// Pauser lets a caller pause a running ProcessFolderWithContext between
+// files. Files already in flight finish; no new file starts until Resume.
+// A nil *Pauser never pauses.
+type Pauser struct {
+	mu      sync.Mutex
+	resumed chan struct{} // nil while running, closed on Resume
+}
+
+// NewPauser creates a Pauser in the running state
+func NewPauser() *Pauser {
+	return &Pauser{}
+}
+
+// Pause stops new files from being started
+func (p *Pauser) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets processing continue with the remaining files
+func (p *Pauser) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resumed != nil {
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+// Paused returns whether processing is paused
+func (p *Pauser) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed != nil
+}
+
+// Wait blocks while paused. It returns ctx.Err() if ctx is cancelled first.
+func (p *Pauser) Wait(ctx context.Context) error {
+	if p == nil {
+		return ctx.Err()
+	}
+	p.mu.Lock()
+	resumed := p.resumed
+	p.mu.Unlock()
+
+	if resumed == nil {
+		return ctx.Err()
+	}
+	select {
+	case <-resumed:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
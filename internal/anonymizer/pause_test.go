@@ -0,0 +1,39 @@
+package anonymizer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPauserWait(t *testing.T) {
+	var nilPauser *Pauser
+	if err := nilPauser.Wait(context.Background()); err != nil || nilPauser.Paused() {
+		t.Fatalf("nil Pauser should never pause, got %v", err)
+	}
+
+	p := NewPauser()
+	p.Pause()
+	if !p.Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+
+	done := make(chan error)
+	go func() { done <- p.Wait(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Resume()
+	if err := <-done; err != nil {
+		t.Errorf("Wait after Resume = %v", err)
+	}
+
+	p.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait on cancelled context = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,671 @@
+package anonymizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/jpegls"
+	"dicom-anonymizer/internal/logging"
+	"dicom-anonymizer/internal/progress"
+)
+
+// Plan is what a run will do, decided before any file is written: the
+// files found, their patient groups and the anonymous ID of each group.
+// BuildPlan commits the IDs to the mapping file in one go, so a run
+// stopped while processing never leaves patients half assigned, and a
+// dry run shows exactly the plan a real run executes.
+type Plan struct {
+	InputFolder  string // Folder the output mirrors; the parent of a single input file
+	OutputFolder string
+
+	Files      int // DICOM files found, including the skipped ones
+	Patients   []PlannedPatient
+	Anonymized []string // Already marked PatientIdentityRemoved (see Config.Force)
+	TooLarge   []string // Larger than Config.MaxFileSize
+
+	started time.Time
+	mapper  *identity.PseudonymizationMapper // Holds the assigned IDs
+}
+
+// PlannedPatient is a patient group with the anonymous ID it was assigned
+type PlannedPatient struct {
+	*PatientGroup
+	AnonID string
+	Method identity.MatchMethod
+}
+
+// PatientPreviewRow is one planned patient as a dry run shows it
+type PatientPreviewRow struct {
+	AnonID    string
+	Display   string // Who the patient is, e.g. 'DOE^JANE' + DOB or PID 'MRN1'
+	Method    string // How the files were matched, e.g. PID fallback: placeholder-name
+	FileCount int
+}
+
+// String returns the row as a dry run line, e.g.
+// "ANON-000001 <- 'DOE^JANE' + DOB (3 files) [identity match]"
+func (r PatientPreviewRow) String() string {
+	return fmt.Sprintf("%s <- %s (%d files) [%s]", r.AnonID, r.Display, r.FileCount, r.Method)
+}
+
+// PreviewRows returns the preview of each planned patient, in plan order.
+// The CLI dry run, its TSV export and the GUI preview table all show these.
+func PreviewRows(patients []PlannedPatient) []PatientPreviewRow {
+	rows := make([]PatientPreviewRow, len(patients))
+	for i, patient := range patients {
+		row := PatientPreviewRow{AnonID: patient.AnonID, FileCount: len(patient.Files)}
+		switch {
+		case patient.Method == identity.MatchIdentity:
+			row.Display = fmt.Sprintf("'%s' + DOB", patient.Name)
+			row.Method = "identity match"
+		case patient.FileKey != "":
+			row.Display = "unidentified"
+			row.Method = patient.FileKey
+		case patient.Reason != identity.ReasonValid:
+			row.Display = fmt.Sprintf("PID '%s'", patient.PID)
+			row.Method = "PID fallback: " + string(patient.Reason)
+		default:
+			row.Display = fmt.Sprintf("PID '%s'", patient.PID)
+			row.Method = "PID fallback"
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// tsvField keeps a value on its line and in its column
+var tsvField = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// WritePreviewTSV writes rows as tab-separated values under a header line
+func WritePreviewTSV(w io.Writer, rows []PatientPreviewRow) error {
+	if _, err := io.WriteString(w, "anon_id\tpatient\tmethod\tfiles\n"); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", tsvField.Replace(r.AnonID), tsvField.Replace(r.Display), tsvField.Replace(r.Method), r.FileCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groups returns the patient groups of the plan
+func (p *Plan) groups() []*PatientGroup {
+	groups := make([]*PatientGroup, len(p.Patients))
+	for i, patient := range p.Patients {
+		groups[i] = patient.PatientGroup
+	}
+	return groups
+}
+
+// openMapper opens the mapping file of cfg for a run
+func openMapper(cfg Config, log logging.Logger) (*identity.PseudonymizationMapper, error) {
+	mapper, err := identity.NewPseudonymizationMapperWithLogger(cfg.MappingFile, cfg.Salt, log)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.EncryptMapping {
+		if err := mapper.EnableEncryption(); err != nil {
+			return nil, fmt.Errorf("could not encrypt mapping: %w", err)
+		}
+	}
+	if err := mapper.SetIDFormat(cfg.IDPrefix, cfg.IDFormat); err != nil {
+		return nil, err
+	}
+	if err := mapper.SetUIDRoot(cfg.UIDRoot); err != nil {
+		return nil, err
+	}
+	mapper.SetToolVersion(VersionString())
+	if cfg.FuzzyNameMatching {
+		mapper.EnableFuzzyNames(cfg.Nicknames)
+	}
+	mapper.SetDeferSave(true)
+	return mapper, nil
+}
+
+// runOutput returns the output writer and logger of cfg
+func runOutput(cfg Config) (func(string), logging.Logger) {
+	output := cfg.OutputWriter
+	if output == nil {
+		output = func(s string) { fmt.Print(s) }
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logging.NewFunc(output, logging.LevelInfo)
+	}
+	return output, log
+}
+
+// BuildPlan is the first pass of a run: it finds the input files, groups
+// them by patient and assigns every group its anonymous ID, saving the
+// mapping before returning. No file is read beyond its metadata and no
+// output is written.
+func BuildPlan(cfg Config) (*Plan, error) {
+	output, log := runOutput(cfg)
+
+	if _, err := NewScrubber(cfg.ScrubPatterns, cfg.ScrubPatientName); err != nil {
+		return nil, err
+	}
+	if err := cfg.checkInPlace(); err != nil {
+		return nil, err
+	}
+
+	mapper, err := openMapper(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		InputFolder:  cfg.InputFolder,
+		OutputFolder: cfg.OutputDir(),
+		started:      time.Now(),
+		mapper:       mapper,
+	}
+
+	// Find all DICOM files. A single input file is processed as is, and
+	// written to its patient folder under its own name.
+	var files []string
+	if dir := InputDir(plan.InputFolder); dir != plan.InputFolder {
+		files = []string{plan.InputFolder}
+		plan.InputFolder = dir
+	} else {
+		files, err = dcm.FindDicomFilesWithOptions(plan.InputFolder, dcm.FindOptions{
+			Recursive: cfg.Recursive,
+			MaxDepth:  cfg.MaxDepth,
+			OutputDir: plan.OutputFolder,
+
+			FollowSymlinks: cfg.FollowSymlinks,
+			OnSkip: func(path string, err error) {
+				log.Warnf("Skipping %s: %v", path, err)
+			},
+			IncludePatterns: cfg.IncludePatterns,
+			ExcludePatterns: cfg.ExcludePatterns,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not find DICOM files: %w", err)
+		}
+	}
+	if cfg.InPlace {
+		files = withoutInPlaceFiles(files)
+	}
+
+	plan.Files = len(files)
+	if len(files) == 0 {
+		output(fmt.Sprintf("No DICOM files found in %s\n", plan.InputFolder))
+		return plan, nil
+	}
+
+	output(fmt.Sprintf("Found %d DICOM file(s) in %s\n", len(files), plan.InputFolder))
+
+	// Oversized files are never parsed, not even for grouping
+	if cfg.MaxFileSize > 0 {
+		files, plan.TooLarge = splitBySize(files, cfg.MaxFileSize)
+		for _, filePath := range plan.TooLarge {
+			log.Warnf("Skipping %s: larger than the maximum file size of %d MB", filePath, cfg.MaxFileSize>>20)
+		}
+	}
+
+	// Group files by patient identity (Name+DOB) or PatientID
+	patients, anonymized := groupFilesByPatient(files, cfg, output)
+	plan.Anonymized = anonymized
+	output(fmt.Sprintf("Found %d unique patient(s)\n", len(patients)))
+	if len(anonymized) > 0 {
+		output(fmt.Sprintf("Skipping %d already anonymized file(s) (PatientIdentityRemoved=YES; use Force to reprocess)\n", len(anonymized)))
+	}
+
+	for _, patient := range patients {
+		anonID, method := patient.anonID(mapper)
+		plan.Patients = append(plan.Patients, PlannedPatient{
+			PatientGroup: patient,
+			AnonID:       anonID,
+			Method:       method,
+		})
+	}
+	// Anonymous IDs that were assigned but not saved could be reassigned
+	// to other patients by a later run
+	if err := mapper.Flush(); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// ExecutePlan is the second pass of a run: it anonymizes the files of a
+// plan from BuildPlan, or prints the plan when cfg.DryRun is set.
+func ExecutePlan(plan *Plan, cfg Config, progressCb ProgressCallback) (*Stats, error) {
+	return ExecutePlanWithContext(context.Background(), plan, cfg, progressCb)
+}
+
+// ExecutePlanWithContext executes a plan like ExecutePlan, stopping between
+// files once ctx is cancelled (see ProcessFolderWithContext).
+func ExecutePlanWithContext(ctx context.Context, plan *Plan, cfg Config, progressCb ProgressCallback) (*Stats, error) {
+	output, log := runOutput(cfg)
+
+	if plan.Files == 0 {
+		return &Stats{}, nil
+	}
+
+	mapper := plan.mapper
+	if mapper == nil {
+		var err error
+		if mapper, err = openMapper(cfg, log); err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		if err := mapper.Flush(); err != nil {
+			log.Warnf("%v", err)
+		}
+	}()
+
+	started := plan.started
+	if started.IsZero() {
+		started = time.Now()
+	}
+	inputFolder := plan.InputFolder
+	outputFolder := plan.OutputFolder
+	patients := plan.groups()
+
+	if cfg.DryRun {
+		stats, err := dryRun(plan.Patients, output)
+		if err == nil {
+			var groupedFiles []string
+			for _, patient := range patients {
+				groupedFiles = append(groupedFiles, patient.Files...)
+			}
+			stats.Resume = ResumeSummary(cfg, groupedFiles)
+			if r := stats.Resume; r != nil {
+				output(fmt.Sprintf("Resume: %d new, %d to retry, %d changed, %d already done\n", r.New, r.Retry, r.Changed, r.Done))
+			}
+		}
+		if err == nil && cfg.ExplainFiles > 0 {
+			stats.Explanations = explainPatients(cfg, patients, mapper, cfg.ExplainFiles)
+			output(fmt.Sprintf("\n[DRY RUN] Tag changes in the first %d file(s):\n", len(stats.Explanations)))
+			output(formatExplanations(stats.Explanations))
+		}
+		if err == nil && cfg.TextDetector != nil && cfg.ProcessUltrasound {
+			stats.DetectedText = detectPatientText(patients, cfg.TextDetector)
+			output("\n[DRY RUN] Burned-in text detected:\n")
+			output(formatFileText(stats.DetectedText))
+		}
+		return stats, err
+	}
+
+	profile := cfg.tagProfile()
+
+	uidMapper := identity.NewUIDMapperWithLogger(identity.UIDMappingFile(cfg.MappingFile), cfg.Salt, mapper.UIDRoot(), log)
+	if cfg.EncryptMapping {
+		// Original UIDs re-identify studies as well as the patient mapping
+		if err := uidMapper.EnableEncryption(); err != nil {
+			return nil, fmt.Errorf("could not encrypt UID mapping: %w", err)
+		}
+	}
+	defer func() {
+		if err := uidMapper.Save(); err != nil {
+			log.Warnf("%v", err)
+		}
+	}()
+
+	tracker := progress.NewTrackerWithLogger(filepath.Join(outputFolder, ".progress.json"), cfg.HashMode, log)
+	// Save the mappings first so saved progress never refers to
+	// patients or UIDs that a crash would lose
+	tracker.SetCheckpoint(cfg.Checkpoint, func() {
+		if err := mapper.Flush(); err != nil {
+			log.Warnf("%v", err)
+		}
+		if err := uidMapper.Save(); err != nil {
+			log.Warnf("%v", err)
+		}
+	})
+	defer tracker.Close()
+	errorLogger, err := progress.NewErrorLoggerWithLogger(filepath.Join(outputFolder, "errors.log"), log)
+	if err != nil {
+		return nil, fmt.Errorf("could not create error logger: %w", err)
+	}
+	defer errorLogger.Close()
+
+	if cfg.RetryFailed {
+		tracker.ClearFailed()
+	}
+
+	// Progress counts only the files that need work, so resumed runs do
+	// not jump ahead for files finished by an earlier run
+	resumed := make(map[string]bool)
+	totalFiles := 0
+	for _, patient := range patients {
+		for _, filePath := range patient.Files {
+			if tracker.IsProcessed(filePath) {
+				resumed[filePath] = true
+			} else {
+				totalFiles++
+			}
+		}
+	}
+	if len(resumed) > 0 {
+		output(fmt.Sprintf("Resuming: %d file(s) already processed by an earlier run\n", len(resumed)))
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// Process each patient, spreading files across a bounded worker pool
+	stats := &Stats{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var fileIndex int64
+	var bytesProcessed int64
+	statuses := make(map[string]string, plan.Files)
+	sem := make(chan struct{}, workers)
+
+	// Last FilenameSequential number per patient folder, continuing after
+	// the outputs of earlier runs, and the output names taken by this run
+	numbers := make(map[string]int)
+	taken := make(map[string]bool)
+
+	// reportDone advances the progress counter and reports a finished file.
+	// Must be called with mu held so that callbacks are serialized and
+	// reported counts stay monotonic.
+	reportDone := func(filePath, status string) {
+		statuses[filePath] = status
+		current := int(atomic.AddInt64(&fileIndex, 1))
+		if progressCb != nil {
+			progressCb(current, totalFiles, filepath.Base(filePath), status)
+		}
+	}
+
+	for _, filePath := range plan.Anonymized {
+		stats.Skipped++
+		stats.SkippedAnonymized++
+		statuses[filePath] = "skipped"
+		log.Debugf("  Skipped %s: already anonymized", filePath)
+	}
+	for _, filePath := range plan.TooLarge {
+		stats.Skipped++
+		stats.SkippedSize++
+		statuses[filePath] = "skipped"
+	}
+
+patientLoop:
+	for i, patient := range plan.Patients {
+		if ctx.Err() != nil {
+			break
+		}
+
+		anonID, method := patient.AnonID, patient.Method
+
+		if method == identity.MatchIdentity {
+			stats.IdentityMatched++
+		} else {
+			stats.PIDMatched++
+		}
+
+		patientFolder := filepath.Join(outputFolder, anonID)
+		if _, ok := numbers[patientFolder]; !ok && cfg.FilenamePolicy == FilenameSequential && !cfg.InPlace {
+			numbers[patientFolder] = highestSequentialNumber(patientFolder)
+		}
+		fileOpts := cfg.fileOptions(anonID, profile, uidMapper, mapper)
+		fileOpts.Logger = log
+
+		mu.Lock()
+		output(fmt.Sprintf("\nProcessing Patient %d/%d\n", i+1, len(plan.Patients)))
+		if method == identity.MatchIdentity {
+			output(fmt.Sprintf("  Name: %s\n", patient.Name))
+			output(fmt.Sprintf("  DOB: %s\n", patient.DOB))
+		}
+		output(fmt.Sprintf("  Original PID: %s\n", patient.PID))
+		output(fmt.Sprintf("  Anon ID: %s (%s match)\n", anonID, method))
+		output(fmt.Sprintf("  Files: %d\n", len(patient.Files)))
+		mu.Unlock()
+
+		for _, filePath := range patient.Files {
+			if resumed[filePath] {
+				mu.Lock()
+				stats.Skipped++
+				stats.SkippedResumed++
+				statuses[filePath] = "skipped"
+				mu.Unlock()
+				continue
+			}
+
+			if cfg.Pauser.Wait(ctx) != nil {
+				break patientLoop
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break patientLoop
+			}
+			var meta *fileMetadata
+			if m, ok := patient.metadata[filePath]; ok {
+				meta = &m
+			}
+			numbers[patientFolder]++
+			wg.Add(1)
+			go func(filePath string, number int, meta *fileMetadata) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				// Report progress - processing
+				if progressCb != nil {
+					mu.Lock()
+					current := int(atomic.LoadInt64(&fileIndex)) + 1
+					progressCb(min(current, totalFiles), totalFiles, filepath.Base(filePath), "processing")
+					mu.Unlock()
+				}
+
+				// Determine output path
+				outputPath := filePath
+				if !cfg.InPlace {
+					relPath, err := filepath.Rel(inputFolder, filePath)
+					if err != nil {
+						relPath = filepath.Base(filePath)
+					}
+					if cfg.FilenamePolicy == FilenameBySOPUID && meta == nil {
+						loaded, _ := loadFileMetadata(filePath)
+						meta = &loaded
+					}
+					var sopUID string
+					if meta != nil {
+						sopUID = meta.SOPInstanceUID
+					}
+					outputPath = filepath.Join(patientFolder, cfg.outputName(relPath, number, sopUID, uidMapper))
+					mu.Lock()
+					if taken[outputPath] && cfg.FilenamePolicy == FilenameBySOPUID {
+						log.Warnf("%s shares SOPInstanceUID %s with another input; naming it by a hash of its path", filePath, sopUID)
+						outputPath = filepath.Join(patientFolder, cfg.outputName(relPath, number, "", nil))
+					}
+					taken[outputPath] = true
+					mu.Unlock()
+				}
+
+				if meta != nil && meta.Ultrasound && cfg.MaxFrameSize > 0 && meta.FrameSize > cfg.MaxFrameSize {
+					log.Warnf("%s has %d MB frames; redaction holds every frame in memory", filePath, meta.FrameSize>>20)
+				}
+
+				// Re-encoding a large multi-frame file takes a while, so
+				// report it and stop mid-file once ctx is cancelled
+				opts := fileOpts
+				lastPercent := -1
+				opts.encodeProgress = func(done, total int) bool {
+					if ctx.Err() != nil {
+						return false
+					}
+					if percent := done * 100 / total; progressCb != nil && percent != lastPercent {
+						lastPercent = percent
+						mu.Lock()
+						current := int(atomic.LoadInt64(&fileIndex)) + 1
+						progressCb(min(current, totalFiles), totalFiles, filepath.Base(filePath), fmt.Sprintf("compressing %d%%", percent))
+						mu.Unlock()
+					}
+					return true
+				}
+
+				var method Method
+				var processErr error
+				if cfg.InPlace {
+					outputPath, method, processErr = anonymizeInPlace(filePath, cfg, opts, meta)
+				} else {
+					outputPath, method, processErr = anonymizeFile(filePath, outputPath, cfg, opts, meta)
+				}
+				// A file interrupted by cancellation is not a failure; it
+				// stays unrecorded and is processed again on resume
+				if processErr != nil && ctx.Err() != nil && errors.Is(processErr, jpegls.ErrAborted) {
+					return
+				}
+				if method == MethodSkipped || method == MethodSkippedModality || method == MethodSkippedAnonymized || method == MethodSkippedExisting || method == MethodSkippedSize {
+					mu.Lock()
+					stats.Skipped++
+					switch method {
+					case MethodSkippedModality:
+						stats.SkippedModality++
+						log.Debugf("  Skipped %s: modality not selected", filePath)
+					case MethodSkippedAnonymized:
+						stats.SkippedAnonymized++
+						log.Debugf("  Skipped %s: already anonymized", filePath)
+					case MethodSkippedExisting:
+						stats.SkippedExisting++
+						log.Debugf("  Skipped %s: output already exists", filePath)
+					case MethodSkippedSize:
+						stats.SkippedSize++
+						log.Warnf("Skipping %s: larger than the maximum file size of %d MB", filePath, cfg.MaxFileSize>>20)
+					}
+					reportDone(filePath, "skipped")
+					mu.Unlock()
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				if processErr != nil {
+					stats.Failed++
+					errMsg := processErr.Error()
+					tracker.MarkError(filePath, errMsg)
+					errorLogger.Log(filePath, string(CategoryOf(processErr)), errMsg)
+					output(fmt.Sprintf("  Error: %s: %s\n", filepath.Base(filePath), errMsg))
+					reportDone(filePath, "failed")
+				} else {
+					stats.Success++
+					if method == MethodMetadataFallback {
+						stats.PixelsNotRedacted++
+						output(fmt.Sprintf("  Warning: %s: pixels NOT redacted (dcmtk not installed), metadata anonymized\n", filepath.Base(filePath)))
+					}
+					log.Debugf("  Anonymized %s -> %s", filePath, outputPath)
+					if info, err := os.Stat(filePath); err == nil {
+						bytesProcessed += info.Size()
+					}
+					// In place, this fingerprints the anonymized file
+					tracker.MarkSuccess(filePath, outputPath)
+					reportDone(filePath, "success")
+				}
+			}(filePath, numbers[patientFolder], meta)
+		}
+	}
+
+	wg.Wait()
+
+	stats.TotalPatients = len(plan.Patients)
+	for _, patient := range plan.Patients {
+		stats.LinkedGroups += len(patient.Linked)
+	}
+	stats.Failures = errorLogger.Entries()
+	stats.ErrorLog = errorLogger.LogFile()
+
+	// writeReport writes the JSON run report if one was requested
+	writeReport := func(cancelled bool) {
+		if cfg.ReportFile == "" {
+			return
+		}
+		report := buildReport(cfg, stats, statuses, patients, tracker, bytesProcessed, started, cancelled)
+		if err := WriteReport(cfg.ReportFile, report); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		tracker.Flush()
+		errorLogger.Flush()
+		writeReport(true)
+		output(fmt.Sprintf("\nCancelled: %d succeeded, %d failed, %d skipped\n",
+			stats.Success, stats.Failed, stats.Skipped))
+		return stats, err
+	}
+
+	// Print summary
+	output(fmt.Sprintf("\n%s\n", strings.Repeat("=", 50)))
+	output(fmt.Sprintf("Complete! %d succeeded, %d failed, %d skipped\n",
+		stats.Success, stats.Failed, stats.Skipped))
+	if len(cfg.Modalities) > 0 {
+		output(fmt.Sprintf("Modalities: %s (%d files with other modalities skipped)\n",
+			strings.Join(cfg.Modalities, ", "), stats.SkippedModality))
+	}
+	if stats.SkippedAnonymized > 0 {
+		output(fmt.Sprintf("Already anonymized: %d files skipped\n", stats.SkippedAnonymized))
+	}
+	if stats.SkippedExisting > 0 {
+		output(fmt.Sprintf("Existing output: %d files skipped\n", stats.SkippedExisting))
+	}
+	if stats.SkippedSize > 0 {
+		output(fmt.Sprintf("Too large: %d files skipped\n", stats.SkippedSize))
+	}
+	if stats.SkippedResumed > 0 {
+		output(fmt.Sprintf("Resumed: %d files processed by an earlier run skipped\n", stats.SkippedResumed))
+	}
+	if stats.PixelsNotRedacted > 0 {
+		output(fmt.Sprintf("WARNING: %d ultrasound files have metadata anonymized but pixels NOT redacted\n", stats.PixelsNotRedacted))
+	}
+	output(fmt.Sprintf("Matching: %d by Name+DOB, %d by PatientID\n",
+		stats.IdentityMatched, stats.PIDMatched))
+	output(fmt.Sprintf("  %s\n", errorLogger.Summary()))
+	if hint := DcmtkFailureHint(stats.Failures); hint != "" {
+		output(fmt.Sprintf("  %s\n", hint))
+	}
+	if cfg.InPlace {
+		output(fmt.Sprintf("In place: %d original(s) overwritten in %s\n", stats.Success, inputFolder))
+		if cfg.InPlaceBackup {
+			output(fmt.Sprintf("Backups: kept as *%s next to each file\n", InPlaceBackupSuffix))
+		}
+	} else {
+		output(fmt.Sprintf("Output: %s\n", outputFolder))
+	}
+	if cfg.MappingFile != "" {
+		output(fmt.Sprintf("Mapping: %s\n", cfg.MappingFile))
+		output(fmt.Sprintf("UID mapping: %s\n", identity.UIDMappingFile(cfg.MappingFile)))
+	}
+	if cfg.ReportFile != "" {
+		writeReport(false)
+		output(fmt.Sprintf("Report: %s\n", cfg.ReportFile))
+	}
+	if cfg.WriteManifest {
+		manifestFile := filepath.Join(outputFolder, ManifestFileName)
+		manifest, err := BuildManifest(outputFolder, cfg.Salt)
+		if err == nil {
+			err = WriteManifest(manifestFile, manifest)
+		}
+		if err != nil {
+			log.Warnf("%v", err)
+		} else {
+			output(fmt.Sprintf("Manifest: %s (%d files)\n", manifestFile, len(manifest.Files)))
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,127 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/logging"
+)
+
+func TestBuildPlanCommitsIDsBeforeProcessing(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFile(t, filepath.Join(input, "a.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1", tag.SOPInstanceUID: "1.3.1"})
+	writeTestFile(t, filepath.Join(input, "b.dcm"), map[tag.Tag]string{tag.PatientID: "MRN2", tag.SOPInstanceUID: "1.3.2"})
+
+	mappingFile := filepath.Join(dir, "patient_mapping.json")
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     mappingFile,
+		Salt:            "secret",
+		ProcessMetadata: true,
+		OutputWriter:    func(string) {},
+	}
+	plan, err := BuildPlan(cfg)
+	if err != nil {
+		t.Fatalf("BuildPlan failed: %v", err)
+	}
+	if plan.Files != 2 || len(plan.Patients) != 2 {
+		t.Fatalf("plan has %d files and %d patients, want 2 and 2", plan.Files, len(plan.Patients))
+	}
+
+	// The IDs are in the mapping before any file is written
+	if _, err := os.Stat(cfg.OutputDir()); !os.IsNotExist(err) {
+		t.Errorf("BuildPlan created the output folder: %v", err)
+	}
+	saved, err := identity.NewPseudonymizationMapperWithLogger(mappingFile, cfg.Salt, logging.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, patient := range plan.Patients {
+		if got, _ := saved.GetAnonID(patient.PID, patient.Name, patient.DOB); got != patient.AnonID {
+			t.Errorf("saved ID of %s = %s, want %s", patient.PID, got, patient.AnonID)
+		}
+	}
+
+	// A dry run shows the plan, and the real run executes it
+	cfg.DryRun = true
+	if _, err := ExecutePlan(plan, cfg, nil); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	cfg.DryRun = false
+	stats, err := ExecutePlan(plan, cfg, nil)
+	if err != nil {
+		t.Fatalf("ExecutePlan failed: %v", err)
+	}
+	if stats.Success != 2 {
+		t.Errorf("Success = %d, want 2", stats.Success)
+	}
+	for _, patient := range plan.Patients {
+		written, _ := filepath.Glob(filepath.Join(cfg.OutputDir(), patient.AnonID, "*.dcm"))
+		if len(written) != 1 {
+			t.Errorf("%s has %d files, want 1", patient.AnonID, len(written))
+		}
+	}
+}
+
+func TestBuildPlanFailsWhenMappingIsNotSaved(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	writeTestFile(t, filepath.Join(input, "a.dcm"), map[tag.Tag]string{tag.PatientID: "MRN1"})
+
+	// A file in place of the mapping directory makes the save fail
+	blocked := filepath.Join(dir, "mappings")
+	if err := os.WriteFile(blocked, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(blocked, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		OutputWriter:    func(string) {},
+	}
+	if _, err := BuildPlan(cfg); err == nil {
+		t.Error("BuildPlan succeeded although the assigned IDs were not saved")
+	}
+}
+
+func TestPreviewRows(t *testing.T) {
+	patients := []PlannedPatient{
+		{PatientGroup: &PatientGroup{Name: "DOE^JANE", DOB: "19700101", PID: "MRN3", Files: []string{"a", "b"}}, AnonID: "ANON-000001", Method: identity.MatchIdentity},
+		{PatientGroup: &PatientGroup{PID: "MRN1", Reason: identity.ReasonPlaceholderName, Files: []string{"c"}}, AnonID: "ANON-000002", Method: identity.MatchPID},
+		{PatientGroup: &PatientGroup{PID: "MRN\t2", Reason: identity.ReasonValid, Files: []string{"d"}}, AnonID: "ANON-000003", Method: identity.MatchPID},
+	}
+	rows := PreviewRows(patients)
+	want := []PatientPreviewRow{
+		{AnonID: "ANON-000001", Display: "'DOE^JANE' + DOB", Method: "identity match", FileCount: 2},
+		{AnonID: "ANON-000002", Display: "PID 'MRN1'", Method: "PID fallback: placeholder-name", FileCount: 1},
+		{AnonID: "ANON-000003", Display: "PID 'MRN\t2'", Method: "PID fallback", FileCount: 1},
+	}
+	for i := range want {
+		if rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, rows[i], want[i])
+		}
+	}
+	if got := rows[0].String(); got != "ANON-000001 <- 'DOE^JANE' + DOB (2 files) [identity match]" {
+		t.Errorf("String() = %q", got)
+	}
+
+	// Tabs in values must not shift the columns
+	var tsv strings.Builder
+	if err := WritePreviewTSV(&tsv, rows); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(tsv.String(), "\n"), "\n")
+	if len(lines) != 4 || lines[0] != "anon_id\tpatient\tmethod\tfiles" {
+		t.Fatalf("TSV = %q", tsv.String())
+	}
+	if lines[3] != "ANON-000003\tPID 'MRN 2'\tPID fallback\t1" {
+		t.Errorf("TSV row = %q", lines[3])
+	}
+}
@@ -0,0 +1,43 @@
+package anonymizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// WritePreset writes cfg as indented JSON so a batch setup can be reused.
+// The secret key (Salt), callbacks and the Pauser are never written; the
+// key must be entered again after loading.
+func WritePreset(w io.Writer, cfg Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal preset: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("could not write preset: %w", err)
+	}
+	return nil
+}
+
+// ReadPreset reads a preset written by WritePreset. The returned config
+// has no Salt, OutputWriter or Logger.
+func ReadPreset(r io.Reader) (Config, error) {
+	// Presets saved before these options existed get the defaults
+	cfg := DefaultConfig()
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid preset: %w", err)
+	}
+
+	// Profile tags are resolved when parsed, not when unmarshalled
+	if cfg.Profile != nil {
+		data, err := json.Marshal(cfg.Profile)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid preset profile: %w", err)
+		}
+		if cfg.Profile, err = ParseTagProfile(data); err != nil {
+			return Config{}, fmt.Errorf("invalid preset profile: %w", err)
+		}
+	}
+	return cfg, nil
+}
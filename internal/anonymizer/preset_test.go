@@ -0,0 +1,57 @@
+package anonymizer
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestPresetRoundTripOmitsSecretKey(t *testing.T) {
+	cfg := Config{
+		InputFolder:         "/data/batch1",
+		MappingFile:         "/data/patient_mapping.json",
+		Salt:                "top-secret-key",
+		RedactRows:          90,
+		RedactRegions:       []image.Rectangle{image.Rect(10, 20, 110, 70)},
+		Recursive:           true,
+		ProcessUltrasound:   true,
+		KeepInstitutionName: true,
+		Profile:             DefaultTagProfile(),
+		OutputWriter:        func(string) {},
+	}
+
+	var buf bytes.Buffer
+	if err := WritePreset(&buf, cfg); err != nil {
+		t.Fatalf("WritePreset failed: %v", err)
+	}
+	if strings.Contains(buf.String(), cfg.Salt) {
+		t.Fatalf("preset contains the secret key:\n%s", buf.String())
+	}
+
+	loaded, err := ReadPreset(&buf)
+	if err != nil {
+		t.Fatalf("ReadPreset failed: %v", err)
+	}
+	if loaded.Salt != "" || loaded.InputFolder != cfg.InputFolder || loaded.RedactRows != 90 ||
+		!loaded.Recursive || !loaded.ProcessUltrasound || loaded.KeepSex || !loaded.KeepInstitutionName {
+		t.Errorf("loaded = %+v", loaded)
+	}
+	if len(loaded.RedactRegions) != 1 || loaded.RedactRegions[0] != cfg.RedactRegions[0] {
+		t.Errorf("regions = %v, want %v", loaded.RedactRegions, cfg.RedactRegions)
+	}
+	if len(loaded.Profile.ClearTags()) != len(cfg.Profile.ClearTags()) {
+		t.Errorf("profile clear tags = %d, want %d", len(loaded.Profile.ClearTags()), len(cfg.Profile.ClearTags()))
+	}
+}
+
+func TestReadPresetDefaults(t *testing.T) {
+	// A preset saved before these options existed
+	loaded, err := ReadPreset(strings.NewReader(`{"RedactRows": 90}`))
+	if err != nil {
+		t.Fatalf("ReadPreset failed: %v", err)
+	}
+	if !loaded.KeepSex || !loaded.KeepInstitutionName || !loaded.KeepStudyDescription || !loaded.RemovePrivateTags {
+		t.Errorf("loaded = %+v, want the DefaultConfig options", loaded)
+	}
+}
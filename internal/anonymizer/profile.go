@@ -0,0 +1,210 @@
+package anonymizer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+//go:embed profiles/default.json
+var defaultProfileJSON []byte
+
+// TagProfile lists which tags are cleared, date-handled, kept or hashed.
+// Tags are given as "gggg,eeee" (hex) or as a DICOM keyword.
+type TagProfile struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Clear        []string `json:"clear"`
+	TruncateDate []string `json:"truncate_date"`
+	Keep         []string `json:"keep"`
+	Hash         []string `json:"hash"`
+
+	// Resolved tags
+	clear []tag.Tag
+	dates []tag.Tag
+	keep  []tag.Tag
+	hash  []tag.Tag
+}
+
+// DefaultTagProfile returns the built-in baseline profile.
+func DefaultTagProfile() *TagProfile {
+	p, err := ParseTagProfile(defaultProfileJSON)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded default profile: %v", err))
+	}
+	return p
+}
+
+// LoadTagProfile reads a tag profile from a JSON file.
+func LoadTagProfile(path string) (*TagProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read profile: %w", err)
+	}
+
+	p, err := ParseTagProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// ParseTagProfile parses a JSON tag profile and resolves its tags.
+func ParseTagProfile(data []byte) (*TagProfile, error) {
+	var p TagProfile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.clear, err = parseTags(p.Clear); err != nil {
+		return nil, fmt.Errorf("clear: %w", err)
+	}
+	if p.dates, err = parseTags(p.TruncateDate); err != nil {
+		return nil, fmt.Errorf("truncate_date: %w", err)
+	}
+	if p.keep, err = parseTags(p.Keep); err != nil {
+		return nil, fmt.Errorf("keep: %w", err)
+	}
+	if p.hash, err = parseTags(p.Hash); err != nil {
+		return nil, fmt.Errorf("hash: %w", err)
+	}
+
+	return &p, nil
+}
+
+// ClearTags returns the tags cleared by the profile, excluding kept tags.
+func (p *TagProfile) ClearTags() []tag.Tag {
+	return p.withoutKept(p.clear)
+}
+
+// DateTags returns the date tags handled by the date policy, excluding kept tags.
+func (p *TagProfile) DateTags() []tag.Tag {
+	return p.withoutKept(p.dates)
+}
+
+// HashTags returns the tags replaced with a salted hash, excluding kept tags.
+func (p *TagProfile) HashTags() []tag.Tag {
+	return p.withoutKept(p.hash)
+}
+
+// apply clears, hashes and date-handles the dataset according to the
+// profile, including the items of sequences.
+func (p *TagProfile) apply(ds *dcm.Dataset, dates DateHandling, salt string) {
+	p.applyElements(ds, dates, salt)
+	ds.WalkSequences(func(item *dcm.Dataset) {
+		p.applyElements(item, dates, salt)
+	})
+}
+
+// applyElements applies the profile to the top-level elements of ds.
+// Cleared sequences keep the kept tags of their items.
+func (p *TagProfile) applyElements(ds *dcm.Dataset, dates DateHandling, salt string) {
+	for _, t := range p.ClearTags() {
+		if !ds.ClearSequenceExcept(t, p.keep) {
+			ds.ClearTag(t)
+		}
+	}
+
+	for _, t := range p.HashTags() {
+		if value := ds.GetString(t); value != "" {
+			ds.SetString(t, identity.CreateValueHash(value, salt))
+		}
+	}
+
+	dates.apply(ds, p.DateTags())
+}
+
+// withCleared returns a copy of the profile that clears tags instead of
+// keeping them.
+func (p *TagProfile) withCleared(tags ...tag.Tag) *TagProfile {
+	c := *p
+	c.keep = nil
+	for _, t := range p.keep {
+		if !containsTag(tags, t) {
+			c.keep = append(c.keep, t)
+		}
+	}
+	c.clear = append([]tag.Tag(nil), p.clear...)
+	for _, t := range tags {
+		if !containsTag(c.clear, t) {
+			c.clear = append(c.clear, t)
+		}
+	}
+	return &c
+}
+
+// withKept returns a copy of the profile that keeps tags, including inside
+// cleared sequences, whatever its other lists say.
+func (p *TagProfile) withKept(tags ...tag.Tag) *TagProfile {
+	c := *p
+	c.keep = append([]tag.Tag(nil), p.keep...)
+	for _, t := range tags {
+		if !containsTag(c.keep, t) {
+			c.keep = append(c.keep, t)
+		}
+	}
+	return &c
+}
+
+func (p *TagProfile) withoutKept(tags []tag.Tag) []tag.Tag {
+	result := make([]tag.Tag, 0, len(tags))
+	for _, t := range tags {
+		if !containsTag(p.keep, t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func containsTag(tags []tag.Tag, t tag.Tag) bool {
+	for _, other := range tags {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTags resolves "gggg,eeee" or keyword strings to tags.
+func parseTags(names []string) ([]tag.Tag, error) {
+	tags := make([]tag.Tag, 0, len(names))
+	for _, name := range names {
+		t, err := parseTag(name)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, nil
+}
+
+func parseTag(name string) (tag.Tag, error) {
+	name = strings.TrimSpace(name)
+
+	if group, elem, ok := strings.Cut(strings.Trim(name, "()"), ","); ok {
+		g, err := strconv.ParseUint(strings.TrimSpace(group), 16, 16)
+		if err != nil {
+			return tag.Tag{}, fmt.Errorf("invalid tag %q", name)
+		}
+		e, err := strconv.ParseUint(strings.TrimSpace(elem), 16, 16)
+		if err != nil {
+			return tag.Tag{}, fmt.Errorf("invalid tag %q", name)
+		}
+		return tag.Tag{Group: uint16(g), Element: uint16(e)}, nil
+	}
+
+	info, err := tag.FindByName(name)
+	if err != nil {
+		return tag.Tag{}, fmt.Errorf("unknown tag keyword %q", name)
+	}
+	return info.Tag, nil
+}
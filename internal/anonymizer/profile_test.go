@@ -0,0 +1,135 @@
+package anonymizer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestDefaultTagProfile(t *testing.T) {
+	p := DefaultTagProfile()
+
+	if !containsTag(p.ClearTags(), tag.PatientName) {
+		t.Errorf("default profile does not clear PatientName")
+	}
+	if !containsTag(p.DateTags(), tag.StudyDate) {
+		t.Errorf("default profile does not handle StudyDate")
+	}
+	if containsTag(p.ClearTags(), tag.PatientSex) {
+		t.Errorf("default profile clears kept tag PatientSex")
+	}
+}
+
+func TestParseTagProfile(t *testing.T) {
+	p, err := ParseTagProfile([]byte(`{
+		"clear": ["0010,0010", "(0008,0090)", "PatientBirthDate"],
+		"keep": ["PatientBirthDate"],
+		"hash": ["AccessionNumber"]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseTagProfile failed: %v", err)
+	}
+
+	clear := p.ClearTags()
+	if len(clear) != 2 || clear[0] != tag.PatientName || clear[1] != tag.ReferringPhysicianName {
+		t.Errorf("ClearTags() = %v", clear)
+	}
+	if hash := p.HashTags(); len(hash) != 1 || hash[0] != tag.AccessionNumber {
+		t.Errorf("HashTags() = %v", hash)
+	}
+
+	if _, err := ParseTagProfile([]byte(`{"clear": ["NotATag"]}`)); err == nil {
+		t.Errorf("expected error for unknown keyword")
+	}
+}
+
+func TestClearedContextTags(t *testing.T) {
+	cfg := Config{KeepInstitutionName: true}
+	p := DefaultTagProfile().withCleared(cfg.clearedContextTags()...)
+
+	for _, cleared := range []tag.Tag{tag.PatientSex, tag.StudyDescription} {
+		if !containsTag(p.ClearTags(), cleared) {
+			t.Errorf("%v is not cleared", cleared)
+		}
+	}
+	if containsTag(p.ClearTags(), tag.InstitutionName) {
+		t.Errorf("kept InstitutionName is cleared")
+	}
+
+	// The defaults keep the profile's clinical context
+	if tags := DefaultConfig().clearedContextTags(); len(tags) != 0 {
+		t.Errorf("DefaultConfig clears %v", tags)
+	}
+
+	// The default profile itself is unchanged
+	if containsTag(DefaultTagProfile().ClearTags(), tag.PatientSex) {
+		t.Errorf("withCleared modified the default profile")
+	}
+}
+
+func TestKeptTagsSurviveClearedSequence(t *testing.T) {
+	newDataset := func() *dcm.Dataset {
+		var item []*dicom.Element
+		for _, e := range []struct {
+			t     tag.Tag
+			value string
+		}{
+			{tag.AccessionNumber, "ACC123"},
+			{tag.StudyDescription, "CT CHEST"},
+			{tag.RequestedProcedureID, "RP42"},
+		} {
+			elem, err := dicom.NewElement(e.t, []string{e.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			item = append(item, elem)
+		}
+		seq, err := dicom.NewElement(tag.RequestAttributesSequence, [][]*dicom.Element{item})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dcm.Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{seq}}}
+	}
+	nested := func(ds *dcm.Dataset) map[tag.Tag]string {
+		values := map[tag.Tag]string{}
+		ds.WalkSequences(func(item *dcm.Dataset) {
+			for _, elem := range item.Data.Elements {
+				values[elem.Tag] = item.GetString(elem.Tag)
+			}
+		})
+		return values
+	}
+
+	// RequestAttributesSequence is cleared, but its StudyDescription is kept
+	ds := newDataset()
+	DefaultTagProfile().apply(ds, DateHandling{}, "salt")
+	got := nested(ds)
+	if got[tag.StudyDescription] != "CT CHEST" {
+		t.Errorf("nested StudyDescription = %q, want CT CHEST", got[tag.StudyDescription])
+	}
+	for _, cleared := range []tag.Tag{tag.AccessionNumber, tag.RequestedProcedureID} {
+		if _, ok := got[cleared]; ok {
+			t.Errorf("%v left in the cleared sequence", cleared)
+		}
+	}
+
+	// Validation accepts the sequence, as it holds only kept tags
+	path := filepath.Join(t.TempDir(), "kept.dcm")
+	if err := ds.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if findings := Validate(path, nil); len(findings) != 0 {
+		t.Errorf("Validate() = %v, want no findings", findings)
+	}
+
+	// Without the keep, the whole sequence is emptied
+	ds = newDataset()
+	DefaultTagProfile().withCleared(tag.StudyDescription).apply(ds, DateHandling{}, "salt")
+	if got := nested(ds); len(got) != 0 {
+		t.Errorf("cleared sequence still holds %v", got)
+	}
+}
@@ -0,0 +1,169 @@
+package anonymizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/progress"
+)
+
+// Build metadata recorded in run reports and mapping files. Release builds
+// set it with -ldflags, e.g.
+// -X dicom-anonymizer/internal/anonymizer.Version=1.2.3
+// -X dicom-anonymizer/internal/anonymizer.Commit=$(git rev-parse HEAD)
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// VersionString returns the version with the commit and build date when
+// they are known, e.g. "1.2.3 (commit 4a8804f, built 2026-10-15)".
+func VersionString() string {
+	var details []string
+	if Commit != "" {
+		commit := Commit
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		details = append(details, "commit "+commit)
+	}
+	if BuildDate != "" {
+		details = append(details, "built "+BuildDate)
+	}
+	if len(details) == 0 {
+		return Version
+	}
+	return Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+// ReportFormatVersion is bumped whenever a field of Report is renamed or
+// removed. Added fields do not change it.
+const ReportFormatVersion = 1
+
+// Report is the JSON document written to Config.ReportFile when a run
+// finishes. Field names are stable so reports of different runs can be
+// diffed by downstream tooling.
+type Report struct {
+	FormatVersion  int             `json:"format_version"`
+	ToolVersion    string          `json:"tool_version"`
+	ToolCommit     string          `json:"tool_commit,omitempty"`
+	BuildDate      string          `json:"build_date,omitempty"`
+	Input          string          `json:"input"`
+	Output         string          `json:"output"`
+	Started        string          `json:"started"`  // RFC 3339
+	Finished       string          `json:"finished"` // RFC 3339
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	Cancelled      bool            `json:"cancelled"`
+	BytesProcessed int64           `json:"bytes_processed"` // Input size of files anonymized in this run
+	Stats          ReportStats     `json:"stats"`
+	Files          []ReportFile    `json:"files"`    // Every file reached in this run, sorted by path
+	Failures       []ReportFailure `json:"failures"` // Files that failed in this run, sorted by path
+}
+
+// ReportStats mirrors Stats with stable JSON names
+type ReportStats struct {
+	Success         int `json:"success"`
+	Failed          int `json:"failed"`
+	Skipped         int `json:"skipped"`
+	IdentityMatched int `json:"identity_matched"`
+	PIDMatched      int `json:"pid_matched"`
+	TotalPatients   int `json:"total_patients"`
+}
+
+// ReportFile is the outcome of one input file. Status is "success",
+// "failed" or "skipped" (already processed or modality not selected).
+type ReportFile struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+
+	// Why the file's Name+DOB could not identify the patient, e.g.
+	// "placeholder-name" (see identity.IdentityReason); empty if it could
+	IdentityReason string `json:"identity_reason,omitempty"`
+}
+
+// ReportFailure is a failed file and its error message
+type ReportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// buildReport assembles the report for a run. statuses holds the status of
+// each file reached in the run; output paths and errors come from tracker,
+// identity reasons from the metadata read while grouping patients.
+func buildReport(cfg Config, stats *Stats, statuses map[string]string, patients []*PatientGroup,
+	tracker *progress.Tracker, bytesProcessed int64, started time.Time, cancelled bool) *Report {
+	finished := time.Now()
+	report := &Report{
+		FormatVersion:  ReportFormatVersion,
+		ToolVersion:    Version,
+		ToolCommit:     Commit,
+		BuildDate:      BuildDate,
+		Input:          cfg.InputFolder,
+		Output:         cfg.OutputDir(),
+		Started:        started.Format(time.RFC3339),
+		Finished:       finished.Format(time.RFC3339),
+		ElapsedSeconds: finished.Sub(started).Seconds(),
+		Cancelled:      cancelled,
+		BytesProcessed: bytesProcessed,
+		Stats: ReportStats{
+			Success:         stats.Success,
+			Failed:          stats.Failed,
+			Skipped:         stats.Skipped,
+			IdentityMatched: stats.IdentityMatched,
+			PIDMatched:      stats.PIDMatched,
+			TotalPatients:   stats.TotalPatients,
+		},
+		Files:    []ReportFile{},
+		Failures: []ReportFailure{},
+	}
+
+	reasons := make(map[string]identity.IdentityReason)
+	for _, p := range patients {
+		for _, path := range p.Files {
+			reasons[path] = p.metadata[path].IdentityReason
+		}
+	}
+
+	paths := make([]string, 0, len(statuses))
+	for path := range statuses {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file := ReportFile{Path: path, Status: statuses[path], IdentityReason: string(reasons[path])}
+		if tracker != nil {
+			if entry, ok := tracker.Entry(path); ok {
+				file.Output = entry.Output
+				file.Error = entry.Error
+			}
+		}
+		report.Files = append(report.Files, file)
+
+		if file.Status == "failed" {
+			report.Failures = append(report.Failures, ReportFailure{Path: path, Error: file.Error})
+		}
+	}
+
+	return report
+}
+
+// WriteReport writes report as indented JSON to path.
+func WriteReport(path string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("could not write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package anonymizer
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/internal/identity"
+)
+
+func TestProcessFolderReport(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(input, "a.dcm")
+	writeTestFile(t, filePath, map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.SOPInstanceUID: "1.2.3.4.5.6",
+	})
+	reportFile := filepath.Join(dir, "report.json")
+
+	stats, err := ProcessFolder(Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		ReportFile:      reportFile,
+		OutputWriter:    func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("report not written: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid report: %v", err)
+	}
+
+	if report.FormatVersion != ReportFormatVersion || report.ToolVersion != Version {
+		t.Errorf("versions = %d/%q, want %d/%q", report.FormatVersion, report.ToolVersion, ReportFormatVersion, Version)
+	}
+	if report.Stats.Success != stats.Success || report.Stats.Success != 1 {
+		t.Errorf("report success = %d, stats success = %d, want 1", report.Stats.Success, stats.Success)
+	}
+	info, _ := os.Stat(filePath)
+	if report.BytesProcessed != info.Size() {
+		t.Errorf("bytes_processed = %d, want %d", report.BytesProcessed, info.Size())
+	}
+	if len(report.Files) != 1 || report.Files[0].Path != filePath || report.Files[0].Status != "success" || report.Files[0].Output == "" {
+		t.Errorf("files = %+v, want one successful entry for %s", report.Files, filePath)
+	}
+	if got := report.Files[0].IdentityReason; got != string(identity.ReasonMissingDOB) {
+		t.Errorf("identity_reason = %q, want %q", got, identity.ReasonMissingDOB)
+	}
+	if report.Failures == nil || len(report.Failures) != 0 {
+		t.Errorf("failures = %v, want empty array", report.Failures)
+	}
+}
+
+func TestProcessFolderModalityFilter(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, modality := range map[string]string{"ct.dcm": "CT", "mr.dcm": "MR", "cr.dcm": "CR"} {
+		writeTestFile(t, filepath.Join(input, name), map[tag.Tag]string{
+			tag.PatientName:    "SMITH^JOHN",
+			tag.PatientID:      "MRN123",
+			tag.Modality:       modality,
+			tag.SOPInstanceUID: "1.2.3." + modality,
+		})
+	}
+
+	stats, err := ProcessFolder(Config{
+		InputFolder:     input,
+		MappingFile:     filepath.Join(dir, "patient_mapping.json"),
+		Salt:            "secret",
+		ProcessMetadata: true,
+		Modalities:      ParseModalities("ct, mr"),
+		OutputWriter:    func(string) {},
+	})
+	if err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	if stats.Success != 2 || stats.Skipped != 1 || stats.SkippedModality != 1 {
+		t.Errorf("success/skipped/skipped modality = %d/%d/%d, want 2/1/1",
+			stats.Success, stats.Skipped, stats.SkippedModality)
+	}
+}
@@ -0,0 +1,71 @@
+package anonymizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// RestoreResult reports what Restore was able to put back
+type RestoreResult struct {
+	AnonID        string
+	PatientID     string // Original PatientID written to the copy (empty if unknown)
+	UIDsRestored  int
+	DatesRestored bool // False when dates were truncated or removed (not reversible)
+}
+
+// Restore writes a copy of an anonymized file with the original PatientID,
+// UIDs and (if dates were shifted) dates put back from the mappings.
+// profile must list the same date tags as the anonymization run (nil =
+// DefaultTagProfile). Cleared tags such as PatientName cannot be restored.
+func Restore(inputPath, outputPath string, mapper *identity.PseudonymizationMapper, uids *identity.UIDMapper, profile *TagProfile) (*RestoreResult, error) {
+	ds, err := dcm.ReadDicom(inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{AnonID: strings.TrimSpace(ds.GetPatientID())}
+
+	entry, ok := mapper.Reverse(result.AnonID)
+	if !ok {
+		return nil, fmt.Errorf("anonymous ID %q not found in mapping", result.AnonID)
+	}
+
+	if len(entry.PatientIDs) > 0 {
+		result.PatientID = entry.PatientIDs[0]
+		ds.SetString(tag.PatientID, result.PatientID)
+		// Identifies the patient again, so a later run must not skip it
+		ds.RemoveTag(tag.PatientIdentityRemoved)
+		ds.RemoveTag(tag.DeidentificationMethod)
+		ds.RemoveTag(tag.DeidentificationMethodCodeSequence)
+	}
+
+	if uids != nil {
+		for _, t := range UIDTagsToRemap {
+			if original, ok := uids.Original(ds.GetString(t)); ok {
+				ds.SetString(t, original)
+				result.UIDsRestored++
+			}
+		}
+	}
+
+	if days, ok := mapper.LookupDateShift(result.AnonID); ok {
+		if profile == nil {
+			profile = DefaultTagProfile()
+		}
+		for _, t := range profile.DateTags() {
+			ds.ShiftDate(t, -days)
+		}
+		result.DatesRestored = true
+	}
+
+	if err := ds.Save(outputPath); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
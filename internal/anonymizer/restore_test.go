@@ -0,0 +1,116 @@
+package anonymizer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// writeTestFile writes a minimal metadata-only DICOM file.
+func writeTestFile(t testing.TB, path string, values map[tag.Tag]string) {
+	t.Helper()
+
+	elems := []*dicom.Element{}
+	for _, e := range []struct {
+		t     tag.Tag
+		value string
+	}{
+		{tag.MediaStorageSOPClassUID, "1.2.840.10008.5.1.4.1.1.7"},
+		{tag.MediaStorageSOPInstanceUID, values[tag.SOPInstanceUID]},
+		{tag.TransferSyntaxUID, "1.2.840.10008.1.2.1"},
+		{tag.PatientName, values[tag.PatientName]},
+		{tag.PatientID, values[tag.PatientID]},
+		{tag.StudyDate, values[tag.StudyDate]},
+		{tag.SOPInstanceUID, values[tag.SOPInstanceUID]},
+		{tag.StudyInstanceUID, values[tag.StudyInstanceUID]},
+	} {
+		elem, err := dicom.NewElement(e.t, []string{e.value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	for _, optional := range []tag.Tag{tag.PatientBirthDate, tag.Modality} {
+		value, ok := values[optional]
+		if !ok {
+			continue
+		}
+		elem, err := dicom.NewElement(optional, []string{value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", optional, err)
+		}
+		elems = append(elems, elem)
+	}
+
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+	if err := ds.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.dcm")
+	anonymized := filepath.Join(dir, "anon.dcm")
+	restored := filepath.Join(dir, "restored.dcm")
+	mappingFile := filepath.Join(dir, "patient_mapping.json")
+
+	original := map[tag.Tag]string{
+		tag.PatientName:      "SMITH^JOHN",
+		tag.PatientID:        "MRN123",
+		tag.StudyDate:        "20240115",
+		tag.SOPInstanceUID:   "1.2.3.4.5.6",
+		tag.StudyInstanceUID: "1.2.3.4.5",
+	}
+	writeTestFile(t, input, original)
+
+	mapper, err := identity.NewPseudonymizationMapper(mappingFile, "secret")
+	if err != nil {
+		t.Fatalf("NewPseudonymizationMapper failed: %v", err)
+	}
+	uids := identity.NewUIDMapper(identity.UIDMappingFile(mappingFile), "secret", "")
+	anonID, _ := mapper.GetAnonID(original[tag.PatientID], original[tag.PatientName], "19700101")
+	dates := DateHandling{Policy: DatePolicyShiftDays, ShiftDays: mapper.GetDateShift(anonID)}
+
+	opts := FileOptions{PatientID: anonID, UIDs: uids, Dates: dates, Salt: "secret"}
+	if err := AnonymizeMetadata(input, anonymized, opts); err != nil {
+		t.Fatalf("AnonymizeMetadata failed: %v", err)
+	}
+
+	ds, err := dcm.ReadDicom(anonymized)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	if got := ds.GetString(tag.StudyInstanceUID); got == original[tag.StudyInstanceUID] {
+		t.Errorf("StudyInstanceUID was not remapped")
+	}
+	if got, want := ds.GetString(tag.MediaStorageSOPInstanceUID), ds.GetString(tag.SOPInstanceUID); got != want {
+		t.Errorf("MediaStorageSOPInstanceUID = %q, want %q", got, want)
+	}
+	if got := ds.GetString(tag.StudyDate); got == original[tag.StudyDate] {
+		t.Errorf("StudyDate was not shifted")
+	}
+
+	result, err := Restore(anonymized, restored, mapper, uids, nil)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if !result.DatesRestored || result.UIDsRestored != 3 {
+		t.Errorf("Restore result = %+v", result)
+	}
+
+	ds, err = dcm.ReadDicom(restored)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	for _, tg := range []tag.Tag{tag.PatientID, tag.StudyDate, tag.SOPInstanceUID, tag.StudyInstanceUID} {
+		if got := ds.GetString(tg); got != original[tg] {
+			t.Errorf("%v = %q, want %q", tg, got, original[tg])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package anonymizer
+
+import (
+	"strings"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// ScanSummary counts what a set of DICOM files holds, to pick modality
+// options before a run. Nothing is written.
+type ScanSummary struct {
+	Files            int
+	Unreadable       int            // Metadata could not be read
+	Modalities       map[string]int // By upper-case Modality ("" = not set)
+	TransferSyntaxes map[string]int // By TransferSyntaxUID ("" = not set)
+
+	// How files will be grouped into patients: by Name+DOB, by PatientID
+	// because Name or DOB is missing or a placeholder, or by neither (see
+	// UnidentifiedPolicy)
+	NameAndDOB    int
+	PatientIDOnly int
+	Unidentified  int
+}
+
+// ScanFiles reads the metadata of files and counts their modalities,
+// transfer syntaxes and identifiers
+func ScanFiles(files []string) *ScanSummary {
+	summary := &ScanSummary{
+		Modalities:       make(map[string]int),
+		TransferSyntaxes: make(map[string]int),
+	}
+	for _, path := range files {
+		summary.Files++
+		ds, err := dcm.ReadDicomMetadataOnly(path)
+		if err != nil {
+			summary.Unreadable++
+			continue
+		}
+
+		summary.Modalities[strings.ToUpper(strings.TrimSpace(ds.GetModality()))]++
+		summary.TransferSyntaxes[strings.TrimSpace(ds.GetTransferSyntax())]++
+
+		switch {
+		case identity.IsValidIdentity(ds.GetPatientName(), ds.GetPatientBirthDate()):
+			summary.NameAndDOB++
+		case strings.TrimSpace(ds.GetPatientID()) != "":
+			summary.PatientIDOnly++
+		default:
+			summary.Unidentified++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,45 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func TestScanFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for name, values := range map[string]map[tag.Tag]string{
+		"identified.dcm":  {tag.PatientName: "SMITH^JOHN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN1", tag.Modality: "US"},
+		"placeholder.dcm": {tag.PatientName: "UNKNOWN", tag.PatientBirthDate: "19800101", tag.PatientID: "MRN2", tag.Modality: "us"},
+		"pid-only.dcm":    {tag.PatientID: "MRN3", tag.Modality: "CT"},
+		"anonymous.dcm":   {},
+	} {
+		values[tag.SOPInstanceUID] = "1.2.3." + name[:3]
+		path := filepath.Join(dir, name)
+		writeTestFile(t, path, values)
+		files = append(files, path)
+	}
+	broken := filepath.Join(dir, "broken.dcm")
+	if err := os.WriteFile(broken, []byte("not DICOM"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, broken)
+
+	summary := ScanFiles(files)
+	if summary.Files != 5 || summary.Unreadable != 1 {
+		t.Errorf("Files, Unreadable = %d, %d, want 5, 1", summary.Files, summary.Unreadable)
+	}
+	if summary.Modalities["US"] != 2 || summary.Modalities["CT"] != 1 || summary.Modalities[""] != 1 {
+		t.Errorf("Modalities = %v, want US:2 CT:1 (none):1", summary.Modalities)
+	}
+	if summary.TransferSyntaxes["1.2.840.10008.1.2.1"] != 4 {
+		t.Errorf("TransferSyntaxes = %v, want 4 explicit VR little endian", summary.TransferSyntaxes)
+	}
+	if summary.NameAndDOB != 1 || summary.PatientIDOnly != 2 || summary.Unidentified != 1 {
+		t.Errorf("NameAndDOB, PatientIDOnly, Unidentified = %d, %d, %d, want 1, 2, 1",
+			summary.NameAndDOB, summary.PatientIDOnly, summary.Unidentified)
+	}
+}
@@ -0,0 +1,130 @@
+package anonymizer
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// ScrubReplacement replaces each match of a scrub pattern
+const ScrubReplacement = "***"
+
+// ScrubVRs are the free-text VRs a Scrubber rewrites, e.g. the
+// StudyDescription (LO) kept for clinical context
+var ScrubVRs = []string{"LO", "LT", "ST", "UT"}
+
+// Scrubber redacts identifying text inside the free-text values a profile
+// keeps, leaving the rest of each value: with the patient's name among
+// the tokens, "CT CHEST - JOHN SMITH" becomes "CT CHEST - ***".
+type Scrubber struct {
+	Patterns    []*regexp.Regexp
+	PatientName bool // Also redact the tokens of the file's own PatientName
+}
+
+// NewScrubber compiles patterns (Go regular expressions). It returns nil
+// when there is nothing to scrub.
+func NewScrubber(patterns []string, patientName bool) (*Scrubber, error) {
+	if len(patterns) == 0 && !patientName {
+		return nil, nil
+	}
+	s := &Scrubber{PatientName: patientName}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern %q: %w", pattern, err)
+		}
+		s.Patterns = append(s.Patterns, re)
+	}
+	return s, nil
+}
+
+// patientNamePattern matches the tokens of a DICOM PatientName in any
+// case and order; adjacent tokens match as one, so "JOHN SMITH" is
+// replaced once. Single letters (initials) are ignored. Returns nil for
+// names without tokens.
+func patientNamePattern(name string) *regexp.Regexp {
+	name = strings.ToUpper(name)
+	name = nonLetterRegex.ReplaceAllString(name, " ")
+
+	var tokens []string
+	seen := make(map[string]bool)
+	for _, token := range strings.Fields(name) {
+		if utf8.RuneCountInString(token) < 2 || seen[token] {
+			continue
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	// Longest first, so ANNA is not matched as ANN
+	sort.Slice(tokens, func(i, j int) bool { return len(tokens[i]) > len(tokens[j]) })
+	for i, token := range tokens {
+		tokens[i] = regexp.QuoteMeta(token)
+	}
+	word := `(?:` + strings.Join(tokens, "|") + `)`
+	return regexp.MustCompile(`(?i)` + word + `(?:[\s^,.-]+` + word + `)*`)
+}
+
+var nonLetterRegex = regexp.MustCompile(`[^\p{L}]+`)
+
+// replaceWords replaces the matches of re that are whole words: \b only
+// knows ASCII letters, so JOSÉ would not end at a boundary.
+func replaceWords(re *regexp.Regexp, value string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringIndex(value, -1) {
+		before, _ := utf8.DecodeLastRuneInString(value[:m[0]])
+		after, _ := utf8.DecodeRuneInString(value[m[1]:])
+		if isWordRune(before) || isWordRune(after) {
+			continue // Part of a longer word, e.g. JOHN in JOHNSON
+		}
+		b.WriteString(value[last:m[0]])
+		b.WriteString(ScrubReplacement)
+		last = m[1]
+	}
+	if last == 0 {
+		return value
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// scrub applies the scrubber to ds and its sequence items. name is the
+// PatientName the file had before anonymization.
+func (s *Scrubber) scrub(ds *dcm.Dataset, name string) {
+	if s == nil {
+		return
+	}
+	var namePattern *regexp.Regexp
+	if s.PatientName {
+		namePattern = patientNamePattern(name)
+	}
+	if namePattern == nil && len(s.Patterns) == 0 {
+		return
+	}
+
+	redact := func(value string) string {
+		if namePattern != nil {
+			value = replaceWords(namePattern, value)
+		}
+		for _, re := range s.Patterns {
+			value = re.ReplaceAllLiteralString(value, ScrubReplacement)
+		}
+		return value
+	}
+	ds.MapStrings(ScrubVRs, redact)
+	ds.WalkSequences(func(item *dcm.Dataset) {
+		item.MapStrings(ScrubVRs, redact)
+	})
+}
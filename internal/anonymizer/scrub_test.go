@@ -0,0 +1,76 @@
+package anonymizer
+
+import (
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestPatientNamePattern(t *testing.T) {
+	tests := []struct{ name, value, want string }{
+		{"SMITH^JOHN", "CT CHEST - JOHN SMITH", "CT CHEST - ***"},
+		{"SMITH^JOHN", "smith, john: follow-up", "***: follow-up"},
+		{"SMITH^JOHN", "JOHNSON KNEE", "JOHNSON KNEE"},
+		{"SMITH^JOHN^A", "A SMITH", "A ***"}, // Initials are not redacted
+		{"MÜLLER^JOSÉ", "MRT KNIE JOSÉ MÜLLER", "MRT KNIE ***"},
+		{"ANN^ANNA", "ANNA", "***"},
+		{"^^", "CT CHEST", "CT CHEST"},
+	}
+	for _, tt := range tests {
+		got := tt.value
+		if re := patientNamePattern(tt.name); re != nil {
+			got = replaceWords(re, tt.value)
+		}
+		if got != tt.want {
+			t.Errorf("name %q: %q -> %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestScrubKeptText(t *testing.T) {
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t     tag.Tag
+		value string
+	}{
+		{tag.StudyDescription, "CT CHEST - JOHN SMITH"},
+		{tag.SeriesDescription, "AXIAL 5MM"},
+		{tag.ImageComments, "Prior at MRN 12345"},
+		{tag.PatientName, "SMITH^JOHN"},
+		{tag.PatientID, "MRN123"},
+	} {
+		elem, err := dicom.NewElement(e.t, []string{e.value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+
+	scrub, err := NewScrubber([]string{`MRN \d+`}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	FileOptions{PatientID: "ANON-000001", Scrub: scrub}.applyTo(ds)
+
+	for tg, want := range map[tag.Tag]string{
+		tag.StudyDescription:  "CT CHEST - ***",
+		tag.SeriesDescription: "AXIAL 5MM",
+		tag.ImageComments:     "Prior at ***",
+		tag.PatientID:         "ANON-000001",
+	} {
+		if got := ds.GetString(tg); got != want {
+			t.Errorf("%v = %q, want %q", tg, got, want)
+		}
+	}
+
+	if _, err := NewScrubber([]string{"("}, false); err == nil {
+		t.Error("NewScrubber accepted an invalid pattern")
+	}
+	if s, _ := NewScrubber(nil, false); s != nil {
+		t.Error("NewScrubber without patterns should return nil")
+	}
+}
@@ -2,98 +2,55 @@ package anonymizer
 
 import "github.com/suyashkumar/dicom/pkg/tag"
 
-// PIITagsToClear are DICOM tags to clear completely (set to empty string)
-var PIITagsToClear = []tag.Tag{
-	// Patient identifiers
-	tag.PatientName,
-	tag.PatientBirthDate,
-	tag.PatientAge,
-	// tag.PatientSex - KEPT for clinical relevance
-	tag.PatientAddress,
-	tag.PatientTelephoneNumbers,
-	tag.OtherPatientIDs,
-	tag.OtherPatientIDsSequence,
-	tag.PatientBirthTime,
-	tag.PatientMotherBirthName,
-	tag.MilitaryRank,
-	tag.EthnicGroup,
-	tag.PatientReligiousPreference,
-	tag.PatientComments,
-
-	// Times only (dates handled separately to keep year-month)
-	tag.StudyTime,
-	tag.SeriesTime,
-	tag.AcquisitionTime,
-	tag.ContentTime,
-	tag.InstanceCreationTime,
-
-	// Institution information (InstitutionName KEPT for research tracking)
-	tag.InstitutionAddress,
-	tag.InstitutionalDepartmentName,
-	tag.StationName,
-
-	// Study/Series descriptions - KEPT for clinical context
-
-	// Physician information
-	tag.ReferringPhysicianName,
-	tag.ReferringPhysicianAddress,
-	tag.ReferringPhysicianTelephoneNumbers,
-	tag.PerformingPhysicianName,
-	tag.OperatorsName,
-	tag.PhysiciansOfRecord,
-	tag.NameOfPhysiciansReadingStudy,
-	tag.RequestingPhysician,
-	tag.ScheduledPerformingPhysicianName,
-
-	// Other identifiers
-	tag.AccessionNumber,
-	tag.RequestAttributesSequence,
-	tag.PerformedProcedureStepID,
-	tag.ScheduledProcedureStepID,
-	tag.StudyID,
-}
-
-// DateTagsToTruncate are DICOM date tags to truncate to YYYYMM01
-var DateTagsToTruncate = []tag.Tag{
-	tag.StudyDate,
-	tag.SeriesDate,
-	tag.AcquisitionDate,
-	tag.ContentDate,
-	tag.InstanceCreationDate,
+// SafePrivateCreators are private creators whose blocks hold acquisition
+// parameters rather than patient data (see the Retain Safe Private Option
+// in DICOM PS3.15 Table E.3.10-1). RemovePrivateTags keeps their blocks.
+var SafePrivateCreators = []string{
+	"GEMS_ACQU_01",
+	"GEMS_PARM_01",
+	"GEMS_SERS_01",
+	"SIEMENS MR HEADER",
+	"SIEMENS CT VA0  COAD",
+	"Philips Imaging DD 001",
+	"Philips MR Imaging DD 001",
 }
 
-// UltrasoundPIITags are additional PII tags specific to ultrasound
-var UltrasoundPIITags = []tag.Tag{
-	tag.PatientName,
-	tag.PatientBirthDate,
-	tag.PatientAge,
-	// tag.PatientSex - KEPT for clinical relevance
-	tag.PatientAddress,
-	tag.PatientTelephoneNumbers,
-	tag.OtherPatientIDs,
-	tag.StudyTime,
-	tag.SeriesTime,
-	tag.AcquisitionTime,
-	tag.ContentTime,
-	// tag.InstitutionName - KEPT for research tracking
-	tag.InstitutionAddress,
-	tag.InstitutionalDepartmentName,
-	tag.StationName,
-	tag.ReferringPhysicianName,
-	tag.PerformingPhysicianName,
-	tag.OperatorsName,
-	tag.PhysiciansOfRecord,
-	tag.NameOfPhysiciansReadingStudy,
-	tag.AccessionNumber,
-	tag.StudyID,
-	// tag.StudyDescription - KEPT for clinical context
-	// tag.SeriesDescription - KEPT for clinical context
+// CalibrationTags are the measurement and display calibration attributes
+// quantitative research depends on. Neither built-in profile changes them;
+// with FileOptions.PreserveCalibration they are kept even if a custom
+// profile clears them. Kept sequences keep their whole items, such as the
+// PhysicalDeltaX of each ultrasound region.
+var CalibrationTags = []tag.Tag{
+	tag.SliceThickness,
+	tag.ImagerPixelSpacing,
+	tag.NominalScannedPixelSpacing,
+	tag.SpacingBetweenSlices,
+	tag.EstimatedRadiographicMagnificationFactor,
+	tag.SequenceOfUltrasoundRegions,
+	tag.PhysicalUnitsXDirection,
+	tag.PhysicalUnitsYDirection,
+	tag.ReferencePixelX0,
+	tag.ReferencePixelY0,
+	tag.PhysicalDeltaX,
+	tag.PhysicalDeltaY,
+	tag.PixelSpacing,
+	tag.PixelAspectRatio,
+	tag.PixelSpacingCalibrationType,
+	tag.WindowCenter,
+	tag.WindowWidth,
+	tag.RescaleIntercept,
+	tag.RescaleSlope,
+	tag.RescaleType,
+	tag.VOILUTFunction,
+	tag.Units,
+	tag.RealWorldValueMappingSequence,
 }
 
-// UltrasoundDateTags are date tags for ultrasound
-var UltrasoundDateTags = []tag.Tag{
-	tag.StudyDate,
-	tag.SeriesDate,
-	tag.AcquisitionDate,
-	tag.ContentDate,
+// UIDTagsToRemap are UID tags replaced with deterministic pseudonymous UIDs
+var UIDTagsToRemap = []tag.Tag{
+	tag.StudyInstanceUID,
+	tag.SeriesInstanceUID,
+	tag.SOPInstanceUID,
+	tag.FrameOfReferenceUID,
+	tag.MediaStorageSOPInstanceUID, // File meta, must match SOPInstanceUID
 }
@@ -0,0 +1,127 @@
+package anonymizer
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// TextDetector finds burned-in text in a frame, returning the bounding
+// boxes of the text in pixel coordinates. Implementations must be safe for
+// concurrent use, as worker goroutines share the detector.
+type TextDetector interface {
+	DetectText(img image.Image) ([]image.Rectangle, error)
+}
+
+// NoTextDetector is the default TextDetector. It never finds text, so only
+// the fixed regions are redacted.
+type NoTextDetector struct{}
+
+// DetectText returns no boxes
+func (NoTextDetector) DetectText(image.Image) ([]image.Rectangle, error) {
+	return nil, nil
+}
+
+// textBoxPadding widens detected boxes to cover anti-aliased glyph edges
+const textBoxPadding = 2
+
+// FrameText is the text detected in one frame
+type FrameText struct {
+	Frame int // Zero-based frame index
+	Boxes []image.Rectangle
+}
+
+// FileText lists the frames of a file with detected text
+type FileText struct {
+	Path   string
+	Frames []FrameText
+	Error  string // Why the file could not be checked (empty = ok)
+}
+
+// DetectFrameText runs the detector on every frame of ds and returns the
+// frames with text.
+func DetectFrameText(ds *dcm.Dataset, detector TextDetector) ([]FrameText, error) {
+	images, err := ds.FrameImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []FrameText
+	for i, img := range images {
+		boxes, err := detector.DetectText(img)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		if len(boxes) > 0 {
+			frames = append(frames, FrameText{Frame: i, Boxes: boxes})
+		}
+	}
+	return frames, nil
+}
+
+// textRegions returns the padded boxes of all frames. The same regions are
+// redacted in every frame, since ultrasound overlays rarely move.
+func textRegions(frames []FrameText) []image.Rectangle {
+	var regions []image.Rectangle
+	for _, f := range frames {
+		for _, box := range f.Boxes {
+			box = box.Inset(-textBoxPadding)
+			box.Min.X, box.Min.Y = max(box.Min.X, 0), max(box.Min.Y, 0)
+			regions = append(regions, box)
+		}
+	}
+	return regions
+}
+
+// detectPatientText runs the detector on the ultrasound files of the
+// patients without writing anything, for dry runs.
+func detectPatientText(patients []*PatientGroup, detector TextDetector) []FileText {
+	var results []FileText
+	for _, patient := range patients {
+		for _, path := range patient.Files {
+			if meta, err := dcm.ReadDicomMetadataOnly(path); err != nil || !meta.IsUltrasound() {
+				continue
+			}
+
+			result := FileText{Path: path}
+			ds, err := dcm.ReadDecoded(path)
+			if err == nil {
+				result.Frames, err = DetectFrameText(ds, detector)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// formatFileText renders detected text for the dry run output
+func formatFileText(results []FileText) string {
+	var b strings.Builder
+	found := 0
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(&b, "  %s: error: %s\n", filepath.Base(r.Path), r.Error)
+			continue
+		}
+		if len(r.Frames) == 0 {
+			continue
+		}
+		found++
+
+		frames := make([]string, len(r.Frames))
+		boxes := 0
+		for i, f := range r.Frames {
+			frames[i] = fmt.Sprint(f.Frame)
+			boxes += len(f.Boxes)
+		}
+		fmt.Fprintf(&b, "  %s: frames %s (%d boxes)\n", filepath.Base(r.Path), strings.Join(frames, ", "), boxes)
+	}
+	fmt.Fprintf(&b, "  %d of %d ultrasound file(s) have detected text\n", found, len(results))
+	return b.String()
+}
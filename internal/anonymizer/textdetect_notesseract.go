@@ -0,0 +1,11 @@
+//go:build !tesseract
+
+package anonymizer
+
+import "fmt"
+
+// NewOCRDetector returns the Tesseract detector, which is only available in
+// builds with -tags tesseract.
+func NewOCRDetector() (TextDetector, error) {
+	return nil, fmt.Errorf("OCR is not available in this build (rebuild with -tags tesseract)")
+}
@@ -0,0 +1,61 @@
+//go:build tesseract
+
+package anonymizer
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+
+	"github.com/otiai10/gosseract/v2"
+)
+
+// TesseractDetector detects text with the Tesseract OCR engine through
+// gosseract. Building it requires libtesseract and
+//
+//	go get github.com/otiai10/gosseract/v2
+//	go build -tags tesseract ./cmd/anonymizer
+type TesseractDetector struct {
+	Languages     []string // Tesseract language codes (nil = "eng")
+	MinConfidence float64  // Ignore words recognized with less confidence, 0-100
+}
+
+// NewOCRDetector returns the Tesseract detector with default settings.
+func NewOCRDetector() (TextDetector, error) {
+	return &TesseractDetector{MinConfidence: 30}, nil
+}
+
+// DetectText returns the boxes of the words Tesseract recognizes. Each call
+// uses its own client, as gosseract clients are not safe for concurrent use.
+func (d *TesseractDetector) DetectText(img image.Image) ([]image.Rectangle, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	client := gosseract.NewClient()
+	defer client.Close()
+	if len(d.Languages) > 0 {
+		if err := client.SetLanguage(d.Languages...); err != nil {
+			return nil, err
+		}
+	}
+	if err := client.SetImageFromBytes(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	words, err := client.GetBoundingBoxes(gosseract.RIL_WORD)
+	if err != nil {
+		return nil, err
+	}
+
+	var boxes []image.Rectangle
+	for _, w := range words {
+		if strings.TrimSpace(w.Word) == "" || w.Confidence < d.MinConfidence {
+			continue
+		}
+		boxes = append(boxes, w.Box)
+	}
+	return boxes, nil
+}
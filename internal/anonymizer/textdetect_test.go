@@ -0,0 +1,62 @@
+package anonymizer
+
+import (
+	"image"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// secondFrameDetector finds one box, in the second frame it is called on
+type secondFrameDetector struct {
+	calls int
+	box   image.Rectangle
+}
+
+func (d *secondFrameDetector) DetectText(img image.Image) ([]image.Rectangle, error) {
+	d.calls++
+	if d.calls == 2 {
+		return []image.Rectangle{d.box}, nil
+	}
+	return nil, nil
+}
+
+func TestDetectedTextIsRedactedInEveryFrame(t *testing.T) {
+	rows, cols := 8, 8
+	ds := newMultiFrameDataset(t, rows, cols, 3)
+	detector := &secondFrameDetector{box: image.Rect(4, 4, 5, 5)}
+
+	frames, err := DetectFrameText(ds, detector)
+	if err != nil {
+		t.Fatalf("DetectFrameText failed: %v", err)
+	}
+	if detector.calls != 3 || len(frames) != 1 || frames[0].Frame != 1 {
+		t.Fatalf("frames = %+v after %d calls, want text in frame 1 of 3", frames, detector.calls)
+	}
+
+	if err := redactRegions(ds, textRegions(frames)); err != nil {
+		t.Fatalf("redactRegions failed: %v", err)
+	}
+
+	// The box is padded to (2,2)-(7,7) and redacted in all frames
+	elem, _ := ds.Data.FindElementByTag(tag.PixelData)
+	for f, fr := range elem.Value.GetValue().(dicom.PixelDataInfo).Frames {
+		for i, pixel := range fr.NativeData.Data {
+			want := 200
+			if image.Pt(i%cols, i/cols).In(image.Rect(2, 2, 7, 7)) {
+				want = 0
+			}
+			if pixel[0] != want {
+				t.Errorf("frame %d pixel (%d,%d) = %d, want %d", f, i%cols, i/cols, pixel[0], want)
+			}
+		}
+	}
+}
+
+func TestNoTextDetector(t *testing.T) {
+	frames, err := DetectFrameText(newMultiFrameDataset(t, 4, 4, 2), NoTextDetector{})
+	if err != nil || len(frames) != 0 {
+		t.Errorf("NoTextDetector found %+v, %v; want nothing", frames, err)
+	}
+}
@@ -1,8 +1,13 @@
 package anonymizer
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/pkg/frame"
@@ -11,20 +16,37 @@ import (
 	dcm "dicom-anonymizer/internal/dicom"
 )
 
+// ErrPixelsNotRedacted is returned by AnonymizeUltrasound when it wrote the
+// file with opts.MetadataOnlyFallback: metadata is anonymized but the
+// burned-in pixels are not redacted
+var ErrPixelsNotRedacted = errors.New("pixels not redacted: dcmtk not installed")
+
 // AnonymizeUltrasound anonymizes an ultrasound DICOM file with pixel redaction.
-func AnonymizeUltrasound(inputPath, outputPath string, redactRows int, patientID string) error {
+// Pixels inside any of regions are always redacted; redactRows adds a
+// full-width band at the top when the file declares no ultrasound regions.
+func AnonymizeUltrasound(inputPath, outputPath string, redactRows int, regions []image.Rectangle, opts FileOptions) error {
 	var ds *dcm.Dataset
 	var tempFile string
 	var err error
 
-	// Track if original was JPEG-LS compressed for re-compression
+	// Track if original was JPEG-LS or RLE compressed for re-compression
 	wasJPEGLSCompressed := dcm.IsJPEGLSCompressed(inputPath)
+	wasRLECompressed := !wasJPEGLSCompressed && dcm.IsRLECompressed(inputPath)
 
 	// Handle JPEG-LS compression
 	if wasJPEGLSCompressed {
 		tempFile, err = dcm.DecompressJPEGLS(inputPath)
+		if err != nil && opts.MetadataOnlyFallback && errors.Is(err, dcm.ErrDcmtkNotInstalled) {
+			// There is no pure Go JPEG-LS decoder, so the pixels can only
+			// be copied as they are
+			opts.pixelsNotRedacted = true
+			if err := AnonymizeMetadata(inputPath, outputPath, opts); err != nil {
+				return err
+			}
+			return ErrPixelsNotRedacted
+		}
 		if err != nil {
-			return fmt.Errorf("JPEG-LS decompression failed: %w", err)
+			return fail(FailureDecompress, "JPEG-LS decompression failed: %w", err)
 		}
 		defer os.Remove(tempFile)
 
@@ -34,52 +56,202 @@ func AnonymizeUltrasound(inputPath, outputPath string, redactRows int, patientID
 	}
 
 	if err != nil {
-		return fmt.Errorf("could not read DICOM: %w", err)
+		return fail(FailureParse, "could not read DICOM: %w", err)
 	}
 
-	// Redact pixel data (top rows contain burned-in text)
-	if err := redactPixels(ds, redactRows); err != nil {
-		return fmt.Errorf("pixel redaction failed: %w", err)
+	// RLE is decoded in-process, no dcmtk needed
+	if wasRLECompressed {
+		if err := ds.DecompressRLE(); err != nil {
+			return fail(FailureDecompress, "RLE decompression failed: %w", err)
+		}
 	}
 
-	// Set anonymized patient ID
-	ds.SetString(tag.PatientID, patientID)
-
-	// Clear PII fields
-	for _, t := range UltrasoundPIITags {
-		ds.ClearTag(t)
+	// Detected text is redacted on top of the fixed regions
+	if opts.TextDetector != nil {
+		frames, err := DetectFrameText(ds, opts.TextDetector)
+		if err != nil {
+			return fail(FailureRedaction, "text detection failed: %w", err)
+		}
+		regions = append(append([]image.Rectangle(nil), regions...), textRegions(frames)...)
 	}
 
-	// Truncate dates to year-month only (YYYYMM01)
-	for _, t := range UltrasoundDateTags {
-		ds.TruncateDate(t)
+	// Redact burned-in text
+	redacted, err := redactMasked(ds, RedactionMask(ds, redactRows, regions))
+	if err != nil {
+		return fail(FailureRedaction, "pixel redaction failed: %w", err)
 	}
 
+	// An empty mask, e.g. no rows and regions, leaves the pixels as they are
+	opts.pixelsCleaned = redacted > 0
+	opts.applyTo(ds)
+
 	// Save anonymized file with re-compression if original was compressed
-	return ds.SaveWithOptions(outputPath, dcm.SaveOptions{
+	if err := ds.SaveWithOptions(outputPath, dcm.SaveOptions{
 		CompressJPEGLS: wasJPEGLSCompressed,
-	})
+		CompressRLE:    wasRLECompressed,
+		Progress:       opts.encodeProgress,
+	}); err != nil {
+		return fail(FailureWrite, "%w", err)
+	}
+	return nil
+}
+
+// RedactionMask returns the pixels AnonymizeUltrasound blacks out to remove
+// burned-in text: everything outside the declared ultrasound regions when
+// the device reports them, otherwise the top rows. User regions are
+// redacted in both cases.
+func RedactionMask(ds *dcm.Dataset, redactRows int, regions []image.Rectangle) func(x, y int) bool {
+	if usRegs := usRegions(ds); len(usRegs) > 0 {
+		return func(x, y int) bool {
+			return !inAnyRect(usRegs, x, y) || inAnyRect(regions, x, y)
+		}
+	}
+	rects := append([]image.Rectangle{TopRowsRegion(redactRows)}, regions...)
+	return func(x, y int) bool { return inAnyRect(rects, x, y) }
 }
 
-// redactPixels blacks out the top rows of pixel data
+// redactPixels blacks out the top rows of pixel data. Black is the value
+// that displays as black under the PhotometricInterpretation, the highest
+// one for MONOCHROME1 (see blackSamples).
 func redactPixels(ds *dcm.Dataset, redactRows int) error {
+	return redactRegions(ds, []image.Rectangle{TopRowsRegion(redactRows)})
+}
+
+// redactRegions blacks out every pixel inside any of the rectangles.
+// Rectangles are clamped to the image bounds.
+func redactRegions(ds *dcm.Dataset, regions []image.Rectangle) error {
+	_, err := redactMasked(ds, func(x, y int) bool { return inAnyRect(regions, x, y) })
+	return err
+}
+
+// TopRowsRegion returns a full-width rectangle covering the top rows.
+func TopRowsRegion(rows int) image.Rectangle {
+	if rows <= 0 {
+		return image.Rectangle{}
+	}
+	return image.Rect(0, 0, math.MaxInt32, rows)
+}
+
+// ParseRegion parses a rectangle given as "x,y,w,h" in pixels.
+func ParseRegion(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q: expected x,y,w,h", s)
+	}
+
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return image.Rectangle{}, fmt.Errorf("invalid region %q: expected non-negative integers", s)
+		}
+		v[i] = n
+	}
+	if v[2] == 0 || v[3] == 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q: width and height must be positive", s)
+	}
+
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// FormatRegion formats a rectangle as "x,y,w,h".
+func FormatRegion(r image.Rectangle) string {
+	return fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+}
+
+// RedactOutsideUSRegion blacks out every pixel outside the data regions
+// declared in SequenceOfUltrasoundRegions, which is where vendors burn in
+// patient text.
+func RedactOutsideUSRegion(ds *dcm.Dataset) error {
+	regions := usRegions(ds)
+	if len(regions) == 0 {
+		return fmt.Errorf("no ultrasound regions declared")
+	}
+
+	_, err := redactMasked(ds, func(x, y int) bool {
+		return !inAnyRect(regions, x, y)
+	})
+	return err
+}
+
+// usRegions returns the pixel rectangles of SequenceOfUltrasoundRegions.
+// Region coordinates are inclusive, so Max is converted to an exclusive bound.
+func usRegions(ds *dcm.Dataset) []image.Rectangle {
+	seqElem, err := ds.Data.FindElementByTag(tag.SequenceOfUltrasoundRegions)
+	if err != nil || seqElem.Value == nil {
+		return nil
+	}
+
+	items, ok := seqElem.Value.GetValue().([]*dicom.SequenceItemValue)
+	if !ok {
+		return nil
+	}
+
+	var regions []image.Rectangle
+	for _, item := range items {
+		elems, ok := item.GetValue().([]*dicom.Element)
+		if !ok {
+			continue
+		}
+
+		var minX, minY, maxX, maxY *dicom.Element
+		for _, e := range elems {
+			switch e.Tag {
+			case tag.RegionLocationMinX0:
+				minX = e
+			case tag.RegionLocationMinY0:
+				minY = e
+			case tag.RegionLocationMaxX1:
+				maxX = e
+			case tag.RegionLocationMaxY1:
+				maxY = e
+			}
+		}
+		if minX == nil || minY == nil || maxX == nil || maxY == nil {
+			continue
+		}
+
+		r := image.Rect(getIntValue(minX), getIntValue(minY), getIntValue(maxX)+1, getIntValue(maxY)+1)
+		if !r.Empty() {
+			regions = append(regions, r)
+		}
+	}
+
+	return regions
+}
+
+func inAnyRect(rects []image.Rectangle, x, y int) bool {
+	p := image.Pt(x, y)
+	for _, r := range rects {
+		if p.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactMasked blacks out every pixel for which redact(x, y) is true, in
+// every frame, with the sample values of blackSamples. Returns how many
+// pixels were blacked out, summed over the frames.
+func redactMasked(ds *dcm.Dataset, redact func(x, y int) bool) (int, error) {
 	// Find pixel data element
 	pixelElem, err := ds.Data.FindElementByTag(tag.PixelData)
 	if err != nil {
-		return fmt.Errorf("no pixel data found: %w", err)
+		return 0, fail(FailureNoPixelData, "no pixel data found: %w", err)
 	}
 
 	// Get pixel data info
 	rowsElem, err := ds.Data.FindElementByTag(tag.Rows)
 	if err != nil {
-		return fmt.Errorf("no Rows tag found: %w", err)
+		return 0, fail(FailureNoPixelData, "no Rows tag found: %w", err)
 	}
 	colsElem, err := ds.Data.FindElementByTag(tag.Columns)
 	if err != nil {
-		return fmt.Errorf("no Columns tag found: %w", err)
+		return 0, fail(FailureNoPixelData, "no Columns tag found: %w", err)
 	}
 	samplesElem, _ := ds.Data.FindElementByTag(tag.SamplesPerPixel)
 	bitsAllocElem, _ := ds.Data.FindElementByTag(tag.BitsAllocated)
+	planarElem, _ := ds.Data.FindElementByTag(tag.PlanarConfiguration)
 
 	rows := getIntValue(rowsElem)
 	cols := getIntValue(colsElem)
@@ -92,45 +264,140 @@ func redactPixels(ds *dcm.Dataset, redactRows int) error {
 		bitsAlloc = 8
 	}
 	bytesPerSample := bitsAlloc / 8
+	// Color-by-plane data stores each sample's plane after the previous one
+	planar := samples > 1 && getIntValue(planarElem) == 1
+	black := blackSamples(ds, samples, bitsAlloc)
+
+	if rows == 0 || cols == 0 {
+		return 0, fail(FailureNoPixelData, "invalid image dimensions: %dx%d", cols, rows)
+	}
 
 	// Get the pixel data
 	pixelInfo := pixelElem.Value.GetValue()
+	redacted := 0
 
 	switch v := pixelInfo.(type) {
 	case dicom.PixelDataInfo:
-		// Handle native frames - modify in place
-		if len(v.Frames) > 0 {
-			for _, fr := range v.Frames {
-				redactFrame(fr, rows, cols, samples, bytesPerSample, redactRows)
+		// Handle native frames - modify every frame in place
+		for i, fr := range v.Frames {
+			if fr.Encapsulated {
+				return 0, fail(FailureDecompress, "frame %d is still compressed, cannot redact", i)
 			}
-			// Frames are modified in-place, no need to reassign
+			redacted += redactFrame(fr, cols, samples, planar, black, redact)
 		}
 	case []byte:
-		// Handle raw byte data
-		bytesPerRow := cols * samples * bytesPerSample
-		redactBytes := min(redactRows*bytesPerRow, len(v))
-		for i := 0; i < redactBytes; i++ {
-			v[i] = 0
+		// Handle raw byte data - frames are stored back to back, samples
+		// interleaved per pixel unless planar
+		bytesPerPixel := samples * bytesPerSample
+		frameSize := rows * cols * bytesPerPixel
+		planeSize := rows * cols * bytesPerSample
+		for start := 0; start < len(v); start += frameSize {
+			for y := 0; y < rows; y++ {
+				for x := 0; x < cols; x++ {
+					if !redact(x, y) {
+						continue
+					}
+					redacted++
+					for s := 0; s < samples; s++ {
+						offset := start + (y*cols+x)*bytesPerPixel + s*bytesPerSample
+						if planar {
+							offset = start + s*planeSize + (y*cols+x)*bytesPerSample
+						}
+						putSample(v[min(offset, len(v)):min(offset+bytesPerSample, len(v))], black[s])
+					}
+				}
+			}
 		}
 	}
 
-	return nil
+	return redacted, nil
 }
 
-// redactFrame blacks out the top rows of a frame
-func redactFrame(f *frame.Frame, _, cols, _, _ int, redactRows int) {
+// redactFrame sets the pixels of a native frame selected by redact to the
+// black sample values and returns how many it set
+func redactFrame(f *frame.Frame, cols, samples int, planar bool, black []int, redact func(x, y int) bool) int {
 	if f.NativeData.Data == nil {
-		return
+		return 0
+	}
+	redacted := 0
+
+	// The parser fills Data in stream order, so for planar data entry i
+	// holds the i-th group of samples of the RRR...GGG...BBB stream
+	if planar {
+		pixels := len(f.NativeData.Data)
+		for i := 0; i < pixels; i++ {
+			if !redact(i%cols, i/cols) {
+				continue
+			}
+			redacted++
+			for s := 0; s < samples; s++ {
+				k := s*pixels + i
+				if pixel := f.NativeData.Data[k/samples]; k%samples < len(pixel) {
+					pixel[k%samples] = black[s]
+				}
+			}
+		}
+		return redacted
 	}
 
 	// For NativeData, each pixel value is stored as an int
 	// Data is [][]int where outer is pixels, inner is samples
-	pixelsToRedact := min(redactRows*cols, len(f.NativeData.Data))
+	for i, pixel := range f.NativeData.Data {
+		if !redact(i%cols, i/cols) {
+			continue
+		}
+		redacted++
+		for j := range pixel {
+			if j < len(black) {
+				pixel[j] = black[j]
+			}
+		}
+	}
+	return redacted
+}
 
-	for i := 0; i < pixelsToRedact; i++ {
-		for j := range f.NativeData.Data[i] {
-			f.NativeData.Data[i][j] = 0
+// blackSamples returns the stored value of each sample that displays as
+// black, as an unsigned value of bitsAlloc bits: the lowest value for
+// MONOCHROME2 (the most negative one for signed data), the highest for
+// MONOCHROME1, where low values are white, and mid-range chroma for
+// YBR_FULL. Zero is only black for unsigned MONOCHROME2 and RGB; signed
+// data would show it as mid-gray.
+func blackSamples(ds *dcm.Dataset, samples, bitsAlloc int) []int {
+	bitsStoredElem, _ := ds.Data.FindElementByTag(tag.BitsStored)
+	pixelRepElem, _ := ds.Data.FindElementByTag(tag.PixelRepresentation)
+	bitsStored := getIntValue(bitsStoredElem)
+	if bitsStored <= 0 || bitsStored > bitsAlloc {
+		bitsStored = bitsAlloc
+	}
+	signed := getIntValue(pixelRepElem) == 1
+	photometric := strings.TrimSpace(ds.GetString(tag.PhotometricInterpretation))
+
+	black := make([]int, samples)
+	switch {
+	case samples == 1 && photometric == "MONOCHROME1":
+		black[0] = 1<<bitsStored - 1
+		if signed {
+			black[0] = 1<<(bitsStored-1) - 1
 		}
+	case samples == 1 && signed:
+		black[0] = -(1 << (bitsStored - 1))
+	case samples == 3 && photometric == "YBR_FULL":
+		black[1] = 1 << (bitsStored - 1)
+		black[2] = 1 << (bitsStored - 1)
+	}
+
+	// Two's complement in the allocated bits, so negative values are
+	// sign-extended over any padding bits
+	for i := range black {
+		black[i] &= 1<<bitsAlloc - 1
+	}
+	return black
+}
+
+// putSample stores a sample value little-endian in b
+func putSample(b []byte, value int) {
+	for i := range b {
+		b[i] = byte(value >> (8 * i))
 	}
 }
 
@@ -0,0 +1,456 @@
+package anonymizer
+
+import (
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/frame"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// newMultiFrameDataset builds an 8-bit grayscale dataset whose native frames
+// are filled with a non-zero value.
+func newMultiFrameDataset(t *testing.T, rows, cols, numFrames int) *dcm.Dataset {
+	t.Helper()
+
+	frames := make([]*frame.Frame, numFrames)
+	for f := range frames {
+		data := make([][]int, rows*cols)
+		for i := range data {
+			data[i] = []int{200}
+		}
+		frames[f] = &frame.Frame{
+			NativeData: frame.NativeFrame{
+				Data:          data,
+				Rows:          rows,
+				Cols:          cols,
+				BitsPerSample: 8,
+			},
+		}
+	}
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.Rows, []int{rows}},
+		{tag.Columns, []int{cols}},
+		{tag.SamplesPerPixel, []int{1}},
+		{tag.BitsAllocated, []int{8}},
+		{tag.PixelData, dicom.PixelDataInfo{Frames: frames}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+
+	return &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}
+}
+
+func TestRedactPixelsMultiFrame(t *testing.T) {
+	rows, cols, redactRows := 6, 4, 2
+	ds := newMultiFrameDataset(t, rows, cols, 3)
+
+	if err := redactPixels(ds, redactRows); err != nil {
+		t.Fatalf("redactPixels failed: %v", err)
+	}
+
+	elem, err := ds.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		t.Fatalf("no pixel data: %v", err)
+	}
+	pdi := elem.Value.GetValue().(dicom.PixelDataInfo)
+
+	for f, fr := range pdi.Frames {
+		for i, pixel := range fr.NativeData.Data {
+			want := 200
+			if i < redactRows*cols {
+				want = 0
+			}
+			if pixel[0] != want {
+				t.Errorf("frame %d pixel %d = %d, want %d", f, i, pixel[0], want)
+			}
+		}
+	}
+}
+
+func TestRedactPixelsRawBytesMultiFrame(t *testing.T) {
+	rows, cols, redactRows, numFrames := 6, 4, 2, 3
+	ds := newMultiFrameDataset(t, rows, cols, 1)
+
+	raw := make([]byte, rows*cols*numFrames)
+	for i := range raw {
+		raw[i] = 200
+	}
+	elem, err := dicom.NewElement(tag.PixelData, raw)
+	if err != nil {
+		t.Fatalf("NewElement failed: %v", err)
+	}
+	for i, e := range ds.Data.Elements {
+		if e.Tag == tag.PixelData {
+			ds.Data.Elements[i] = elem
+		}
+	}
+
+	if err := redactPixels(ds, redactRows); err != nil {
+		t.Fatalf("redactPixels failed: %v", err)
+	}
+
+	frameSize := rows * cols
+	for i, b := range raw {
+		want := byte(200)
+		if i%frameSize < redactRows*cols {
+			want = 0
+		}
+		if b != want {
+			t.Errorf("frame %d byte %d = %d, want %d", i/frameSize, i%frameSize, b, want)
+		}
+	}
+}
+
+func TestRedactOutsideUSRegion(t *testing.T) {
+	rows, cols := 6, 8
+	ds := newMultiFrameDataset(t, rows, cols, 2)
+
+	// One data region covering x 2..5, y 1..4 (inclusive)
+	var regionElems []*dicom.Element
+	for _, e := range []struct {
+		t     tag.Tag
+		value int
+	}{
+		{tag.RegionLocationMinX0, 2},
+		{tag.RegionLocationMinY0, 1},
+		{tag.RegionLocationMaxX1, 5},
+		{tag.RegionLocationMaxY1, 4},
+	} {
+		elem, err := dicom.NewElement(e.t, []int{e.value})
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		regionElems = append(regionElems, elem)
+	}
+	seq, err := dicom.NewElement(tag.SequenceOfUltrasoundRegions, [][]*dicom.Element{regionElems})
+	if err != nil {
+		t.Fatalf("NewElement(sequence) failed: %v", err)
+	}
+	ds.Data.Elements = append(ds.Data.Elements, seq)
+
+	if err := RedactOutsideUSRegion(ds); err != nil {
+		t.Fatalf("RedactOutsideUSRegion failed: %v", err)
+	}
+
+	elem, err := ds.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		t.Fatalf("no pixel data: %v", err)
+	}
+	pdi := elem.Value.GetValue().(dicom.PixelDataInfo)
+
+	for f, fr := range pdi.Frames {
+		for i, pixel := range fr.NativeData.Data {
+			x, y := i%cols, i/cols
+			want := 0
+			if x >= 2 && x <= 5 && y >= 1 && y <= 4 {
+				want = 200
+			}
+			if pixel[0] != want {
+				t.Errorf("frame %d pixel (%d,%d) = %d, want %d", f, x, y, pixel[0], want)
+			}
+		}
+	}
+}
+
+func TestRedactRegionsMultiSampleRawBytes(t *testing.T) {
+	rows, cols, samples := 4, 6, 3
+	ds := newMultiFrameDataset(t, rows, cols, 1)
+
+	raw := make([]byte, rows*cols*samples)
+	for i := range raw {
+		raw[i] = 200
+	}
+	for i, e := range ds.Data.Elements {
+		var data interface{}
+		switch e.Tag {
+		case tag.PixelData:
+			data = raw
+		case tag.SamplesPerPixel:
+			data = []int{samples}
+		default:
+			continue
+		}
+		elem, err := dicom.NewElement(e.Tag, data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.Tag, err)
+		}
+		ds.Data.Elements[i] = elem
+	}
+
+	// Banner on the right edge, partly outside the image
+	region, err := ParseRegion("4,1,10,2")
+	if err != nil {
+		t.Fatalf("ParseRegion failed: %v", err)
+	}
+	if err := redactRegions(ds, []image.Rectangle{region}); err != nil {
+		t.Fatalf("redactRegions failed: %v", err)
+	}
+
+	for i, b := range raw {
+		pixel := i / samples
+		x, y := pixel%cols, pixel/cols
+		want := byte(200)
+		if x >= 4 && y >= 1 && y <= 2 {
+			want = 0
+		}
+		if b != want {
+			t.Errorf("pixel (%d,%d) sample %d = %d, want %d", x, y, i%samples, b, want)
+		}
+	}
+}
+
+func TestRedactRegionsPlanarRawBytes(t *testing.T) {
+	rows, cols, samples := 2, 4, 3
+	ds := newMultiFrameDataset(t, rows, cols, 1)
+
+	raw := make([]byte, rows*cols*samples)
+	for i := range raw {
+		raw[i] = 200
+	}
+	for i, e := range ds.Data.Elements {
+		var data interface{}
+		switch e.Tag {
+		case tag.PixelData:
+			data = raw
+		case tag.SamplesPerPixel:
+			data = []int{samples}
+		default:
+			continue
+		}
+		elem, err := dicom.NewElement(e.Tag, data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.Tag, err)
+		}
+		ds.Data.Elements[i] = elem
+	}
+	planar, err := dicom.NewElement(tag.PlanarConfiguration, []int{1})
+	if err != nil {
+		t.Fatalf("NewElement failed: %v", err)
+	}
+	ds.Data.Elements = append(ds.Data.Elements, planar)
+
+	// Left column only
+	if err := redactRegions(ds, []image.Rectangle{image.Rect(0, 0, 1, rows)}); err != nil {
+		t.Fatalf("redactRegions failed: %v", err)
+	}
+
+	// Each color plane holds rows*cols samples
+	for i, b := range raw {
+		pixel := i % (rows * cols)
+		x, y := pixel%cols, pixel/cols
+		want := byte(200)
+		if x == 0 {
+			want = 0
+		}
+		if b != want {
+			t.Errorf("plane %d pixel (%d,%d) = %d, want %d", i/(rows*cols), x, y, b, want)
+		}
+	}
+}
+
+// setElement replaces or adds an element of a test dataset
+func setElement(t *testing.T, ds *dcm.Dataset, tg tag.Tag, data interface{}) {
+	t.Helper()
+	elem, err := dicom.NewElement(tg, data)
+	if err != nil {
+		t.Fatalf("NewElement(%v) failed: %v", tg, err)
+	}
+	for i, e := range ds.Data.Elements {
+		if e.Tag == tg {
+			ds.Data.Elements[i] = elem
+			return
+		}
+	}
+	ds.Data.Elements = append(ds.Data.Elements, elem)
+}
+
+func TestRedactMonochrome1UsesMaxValue(t *testing.T) {
+	rows, cols, redactRows := 4, 3, 1
+	ds := newMultiFrameDataset(t, rows, cols, 1)
+	setElement(t, ds, tag.PhotometricInterpretation, []string{"MONOCHROME1"})
+	setElement(t, ds, tag.BitsStored, []int{8})
+
+	if err := redactPixels(ds, redactRows); err != nil {
+		t.Fatalf("redactPixels failed: %v", err)
+	}
+
+	elem, _ := ds.Data.FindElementByTag(tag.PixelData)
+	for i, pixel := range elem.Value.GetValue().(dicom.PixelDataInfo).Frames[0].NativeData.Data {
+		want := 200
+		if i < redactRows*cols {
+			want = 255 // White is 0 in MONOCHROME1
+		}
+		if pixel[0] != want {
+			t.Errorf("pixel %d = %d, want %d", i, pixel[0], want)
+		}
+	}
+}
+
+func TestRedactHighBitDepthRawBytes(t *testing.T) {
+	tests := []struct {
+		name        string
+		photometric string
+		signed      bool
+		want        uint16
+	}{
+		{"MONOCHROME2 unsigned", "MONOCHROME2", false, 0},
+		{"MONOCHROME2 signed", "MONOCHROME2", true, 0xF800}, // -2048, sign-extended over the padding bits
+		{"MONOCHROME1 unsigned", "MONOCHROME1", false, 0x0FFF},
+		{"MONOCHROME1 signed", "MONOCHROME1", true, 0x07FF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, cols, redactRows := 3, 2, 1
+			ds := newMultiFrameDataset(t, rows, cols, 1)
+
+			raw := make([]byte, rows*cols*2)
+			for i := 0; i < len(raw); i += 2 {
+				raw[i], raw[i+1] = 0x34, 0x02 // 564
+			}
+			setElement(t, ds, tag.PixelData, raw)
+			setElement(t, ds, tag.BitsAllocated, []int{16})
+			setElement(t, ds, tag.BitsStored, []int{12})
+			setElement(t, ds, tag.PhotometricInterpretation, []string{tt.photometric})
+			if tt.signed {
+				setElement(t, ds, tag.PixelRepresentation, []int{1})
+			}
+
+			if err := redactPixels(ds, redactRows); err != nil {
+				t.Fatalf("redactPixels failed: %v", err)
+			}
+
+			for p := 0; p < rows*cols; p++ {
+				got := uint16(raw[2*p]) | uint16(raw[2*p+1])<<8
+				want := uint16(564)
+				if p < redactRows*cols {
+					want = tt.want
+				}
+				if got != want {
+					t.Errorf("pixel %d = %#04x, want %#04x", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAnonymizeUltrasoundMetadataOnlyFallback(t *testing.T) {
+	t.Setenv("PATH", t.TempDir()) // No dcmtk
+
+	ds := newMultiFrameDataset(t, 4, 4, 1)
+	setElement(t, ds, tag.BitsStored, []int{8})
+	setElement(t, ds, tag.PhotometricInterpretation, []string{"MONOCHROME2"})
+	setElement(t, ds, tag.PatientID, []string{"MRN123"})
+	setElement(t, ds, tag.Modality, []string{"US"})
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.dcm")
+	if err := ds.SaveWithOptions(input, dcm.SaveOptions{CompressJPEGLS: true, PreferPureGo: true}); err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+	output := filepath.Join(dir, "out.dcm")
+
+	opts := FileOptions{PatientID: "ANON-000001"}
+	err := AnonymizeUltrasound(input, output, 1, nil, opts)
+	if got := CategoryOf(err); got != FailureDcmtkMissing {
+		t.Fatalf("without fallback: %v (%s), want %s", err, got, FailureDcmtkMissing)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatal("without fallback the file was written")
+	}
+
+	opts.MetadataOnlyFallback = true
+	if err := AnonymizeUltrasound(input, output, 1, nil, opts); !errors.Is(err, ErrPixelsNotRedacted) {
+		t.Fatalf("with fallback: %v, want ErrPixelsNotRedacted", err)
+	}
+	anon, err := dcm.ReadDicom(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := anon.GetPatientID(); got != "ANON-000001" {
+		t.Errorf("PatientID = %q, want ANON-000001", got)
+	}
+	elem, err := anon.Data.FindElementByTag(tag.DeidentificationMethod)
+	if err != nil {
+		t.Fatal("no DeidentificationMethod")
+	}
+	want := []string{DeidentificationMethodProfile, DeidentificationMethodPixelsNotRedacted}
+	if got := elem.Value.GetValue().([]string); !reflect.DeepEqual(got, want) {
+		t.Errorf("DeidentificationMethod = %q, want %q", got, want)
+	}
+
+	// The pixels may still show the patient, so a later run must not skip
+	// the file as already anonymized, with either profile
+	for _, confidentiality := range []bool{false, true} {
+		opts.Confidentiality = confidentiality
+		if err := AnonymizeUltrasound(input, output, 1, nil, opts); !errors.Is(err, ErrPixelsNotRedacted) {
+			t.Fatalf("confidentiality=%v: %v, want ErrPixelsNotRedacted", confidentiality, err)
+		}
+		anon, err := dcm.ReadDicom(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := anon.GetString(tag.PatientIdentityRemoved); got != "NO" {
+			t.Errorf("confidentiality=%v: PatientIdentityRemoved = %q, want NO", confidentiality, got)
+		}
+		if meta, _ := loadFileMetadata(output); meta.IdentityRemoved {
+			t.Errorf("confidentiality=%v: output counts as already anonymized", confidentiality)
+		}
+	}
+}
+
+func TestAnonymizeUltrasoundCleanPixelDataOnlyWhenRedacted(t *testing.T) {
+	ds := newMultiFrameDataset(t, 4, 4, 1)
+	setElement(t, ds, tag.BitsStored, []int{8})
+	setElement(t, ds, tag.PhotometricInterpretation, []string{"MONOCHROME2"})
+	setElement(t, ds, tag.Modality, []string{"US"})
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.dcm")
+	if err := ds.SaveWithOptions(input, dcm.SaveOptions{}); err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		redactRows int
+		want       bool
+	}{
+		{redactRows: 0, want: false}, // Empty mask
+		{redactRows: 1, want: true},
+	} {
+		output := filepath.Join(dir, "out.dcm")
+		opts := FileOptions{PatientID: "ANON-000001", Confidentiality: true}
+		if err := AnonymizeUltrasound(input, output, tt.redactRows, nil, opts); err != nil {
+			t.Fatalf("redactRows=%d: %v", tt.redactRows, err)
+		}
+		anon, err := dcm.ReadDicom(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elem, err := anon.Data.FindElementByTag(tag.DeidentificationMethod)
+		if err != nil {
+			t.Fatal("no DeidentificationMethod")
+		}
+		cleaned := false
+		for _, method := range elem.Value.GetValue().([]string) {
+			cleaned = cleaned || method == CleanPixelDataMethod
+		}
+		if cleaned != tt.want {
+			t.Errorf("redactRows=%d: Clean Pixel Data recorded = %v, want %v", tt.redactRows, cleaned, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package anonymizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+)
+
+// UnidentifiedPolicy controls the anonymous ID of files with neither a
+// valid Name+DOB nor a PatientID, and of files whose metadata cannot be
+// read
+type UnidentifiedPolicy string
+
+const (
+	UnidentifiedNew         UnidentifiedPolicy = "new"          // Share the placeholder PatientID UNKNOWN; unreadable files get a new ID every run (default)
+	UnidentifiedSOPInstance UnidentifiedPolicy = "sop-uid"      // One stable ID per SOPInstanceUID (content hash when missing)
+	UnidentifiedContentHash UnidentifiedPolicy = "content-hash" // One stable ID per SHA-256 of the file contents
+	UnidentifiedBucket      UnidentifiedPolicy = "bucket"       // All such files under the anonymous ID UnidentifiedAnonID
+)
+
+// UnidentifiedAnonID is the anonymous ID and output folder of the files
+// routed by UnidentifiedBucket
+const UnidentifiedAnonID = "UNIDENTIFIED"
+
+// ParseUnidentifiedPolicy validates a policy name (empty = UnidentifiedNew)
+func ParseUnidentifiedPolicy(s string) (UnidentifiedPolicy, error) {
+	switch policy := UnidentifiedPolicy(s); policy {
+	case "":
+		return UnidentifiedNew, nil
+	case UnidentifiedNew, UnidentifiedSOPInstance, UnidentifiedContentHash, UnidentifiedBucket:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid unidentified policy %q (use new, sop-uid, content-hash, or bucket)", s)
+}
+
+// unidentifiedKey returns the PatientGroup.FileKey of an unidentified file,
+// or "" to group it the default way. ds is nil for unreadable files.
+func unidentifiedKey(policy UnidentifiedPolicy, filePath string, ds *dcm.Dataset) string {
+	switch policy {
+	case UnidentifiedBucket:
+		return UnidentifiedAnonID
+	case UnidentifiedSOPInstance:
+		if ds != nil {
+			if uid := strings.TrimSpace(ds.GetString(tag.SOPInstanceUID)); uid != "" {
+				return "sop:" + uid
+			}
+		}
+		return contentKey(filePath)
+	case UnidentifiedContentHash:
+		return contentKey(filePath)
+	}
+	return ""
+}
+
+// contentKey keys a file by the SHA-256 of its contents. Files that cannot
+// be read keep their path, which is stable as long as the input is.
+func contentKey(filePath string) string {
+	_, sum, err := fileChecksum(filePath)
+	if err != nil {
+		return "path:" + filePath
+	}
+	return "sha256:" + sum
+}
+
+// anonID gets or creates the anonymous ID of a patient group and links
+// the groups merged into it to that ID
+func (p *PatientGroup) anonID(mapper *identity.PseudonymizationMapper) (string, identity.MatchMethod) {
+	var anonID string
+	var method identity.MatchMethod
+	switch p.FileKey {
+	case "":
+		anonID, method = mapper.GetAnonID(p.PID, p.Name, p.DOB)
+	case UnidentifiedAnonID:
+		return UnidentifiedAnonID, identity.MatchNone
+	default:
+		anonID, method = mapper.GetFileAnonID(p.FileKey)
+	}
+
+	for _, linked := range p.Linked {
+		mapper.LinkAnonID(anonID, linked.PID, linked.Name, linked.DOB)
+	}
+	return anonID, method
+}
@@ -0,0 +1,148 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// writeUnidentifiedFiles writes two files without PatientID, name or DOB
+// and an exact copy of the first
+func writeUnidentifiedFiles(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "a.dcm"), map[tag.Tag]string{tag.SOPInstanceUID: "1.2.3.1"})
+	writeTestFile(t, filepath.Join(dir, "b.dcm"), map[tag.Tag]string{tag.SOPInstanceUID: "1.2.3.2"})
+	data, err := os.ReadFile(filepath.Join(dir, "a.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "copy.dcm"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// anonFolders returns the output folder of each file of a run
+func anonFolders(t *testing.T, output string) map[string]string {
+	t.Helper()
+	folders := map[string]string{}
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name()[0] == '.' {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(output, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			folders[f.Name()] = e.Name()
+		}
+	}
+	return folders
+}
+
+func TestUnidentifiedPolicy(t *testing.T) {
+	tests := []struct {
+		policy     UnidentifiedPolicy
+		sameAB     bool // a.dcm and b.dcm share an anonymous ID
+		wantFolder string
+	}{
+		{UnidentifiedNew, true, ""},
+		{UnidentifiedSOPInstance, false, ""},
+		{UnidentifiedContentHash, false, ""},
+		{UnidentifiedBucket, true, UnidentifiedAnonID},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			dir := t.TempDir()
+			input := filepath.Join(dir, "input")
+			writeUnidentifiedFiles(t, input)
+
+			run := func(output string) map[string]string {
+				cfg := Config{
+					InputFolder:        input,
+					OutputFolder:       filepath.Join(dir, output),
+					MappingFile:        filepath.Join(dir, "patient_mapping.json"),
+					Salt:               "secret",
+					ProcessMetadata:    true,
+					UnidentifiedPolicy: tt.policy,
+					OutputWriter:       func(string) {},
+				}
+				if _, err := ProcessFolder(cfg); err != nil {
+					t.Fatalf("ProcessFolder failed: %v", err)
+				}
+				return anonFolders(t, cfg.OutputFolder)
+			}
+
+			first := run("out1")
+			if len(first) != 3 {
+				t.Fatalf("first run wrote %v, want 3 files", first)
+			}
+			if got := first["a.dcm"] == first["b.dcm"]; got != tt.sameAB {
+				t.Errorf("a.dcm and b.dcm in %s and %s, want same = %v", first["a.dcm"], first["b.dcm"], tt.sameAB)
+			}
+			if first["a.dcm"] != first["copy.dcm"] {
+				t.Errorf("a.dcm in %s but its copy in %s", first["a.dcm"], first["copy.dcm"])
+			}
+			if tt.wantFolder != "" && first["a.dcm"] != tt.wantFolder {
+				t.Errorf("a.dcm in %s, want %s", first["a.dcm"], tt.wantFolder)
+			}
+
+			ds, err := dcm.ReadDicom(filepath.Join(dir, "out1", first["a.dcm"], "a.dcm"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ds.GetPatientID(); got != first["a.dcm"] {
+				t.Errorf("PatientID = %q, want %q", got, first["a.dcm"])
+			}
+
+			// The mapping file keeps the IDs stable across runs
+			second := run("out2")
+			for name, folder := range first {
+				if second[name] != folder {
+					t.Errorf("%s in %s, then in %s", name, folder, second[name])
+				}
+			}
+		})
+	}
+}
+
+func TestUnidentifiedPolicyIgnoresIdentifiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.dcm"), map[tag.Tag]string{
+		tag.PatientID:      "MRN123",
+		tag.SOPInstanceUID: "1.2.3.1",
+	})
+	writeTestFile(t, filepath.Join(dir, "b.dcm"), map[tag.Tag]string{
+		tag.PatientID:      "MRN123",
+		tag.SOPInstanceUID: "1.2.3.2",
+	})
+
+	files := []string{filepath.Join(dir, "a.dcm"), filepath.Join(dir, "b.dcm")}
+	patients, _ := groupFilesByPatient(files, Config{Salt: "secret", UnidentifiedPolicy: UnidentifiedSOPInstance}, func(string) {})
+	if len(patients) != 1 || patients[0].FileKey != "" || patients[0].PID != "MRN123" {
+		t.Fatalf("groups = %+v, want one MRN123 group", patients)
+	}
+}
+
+func TestParseUnidentifiedPolicy(t *testing.T) {
+	if policy, err := ParseUnidentifiedPolicy(""); err != nil || policy != UnidentifiedNew {
+		t.Errorf(`ParseUnidentifiedPolicy("") = %q, %v`, policy, err)
+	}
+	if policy, err := ParseUnidentifiedPolicy("bucket"); err != nil || policy != UnidentifiedBucket {
+		t.Errorf(`ParseUnidentifiedPolicy("bucket") = %q, %v`, policy, err)
+	}
+	if _, err := ParseUnidentifiedPolicy("random"); err == nil {
+		t.Error("ParseUnidentifiedPolicy accepted an unknown policy")
+	}
+}
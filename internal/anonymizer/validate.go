@@ -0,0 +1,168 @@
+package anonymizer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// Finding is identifying data left in an anonymized file. Values are never
+// included, only their length.
+type Finding struct {
+	Path  string
+	Tag   string // Keyword, with the enclosing sequences for nested tags
+	Issue string // e.g. "not cleared: present (10 chars)"
+}
+
+// String formats the finding as "PatientName: not cleared: present (10 chars)"
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Tag, f.Issue)
+}
+
+// ValidateOptions describes the run whose output is validated
+type ValidateOptions struct {
+	Dates             DatePolicy // Expected date handling (empty = DatePolicyTruncateMonth)
+	RemovePrivateTags bool       // Flag private (odd group) elements
+	PrivateCreators   []string   // Private blocks allowed with RemovePrivateTags (nil = SafePrivateCreators)
+}
+
+// Validate re-reads an anonymized file and reports the tags of the profile
+// that still hold data, with truncated dates expected. A nil profile means
+// DefaultTagProfile.
+func Validate(path string, profile *TagProfile) []Finding {
+	return ValidateWithOptions(path, profile, ValidateOptions{})
+}
+
+// ValidateWithOptions re-reads an anonymized file and reports non-empty
+// tags of the profile's clear list, dates not handled as opts.Dates
+// requires and, with RemovePrivateTags, private elements outside the
+// allowed blocks. Shifted dates cannot be told from original ones and are
+// not checked. A file that cannot be read is one finding.
+func ValidateWithOptions(path string, profile *TagProfile, opts ValidateOptions) []Finding {
+	if profile == nil {
+		profile = DefaultTagProfile()
+	}
+
+	ds, err := dcm.ReadDicomMetadataOnly(path)
+	if err != nil {
+		return []Finding{{Path: path, Tag: "(file)", Issue: fmt.Sprintf("unreadable: %v", err)}}
+	}
+
+	clearTags, dateTags := profile.ClearTags(), profile.DateTags()
+	var findings []Finding
+	snapshots := snapshotElements(ds.Data.Elements, "")
+	for i, s := range snapshots {
+		if s.empty() {
+			continue
+		}
+		switch {
+		case containsTag(clearTags, s.tag) && keptItemsOnly(snapshots, i, profile.keep):
+			// A cleared sequence holding only kept tags
+		case containsTag(clearTags, s.tag):
+			findings = append(findings, Finding{Path: path, Tag: s.path, Issue: "not cleared: " + s.describe()})
+		case containsTag(dateTags, s.tag):
+			if issue := opts.dateIssue(s.value); issue != "" {
+				findings = append(findings, Finding{Path: path, Tag: s.path, Issue: issue})
+			}
+		}
+	}
+
+	if opts.RemovePrivateTags {
+		creators := opts.PrivateCreators
+		// Of the built-in safe blocks only numbers are kept
+		builtIn := creators == nil
+		if builtIn {
+			creators = SafePrivateCreators
+		}
+		findings = append(findings, privateFindings(path, "", ds, creators, builtIn)...)
+	}
+	return findings
+}
+
+// privateFindings reports the private elements of ds outside the blocks of
+// creators and, with text, the text in those blocks, then does the same
+// for every sequence item. prefix names the enclosing sequences.
+func privateFindings(path, prefix string, ds *dcm.Dataset, creators []string, text bool) []Finding {
+	var findings []Finding
+	for _, t := range ds.PrivateTags(creators) {
+		findings = append(findings, Finding{Path: path, Tag: prefix + tagName(t), Issue: "private tag not removed"})
+	}
+	if text {
+		for _, t := range ds.PrivateText(creators) {
+			findings = append(findings, Finding{Path: path, Tag: prefix + tagName(t), Issue: "text in safe private block not removed"})
+		}
+	}
+
+	for _, elem := range ds.Data.Elements {
+		if elem.Value == nil {
+			continue
+		}
+		items, _ := elem.Value.GetValue().([]*dicom.SequenceItemValue)
+		for i, item := range items {
+			elements, _ := item.GetValue().([]*dicom.Element)
+			itemDS := &dcm.Dataset{Data: dicom.Dataset{Elements: elements}}
+			itemPrefix := fmt.Sprintf("%s%s[%d] > ", prefix, tagName(elem.Tag), i)
+			findings = append(findings, privateFindings(path, itemPrefix, itemDS, creators, text)...)
+		}
+	}
+	return findings
+}
+
+// keptItemsOnly reports whether snapshots[i] is a sequence whose items
+// hold only tags in keep (and sequences of them), as a cleared sequence
+// keeps them
+func keptItemsOnly(snapshots []elementSnapshot, i int, keep []tag.Tag) bool {
+	if snapshots[i].vr != "SQ" {
+		return false
+	}
+	prefix := snapshots[i].path + "["
+	found := false
+	for _, s := range snapshots[i+1:] {
+		if !strings.HasPrefix(s.path, prefix) {
+			break
+		}
+		if s.vr == "SQ" {
+			continue
+		}
+		if !containsTag(keep, s.tag) {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// dateIssue checks a non-empty date value against the date policy
+func (o ValidateOptions) dateIssue(value string) string {
+	switch o.Dates {
+	case DatePolicyShiftDays:
+		return ""
+	case DatePolicyRemove:
+		return "date not removed"
+	}
+	value = strings.TrimSpace(value)
+	if len(value) != 8 || !strings.HasSuffix(value, "01") {
+		return "date not truncated"
+	}
+	return ""
+}
+
+// ValidateFiles validates the files written by a run with cfg
+func ValidateFiles(cfg Config, files []string) []Finding {
+	profile := cfg.tagProfile()
+	opts := ValidateOptions{
+		Dates:             cfg.DatePolicy,
+		RemovePrivateTags: cfg.RemovePrivateTags,
+		PrivateCreators:   cfg.PrivateCreators,
+	}
+
+	var findings []Finding
+	for _, path := range files {
+		findings = append(findings, ValidateWithOptions(path, profile, opts)...)
+	}
+	return findings
+}
@@ -0,0 +1,128 @@
+package anonymizer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input")
+	if err := os.Mkdir(input, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(input, "a.dcm")
+	writeTestFile(t, path, map[tag.Tag]string{
+		tag.PatientName:    "SMITH^JOHN",
+		tag.PatientID:      "MRN123",
+		tag.StudyDate:      "20240315",
+		tag.SOPInstanceUID: "1.2.3.4.5.6",
+	})
+
+	// The original file leaks the name and the full date
+	got := map[string]string{}
+	for _, f := range Validate(path, nil) {
+		got[f.Tag] = f.String()
+		if strings.Contains(f.String(), "SMITH") {
+			t.Errorf("finding %q reveals the original value", f)
+		}
+	}
+	for name, want := range map[string]string{
+		"PatientName": "PatientName: not cleared: present (10 chars)",
+		"StudyDate":   "StudyDate: date not truncated",
+	} {
+		if got[name] != want {
+			t.Errorf("%s finding = %q, want %q", name, got[name], want)
+		}
+	}
+	if len(got) != 2 {
+		t.Errorf("findings = %v, want PatientName and StudyDate", got)
+	}
+
+	if findings := ValidateWithOptions(path, nil, ValidateOptions{Dates: DatePolicyShiftDays}); len(findings) != 1 {
+		t.Errorf("findings with shifted dates = %v, want only PatientName", findings)
+	}
+
+	cfg := Config{
+		InputFolder:       input,
+		OutputFolder:      filepath.Join(dir, "output"),
+		MappingFile:       filepath.Join(dir, "patient_mapping.json"),
+		Salt:              "secret",
+		ProcessMetadata:   true,
+		RemovePrivateTags: true,
+		OutputWriter:      func(string) {},
+	}
+	if _, err := ProcessFolder(cfg); err != nil {
+		t.Fatalf("ProcessFolder failed: %v", err)
+	}
+	outputs, err := dcm.FindDicomFiles(cfg.OutputFolder, true)
+	if err != nil || len(outputs) != 1 {
+		t.Fatalf("outputs = %v, %v; want one file", outputs, err)
+	}
+	if findings := ValidateFiles(cfg, outputs); len(findings) != 0 {
+		t.Errorf("findings in anonymized output = %v, want none", findings)
+	}
+
+	if findings := Validate(filepath.Join(dir, "missing.dcm"), nil); len(findings) != 1 || !strings.HasPrefix(findings[0].Issue, "unreadable") {
+		t.Errorf("findings for missing file = %v, want one unreadable finding", findings)
+	}
+}
+
+func TestValidateFindsNestedPrivateTags(t *testing.T) {
+	newElement := func(tg tag.Tag, data interface{}) *dicom.Element {
+		elem, err := dicom.NewElement(tg, data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", tg, err)
+		}
+		return elem
+	}
+	text := func(tg tag.Tag, value string) *dicom.Element {
+		v, err := dicom.NewValue([]string{value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &dicom.Element{Tag: tg, ValueRepresentation: tag.VRString, RawValueRepresentation: "LO", Value: v}
+	}
+
+	path := filepath.Join(t.TempDir(), "a.dcm")
+	ds := &dcm.Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{
+		newElement(tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.7"}),
+		newElement(tag.MediaStorageSOPInstanceUID, []string{"1.2.3.4"}),
+		newElement(tag.TransferSyntaxUID, []string{"1.2.840.10008.1.2.1"}),
+		newElement(tag.SOPInstanceUID, []string{"1.2.3.4"}),
+		newElement(tag.ReferencedImageSequence, [][]*dicom.Element{{
+			text(tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT"),
+			text(tag.Tag{Group: 0x0009, Element: 0x1001}, "SMITH^JOHN"),
+			text(tag.Tag{Group: 0x0019, Element: 0x0010}, "GEMS_ACQU_01"),
+			text(tag.Tag{Group: 0x0019, Element: 0x1023}, "1.5"),
+			text(tag.Tag{Group: 0x0019, Element: 0x1024}, "SMITH HEAD COIL"),
+		}}),
+	}}}
+	if err := ds.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, f := range ValidateWithOptions(path, nil, ValidateOptions{RemovePrivateTags: true}) {
+		got[f.Tag] = f.Issue
+	}
+	for name, want := range map[string]string{
+		"ReferencedImageSequence[0] > (0009,0010)": "private tag not removed",
+		"ReferencedImageSequence[0] > (0009,1001)": "private tag not removed",
+		"ReferencedImageSequence[0] > (0019,1024)": "text in safe private block not removed",
+	} {
+		if got[name] != want {
+			t.Errorf("%s finding = %q, want %q", name, got[name], want)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("findings = %v, want the ACME block and the coil name", got)
+	}
+}
@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"dicom-anonymizer/internal/identity"
+)
+
+// AuditCollisions lists the anonymous IDs of a mapping file that hold more
+// than one identity hash, with how many different names were seen and any
+// conflicts recorded, so merged patients can be reviewed. secretKey is
+// needed for encrypted mappings.
+func AuditCollisions(mappingFile, secretKey string) error {
+	if mappingFile == "" {
+		return fmt.Errorf("mapping file is required (-m)")
+	}
+	if _, err := os.Stat(mappingFile); err != nil {
+		return fmt.Errorf("mapping file does not exist: %s", mappingFile)
+	}
+
+	mapper, err := identity.NewPseudonymizationMapper(mappingFile, secretKey)
+	if err != nil {
+		return err
+	}
+
+	audits := mapper.AuditCollisions()
+	if len(audits) == 0 {
+		fmt.Println("No anonymous IDs with more than one identity hash")
+		return nil
+	}
+
+	conflicted := 0
+	fmt.Printf("%-16s %6s %6s %5s  %s\n", "Anon ID", "Hashes", "Names", "PIDs", "Conflicts")
+	for _, audit := range audits {
+		conflicts := "-"
+		if len(audit.Conflicts) > 0 {
+			conflicts = strings.Join(audit.Conflicts, ", ")
+			conflicted++
+		}
+		fmt.Printf("%-16s %6d %6d %5d  %s\n", audit.AnonID, audit.IdentityHashes, audit.NameSignals, audit.PatientIDs, conflicts)
+	}
+
+	fmt.Println()
+	fmt.Printf("%d anonymous ID(s) with several identity hashes, %d with conflicts\n", len(audits), conflicted)
+	fmt.Println("Names counts distinct names (from a short keyed hash; name variants such as")
+	fmt.Println("initials or nicknames count once). Names above 1 or conflicts mean different")
+	fmt.Println("names share the ID: a changed name, a shared PatientID or a hash collision.")
+	return nil
+}
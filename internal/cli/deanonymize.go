@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dicom-anonymizer/internal/anonymizer"
+	"dicom-anonymizer/internal/identity"
+)
+
+// DeanonymizeOptions holds options for reversing an anonymous ID
+type DeanonymizeOptions struct {
+	AnonID      string
+	MappingFile string
+	SecretKey   string // Needed to open an encrypted mapping file
+	RestoreFile string // Anonymized DICOM file to restore into a copy (optional)
+	ProfileFile string // Tag profile used for the anonymization run (optional)
+}
+
+// Deanonymize prints the original identifiers behind an anonymous ID and,
+// if a file is given, restores them into a copy of that anonymized file.
+func Deanonymize(opts DeanonymizeOptions) error {
+	if opts.MappingFile == "" {
+		return fmt.Errorf("mapping file is required (-m)")
+	}
+	if _, err := os.Stat(opts.MappingFile); err != nil {
+		return fmt.Errorf("mapping file does not exist: %s", opts.MappingFile)
+	}
+
+	mapper, err := identity.NewPseudonymizationMapper(opts.MappingFile, opts.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	if opts.AnonID != "" {
+		entry, ok := mapper.Reverse(opts.AnonID)
+		if !ok {
+			return fmt.Errorf("anonymous ID %q not found in %s", opts.AnonID, opts.MappingFile)
+		}
+		printReverseEntry(mapper, opts.AnonID, entry)
+	}
+
+	if opts.RestoreFile != "" {
+		ext := filepath.Ext(opts.RestoreFile)
+		outputPath := strings.TrimSuffix(opts.RestoreFile, ext) + "_restored" + ext
+
+		var profile *anonymizer.TagProfile
+		if opts.ProfileFile != "" {
+			if profile, err = anonymizer.LoadTagProfile(opts.ProfileFile); err != nil {
+				return err
+			}
+		}
+
+		uids := identity.NewUIDMapper(identity.UIDMappingFile(opts.MappingFile), opts.SecretKey, "")
+		result, err := anonymizer.Restore(opts.RestoreFile, outputPath, mapper, uids, profile)
+		if err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+
+		fmt.Println()
+		fmt.Printf("Restored: %s\n", outputPath)
+		fmt.Printf("  Anon ID:    %s\n", result.AnonID)
+		if result.PatientID != "" {
+			fmt.Printf("  PatientID:  %s\n", result.PatientID)
+		} else {
+			fmt.Println("  PatientID:  unknown (no original PatientID recorded)")
+		}
+		fmt.Printf("  UIDs:       %d restored\n", result.UIDsRestored)
+		if result.DatesRestored {
+			fmt.Println("  Dates:      restored (shift reversed)")
+		} else {
+			fmt.Println("  Dates:      not restored (truncated or removed dates cannot be reversed)")
+		}
+	}
+
+	return nil
+}
+
+func printReverseEntry(mapper *identity.PseudonymizationMapper, anonID string, entry *identity.ReverseMapEntry) {
+	fmt.Printf("Anonymous ID:     %s\n", anonID)
+	fmt.Printf("Original PIDs:    %s\n", joinOrNone(entry.PatientIDs))
+	fmt.Printf("Identity hashes:  %s\n", joinOrNone(entry.IdentityHashes))
+	if days, ok := mapper.LookupDateShift(anonID); ok {
+		fmt.Printf("Date shift:       %+d days\n", days)
+	}
+	fmt.Println()
+	fmt.Println("Identity hashes are HMAC-SHA256(Name+DOB) keyed by the secret key. To check")
+	fmt.Println("whether a patient matches, recompute the hash with the same secret key.")
+}
+
+func joinOrNone(values []string) string {
+	if len(values) == 0 {
+		return "(none)"
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"flag"
+	"strconv"
+)
+
+// BoolFlag returns the value of a boolean flag that has a shorthand, e.g.
+// -recursive and -r: the one given on the command line, the long one when
+// both were given, or the long flag's default when neither was.
+func BoolFlag(fs *flag.FlagSet, long, short string) bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	name := long
+	if !set[long] && set[short] {
+		name = short
+	}
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	value, _ := strconv.ParseBool(f.Value.String())
+	return value
+}
@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestBoolFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"-r=false"}, false},
+		{[]string{"-recursive=false"}, false},
+		{[]string{"-recursive=false", "-r=false"}, false},
+		{[]string{"-r"}, true},
+		{[]string{"-recursive=true", "-r=false"}, true}, // The long flag wins
+		{[]string{"-recursive=false", "-r=true"}, false},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Bool("recursive", true, "")
+		fs.Bool("r", true, "")
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := BoolFlag(fs, "recursive", "r"); got != tt.want {
+			t.Errorf("BoolFlag(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"dicom-anonymizer/internal/anonymizer"
+	"dicom-anonymizer/internal/identity"
+)
+
+// MergeOptions holds options for combining mapping files
+type MergeOptions struct {
+	MappingFiles []string // First file is the base, later files are merged into it
+	OutputFile   string
+	SecretKey    string // Needed to open encrypted mapping files
+}
+
+// MergeMappings combines several mapping files into one, renumbering the
+// anonymous IDs of later files where they do not match existing patients.
+func MergeMappings(opts MergeOptions) error {
+	if len(opts.MappingFiles) < 2 {
+		return fmt.Errorf("at least two mapping files are required")
+	}
+	if opts.OutputFile == "" {
+		return fmt.Errorf("output file is required (-o)")
+	}
+	for _, path := range opts.MappingFiles {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("mapping file does not exist: %s", path)
+		}
+	}
+
+	merged, err := identity.NewPseudonymizationMapper(opts.MappingFiles[0], opts.SecretKey)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+	for _, path := range opts.MappingFiles[1:] {
+		other, err := identity.NewPseudonymizationMapper(path, opts.SecretKey)
+		if err != nil {
+			return err
+		}
+
+		c, err := merged.Merge(other)
+		if err != nil {
+			return fmt.Errorf("could not merge %s: %w", path, err)
+		}
+		conflicts = append(conflicts, c...)
+	}
+
+	merged.SetToolVersion(anonymizer.VersionString())
+	if err := merged.SaveAs(opts.OutputFile); err != nil {
+		return err
+	}
+
+	stats := merged.GetStats()
+	fmt.Println()
+	fmt.Printf("Merged %d mapping files into %s\n", len(opts.MappingFiles), opts.OutputFile)
+	fmt.Printf("Patients:  %d total\n", stats.TotalPatients)
+	fmt.Println("Renumbered patients list their previous IDs under merged_from.")
+
+	if len(conflicts) > 0 {
+		fmt.Println()
+		fmt.Printf("Conflicts (%d) - existing links were kept:\n", len(conflicts))
+		for _, c := range conflicts {
+			fmt.Printf("  %s\n", c)
+		}
+	}
+
+	return nil
+}
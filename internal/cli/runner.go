@@ -2,30 +2,143 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"time"
 
 	"dicom-anonymizer/internal/anonymizer"
 	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/logging"
+	"dicom-anonymizer/internal/progress"
 )
 
+// Exit codes of the CLI
+const (
+	ExitOK          = 0 // Finished; per-file failures only count with FailOnError
+	ExitFatal       = 1 // Invalid options, setup error or cancellation
+	ExitFilesFailed = 2 // Finished, but files failed and FailOnError is set, or Validate had findings
+)
+
+// ErrFilesFailed is returned by Run with FailOnError when any file failed
+var ErrFilesFailed = errors.New("some files failed")
+
+// ExitCode returns the process exit code for an error returned by Run
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrFilesFailed), errors.Is(err, ErrFindings):
+		return ExitFilesFailed
+	}
+	return ExitFatal
+}
+
 // Options holds CLI configuration options
 type Options struct {
-	InputFolder       string
+	InputFolders      []string // Processed in order with the same key and mapping
+	OutputFolder      string   // With several inputs, each gets a subfolder named after its input
 	SecretKey         string
 	MappingFile       string
 	RedactRows        int
+	RedactRegions     []image.Rectangle
+	OCR               bool // Redact text found by the Tesseract detector (builds with -tags tesseract)
+	AllowMetadataOnly bool // Write JPEG-LS ultrasound files without redaction when dcmtk is missing
 	Recursive         bool
+	MaxDepth          int // With Recursive, directory levels searched below each input (0 = unlimited)
+	FollowSymlinks    bool
+	TempDir           string // Folder for dcmtk temporary files (default: system temp)
 	RetryFailed       bool
+	Force             bool   // Reprocess files already marked PatientIdentityRemoved=YES
+	InPlace           bool   // Overwrite the original files; needs IUnderstand
+	InPlaceBackup     bool   // With InPlace, keep each original as <name>.orig
+	IUnderstand       bool   // Confirms InPlace
+	OnExisting        string // What to do with existing output files: overwrite, skip, error, rename
+	Filenames         string // Output file names: preserve, sop-uid, sequential
+	MaxFileSizeMB     int    // Skip files larger than this (0 = no limit)
+	MaxFrameSizeMB    int    // Warn about ultrasound frames larger than this (0 = never)
 	ProcessMetadata   bool
 	ProcessUltrasound bool
 	DryRun            bool
+	Explain           int    // With DryRun, list the tag edits for this many files
+	PreviewTSV        string // With DryRun, write the planned patients as TSV to this path
+	Workers           int
+	DatePolicy        string
+	ProfileFile       string
+	ContentHash       bool
+	Checkpoint        string // Save progress every N files ("100") or every duration ("30s"); empty = every 100 files or 30s
+	EncryptMapping    bool
+	ExportCSV         string // Write the mapping as CSV to this path after processing
+	ReportFile        string // Write a JSON run report to this path after processing
+	Manifest          bool   // Write manifest.json with output checksums after processing
+	FuzzyNames        bool
+	NicknameFile      string
+	Unidentified      string // Anonymous IDs of files without identity or PatientID: new, sop-uid, content-hash, bucket
+	IDPrefix          string
+	IDFormat          string
+	UIDRoot           string // Organization root of generated UIDs (default: 2.25)
+	KeepSex           bool
+	KeepInstitution   bool
+	KeepStudyDesc     bool
+	Verbose           bool     // Log per-patient and per-file details instead of a progress bar
+	Quiet             bool     // Only print warnings, errors and the final summary
+	Include           []string // Only process files matching one of these globs (relative to the input folder)
+	Exclude           []string // Skip files matching one of these globs
+	RemovePrivateTags bool     // Drop private (odd group) tags except known-safe vendor blocks
+	RemoveOverlays    bool     // Drop overlay planes (groups 60xx)
+	Confidentiality   bool     // Apply the DICOM PS3.15 Basic Profile instead of the tag profile
+	Retain            string   // Comma-separated PS3.15 retain options, e.g. "uids,dates"
+	KeepCalibration   bool     // Keep pixel spacing, rescale and window tags under any profile
+	Scrub             []string // Regular expressions redacted from kept free text
+	ScrubNames        bool     // Redact each file's patient name from kept free text
+	FailOnError       bool     // Return ErrFilesFailed when any file failed
+	Modalities        string   // Comma-separated DICOM Modality values to process, e.g. "CT,MR" (empty = all)
+}
+
+// StringsFlag collects a repeated string flag such as -include
+type StringsFlag []string
+
+// String returns the values as a space-separated list
+func (s *StringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+// Set appends one value
+func (s *StringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// RegionFlag collects repeated -redact-region x,y,w,h flags
+type RegionFlag []image.Rectangle
+
+// String returns the regions as a space-separated list
+func (r *RegionFlag) String() string {
+	parts := make([]string, len(*r))
+	for i, region := range *r {
+		parts[i] = anonymizer.FormatRegion(region)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Set parses and appends one region
+func (r *RegionFlag) Set(value string) error {
+	region, err := anonymizer.ParseRegion(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, region)
+	return nil
 }
 
 // Run executes the CLI anonymization process
@@ -35,24 +148,25 @@ func Run(opts Options) error {
 		return err
 	}
 
-	// Validate input folder
-	if opts.InputFolder == "" {
-		return fmt.Errorf("input folder is required")
+	cfg, outputs, err := resolveConfig(&opts)
+	if err != nil {
+		return err
 	}
 
-	// Check if input folder exists
-	info, err := os.Stat(opts.InputFolder)
-	if err != nil {
-		return fmt.Errorf("input folder does not exist: %s", opts.InputFolder)
+	if err := dcm.SetTempDir(opts.TempDir); err != nil {
+		return err
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("input path is not a directory: %s", opts.InputFolder)
+	level := logging.LevelInfo
+	if opts.Verbose {
+		level = logging.LevelDebug
+	} else if opts.Quiet {
+		level = logging.LevelWarn
 	}
+	logger := logging.New(os.Stdout, level)
 
-	// Set default mapping file if not specified
-	if opts.MappingFile == "" {
-		parentDir := filepath.Dir(opts.InputFolder)
-		opts.MappingFile = filepath.Join(parentDir, "patient_mapping.json")
+	// Temporary files of runs that crashed or were killed
+	if removed, err := dcm.RemoveStaleTempFiles(dcm.StaleTempFileAge); err == nil && removed > 0 {
+		logger.Debugf("Removed %d stale temp file(s) from %s", removed, dcm.TempDir())
 	}
 
 	// Generate or validate secret key
@@ -61,54 +175,482 @@ func Run(opts Options) error {
 		opts.SecretKey = GenerateSecretKey()
 		keyGenerated = true
 	}
+	cfg.Salt = opts.SecretKey
+
+	// Print header (always when the key must be saved)
+	if !opts.Quiet || keyGenerated {
+		printHeader(opts, keyGenerated)
+	}
+
+	cfg.Logger = logger
+	cfg.OutputWriter = func(s string) {} // Suppress internal output, we use progress callback
+	if opts.Verbose {
+		cfg.OutputWriter = func(s string) { logger.Debugf("%s", s) }
+	}
+
+	// Create progress bar (replaced by log lines with -v, hidden with -q)
+	showProgress := level == logging.LevelInfo
+	pb := newProgressBar(50)
+
+	// Progress callback
+	progressCallback := func(current, total int, filename, status string) {
+		if showProgress {
+			pb.update(current, total)
+		}
+	}
+
+	// Run anonymization
+	if opts.DryRun {
+		fmt.Println("\n[DRY RUN MODE]")
+	}
+	fmt.Println()
+
+	// Stop cleanly on Ctrl+C; finished files stay recorded so a rerun resumes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Folders share the mapping, so a patient keeps its ID across them
+	stats := &anonymizer.Stats{}
+	var outputDirs, reports []string
+	for i, folder := range opts.InputFolders {
+		cfg = inputConfig(cfg, opts, outputs, i)
+		outputDirs = append(outputDirs, cfg.OutputDir())
+		if cfg.ReportFile != "" {
+			reports = append(reports, cfg.ReportFile)
+		}
+		if len(opts.InputFolders) > 1 {
+			fmt.Printf("Input %d/%d: %s\n", i+1, len(opts.InputFolders), folder)
+		}
+		pb.reset()
+
+		folderStats, err := anonymizer.ProcessFolderWithContext(ctx, cfg, progressCallback)
+		addStats(stats, folderStats)
+		if errors.Is(err, context.Canceled) {
+			fmt.Println()
+			fmt.Println(strings.Repeat("=", 50))
+			fmt.Printf("Cancelled! %d succeeded, %d failed, %d skipped\n",
+				stats.Success, stats.Failed, stats.Skipped)
+			fmt.Println("Progress has been saved. Run the same command again to resume.")
+			printResult(stats)
+			return fmt.Errorf("processing cancelled")
+		}
+		if err != nil {
+			if len(opts.InputFolders) > 1 {
+				return fmt.Errorf("processing %s failed: %w", folder, err)
+			}
+			return fmt.Errorf("processing failed: %w", err)
+		}
+
+		// Print final progress bar at 100%
+		if showProgress && (folderStats.Success > 0 || folderStats.Failed > 0 || folderStats.Skipped > 0) {
+			total := folderStats.Success + folderStats.Failed + folderStats.Skipped
+			pb.update(total, total)
+			fmt.Println()
+		}
+	}
+
+	// Print summary
+	if opts.InPlace {
+		outputDirs = opts.InputFolders // The anonymized files replaced the originals
+	}
+	printSummary(stats, outputDirs, opts.MappingFile)
+
+	if opts.ExportCSV != "" {
+		if err := exportMappingCSV(opts.MappingFile, opts.SecretKey, opts.ExportCSV); err != nil {
+			return fmt.Errorf("CSV export failed: %w", err)
+		}
+		fmt.Printf("Mapping CSV: %s\n", opts.ExportCSV)
+	}
+	if opts.PreviewTSV != "" {
+		if err := writePreviewTSV(opts.PreviewTSV, stats.Preview); err != nil {
+			return fmt.Errorf("preview export failed: %w", err)
+		}
+		fmt.Printf("Preview TSV: %s\n", opts.PreviewTSV)
+	}
+	if !opts.DryRun {
+		for _, report := range reports {
+			fmt.Printf("Report:    %s\n", report)
+		}
+		if opts.Manifest {
+			for _, dir := range outputDirs {
+				fmt.Printf("Manifest:  %s\n", filepath.Join(dir, anonymizer.ManifestFileName))
+			}
+		}
+	}
+	printResult(stats)
+
+	if opts.FailOnError && stats.Failed > 0 {
+		return fmt.Errorf("%d file(s) failed: %w", stats.Failed, ErrFilesFailed)
+	}
+	return nil
+}
+
+// printedConfig is the configuration of one input as PrintConfig shows
+// it: the preset options of Config plus the run-only options that presets
+// leave out, with the secret key reduced to whether it is set
+type printedConfig struct {
+	anonymizer.Config
+	Salt          string // "set", or "unset" when the run generates a key
+	InPlace       bool
+	InPlaceBackup bool
+	Pauser        bool // Whether the run can be paused between files
+	TextDetector  bool // Whether burned-in text is detected (--ocr)
+}
+
+// newPrintedConfig returns the printed view of cfg
+func newPrintedConfig(cfg anonymizer.Config) printedConfig {
+	salt := "unset"
+	if cfg.Salt != "" {
+		salt = "set"
+	}
+	return printedConfig{
+		Config:        cfg,
+		Salt:          salt,
+		InPlace:       cfg.InPlace,
+		InPlaceBackup: cfg.InPlaceBackup,
+		Pauser:        cfg.Pauser != nil,
+		TextDetector:  cfg.TextDetector != nil,
+	}
+}
+
+// PrintConfig validates opts as Run does and prints the resolved
+// configuration as JSON without processing: an array with the
+// configuration of each input, also for a single input. Defaulted values
+// such as the mapping file and the output folder are filled in; the
+// secret key is only shown as set or unset.
+func PrintConfig(opts Options) error {
+	return writeConfig(os.Stdout, opts)
+}
+
+// writeConfig writes the output of PrintConfig to w
+func writeConfig(w io.Writer, opts Options) error {
+	cfg, outputs, err := resolveConfig(&opts)
+	if err != nil {
+		return err
+	}
+	cfg.Salt = opts.SecretKey
+
+	configs := make([]printedConfig, 0, len(opts.InputFolders))
+	for i := range opts.InputFolders {
+		folderCfg := inputConfig(cfg, opts, outputs, i)
+		folderCfg.OutputFolder = folderCfg.OutputDir()
+		configs = append(configs, newPrintedConfig(folderCfg))
+	}
+
+	data, err := json.MarshalIndent(configs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal config: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// inputConfig returns cfg set up for the i-th input folder
+func inputConfig(cfg anonymizer.Config, opts Options, outputs []string, i int) anonymizer.Config {
+	cfg.InputFolder, cfg.OutputFolder = opts.InputFolders[i], outputs[i]
+	cfg.ReportFile = reportPath(opts.ReportFile, opts.InputFolders[i], len(opts.InputFolders))
+	return cfg
+}
+
+// resolveConfig validates opts and builds the anonymizer config of a run,
+// without Salt, Logger and OutputWriter, and the output folder of each
+// input. It fills in the default mapping file of opts.
+func resolveConfig(opts *Options) (anonymizer.Config, []string, error) {
+	// Validate input folders
+	if len(opts.InputFolders) == 0 {
+		return anonymizer.Config{}, nil, fmt.Errorf("input folder is required")
+	}
+	for _, folder := range opts.InputFolders {
+		info, err := os.Stat(folder)
+		if err != nil {
+			return anonymizer.Config{}, nil, fmt.Errorf("input folder does not exist: %s", folder)
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return anonymizer.Config{}, nil, fmt.Errorf("input path is not a folder or file: %s", folder)
+		}
+	}
+	outputs, err := outputFolders(opts.InputFolders, opts.OutputFolder)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+
+	// Validate date policy
+	datePolicy := anonymizer.DatePolicy(opts.DatePolicy)
+	switch datePolicy {
+	case "", anonymizer.DatePolicyTruncateMonth, anonymizer.DatePolicyShiftDays, anonymizer.DatePolicyRemove:
+	default:
+		return anonymizer.Config{}, nil, fmt.Errorf("invalid date policy %q (use truncate, shift, or remove)", opts.DatePolicy)
+	}
+
+	if opts.MaxDepth < 0 {
+		return anonymizer.Config{}, nil, fmt.Errorf("invalid max depth %d (use 0 for unlimited)", opts.MaxDepth)
+	}
+	if opts.MaxFileSizeMB < 0 || opts.MaxFrameSizeMB < 0 {
+		return anonymizer.Config{}, nil, fmt.Errorf("invalid maximum size (use 0 for no limit)")
+	}
+
+	if opts.IDFormat != "" {
+		if err := identity.ValidateIDFormat(opts.IDFormat); err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
+	if opts.UIDRoot != "" {
+		if err := identity.ValidateUIDRoot(opts.UIDRoot); err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
+
+	unidentified, err := anonymizer.ParseUnidentifiedPolicy(opts.Unidentified)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+	onExisting, err := anonymizer.ParseExistingPolicy(opts.OnExisting)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+	filenames, err := anonymizer.ParseFilenamePolicy(opts.Filenames)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		if err := dcm.ValidatePatterns(patterns); err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
+
+	retain, err := anonymizer.ParseRetainOptions(opts.Retain)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+	if len(retain) > 0 && !opts.Confidentiality {
+		return anonymizer.Config{}, nil, fmt.Errorf("--retain requires --confidentiality-profile")
+	}
+	if opts.Confidentiality && opts.ProfileFile != "" {
+		return anonymizer.Config{}, nil, fmt.Errorf("--profile cannot be combined with --confidentiality-profile")
+	}
+
+	if _, err := anonymizer.NewScrubber(opts.Scrub, opts.ScrubNames); err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+
+	checkpoint, err := progress.ParseCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return anonymizer.Config{}, nil, err
+	}
+
+	var textDetector anonymizer.TextDetector
+	if opts.OCR {
+		if textDetector, err = anonymizer.NewOCRDetector(); err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
+
+	if opts.Explain > 0 && !opts.DryRun {
+		return anonymizer.Config{}, nil, fmt.Errorf("--explain requires --dry-run")
+	}
+	if opts.PreviewTSV != "" && !opts.DryRun {
+		return anonymizer.Config{}, nil, fmt.Errorf("--preview-tsv requires --dry-run")
+	}
+
+	// Overwriting originals cannot be undone, so it must be asked for twice
+	if opts.InPlaceBackup && !opts.InPlace {
+		return anonymizer.Config{}, nil, fmt.Errorf("--in-place-backup requires --in-place")
+	}
+	if opts.InPlace {
+		if !opts.IUnderstand && !opts.DryRun {
+			return anonymizer.Config{}, nil, fmt.Errorf("--in-place overwrites the original files with no way back; add --i-understand to confirm (and --in-place-backup to keep copies)")
+		}
+		if opts.OutputFolder != "" {
+			return anonymizer.Config{}, nil, fmt.Errorf("--in-place cannot be combined with --output: anonymized files replace the originals")
+		}
+		if opts.Force {
+			return anonymizer.Config{}, nil, fmt.Errorf("--in-place cannot be combined with --force: anonymized files would be anonymized again")
+		}
+		if opts.Manifest {
+			return anonymizer.Config{}, nil, fmt.Errorf("--in-place cannot be combined with --manifest")
+		}
+	}
+
+	if opts.Verbose && opts.Quiet {
+		return anonymizer.Config{}, nil, fmt.Errorf("-v and -q cannot be used together")
+	}
+
+	// Load tag profile
+	var profile *anonymizer.TagProfile
+	if opts.ProfileFile != "" {
+		profile, err = anonymizer.LoadTagProfile(opts.ProfileFile)
+		if err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
 
-	// Print header
-	printHeader(opts, keyGenerated)
+	var nicknames map[string]string
+	if opts.NicknameFile != "" {
+		nicknames, err = identity.LoadNicknames(opts.NicknameFile)
+		if err != nil {
+			return anonymizer.Config{}, nil, err
+		}
+	}
+
+	// Set default mapping file if not specified
+	if opts.MappingFile == "" {
+		// A single input file shares the mapping of a run over its folder
+		parentDir := filepath.Dir(anonymizer.InputDir(opts.InputFolders[0]))
+		opts.MappingFile = filepath.Join(parentDir, "patient_mapping.json")
+	}
 
 	// Build anonymizer config
 	cfg := anonymizer.Config{
-		InputFolder:       opts.InputFolder,
+		InputFolder:       opts.InputFolders[0],
+		OutputFolder:      outputs[0],
 		MappingFile:       opts.MappingFile,
-		Salt:              opts.SecretKey,
 		RedactRows:        opts.RedactRows,
+		RedactRegions:     opts.RedactRegions,
 		DryRun:            opts.DryRun,
+		ExplainFiles:      opts.Explain,
 		RetryFailed:       opts.RetryFailed,
+		Force:             opts.Force,
+		OnExisting:        onExisting,
+		FilenamePolicy:    filenames,
+		MaxFileSize:       int64(opts.MaxFileSizeMB) << 20,
+		MaxFrameSize:      int64(opts.MaxFrameSizeMB) << 20,
 		Recursive:         opts.Recursive,
+		MaxDepth:          opts.MaxDepth,
+		FollowSymlinks:    opts.FollowSymlinks,
 		ProcessMetadata:   opts.ProcessMetadata,
 		ProcessUltrasound: opts.ProcessUltrasound,
-		OutputWriter:      func(s string) {}, // Suppress internal output, we use progress callback
+		Workers:           opts.Workers,
+		DatePolicy:        datePolicy,
+		Profile:           profile,
+		EncryptMapping:    opts.EncryptMapping,
+		FuzzyNameMatching: opts.FuzzyNames || opts.NicknameFile != "",
+		Nicknames:         nicknames,
+		IDPrefix:          opts.IDPrefix,
+		IDFormat:          opts.IDFormat,
+		UIDRoot:           opts.UIDRoot,
+		IncludePatterns:   opts.Include,
+		ExcludePatterns:   opts.Exclude,
+		Modalities:        anonymizer.ParseModalities(opts.Modalities),
+		RemovePrivateTags: opts.RemovePrivateTags,
+		RemoveOverlays:    opts.RemoveOverlays,
+		WriteManifest:     opts.Manifest,
+		InPlace:           opts.InPlace,
+		InPlaceBackup:     opts.InPlaceBackup,
+
+		ConfidentialityProfile: opts.Confidentiality,
+		RetainOptions:          retain,
+		PreserveCalibration:    opts.KeepCalibration,
+		ScrubPatterns:          opts.Scrub,
+		ScrubPatientName:       opts.ScrubNames,
+		KeepSex:                opts.KeepSex,
+		KeepInstitutionName:    opts.KeepInstitution,
+		KeepStudyDescription:   opts.KeepStudyDesc,
+		UnidentifiedPolicy:     unidentified,
+
+		AllowMetadataOnlyFallback: opts.AllowMetadataOnly,
 	}
+	if opts.ContentHash {
+		cfg.HashMode = progress.HashSHA256Content
+	}
+	cfg.Checkpoint = checkpoint
+	cfg.TextDetector = textDetector
+	return cfg, outputs, nil
+}
 
-	// Create progress bar
-	pb := newProgressBar(50)
+// outputFolders returns the output folder of each input: the given output
+// for a single input, a subfolder of it named after each input when there
+// are several, or empty (= {input}/anonymized) without an output.
+func outputFolders(inputs []string, output string) ([]string, error) {
+	outputs := make([]string, len(inputs))
+	if output == "" {
+		return outputs, nil
+	}
+	if len(inputs) == 1 {
+		outputs[0] = output
+		return outputs, nil
+	}
 
-	// Progress callback
-	progressCallback := func(current, total int, filename, status string) {
-		pb.update(current, total)
+	seen := make(map[string]string, len(inputs))
+	for i, input := range inputs {
+		outputs[i] = filepath.Join(output, filepath.Base(filepath.Clean(input)))
+		if other, ok := seen[outputs[i]]; ok {
+			return nil, fmt.Errorf("inputs %s and %s would share the output folder %s", other, input, outputs[i])
+		}
+		seen[outputs[i]] = input
 	}
+	return outputs, nil
+}
 
-	// Run anonymization
-	if opts.DryRun {
-		fmt.Println("\n[DRY RUN MODE]")
+// reportPath returns the report file of one input. With several inputs
+// each gets its own report, e.g. report_CT.json for the input folder CT.
+func reportPath(report, input string, inputs int) string {
+	if report == "" || inputs == 1 {
+		return report
 	}
-	fmt.Println()
+	ext := filepath.Ext(report)
+	return strings.TrimSuffix(report, ext) + "_" + filepath.Base(filepath.Clean(input)) + ext
+}
 
-	stats, err := anonymizer.ProcessFolderWithProgress(cfg, progressCallback)
+// addStats adds the counts of one folder's run to total
+func addStats(total, stats *anonymizer.Stats) {
+	if stats == nil {
+		return
+	}
+	total.Success += stats.Success
+	total.Failed += stats.Failed
+	total.Skipped += stats.Skipped
+	total.SkippedModality += stats.SkippedModality
+	total.SkippedAnonymized += stats.SkippedAnonymized
+	total.SkippedExisting += stats.SkippedExisting
+	total.SkippedSize += stats.SkippedSize
+	total.SkippedResumed += stats.SkippedResumed
+	total.PixelsNotRedacted += stats.PixelsNotRedacted
+	total.LinkedGroups += stats.LinkedGroups
+	total.IdentityMatched += stats.IdentityMatched
+	total.PIDMatched += stats.PIDMatched
+	total.TotalPatients += stats.TotalPatients
+	total.Failures = append(total.Failures, stats.Failures...)
+	total.Preview = append(total.Preview, stats.Preview...)
+}
+
+// printResult prints the machine-readable last line of a run, e.g.
+// "RESULT success=150 failed=4 skipped=2"
+func printResult(stats *anonymizer.Stats) {
+	fmt.Printf("RESULT success=%d failed=%d skipped=%d\n", stats.Success, stats.Failed, stats.Skipped)
+}
+
+// exportMappingCSV writes the mapping file as a flat CSV table for auditors
+func exportMappingCSV(mappingFile, secretKey, csvPath string) error {
+	mapper, err := identity.NewPseudonymizationMapper(mappingFile, secretKey)
 	if err != nil {
-		return fmt.Errorf("processing failed: %w", err)
+		return err
 	}
 
-	// Print final progress bar at 100%
-	if stats.Success > 0 || stats.Failed > 0 || stats.Skipped > 0 {
-		total := stats.Success + stats.Failed + stats.Skipped
-		pb.update(total, total)
-		fmt.Println()
+	file, err := os.Create(csvPath)
+	if err != nil {
+		return err
 	}
 
-	// Print summary
-	printSummary(stats, opts.InputFolder, opts.MappingFile)
+	if err := mapper.ExportCSV(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
-	return nil
+// writePreviewTSV writes the patients a dry run planned as a TSV table
+func writePreviewTSV(path string, rows []anonymizer.PatientPreviewRow) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := anonymizer.WritePreviewTSV(file, rows); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 // GenerateSecretKey generates a cryptographically secure 32-character hex key
@@ -137,18 +679,152 @@ IMPORTANT - SECRET KEY:
   * SAVE YOUR KEY SECURELY - store it with your mapping file
 
 FLAGS:
-  -i, --input <path>      Input folder containing DICOM files (required for CLI)
+  -i, --input <path>      Input folder containing DICOM files (required for CLI).
+                          Repeat it or list folders comma-separated to process
+                          them in one run with the same key and mapping.
+                          A single .dcm file is processed without searching
+  -o, --output <path>     Output folder (default: {input}/anonymized). May be
+                          outside the input tree. With several inputs, each
+                          gets a subfolder named after its input folder
   -k, --key <key>         Secret key for pseudonymization (REQUIRED - see above)
                           If not provided, a key is auto-generated and displayed
   -m, --mapping <path>    Patient mapping file (default: {parent}/patient_mapping.json)
                           This file tracks original-to-anonymous ID mappings
-      --redact-rows <n>   Rows to redact from ultrasound images (default: 75)
-  -r, --recursive         Search subdirectories (default: true)
+      --id-prefix <text>  Prefix of new anonymous IDs (default: ANON-)
+      --id-format <fmt>   Number format of new anonymous IDs, with one integer
+                          verb (default: %06d). Fixed once a mapping has IDs
+      --uid-root <root>   Your organization's registered DICOM UID root for
+                          generated UIDs, e.g. 1.2.840.99999 (default: 2.25).
+                          Fixed once a mapping has IDs
+      --fuzzy-names       Match names ignoring middle initials and nicknames
+                          ("JON A SMITH" = "JONATHAN SMITH"). Only affects
+                          patients not yet in the mapping
+      --nicknames <file>  JSON nickname table {"JON": "JONATHAN", ...} for
+                          --fuzzy-names (default: built-in table)
+      --unidentified <policy>
+                          Anonymous IDs of files with no PatientID and no
+                          Name+DOB: new (share the PID UNKNOWN), sop-uid or
+                          content-hash (one stable ID per file), or bucket
+                          (all under UNIDENTIFIED) (default: new)
+      --export-csv <path> After processing, write the mapping as a CSV table
+                          (anon_id, original_pid, identity_hash, date_shift)
+  -v, --verbose           Log each patient and file instead of a progress bar
+  -q, --quiet             Only print warnings, errors and the final summary
+      --report <path>     After processing, write a JSON report with per-file
+                          status, failures, statistics and elapsed time
+      --manifest          After processing, write {output}/manifest.json with
+                          the SHA-256, size and anonymous ID of every output
+                          file (no original identifiers)
+      --encrypt-mapping   Encrypt the mapping file (AES-256-GCM, key derived
+                          from the secret key). Encrypted files are detected
+                          automatically and need the same key to open
+      --redact-rows <n>   Rows to redact from ultrasound images without declared
+                          ultrasound regions (default: 75)
+      --redact-region <x,y,w,h>
+                          Also redact this pixel rectangle in ultrasound images
+                          (repeatable)
+      --ocr               Also redact text found by OCR in ultrasound images;
+                          with --dry-run, list the frames with text. Needs a
+                          build with -tags tesseract
+      --allow-metadata-only
+                          When dcmtk is missing, write JPEG-LS ultrasound
+                          files with metadata anonymized but burned-in pixels
+                          NOT redacted instead of failing them
+  -r, --recursive         Search subdirectories; -r=false turns it off
+                          (default: true)
+      --max-depth <n>     Search at most n directory levels below the input
+                          folder, e.g. 1 for its direct subfolders only
+                          (default: 0, unlimited)
+      --follow-symlinks   Also search symlinked directories, each directory
+                          once (default: symlinked directories are skipped)
+      --include <glob>    Only process DICOM files whose path relative to the
+                          input folder matches, e.g. "*/US/*" or "**/*.dcm".
+                          Patterns without / match the file name (repeatable)
+      --exclude <glob>    Skip DICOM files matching this pattern (repeatable)
       --retry             Retry previously failed files from a previous run
+      --force             Also process files already marked as anonymized
+                          (PatientIdentityRemoved=YES), which are skipped by
+                          default so output is never anonymized twice
+      --in-place          DANGEROUS: overwrite each original with its
+                          anonymized version instead of writing an output
+                          folder. Needs --i-understand
+      --in-place-backup   With --in-place, keep each original as <name>.orig
+                          next to the anonymized file
+      --i-understand      Confirm --in-place
+      --on-existing <mode>
+                          When an output file already exists: overwrite,
+                          skip (count as skipped), error (fail the file), or
+                          rename (write IM0001_1.dcm, ...) (default: overwrite)
+      --filenames <mode>  Output file names below the patient folder: preserve
+                          (mirror the input path), sop-uid (<SOPInstanceUID>.dcm,
+                          remapped) or sequential (00001.dcm, ...). The last two
+                          drop input names that may hold patient names or
+                          accession numbers (default: preserve)
+      --max-file-size <MB>
+                          Skip files larger than this with a warning instead
+                          of reading them into memory (default: 0, no limit)
+      --max-frame-size <MB>
+                          Warn about ultrasound files with larger frames;
+                          redaction holds all frames in memory (default: 256)
+      --content-hash      Detect already-processed files by content (SHA-256)
+                          instead of size + modification time
+      --checkpoint-interval <n|duration>
+                          Save progress every n files or every duration,
+                          e.g. 100 or 30s (default: 100 files or 30s)
+      --workers <n>       Files to process concurrently (default: number of CPUs)
+      --profile <file>    JSON tag profile (clear/truncate_date/keep/hash lists)
+      --keep-sex          Keep PatientSex (default: true; =false clears it)
+      --keep-institution  Keep InstitutionName (default: true)
+      --keep-study-description
+                          Keep StudyDescription (default: true)
+      --remove-private-tags
+                          Remove private (odd group) tags, keeping only known
+                          acquisition-parameter blocks (default: true)
+      --confidentiality-profile
+                          Apply the DICOM PS3.15 Basic Application Level
+                          Confidentiality Profile to all attributes instead of
+                          the tag profile, and mark files as de-identified
+      --retain <list>     PS3.15 options for --confidentiality-profile, comma
+                          separated: dates, uids, device, patient,
+                          institution, safe-private
+      --preserve-calibration
+                          Keep pixel spacing, rescale, window and ultrasound
+                          region calibration even if --profile clears them
+      --scrub-names       Replace the patient's own name with *** in the free
+                          text kept for context, e.g. StudyDescription
+      --scrub <regex>     Also replace matches of this regular expression in
+                          kept free text (LO, LT, ST, UT; repeatable)
+      --remove-overlays   Remove overlay planes (groups 60xx), which can hold
+                          burned-in annotations (default: true)
+      --dates <policy>    Date handling: truncate (YYYYMM01), shift (per-patient
+                          offset, keeps intervals), or remove (default: truncate)
       --metadata          Process CT/MRI/X-Ray files (default: true)
       --ultrasound        Process ultrasound with pixel redaction (default: true)
+      --modality <list>   Only process these DICOM Modality values, comma
+                          separated, e.g. CT,MR (default: all)
   -n, --dry-run           Preview what will be processed, no files modified
+      --explain <n>       With --dry-run, list the tags that would be cleared,
+                          truncated or rewritten in the first n files (values
+                          are shown only as lengths)
+      --preview-tsv <path>
+                          With --dry-run, write the planned patients as a
+                          TSV table: anon_id, patient, method, files
+      --fail-on-error     Exit with status 2 if any file failed (default: exit
+                          0 and report failures in the summary)
+      --print-config      Print the configuration a run with these flags would
+                          use as a JSON array with one entry per input, with
+                          the default mapping and output paths filled in and
+                          the secret key shown only as set or unset, and exit
+                          without processing
   -h, --help              Show this help message
+      --version           Print the version, build details and dcmtk version
+      --temp-dir <dir>    Folder for the temporary files of dcmtk JPEG-LS
+                          decompression and compression, created if needed
+                          (default: system temp). Use when it is too small
+                          for long cine loops
+      --selftest          Check dcmtk, the temp folder, DICOM reading and
+                          writing and the JPEG-LS encoder (decoded again with
+                          dcmtk if installed). Exits with status 1 on failure
 
 WORKFLOW - Processing Multiple Modalities:
 
@@ -178,33 +854,94 @@ EXAMPLES:
   # Process only CT/MRI/X-Ray (skip ultrasound)
   ./dicom-anonymizer -i /path/to/dicoms -k YOUR_SECRET_KEY --ultrasound=false
 
+  # Process one modality of a mixed export at a time
+  ./dicom-anonymizer -i /path/to/dicoms -k YOUR_SECRET_KEY --modality CT,MR
+
   # Process only ultrasound with custom redaction
   ./dicom-anonymizer -i /path/to/dicoms -k YOUR_SECRET_KEY --metadata=false --redact-rows=100
 
   # Retry failed files from previous run
   ./dicom-anonymizer -i /path/to/dicoms -k YOUR_SECRET_KEY --retry
 
+  # Process several modality folders in one run with the same key
+  ./dicom-anonymizer -i /data/CT_Scans -i /data/MRI_Scans -k YOUR_SECRET_KEY
+
   # Use custom mapping file location
   ./dicom-anonymizer -i /path/to/dicoms -k YOUR_SECRET_KEY -m /secure/mappings.json
 
+DE-ANONYMIZATION:
+  --deanonymize <id>      Print the original PatientIDs, identity hashes and
+                          date shift recorded for an anonymous ID (needs -m)
+  --restore <file>        Write <file>_restored.dcm with the original PatientID,
+                          UIDs and shifted dates put back (needs -m, and -k
+                          if the mapping is encrypted)
+
+  --merge-mapping <a.json,b.json> -o <merged.json>
+                          Combine mapping files from several sites. Patients
+                          matched by identity or PatientID keep the ID from the
+                          first file; others are renumbered (previous IDs are
+                          listed under merged_from). Conflicts are reported.
+                          All files must use the same secret key (-k)
+  --audit-collisions      List anonymous IDs holding more than one identity
+                          hash, with how many different names were seen and
+                          any conflicts warned about during runs (needs -m)
+
+  --validate <folder>     Re-read anonymized files and list tags of the
+                          profile that still hold data, dates that are not
+                          truncated (or removed with --dates remove) and,
+                          with --remove-private-tags, private tags. Pass the
+                          --profile, --dates and --keep-* flags of the run.
+                          Exits with status 2 if anything is found
+
+  --scan -i <folder>      List how many input files have each modality and
+                          transfer syntax, and how many have a usable Name+DOB
+                          or only a PatientID. Reads metadata only, writes
+                          nothing. Honours -r, --max-depth, --include and
+                          --exclude (alias: --list-modalities)
+
+  Identity hashes are HMAC-SHA256(Name+DOB) keyed by the secret key; recompute
+  them with the same key to check whether a patient matches an anonymous ID.
+
 OUTPUT:
-  Anonymized files: {input}/anonymized/ANON-XXXXXX/
+  Anonymized files: {output}/ANON-XXXXXX/ ({output} defaults to {input}/anonymized)
   Mapping file:     {parent}/patient_mapping.json (or custom with -m)
-  Error log:        {input}/anonymized/errors.log
+  UID mapping:      {parent}/patient_mapping_uids.json (next to the mapping file)
+  Error log:        {output}/errors.log
 
 SECURITY - KEEP THESE SECRET:
   1. Secret Key     - DO NOT share. Required to maintain patient ID consistency.
-  2. Mapping File   - DO NOT share. Contains original-to-anonymous ID mappings.
-                      Anyone with this file can re-identify patients.
+  2. Mapping Files  - DO NOT share. Contain original-to-anonymous ID and UID
+                      mappings. Anyone with these files can re-identify patients.
 
-  Only share the anonymized DICOM files in the 'anonymized/' folder.`)
+  Only share the anonymized DICOM files in the output folder.`)
+}
+
+// PrintVersion prints the tool version, build details and dcmtk version
+func PrintVersion() {
+	fmt.Printf("dicom-anonymizer %s\n", anonymizer.Version)
+	if anonymizer.Commit != "" {
+		fmt.Printf("Commit:    %s\n", anonymizer.Commit)
+	}
+	if anonymizer.BuildDate != "" {
+		fmt.Printf("Built:     %s\n", anonymizer.BuildDate)
+	}
+	if version, err := dcm.DcmtkVersion(); err == nil {
+		fmt.Printf("dcmtk:     %s\n", version)
+	} else {
+		fmt.Println("dcmtk:     not installed")
+	}
 }
 
 // printHeader prints the CLI header with configuration
 func printHeader(opts Options, keyGenerated bool) {
 	fmt.Println("DICOM Anonymizer")
 	fmt.Println(strings.Repeat("=", 50))
-	fmt.Printf("Input:     %s\n", opts.InputFolder)
+	for _, folder := range opts.InputFolders {
+		fmt.Printf("Input:     %s\n", folder)
+	}
+	if opts.OutputFolder != "" {
+		fmt.Printf("Output:    %s\n", opts.OutputFolder)
+	}
 	fmt.Printf("Mapping:   %s\n", opts.MappingFile)
 
 	if keyGenerated {
@@ -230,44 +967,203 @@ func printHeader(opts Options, keyGenerated bool) {
 		modalities = append(modalities, "CT/MRI/X-Ray")
 	}
 	if opts.ProcessUltrasound {
-		modalities = append(modalities, fmt.Sprintf("Ultrasound (%dpx redaction)", opts.RedactRows))
+		redaction := fmt.Sprintf("%dpx redaction", opts.RedactRows)
+		if opts.OCR {
+			redaction += " + OCR"
+		}
+		modalities = append(modalities, fmt.Sprintf("Ultrasound (%s)", redaction))
 	}
 	if len(modalities) == 0 {
 		modalities = append(modalities, "None")
 	}
 	fmt.Printf("Modality:  %s\n", strings.Join(modalities, ", "))
+	if only := anonymizer.ParseModalities(opts.Modalities); len(only) > 0 {
+		fmt.Printf("Only:      %s\n", strings.Join(only, ", "))
+	}
+	if len(opts.Include) > 0 {
+		fmt.Printf("Include:   %s\n", strings.Join(opts.Include, ", "))
+	}
+	if len(opts.Exclude) > 0 {
+		fmt.Printf("Exclude:   %s\n", strings.Join(opts.Exclude, ", "))
+	}
 
 	// Build options string
 	var options []string
 	if opts.Recursive {
 		options = append(options, "Recursive")
+		if opts.MaxDepth > 0 {
+			options = append(options, fmt.Sprintf("Max depth %d", opts.MaxDepth))
+		}
+		if opts.FollowSymlinks {
+			options = append(options, "Follow symlinks")
+		}
 	}
 	if opts.RetryFailed {
 		options = append(options, "Retry failed")
 	}
+	if opts.Force {
+		options = append(options, "Force")
+	}
+	if opts.InPlace {
+		if opts.InPlaceBackup {
+			options = append(options, "IN PLACE (originals overwritten, kept as *"+anonymizer.InPlaceBackupSuffix+")")
+		} else {
+			options = append(options, "IN PLACE (originals overwritten, no backup)")
+		}
+	}
+	if opts.AllowMetadataOnly {
+		options = append(options, "Metadata-only fallback")
+	}
 	if opts.DryRun {
 		options = append(options, "Dry run")
 	}
+	if opts.Workers > 0 {
+		options = append(options, fmt.Sprintf("%d workers", opts.Workers))
+	}
+	if opts.ContentHash {
+		options = append(options, "Content hash")
+	}
+	if opts.Checkpoint != "" {
+		options = append(options, "Checkpoint every "+opts.Checkpoint)
+	}
+	if opts.TempDir != "" {
+		options = append(options, "Temp: "+opts.TempDir)
+	}
+	if opts.EncryptMapping {
+		options = append(options, "Encrypted mapping")
+	}
+	if opts.Manifest {
+		options = append(options, "Manifest")
+	}
+	if opts.FuzzyNames || opts.NicknameFile != "" {
+		options = append(options, "Fuzzy names")
+	}
+	var cleared []string
+	if !opts.KeepSex {
+		cleared = append(cleared, "sex")
+	}
+	if !opts.KeepInstitution {
+		cleared = append(cleared, "institution")
+	}
+	if !opts.KeepStudyDesc {
+		cleared = append(cleared, "study description")
+	}
+	if len(cleared) > 0 {
+		options = append(options, fmt.Sprintf("Clear %s", strings.Join(cleared, ", ")))
+	}
+	if !opts.RemovePrivateTags {
+		options = append(options, "Keep private tags")
+	}
+	if !opts.RemoveOverlays {
+		options = append(options, "Keep overlays")
+	}
+	if opts.KeepCalibration {
+		options = append(options, "Preserve calibration")
+	}
+	if opts.ScrubNames || len(opts.Scrub) > 0 {
+		var scrub []string
+		if opts.ScrubNames {
+			scrub = append(scrub, "patient names")
+		}
+		if len(opts.Scrub) > 0 {
+			scrub = append(scrub, fmt.Sprintf("%d pattern(s)", len(opts.Scrub)))
+		}
+		options = append(options, fmt.Sprintf("Scrub free text: %s", strings.Join(scrub, ", ")))
+	}
+	if opts.Confidentiality {
+		profile := "PS3.15 Basic Profile"
+		if opts.Retain != "" {
+			profile += fmt.Sprintf(" (retain %s)", opts.Retain)
+		}
+		options = append(options, profile)
+	}
+	if opts.IDPrefix != "" || opts.IDFormat != "" {
+		prefix, format := opts.IDPrefix, opts.IDFormat
+		if prefix == "" {
+			prefix = identity.DefaultIDPrefix
+		}
+		if format == "" {
+			format = identity.DefaultIDFormat
+		}
+		options = append(options, fmt.Sprintf("IDs: %s", prefix+fmt.Sprintf(format, 1)))
+	}
+	if opts.UIDRoot != "" {
+		options = append(options, "UID root: "+opts.UIDRoot)
+	}
+	if opts.ProfileFile != "" {
+		options = append(options, fmt.Sprintf("Profile: %s", filepath.Base(opts.ProfileFile)))
+	}
+	if opts.DatePolicy != "" && opts.DatePolicy != string(anonymizer.DatePolicyTruncateMonth) {
+		options = append(options, fmt.Sprintf("Dates: %s", opts.DatePolicy))
+	}
+	if opts.Unidentified != "" && opts.Unidentified != string(anonymizer.UnidentifiedNew) {
+		options = append(options, fmt.Sprintf("Unidentified: %s", opts.Unidentified))
+	}
+	if opts.OnExisting != "" && opts.OnExisting != string(anonymizer.ExistingOverwrite) {
+		options = append(options, fmt.Sprintf("Existing output: %s", opts.OnExisting))
+	}
+	if opts.Filenames != "" && opts.Filenames != string(anonymizer.FilenamePreserveRelative) {
+		options = append(options, fmt.Sprintf("Filenames: %s", opts.Filenames))
+	}
+	if opts.MaxFileSizeMB > 0 {
+		options = append(options, fmt.Sprintf("Max file size: %d MB", opts.MaxFileSizeMB))
+	}
 	if len(options) > 0 {
 		fmt.Printf("Options:   %s\n", strings.Join(options, ", "))
 	}
 }
 
 // printSummary prints the processing summary
-func printSummary(stats *anonymizer.Stats, inputFolder, mappingFile string) {
+func printSummary(stats *anonymizer.Stats, outputFolders []string, mappingFile string) {
 	fmt.Println()
 	fmt.Println(strings.Repeat("=", 50))
 	fmt.Printf("Complete! %d succeeded, %d failed, %d skipped\n",
 		stats.Success, stats.Failed, stats.Skipped)
+	if stats.SkippedAnonymized > 0 {
+		fmt.Printf("Skipped:   %d already anonymized (use --force to reprocess)\n", stats.SkippedAnonymized)
+	}
+	if stats.SkippedExisting > 0 {
+		fmt.Printf("Skipped:   %d with existing output (--on-existing skip)\n", stats.SkippedExisting)
+	}
+	if stats.SkippedSize > 0 {
+		fmt.Printf("Skipped:   %d larger than --max-file-size\n", stats.SkippedSize)
+	}
+	if stats.SkippedResumed > 0 {
+		fmt.Printf("Resumed:   %d files done by an earlier run skipped\n", stats.SkippedResumed)
+	}
+	if stats.PixelsNotRedacted > 0 {
+		fmt.Printf("WARNING:   %d ultrasound files have pixels NOT redacted (review before sharing)\n", stats.PixelsNotRedacted)
+	}
 	fmt.Printf("Patients:  %d total (%d by Name+DOB, %d by PatientID)\n",
 		stats.TotalPatients, stats.IdentityMatched, stats.PIDMatched)
-	fmt.Printf("Output:    %s\n", filepath.Join(inputFolder, "anonymized"))
+	if stats.LinkedGroups > 0 {
+		fmt.Printf("Linked:    %d patient group(s) merged into others sharing a StudyInstanceUID\n", stats.LinkedGroups)
+	}
+	if len(stats.Failures) > 0 {
+		fmt.Printf("Failures:  %s\n", progress.CategorySummary(stats.Failures))
+		if hint := anonymizer.DcmtkFailureHint(stats.Failures); hint != "" {
+			fmt.Printf("           %s\n", hint)
+		}
+	}
+	for _, folder := range outputFolders {
+		fmt.Printf("Output:    %s\n", folder)
+	}
 	fmt.Printf("Mapping:   %s\n", mappingFile)
 }
 
-// progressBar represents a terminal progress bar
+// rateWindow is how far back the progress bar looks to smooth the rate
+const rateWindow = 10 * time.Second
+
+// progressBar represents a terminal progress bar with throughput and ETA
 type progressBar struct {
-	width int
+	width   int
+	samples []progressSample // Updates within rateWindow, oldest first
+}
+
+// progressSample is the number of files done at a point in time
+type progressSample struct {
+	at      time.Time
+	current int
 }
 
 // newProgressBar creates a new progress bar with specified width
@@ -275,7 +1171,13 @@ func newProgressBar(width int) *progressBar {
 	return &progressBar{width: width}
 }
 
-// update updates the progress bar display
+// reset starts the rate over, e.g. for the next input folder
+func (pb *progressBar) reset() {
+	pb.samples = nil
+}
+
+// update updates the progress bar display, e.g.
+// "[####----]  42%  (420/1000)  35 files/s  ETA 00:16:42"
 func (pb *progressBar) update(current, total int) {
 	if total == 0 {
 		return
@@ -288,7 +1190,46 @@ func (pb *progressBar) update(current, total int) {
 	}
 
 	bar := strings.Repeat("#", filled) + strings.Repeat("-", pb.width-filled)
-	fmt.Printf("\r[%s] %3.0f%%  (%d/%d)", bar, percent*100, current, total)
+	line := fmt.Sprintf("\r[%s] %3.0f%%  (%d/%d)", bar, percent*100, current, total)
+	if rate := pb.rate(time.Now(), current); rate > 0 {
+		line += fmt.Sprintf("  %s files/s", formatRate(rate))
+		if current < total {
+			eta := time.Duration(float64(total-current) / rate * float64(time.Second))
+			line += "  ETA " + formatDuration(eta)
+		}
+	}
+	// Trailing spaces clear what is left of a longer previous line
+	fmt.Printf("%-*s", pb.width+48, line)
+}
+
+// rate records current and returns the files per second over the last
+// rateWindow, or 0 until there are at least a second of updates
+func (pb *progressBar) rate(now time.Time, current int) float64 {
+	pb.samples = append(pb.samples, progressSample{at: now, current: current})
+	for len(pb.samples) > 1 && now.Sub(pb.samples[1].at) >= rateWindow {
+		pb.samples = pb.samples[1:]
+	}
+
+	oldest := pb.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed < 1 || current <= oldest.current {
+		return 0
+	}
+	return float64(current-oldest.current) / elapsed
+}
+
+// formatRate formats a rate with one decimal below 10, e.g. "2.5" or "35"
+func formatRate(rate float64) string {
+	if rate < 10 {
+		return fmt.Sprintf("%.1f", rate)
+	}
+	return fmt.Sprintf("%.0f", rate)
+}
+
+// formatDuration formats d as HH:MM:SS, e.g. "00:16:42"
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
 }
 
 // checkDcmtkStatus checks if dcmtk is installed and prompts for installation if not
@@ -301,9 +1242,10 @@ func checkDcmtkStatus() error {
 	fmt.Println("dcmtk is required to process JPEG-LS compressed DICOM files.")
 	fmt.Println()
 
-	installCmd := getDcmtkInstallCommand()
+	installCmd := dcm.DcmtkInstallCommand()
 	if installCmd == "" {
-		fmt.Println("Please install dcmtk using your system package manager and try again.")
+		fmt.Println(dcm.DcmtkInstallHint())
+		fmt.Println("Then try again.")
 		return fmt.Errorf("dcmtk is not installed")
 	}
 
@@ -324,7 +1266,7 @@ func checkDcmtkStatus() error {
 	}
 
 	fmt.Println("Installing dcmtk...")
-	cmd := exec.Command("bash", "-lc", installCmd)
+	cmd := dcm.ShellCommand(installCmd)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -345,15 +1287,3 @@ func checkDcmtkStatus() error {
 	fmt.Println()
 	return nil
 }
-
-// getDcmtkInstallCommand returns the platform-specific installation command
-func getDcmtkInstallCommand() string {
-	switch runtime.GOOS {
-	case "darwin":
-		return "brew install dcmtk"
-	case "linux":
-		return "sudo apt-get update && sudo apt-get install -y dcmtk"
-	default:
-		return ""
-	}
-}
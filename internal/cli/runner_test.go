@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteConfig(t *testing.T) {
+	input := t.TempDir()
+	opts := Options{
+		InputFolders:  []string{input},
+		SecretKey:     "secret",
+		InPlace:       true,
+		InPlaceBackup: true,
+		IUnderstand:   true,
+	}
+	var buf bytes.Buffer
+	if err := writeConfig(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("printed config contains the secret key")
+	}
+	var configs []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &configs); err != nil {
+		t.Fatalf("printed config is not a JSON array: %v", err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("got %d configs for one input, want 1", len(configs))
+	}
+	for key, want := range map[string]interface{}{
+		"Salt":          "set",
+		"InPlace":       true,
+		"InPlaceBackup": true,
+		"Pauser":        false,
+		"TextDetector":  false,
+		"OutputFolder":  filepath.Join(input, "anonymized"),
+	} {
+		if got := configs[0][key]; got != want {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+
+	opts = Options{InputFolders: []string{input, t.TempDir()}, OutputFolder: t.TempDir()}
+	buf.Reset()
+	if err := writeConfig(&buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	configs = nil
+	if err := json.Unmarshal(buf.Bytes(), &configs); err != nil {
+		t.Fatalf("printed config is not a JSON array: %v", err)
+	}
+	if len(configs) != 2 || configs[1]["Salt"] != "unset" || configs[1]["InPlace"] != false {
+		t.Errorf("printed configs for two inputs = %v, want 2 with the secret key unset", configs)
+	}
+}
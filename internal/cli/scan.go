@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"dicom-anonymizer/internal/anonymizer"
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// ScanOptions holds options for summarizing input folders before a run.
+// The search options should match the run that will follow.
+type ScanOptions struct {
+	InputFolders   []string
+	Recursive      bool
+	MaxDepth       int
+	FollowSymlinks bool
+	Include        []string
+	Exclude        []string
+}
+
+// transferSyntaxNames are the names shown by Scan for common transfer syntaxes
+var transferSyntaxNames = map[string]string{
+	dcm.ImplicitVRLittleEndian: "Implicit VR Little Endian",
+	dcm.ExplicitVRLittleEndian: "Explicit VR Little Endian",
+	dcm.ExplicitVRBigEndian:    "Explicit VR Big Endian",
+	dcm.JPEGLSLossless:         "JPEG-LS Lossless",
+	dcm.JPEGLSNearLossy:        "JPEG-LS Near-Lossless",
+	dcm.RLELossless:            "RLE Lossless",
+	"1.2.840.10008.1.2.1.99":   "Deflated Explicit VR Little Endian",
+	"1.2.840.10008.1.2.4.50":   "JPEG Baseline",
+	"1.2.840.10008.1.2.4.51":   "JPEG Extended",
+	"1.2.840.10008.1.2.4.57":   "JPEG Lossless",
+	"1.2.840.10008.1.2.4.70":   "JPEG Lossless SV1",
+	"1.2.840.10008.1.2.4.90":   "JPEG 2000 Lossless",
+	"1.2.840.10008.1.2.4.91":   "JPEG 2000",
+}
+
+// Scan finds the DICOM files in the input folders, reads their metadata
+// and prints how many files have each modality and transfer syntax, and
+// how many can be grouped by Name+DOB or only by PatientID. Nothing is
+// written.
+func Scan(opts ScanOptions) error {
+	if len(opts.InputFolders) == 0 {
+		return fmt.Errorf("input folder is required")
+	}
+	if opts.MaxDepth < 0 {
+		return fmt.Errorf("invalid max depth %d (use 0 for unlimited)", opts.MaxDepth)
+	}
+	for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+		if err := dcm.ValidatePatterns(patterns); err != nil {
+			return err
+		}
+	}
+
+	var files []string
+	for _, folder := range opts.InputFolders {
+		if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+			return fmt.Errorf("folder does not exist: %s", folder)
+		}
+		found, err := dcm.FindDicomFilesWithOptions(folder, dcm.FindOptions{
+			Recursive:       opts.Recursive,
+			MaxDepth:        opts.MaxDepth,
+			FollowSymlinks:  opts.FollowSymlinks,
+			IncludePatterns: opts.Include,
+			ExcludePatterns: opts.Exclude,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Found %d DICOM file(s) in %s\n", len(found), folder)
+		files = append(files, found...)
+	}
+
+	summary := anonymizer.ScanFiles(files)
+
+	fmt.Println()
+	printCounts("Modality", summary.Modalities, func(modality string) string {
+		if modality == "" {
+			return "(not set)"
+		}
+		return modality
+	})
+
+	fmt.Println()
+	printCounts("Transfer syntax", summary.TransferSyntaxes, func(uid string) string {
+		if uid == "" {
+			return "(not set)"
+		}
+		if name, ok := transferSyntaxNames[uid]; ok {
+			return fmt.Sprintf("%s (%s)", name, uid)
+		}
+		return uid
+	})
+
+	fmt.Println()
+	fmt.Printf("%-50s %7s\n", "Patient grouping", "Files")
+	fmt.Printf("%-50s %7d\n", "Name + birth date", summary.NameAndDOB)
+	fmt.Printf("%-50s %7d\n", "PatientID only (name or birth date missing)", summary.PatientIDOnly)
+	fmt.Printf("%-50s %7d\n", "Neither (see --unidentified)", summary.Unidentified)
+	if summary.Unreadable > 0 {
+		fmt.Printf("%-50s %7d\n", "Unreadable", summary.Unreadable)
+	}
+
+	if summary.TransferSyntaxes[dcm.JPEGLSLossless]+summary.TransferSyntaxes[dcm.JPEGLSNearLossy] > 0 && !dcm.CheckDcmtkInstalled() {
+		fmt.Println()
+		fmt.Println("WARNING: JPEG-LS files found and dcmtk is not installed; ultrasound files among them will fail.")
+		fmt.Println("  " + dcm.DcmtkInstallHint())
+	}
+
+	fmt.Println()
+	fmt.Printf("Scanned %d file(s)\n", summary.Files)
+	return nil
+}
+
+// printCounts prints counts as a table sorted by count, most files first
+func printCounts(heading string, counts map[string]int, label func(string) string) {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	fmt.Printf("%-50s %7s\n", heading, "Files")
+	for _, key := range keys {
+		fmt.Printf("%-50s %7d\n", label(key), counts[key])
+	}
+}
@@ -0,0 +1,314 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dcm "dicom-anonymizer/internal/dicom"
+	"dicom-anonymizer/internal/jpegls"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/frame"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// ErrSelfTestFailed is returned by SelfTest when any check failed
+var ErrSelfTestFailed = errors.New("self-test failed")
+
+// Size of the built-in test image
+const (
+	selfTestWidth  = 64
+	selfTestHeight = 48
+)
+
+// errSkipped marks a check that does not apply on this system
+type errSkipped struct{ reason string }
+
+func (e errSkipped) Error() string { return e.reason }
+
+// SelfTest checks that this install can anonymize files: dcmtk detection,
+// a writable temp folder, reading and writing a trivial DICOM file, and the
+// built-in JPEG-LS encoder on a known image, decoded again with dcmtk when
+// it is installed. Each check prints PASS, FAIL or SKIP with its timing.
+// tempDir is the folder set with --temp-dir ("" = the system temp folder).
+func SelfTest(tempDir string) error {
+	fmt.Println("DICOM Anonymizer Self-Test")
+	fmt.Println(strings.Repeat("=", 50))
+
+	if err := dcm.SetTempDir(tempDir); err != nil {
+		return err
+	}
+
+	var testDir string
+	defer func() {
+		if testDir != "" {
+			os.RemoveAll(testDir)
+		}
+	}()
+
+	checks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"dcmtk detection", selfTestDcmtk},
+		{"temp folder", func() (string, error) {
+			var err error
+			testDir, err = selfTestTempDir()
+			return testDir, err
+		}},
+		{"DICOM read/write", func() (string, error) { return selfTestDicom(testDir) }},
+		{"JPEG-LS encode", selfTestEncode},
+		{"JPEG-LS decode", func() (string, error) { return selfTestDecode(testDir) }},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		start := time.Now()
+		detail, err := check.run()
+		elapsed := time.Since(start).Round(time.Millisecond)
+
+		status := "PASS"
+		var skipped errSkipped
+		switch {
+		case errors.As(err, &skipped):
+			status, detail = "SKIP", skipped.reason
+		case err != nil:
+			status, detail = "FAIL", err.Error()
+			failed++
+		}
+		fmt.Printf("%-4s  %-18s %8s  %s\n", status, check.name, elapsed, detail)
+	}
+
+	fmt.Println()
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return ErrSelfTestFailed
+	}
+	fmt.Println("All checks passed")
+	return nil
+}
+
+// selfTestDcmtk reports the dcmtk version. A missing dcmtk is not a
+// failure, but one that is installed and cannot be run is.
+func selfTestDcmtk() (string, error) {
+	version, err := dcm.DcmtkVersion()
+	if err == nil {
+		return version, nil
+	}
+	if dcm.CheckDcmtkInstalled() {
+		return "", fmt.Errorf("dcmtk is installed but dcmdjpls does not run (is its bin folder on PATH?): %w", err)
+	}
+	return "", errSkipped{"dcmtk not installed; JPEG-LS ultrasound files will fail. " + dcm.DcmtkInstallHint()}
+}
+
+// selfTestTempDir creates the folder the other checks write to, in the
+// same temp location dcmtk decompression uses
+func selfTestTempDir() (string, error) {
+	dir, err := os.MkdirTemp(dcm.TempDir(), "dicom-anonymizer-selftest-*")
+	if err != nil {
+		return "", fmt.Errorf("temp folder not writable: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "probe"), []byte("probe"), 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("temp folder not writable: %w", err)
+	}
+	return dir, nil
+}
+
+// selfTestDicom writes the test image as an uncompressed DICOM file and
+// checks that its identifiers and pixels read back unchanged
+func selfTestDicom(dir string) (string, error) {
+	if dir == "" {
+		return "", errSkipped{"no temp folder"}
+	}
+
+	ds, err := newSelfTestDataset()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "native.dcm")
+	if err := ds.Save(path); err != nil {
+		return "", err
+	}
+
+	read, err := dcm.ReadDicom(path)
+	if err != nil {
+		return "", err
+	}
+	if got := read.GetPatientID(); got != "SELFTEST" {
+		return "", fmt.Errorf("PatientID read back as %q, want %q", got, "SELFTEST")
+	}
+	if err := compareSelfTestPixels(read); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%dx%d, 8-bit", selfTestWidth, selfTestHeight), nil
+}
+
+// selfTestEncode encodes the test image with the built-in JPEG-LS encoder
+// and checks the marker structure of the codestream
+func selfTestEncode() (string, error) {
+	data, err := jpegls.NewEncoder(selfTestWidth, selfTestHeight, 1, 8).Encode(selfTestPixels())
+	if err != nil {
+		return "", err
+	}
+	if err := checkJPEGLSMarkers(data); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d bytes from %d", len(data), selfTestWidth*selfTestHeight), nil
+}
+
+// selfTestDecode writes the test image JPEG-LS compressed with the built-in
+// encoder, decompresses it with dcmdjpls and checks the pixels are
+// unchanged
+func selfTestDecode(dir string) (string, error) {
+	if dir == "" {
+		return "", errSkipped{"no temp folder"}
+	}
+
+	ds, err := newSelfTestDataset()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "jpegls.dcm")
+	if err := ds.SaveWithOptions(path, dcm.SaveOptions{CompressJPEGLS: true, PreferPureGo: true}); err != nil {
+		return "", err
+	}
+
+	decoded, err := dcm.ReadDecoded(path)
+	if errors.Is(err, dcm.ErrDcmtkNotInstalled) {
+		return "", errSkipped{"dcmtk not installed"}
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := compareSelfTestPixels(decoded); err != nil {
+		return "", err
+	}
+	return "lossless with dcmdjpls", nil
+}
+
+// selfTestPixels returns the built-in 8-bit test image: a diagonal
+// gradient on the left half (regular mode) and a flat area with a few
+// steps on the right half (run mode)
+func selfTestPixels() []int {
+	pixels := make([]int, selfTestWidth*selfTestHeight)
+	for y := 0; y < selfTestHeight; y++ {
+		for x := 0; x < selfTestWidth; x++ {
+			value := (x*7 + y*3) % 256
+			if x >= selfTestWidth/2 {
+				value = 40 + (y/12)*50
+			}
+			pixels[y*selfTestWidth+x] = value
+		}
+	}
+	return pixels
+}
+
+// newSelfTestDataset builds a minimal MONOCHROME2 dataset holding the test
+// image
+func newSelfTestDataset() (*dcm.Dataset, error) {
+	pixels := selfTestPixels()
+	data := make([][]int, len(pixels))
+	for i, v := range pixels {
+		data[i] = []int{v}
+	}
+	pixelData := dicom.PixelDataInfo{Frames: []*frame.Frame{{
+		NativeData: frame.NativeFrame{Data: data, Rows: selfTestHeight, Cols: selfTestWidth, BitsPerSample: 8},
+	}}}
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.7"}},
+		{tag.MediaStorageSOPInstanceUID, []string{"2.25.1"}},
+		{tag.PatientID, []string{"SELFTEST"}},
+		{tag.Modality, []string{"OT"}},
+		{tag.SamplesPerPixel, []int{1}},
+		{tag.PhotometricInterpretation, []string{"MONOCHROME2"}},
+		{tag.Rows, []int{selfTestHeight}},
+		{tag.Columns, []int{selfTestWidth}},
+		{tag.BitsAllocated, []int{8}},
+		{tag.BitsStored, []int{8}},
+		{tag.HighBit, []int{7}},
+		{tag.PixelRepresentation, []int{0}},
+		{tag.PixelData, pixelData},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			return nil, fmt.Errorf("could not build test dataset: %w", err)
+		}
+		elems = append(elems, elem)
+	}
+	return &dcm.Dataset{Data: dicom.Dataset{Elements: elems}}, nil
+}
+
+// compareSelfTestPixels checks that ds holds the test image as native
+// pixel data
+func compareSelfTestPixels(ds *dcm.Dataset) error {
+	elem, err := ds.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		return fmt.Errorf("no pixel data: %w", err)
+	}
+	info, ok := elem.Value.GetValue().(dicom.PixelDataInfo)
+	if !ok || len(info.Frames) != 1 || info.Frames[0].Encapsulated {
+		return errors.New("pixel data is not a single native frame")
+	}
+
+	got := info.Frames[0].NativeData.Data
+	want := selfTestPixels()
+	if len(got) != len(want) {
+		return fmt.Errorf("read %d pixels, want %d", len(got), len(want))
+	}
+	for i, sample := range got {
+		if len(sample) != 1 || sample[0] != want[i] {
+			return fmt.Errorf("pixel %d differs: got %v, want %d", i, sample, want[i])
+		}
+	}
+	return nil
+}
+
+// checkJPEGLSMarkers checks that data is SOI, marker segments including
+// SOF55 before the first SOS, entropy-coded data and a final EOI
+func checkJPEGLSMarkers(data []byte) error {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != jpegls.MarkerSOI {
+		return errors.New("codestream does not start with SOI")
+	}
+	if !bytes.HasSuffix(data, []byte{0xFF, jpegls.MarkerEOI}) {
+		return errors.New("codestream does not end with EOI")
+	}
+
+	pos := 2
+	sawFrame := false
+	for {
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return fmt.Errorf("expected a marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+		length := int(data[pos+2])<<8 | int(data[pos+3])
+		if length < 2 || pos+2+length > len(data) {
+			return fmt.Errorf("marker 0x%02X at offset %d has invalid length %d", marker, pos, length)
+		}
+		switch marker {
+		case jpegls.MarkerSOF55:
+			sawFrame = true
+		case jpegls.MarkerSOS:
+			if !sawFrame {
+				return errors.New("SOS before SOF55")
+			}
+			if pos+2+length >= len(data)-2 {
+				return errors.New("no scan data after SOS")
+			}
+			return nil
+		case jpegls.MarkerSOI, jpegls.MarkerEOI:
+			return fmt.Errorf("unexpected marker 0x%02X at offset %d", marker, pos)
+		}
+		pos += 2 + length
+	}
+}
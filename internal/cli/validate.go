@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"dicom-anonymizer/internal/anonymizer"
+	dcm "dicom-anonymizer/internal/dicom"
+)
+
+// ErrFindings is returned by Validate when identifying data was found
+var ErrFindings = errors.New("identifying data found")
+
+// ValidateOptions holds options for auditing anonymized output. The
+// profile, date and tag options should match the run that wrote it.
+type ValidateOptions struct {
+	Folder            string
+	Recursive         bool
+	ProfileFile       string
+	DatePolicy        string
+	RemovePrivateTags bool
+	KeepSex           bool
+	KeepInstitution   bool
+	KeepStudyDesc     bool
+	KeepCalibration   bool
+}
+
+// Validate re-reads the DICOM files in an output folder and prints the
+// tags that still hold identifying data. Nothing is written.
+func Validate(opts ValidateOptions) error {
+	if info, err := os.Stat(opts.Folder); err != nil || !info.IsDir() {
+		return fmt.Errorf("folder does not exist: %s", opts.Folder)
+	}
+
+	datePolicy := anonymizer.DatePolicy(opts.DatePolicy)
+	switch datePolicy {
+	case "", anonymizer.DatePolicyTruncateMonth, anonymizer.DatePolicyShiftDays, anonymizer.DatePolicyRemove:
+	default:
+		return fmt.Errorf("invalid date policy %q (use truncate, shift, or remove)", opts.DatePolicy)
+	}
+
+	var profile *anonymizer.TagProfile
+	if opts.ProfileFile != "" {
+		var err error
+		if profile, err = anonymizer.LoadTagProfile(opts.ProfileFile); err != nil {
+			return err
+		}
+	}
+
+	files, err := dcm.FindDicomFilesWithOptions(opts.Folder, dcm.FindOptions{Recursive: opts.Recursive})
+	if err != nil {
+		return err
+	}
+
+	findings := anonymizer.ValidateFiles(anonymizer.Config{
+		DatePolicy:           datePolicy,
+		Profile:              profile,
+		RemovePrivateTags:    opts.RemovePrivateTags,
+		KeepSex:              opts.KeepSex,
+		KeepInstitutionName:  opts.KeepInstitution,
+		KeepStudyDescription: opts.KeepStudyDesc,
+		PreserveCalibration:  opts.KeepCalibration,
+	}, files)
+
+	fmt.Println()
+	lastPath := ""
+	affected := 0
+	for _, f := range findings {
+		if f.Path != lastPath {
+			rel, err := filepath.Rel(opts.Folder, f.Path)
+			if err != nil {
+				rel = f.Path
+			}
+			fmt.Printf("%s\n", rel)
+			lastPath = f.Path
+			affected++
+		}
+		fmt.Printf("  %s\n", f)
+	}
+	if len(findings) > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("Validated %d file(s): %d finding(s) in %d file(s)\n", len(files), len(findings), affected)
+
+	if len(findings) > 0 {
+		return ErrFindings
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package dicom
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrDcmtkNotInstalled is wrapped by errors from operations that need a
+// dcmtk tool that is not on PATH.
+var ErrDcmtkNotInstalled = errors.New("dcmtk not installed")
+
+// tempDir holds the temporary files of dcmtk decompression and
+// compression ("" = the system temp folder)
+var tempDir string
+
+// SetTempDir sets the folder for the temporary files written when dcmtk
+// decompresses or compresses pixel data, e.g. when the system temp folder
+// is too small for long cine loops. The folder is created if needed and
+// must be writable. "" restores the system temp folder. Call it before
+// processing starts, not while files are being processed.
+func SetTempDir(dir string) error {
+	if dir == "" {
+		tempDir = ""
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create temp folder: %w", err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("temp folder %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	tempDir = dir
+	return nil
+}
+
+// TempDir returns the folder set with SetTempDir, or the system temp
+// folder.
+func TempDir() string {
+	if tempDir != "" {
+		return tempDir
+	}
+	return os.TempDir()
+}
+
+// StaleTempFileAge is the age after which RemoveStaleTempFiles treats a
+// temporary file as left behind by a run that crashed or was killed. No
+// file is processed for that long.
+const StaleTempFileAge = 24 * time.Hour
+
+// tempFilePrefix starts the names of the temporary files of
+// DecompressJPEGLS and writeWithDcmtk. It names this tool, so that
+// RemoveStaleTempFiles never matches other files in a user-supplied temp
+// folder.
+const tempFilePrefix = "dicom-anonymizer-"
+
+// tempFilePattern matches the temporary files of DecompressJPEGLS and
+// writeWithDcmtk (dicom-anonymizer-*.dcm, dicom-anonymizer-uncompressed-*.dcm
+// and dicom-anonymizer-compressed-*.dcm).
+const tempFilePattern = tempFilePrefix + "*.dcm"
+
+// RemoveStaleTempFiles removes this tool's temporary files in TempDir that
+// were last modified more than maxAge ago and returns how many it removed.
+// Files that cannot be removed, e.g. those of another user, are skipped.
+func RemoveStaleTempFiles(maxAge time.Duration) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(TempDir(), tempFilePattern))
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, path := range matches {
+		info, err := os.Lstat(path)
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// DcmtkDownloadURL is where dcmtk can be downloaded when no supported
+// package manager is available.
+const DcmtkDownloadURL = "https://dicom.offis.de/dcmtk.php.en"
+
+// DcmtkInstallCommand returns the platform-specific command installing
+// dcmtk, or "" if no supported package manager is available. On Windows
+// this requires Chocolatey.
+func DcmtkInstallCommand() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "brew install dcmtk"
+	case "linux":
+		return "sudo apt-get update && sudo apt-get install -y dcmtk"
+	case "windows":
+		if _, err := exec.LookPath("choco"); err == nil {
+			return "choco install dcmtk -y"
+		}
+	}
+	return ""
+}
+
+// DcmtkInstallHint returns the install command, or manual install
+// instructions if there is none.
+func DcmtkInstallHint() string {
+	if cmd := DcmtkInstallCommand(); cmd != "" {
+		return cmd
+	}
+	if runtime.GOOS == "windows" {
+		return "Download dcmtk from " + DcmtkDownloadURL + " and add its bin folder to PATH."
+	}
+	return "Install dcmtk using your system package manager."
+}
+
+// DcmtkVersion returns the first line of `dcmdjpls --version`, e.g.
+// "$dcmtk: dcmdjpls v3.6.7 2022-04-22 $".
+func DcmtkVersion() (string, error) {
+	output, err := exec.Command("dcmdjpls", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("dcmdjpls --version failed: %w", err)
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// ShellCommand returns a command running command in the platform shell:
+// cmd /c on Windows, a bash login shell elsewhere.
+func ShellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/c", command)
+	}
+	return exec.Command("bash", "-lc", command)
+}
@@ -0,0 +1,133 @@
+package dicom
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSetTempDir(t *testing.T) {
+	t.Cleanup(func() { SetTempDir("") })
+
+	dir := filepath.Join(t.TempDir(), "scratch", "dcmtk")
+	if err := SetTempDir(dir); err != nil {
+		t.Fatalf("SetTempDir(%q) failed: %v", dir, err)
+	}
+	if got := TempDir(); got != dir {
+		t.Errorf("TempDir() = %q, want %q", got, dir)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("temp folder should be created and left empty, got %v (%v)", entries, err)
+	}
+
+	// A file where the folder should be is rejected and keeps the old setting
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetTempDir(file); err == nil {
+		t.Errorf("SetTempDir(%q) should fail for a file", file)
+	}
+	if got := TempDir(); got != dir {
+		t.Errorf("TempDir() after failed SetTempDir = %q, want %q", got, dir)
+	}
+
+	if err := SetTempDir(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := TempDir(); got != os.TempDir() {
+		t.Errorf("TempDir() after reset = %q, want %q", got, os.TempDir())
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := SetTempDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTempDir("") })
+
+	old := time.Now().Add(-2 * StaleTempFileAge)
+	for name, age := range map[string]time.Time{
+		"dicom-anonymizer-123.dcm":              old,
+		"dicom-anonymizer-uncompressed-456.dcm": old,
+		"dicom-anonymizer-789.dcm":              time.Now(), // Possibly in use
+		"scan.dcm":                              old,        // Not ours
+		"dicom-2024-01-01.dcm":                  old,        // A user's file with a similar name
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, age, age); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := RemoveStaleTempFiles(StaleTempFileAge)
+	if err != nil {
+		t.Fatalf("RemoveStaleTempFiles failed: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d files, want 2", removed)
+	}
+	for name, want := range map[string]bool{
+		"dicom-anonymizer-123.dcm":              false,
+		"dicom-anonymizer-uncompressed-456.dcm": false,
+		"dicom-anonymizer-789.dcm":              true,
+		"scan.dcm":                              true,
+		"dicom-2024-01-01.dcm":                  true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+}
+
+// TestFailingDcmtkLeavesNoTempFiles runs fake dcmtk tools that write part of
+// their output and fail, and checks that no temp file is left behind
+func TestFailingDcmtkLeavesNoTempFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake dcmtk tools are shell scripts")
+	}
+
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho crashed >&2\necho partial > \"$2\"\nexit 1\n"
+	for _, tool := range []string{"dcmdjpls", "dcmcjpls"} {
+		if err := os.WriteFile(filepath.Join(bin, tool), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+
+	dir := t.TempDir()
+	if err := SetTempDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetTempDir("") })
+
+	input := filepath.Join(t.TempDir(), "input.dcm")
+	if err := os.WriteFile(input, []byte("not really JPEG-LS"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecompressJPEGLS(input); err == nil {
+		t.Error("DecompressJPEGLS should fail when dcmdjpls fails")
+	}
+
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+	if err := ds.Write(&bytes.Buffer{}, SaveOptions{CompressJPEGLS: true}); err == nil {
+		t.Error("Write should fail when dcmcjpls fails")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("temp file left behind: %s", entry.Name())
+	}
+}
@@ -3,6 +3,11 @@ package dicom
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/frame"
+	"github.com/suyashkumar/dicom/pkg/tag"
 )
 
 // DICOM encapsulated pixel data tags
@@ -159,3 +164,40 @@ func ExtractFramesFromEncapsulated(data []byte) ([][]byte, error) {
 
 	return frames, nil
 }
+
+// NewEncapsulatedPixelData converts encapsulated pixel data (as produced by
+// EncapsulateFrames) into a PixelData element. The element has undefined
+// length so that dicom.Write emits the offset table and frame items itself.
+func NewEncapsulatedPixelData(data []byte) (*dicom.Element, error) {
+	frames, err := ExtractFramesFromEncapsulated(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames in encapsulated pixel data")
+	}
+
+	pdi := dicom.PixelDataInfo{IsEncapsulated: true}
+	offset := uint32(0)
+	for _, f := range frames {
+		// Single-frame images keep an empty Basic Offset Table
+		if len(frames) > 1 {
+			pdi.Offsets = append(pdi.Offsets, offset)
+		}
+		offset += 8 + uint32(len(f))
+
+		pdi.Frames = append(pdi.Frames, &frame.Frame{
+			Encapsulated:     true,
+			EncapsulatedData: frame.EncapsulatedFrame{Data: f},
+		})
+	}
+
+	elem, err := dicom.NewElement(tag.PixelData, pdi)
+	if err != nil {
+		return nil, err
+	}
+	elem.RawValueRepresentation = "OB"
+	elem.ValueLength = tag.VLUndefinedLength
+
+	return elem, nil
+}
@@ -1,6 +1,8 @@
 package dicom
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -99,25 +101,142 @@ var ExcludedDirs = map[string]bool{
 	".vscode":      true,
 }
 
-// FindDicomFiles finds all DICOM files in the given path.
+// DefaultOutputDirName is the output folder created inside the input folder
+// when no output folder is configured
+const DefaultOutputDirName = "anonymized"
+
+// FindDicomFiles finds all DICOM files in the given path, skipping the
+// default output folder.
 func FindDicomFiles(inputPath string, recursive bool) ([]string, error) {
+	return FindDicomFilesExcluding(inputPath, recursive, filepath.Join(inputPath, DefaultOutputDirName))
+}
+
+// FindDicomFilesExcluding finds all DICOM files in the given path, skipping
+// outputDir. When outputDir lies inside the input tree, any subdirectory
+// with the same name (e.g. output of an earlier run elsewhere in the tree)
+// is skipped too; names merely containing it are ordinary input.
+func FindDicomFilesExcluding(inputPath string, recursive bool, outputDir string) ([]string, error) {
+	return FindDicomFilesWithOptions(inputPath, FindOptions{Recursive: recursive, OutputDir: outputDir})
+}
+
+// FindOptions controls FindDicomFilesWithOptions
+type FindOptions struct {
+	Recursive bool
+	OutputDir string // Skipped, see FindDicomFilesExcluding
+
+	// With Recursive, how many directory levels below the input folder
+	// are searched, e.g. 1 for its direct subfolders only (0 = unlimited)
+	MaxDepth int
+
+	// Walk into symlinked directories, each real directory at most once so
+	// links back up the tree cannot loop. Without it symlinked directories
+	// are skipped; a symlinked input folder is always followed.
+	FollowSymlinks bool
+
+	// OnSkip is called for files that look like DICOM but are truncated or
+	// corrupt (nil = print a warning)
+	OnSkip func(path string, err error)
+
+	// Glob patterns matched against DICOM files' paths relative to the
+	// input folder, see MatchPattern. With IncludePatterns only matching
+	// files are returned; files matching ExcludePatterns are left out.
+	IncludePatterns []string
+	ExcludePatterns []string
+}
+
+// FindDicomFilesWithOptions finds all DICOM files in the given path.
+// Candidates are probed with ProbeDicom; unreadable ones are reported
+// through opts.OnSkip and left out instead of failing later.
+func FindDicomFilesWithOptions(inputPath string, opts FindOptions) ([]string, error) {
+	recursive, outputDir := opts.Recursive, opts.OutputDir
+
+	for _, patterns := range [][]string{opts.IncludePatterns, opts.ExcludePatterns} {
+		if err := ValidatePatterns(patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	onSkip := opts.OnSkip
+	if onSkip == nil {
+		onSkip = func(path string, err error) {
+			fmt.Printf("Warning: Skipping %s: %v\n", path, err)
+		}
+	}
+
+	outputName := ""
+	if outputDir != "" && isWithin(inputPath, outputDir) {
+		outputName = filepath.Base(outputDir)
+	}
+
 	var files []string
 	seenFiles := make(map[string]bool)
 
-	walkFn := func(path string, info os.FileInfo, err error) error {
+	// Real paths of the directories walked while following symlinks
+	visited := make(map[string]bool)
+	firstVisit := func(dir string) bool {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return false
+		}
+		real = absPath(real)
+		if visited[real] {
+			return false
+		}
+		visited[real] = true
+		return true
+	}
+
+	var walkFn filepath.WalkFunc
+
+	// walkLink walks the directory a symlink points to, reporting its
+	// contents under the symlink's path
+	walkLink := func(link string) error {
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return nil
+		}
+		return filepath.Walk(target, func(path string, info os.FileInfo, err error) error {
+			rel, relErr := filepath.Rel(target, path)
+			if relErr != nil {
+				return nil
+			}
+			return walkFn(filepath.Join(link, rel), info, err)
+		})
+	}
+
+	walkFn = func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip files we can't access
 		}
 
+		// filepath.Walk never follows symlinks itself
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil && target.IsDir() {
+				if opts.FollowSymlinks || path == inputPath {
+					return walkLink(path)
+				}
+				return nil
+			}
+		}
+
 		if info.IsDir() {
-			// Skip excluded directories
-			if ExcludedDirs[info.Name()] {
+			// Skip excluded directories and the output folder
+			if ExcludedDirs[info.Name()] || (outputDir != "" && samePath(path, outputDir)) {
+				return filepath.SkipDir
+			}
+			if outputName != "" && info.Name() == outputName && path != inputPath {
 				return filepath.SkipDir
 			}
 			// If not recursive and this is a subdirectory, skip it
 			if !recursive && path != inputPath {
 				return filepath.SkipDir
 			}
+			if opts.MaxDepth > 0 && dirDepth(inputPath, path) > opts.MaxDepth {
+				return filepath.SkipDir
+			}
+			if opts.FollowSymlinks && !firstVisit(path) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -126,11 +245,6 @@ func FindDicomFiles(inputPath string, recursive bool) ([]string, error) {
 			return nil
 		}
 
-		// Skip files in "anonymized" directories
-		if strings.Contains(path, "anonymized") {
-			return nil
-		}
-
 		// Check extension - skip known non-DICOM extensions
 		ext := strings.ToLower(filepath.Ext(path))
 		if ExcludedExtensions[ext] {
@@ -146,10 +260,29 @@ func FindDicomFiles(inputPath string, recursive bool) ([]string, error) {
 			}
 		}
 
-		// If no recognized extension, check DICOM magic bytes first
-		if !isDicom && (ext == "" || !ExcludedExtensions[ext]) {
-			if hasDicomMagicBytes(path) {
-				isDicom = true
+		// Probe the header; files without a DICOM extension need the magic bytes
+		hasMagic, err := ProbeDicom(path)
+		if err != nil {
+			if isDicom || hasMagic {
+				onSkip(path, err)
+			}
+			return nil
+		}
+		if hasMagic {
+			isDicom = true
+		}
+
+		// Include/exclude patterns only apply to real DICOM files
+		if isDicom && (len(opts.IncludePatterns) > 0 || len(opts.ExcludePatterns) > 0) {
+			relPath, err := filepath.Rel(inputPath, path)
+			if err != nil {
+				relPath = info.Name()
+			}
+			if len(opts.IncludePatterns) > 0 && !matchAny(opts.IncludePatterns, relPath) {
+				return nil
+			}
+			if matchAny(opts.ExcludePatterns, relPath) {
+				return nil
 			}
 		}
 
@@ -169,6 +302,83 @@ func FindDicomFiles(inputPath string, recursive bool) ([]string, error) {
 	return files, nil
 }
 
+// dirDepth returns how many levels dir lies below root (0 for root itself)
+func dirDepth(root, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// isWithin reports whether path is dir or lies below it
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(absPath(dir), absPath(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func samePath(a, b string) bool {
+	return absPath(a) == absPath(b)
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// dicomHeaderSize is the 128-byte preamble plus the "DICM" prefix
+const dicomHeaderSize = 132
+
+// ProbeDicom reports whether a file starts with the DICOM preamble and
+// "DICM" magic bytes. Files with the magic bytes get a shallow parse of
+// the file meta information and first data element; an error means the
+// file is too short or corrupt. Files without the preamble are accepted
+// when they start with a file meta element or an implicit VR dataset
+// element. Other files return false and no error.
+func ProbeDicom(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	header := make([]byte, dicomHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !startsWithMetaElement(header[:n]) {
+		return false, fmt.Errorf("file is %d bytes, too short for a DICOM header", n)
+	}
+	if string(header[128:132]) != "DICM" && !startsWithMetaElement(header) && !startsWithImplicitElement(header) {
+		return false, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return true, err
+	}
+	r, size := withPreamble(file, info.Size())
+	parser, err := dicom.NewParser(r, size, nil, dicom.SkipPixelData())
+	if err != nil {
+		return true, fmt.Errorf("unreadable file meta information: %w", err)
+	}
+	if _, err := parser.Next(); err != nil {
+		if errors.Is(err, dicom.ErrorEndOfDICOM) {
+			return true, fmt.Errorf("truncated: no data elements after file meta information")
+		}
+		return true, fmt.Errorf("truncated or corrupt dataset: %w", err)
+	}
+
+	return true, nil
+}
+
 // hasDicomMagicBytes checks if a file has the DICOM magic bytes ("DICM" at offset 128)
 func hasDicomMagicBytes(path string) bool {
 	file, err := os.Open(path)
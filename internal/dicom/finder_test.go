@@ -0,0 +1,311 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// touchFiles creates small valid DICOM files under root
+func touchFiles(t *testing.T, root string, paths ...string) {
+	t.Helper()
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+	for _, p := range paths {
+		if err := ds.Save(filepath.Join(root, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindDicomFilesExcludingOutputOutsideInput(t *testing.T) {
+	root := t.TempDir()
+	input := filepath.Join(root, "input")
+	touchFiles(t, input, "a.dcm", "anonymized_cases/b.dcm")
+
+	files, err := FindDicomFilesExcluding(input, true, filepath.Join(root, "output"))
+	if err != nil {
+		t.Fatalf("FindDicomFilesExcluding failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("found %v, want a.dcm and anonymized_cases/b.dcm", files)
+	}
+}
+
+func TestFindDicomFilesExcludingOutputInsideInput(t *testing.T) {
+	input := t.TempDir()
+	touchFiles(t, input, "a.dcm", "out/ANON-000001/a.dcm")
+
+	files, err := FindDicomFilesExcluding(input, true, filepath.Join(input, "out"))
+	if err != nil {
+		t.Fatalf("FindDicomFilesExcluding failed: %v", err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "a.dcm" || filepath.Dir(files[0]) != input {
+		t.Errorf("found %v, want only a.dcm outside the output folder", files)
+	}
+}
+
+func TestFindDicomFilesMaxDepth(t *testing.T) {
+	input := t.TempDir()
+	touchFiles(t, input, "a.dcm", "p1/b.dcm", "p1/s1/c.dcm", "p1/s1/x/d.dcm")
+
+	for _, tt := range []struct {
+		maxDepth int
+		want     int
+	}{
+		{0, 4},
+		{1, 2},
+		{2, 3},
+	} {
+		files, err := FindDicomFilesWithOptions(input, FindOptions{Recursive: true, MaxDepth: tt.maxDepth})
+		if err != nil {
+			t.Fatalf("FindDicomFilesWithOptions failed: %v", err)
+		}
+		if len(files) != tt.want {
+			t.Errorf("MaxDepth %d found %v, want %d files", tt.maxDepth, files, tt.want)
+		}
+	}
+}
+
+func TestFindDicomFilesSymlinks(t *testing.T) {
+	root := t.TempDir()
+	input := filepath.Join(root, "input")
+	touchFiles(t, input, "a.dcm", "sub/b.dcm")
+	touchFiles(t, root, "outside/c.dcm")
+	for link, target := range map[string]string{
+		filepath.Join(input, "self"):        ".",  // Self-referential
+		filepath.Join(input, "sub", "loop"): "..", // Back up to the input folder
+		filepath.Join(input, "ext"):         filepath.Join(root, "outside"),
+		filepath.Join(root, "link"):         input,
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+
+	for _, tt := range []struct {
+		input  string
+		follow bool
+		want   []string
+	}{
+		{input, false, []string{"a.dcm", "sub/b.dcm"}},
+		{input, true, []string{"a.dcm", "ext/c.dcm", "sub/b.dcm"}},
+		{filepath.Join(root, "link"), false, []string{"a.dcm", "sub/b.dcm"}},
+	} {
+		files, err := FindDicomFilesWithOptions(tt.input, FindOptions{Recursive: true, FollowSymlinks: tt.follow})
+		if err != nil {
+			t.Fatalf("FindDicomFilesWithOptions failed: %v", err)
+		}
+		var got []string
+		for _, f := range files {
+			rel, _ := filepath.Rel(tt.input, f)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s with FollowSymlinks %v found %v, want %v", filepath.Base(tt.input), tt.follow, got, tt.want)
+		}
+	}
+}
+
+func TestFindDicomFilesNameContainingAnonymized(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "preanonymized_source")
+	touchFiles(t, input, "a.dcm", "2023_anonymized_review/b.dcm", "anonymized/ANON-000001/a.dcm")
+
+	files, err := FindDicomFiles(input, true)
+	if err != nil {
+		t.Fatalf("FindDicomFiles failed: %v", err)
+	}
+
+	want := []string{
+		filepath.Join(input, "2023_anonymized_review", "b.dcm"),
+		filepath.Join(input, "a.dcm"),
+	}
+	if len(files) != len(want) {
+		t.Fatalf("found %v, want %v", files, want)
+	}
+	for i := range want {
+		if files[i] != want[i] {
+			t.Errorf("files[%d] = %s, want %s", i, files[i], want[i])
+		}
+	}
+}
+
+func TestProbeDicom(t *testing.T) {
+	dir := t.TempDir()
+	touchFiles(t, dir, "valid.dcm")
+
+	valid, err := os.ReadFile(filepath.Join(dir, "valid.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut off after the preamble and magic bytes
+	if err := os.WriteFile(filepath.Join(dir, "truncated"), valid[:140], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "short.dcm"), []byte("DICM"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes"), make([]byte, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		wantDicom bool
+		wantErr   bool
+	}{
+		{"valid.dcm", true, false},
+		{"truncated", true, true},
+		{"short.dcm", false, true},
+		{"notes", false, false},
+	}
+	for _, tt := range tests {
+		isDicom, err := ProbeDicom(filepath.Join(dir, tt.name))
+		if isDicom != tt.wantDicom || (err != nil) != tt.wantErr {
+			t.Errorf("ProbeDicom(%s) = %v, %v; want %v, error %v", tt.name, isDicom, err, tt.wantDicom, tt.wantErr)
+		}
+	}
+
+	// Corrupt files are reported, not returned
+	var skipped []string
+	files, err := FindDicomFilesWithOptions(dir, FindOptions{
+		OnSkip: func(path string, err error) { skipped = append(skipped, filepath.Base(path)) },
+	})
+	if err != nil {
+		t.Fatalf("FindDicomFilesWithOptions failed: %v", err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "valid.dcm" {
+		t.Errorf("found %v, want only valid.dcm", files)
+	}
+	if len(skipped) != 2 || skipped[0] != "short.dcm" || skipped[1] != "truncated" {
+		t.Errorf("skipped %v, want [short.dcm truncated]", skipped)
+	}
+}
+
+func TestFindDicomFilesPatterns(t *testing.T) {
+	input := t.TempDir()
+	touchFiles(t, input, "p1/US/a.dcm", "p1/CT/b.dcm", "p2/US/deep/c.dcm", "p2/US/scout.dcm")
+
+	files, err := FindDicomFilesWithOptions(input, FindOptions{
+		Recursive:       true,
+		IncludePatterns: []string{"*/US/**"},
+		ExcludePatterns: []string{"scout*"},
+	})
+	if err != nil {
+		t.Fatalf("FindDicomFilesWithOptions failed: %v", err)
+	}
+
+	want := []string{
+		filepath.Join(input, "p1", "US", "a.dcm"),
+		filepath.Join(input, "p2", "US", "deep", "c.dcm"),
+	}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+		t.Errorf("found %v, want %v", files, want)
+	}
+
+	if _, err := FindDicomFilesWithOptions(input, FindOptions{IncludePatterns: []string{"[US"}}); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.dcm", "a/b/c.dcm", true},
+		{"*/US/*", "p1/US/a.dcm", true},
+		{"*/US/*", "p1/US/deep/a.dcm", false},
+		{"**/US/*", "US/a.dcm", true},
+		{"**/US/*", "a/b/US/x.dcm", true},
+		{"p1/**", "p2/a.dcm", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFindDicomFilesWithoutPreamble(t *testing.T) {
+	dir := t.TempDir()
+	touchFiles(t, dir, "valid.dcm")
+
+	valid, err := os.ReadFile(filepath.Join(dir, "valid.dcm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File meta information without the preamble and "DICM" prefix
+	if err := os.WriteFile(filepath.Join(dir, "nopreamble"), valid[dicomHeaderSize:], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bare implicit VR little endian dataset without file meta information
+	var bare []byte
+	for _, e := range []struct {
+		group, element uint16
+		value          string
+	}{
+		{0x0008, 0x0060, "US"},
+		{0x0010, 0x0010, "Doe^John"},
+		{0x0010, 0x0020, "ID12345 "},
+		{0x0020, 0x4000, strings.Repeat("comment ", 16)},
+	} {
+		bare = binary.LittleEndian.AppendUint16(bare, e.group)
+		bare = binary.LittleEndian.AppendUint16(bare, e.element)
+		bare = binary.LittleEndian.AppendUint32(bare, uint32(len(e.value)))
+		bare = append(bare, e.value...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "implicit"), bare, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := FindDicomFilesWithOptions(dir, FindOptions{
+		OnSkip: func(path string, err error) { t.Errorf("skipped %s: %v", path, err) },
+	})
+	if err != nil {
+		t.Fatalf("FindDicomFilesWithOptions failed: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, filepath.Base(f))
+	}
+	if want := []string{"implicit", "nopreamble", "valid.dcm"}; strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("found %v, want %v", names, want)
+	}
+
+	ds, err := ReadDicom(filepath.Join(dir, "nopreamble"))
+	if err != nil {
+		t.Fatalf("ReadDicom(nopreamble) failed: %v", err)
+	}
+	frames, err := ds.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+	if want := []byte{1, 2, 3, 4}; len(frames) != 1 || !bytes.Equal(frames[0], want) {
+		t.Errorf("frames = %v, want [%v]", frames, want)
+	}
+
+	// Saved files get a proper preamble
+	out := filepath.Join(t.TempDir(), "out.dcm")
+	if err := ds.Save(out); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if isDicom, err := ProbeDicom(out); !isDicom || err != nil || !hasDicomMagicBytes(out) {
+		t.Errorf("ProbeDicom(saved) = %v, %v; want a file with magic bytes", isDicom, err)
+	}
+
+	ds, err = ReadDicomMetadataOnly(filepath.Join(dir, "implicit"))
+	if err != nil {
+		t.Fatalf("ReadDicomMetadataOnly(implicit) failed: %v", err)
+	}
+	if got := ds.GetString(tag.PatientName); got != "Doe^John" {
+		t.Errorf("PatientName = %q, want Doe^John", got)
+	}
+}
@@ -0,0 +1,148 @@
+package dicom
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// ReadFrameImage reads a DICOM file and decodes one frame for display.
+// JPEG-LS files are decompressed with dcmtk, RLE files in-process. The
+// returned dataset holds the decoded pixel data.
+func ReadFrameImage(path string, index int) (image.Image, *Dataset, error) {
+	ds, err := ReadDecoded(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, err := ds.FrameImage(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, ds, nil
+}
+
+// ReadDecoded reads a DICOM file with its pixel data decoded to native
+// frames. JPEG-LS files are decompressed with dcmtk, RLE files in-process.
+func ReadDecoded(path string) (*Dataset, error) {
+	readPath := path
+	if IsJPEGLSCompressed(path) {
+		tempPath, err := DecompressJPEGLS(path)
+		if err != nil {
+			return nil, fmt.Errorf("JPEG-LS decompression failed: %w", err)
+		}
+		defer os.Remove(tempPath)
+		readPath = tempPath
+	}
+
+	ds, err := ReadDicom(readPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read DICOM: %w", err)
+	}
+	if strings.Contains(ds.GetTransferSyntax(), RLELossless) {
+		if err := ds.DecompressRLE(); err != nil {
+			return nil, fmt.Errorf("RLE decompression failed: %w", err)
+		}
+	}
+	return ds, nil
+}
+
+// FrameImage converts one frame of native pixel data to an image for
+// display only. Grayscale samples are scaled from their minimum and
+// maximum to 8 bits (MONOCHROME1 is inverted); color samples are shown as
+// RGB using their high 8 bits.
+func (d *Dataset) FrameImage(index int) (image.Image, error) {
+	frames, err := d.extractRawFrames()
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(frames) {
+		return nil, fmt.Errorf("frame %d out of range (%d frames)", index, len(frames))
+	}
+	return d.frameImage(frames[index], index)
+}
+
+// FrameImages converts every frame like FrameImage, decoding the pixel
+// data once.
+func (d *Dataset) FrameImages() ([]image.Image, error) {
+	frames, err := d.extractRawFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]image.Image, len(frames))
+	for i, frame := range frames {
+		if images[i], err = d.frameImage(frame, i); err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// frameImage converts the raw bytes of frame index to an image
+func (d *Dataset) frameImage(frame []byte, index int) (image.Image, error) {
+	width, height, err := d.getImageDimensions()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := d.getSamplesPerPixel()
+	bytesPerSample := (d.getBitsAllocated() + 7) / 8
+	bitsStored := d.getBitsStored()
+	if len(frame) < width*height*samples*bytesPerSample {
+		return nil, fmt.Errorf("frame %d too short: %d bytes", index, len(frame))
+	}
+
+	signed := d.isSigned()
+	mask := 1<<bitsStored - 1
+	sample := func(i int) int {
+		v := int(frame[i*bytesPerSample])
+		if bytesPerSample == 2 {
+			v |= int(frame[i*bytesPerSample+1]) << 8
+		}
+		v &= mask
+		if signed {
+			v = signedValue(v, bitsStored)
+		}
+		return v
+	}
+
+	if samples >= 3 {
+		shift := max(bitsStored-8, 0)
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for p := 0; p < width*height; p++ {
+			img.Set(p%width, p/width, color.RGBA{
+				R: uint8(sample(p*samples) >> shift),
+				G: uint8(sample(p*samples+1) >> shift),
+				B: uint8(sample(p*samples+2) >> shift),
+				A: 0xFF,
+			})
+		}
+		return img, nil
+	}
+
+	lo, hi := sample(0), sample(0)
+	for p := 1; p < width*height; p++ {
+		v := sample(p * samples)
+		lo = min(lo, v)
+		hi = max(hi, v)
+	}
+	invert := strings.TrimSpace(d.GetString(tag.PhotometricInterpretation)) == "MONOCHROME1"
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for p := 0; p < width*height; p++ {
+		v := 0
+		if hi > lo {
+			v = (sample(p*samples) - lo) * 255 / (hi - lo)
+		}
+		if invert {
+			v = 255 - v
+		}
+		img.Pix[p] = uint8(v)
+	}
+	return img, nil
+}
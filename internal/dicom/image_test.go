@@ -0,0 +1,34 @@
+package dicom
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFrameImageGrayscale(t *testing.T) {
+	rows, cols := 2, 3
+	ds := newTestDataset(t, rows, cols, [][]int{
+		{0, 0, 0, 0, 0, 0},
+		{10, 20, 30, 40, 50, 60},
+	})
+
+	img, err := ds.FrameImage(1)
+	if err != nil {
+		t.Fatalf("FrameImage failed: %v", err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("FrameImage returned %T, want *image.Gray", img)
+	}
+	if gray.Bounds() != image.Rect(0, 0, cols, rows) {
+		t.Errorf("bounds = %v, want %dx%d", gray.Bounds(), cols, rows)
+	}
+	// Values are stretched from 10..60 to 0..255
+	if gray.GrayAt(0, 0).Y != 0 || gray.GrayAt(2, 1).Y != 255 || gray.GrayAt(1, 0).Y != 51 {
+		t.Errorf("pixels = %v, want 0 at (0,0), 51 at (1,0), 255 at (2,1)", gray.Pix)
+	}
+
+	if _, err := ds.FrameImage(2); err == nil {
+		t.Error("expected error for out-of-range frame")
+	}
+}
@@ -1,11 +1,13 @@
 package dicom
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
 
 	"dicom-anonymizer/internal/jpegls"
 )
@@ -15,8 +17,14 @@ const (
 	JPEGLSLossless   = "1.2.840.10008.1.2.4.80"
 	JPEGLSNearLossy  = "1.2.840.10008.1.2.4.81"
 
+	// Implicit VR Little Endian (uncompressed, the default transfer syntax)
+	ImplicitVRLittleEndian = "1.2.840.10008.1.2"
+
 	// Explicit VR Little Endian (uncompressed)
 	ExplicitVRLittleEndian = "1.2.840.10008.1.2.1"
+
+	// Explicit VR Big Endian (uncompressed, retired)
+	ExplicitVRBigEndian = "1.2.840.10008.1.2.2"
 )
 
 // IsJPEGLSCompressed checks if a DICOM file uses JPEG-LS compression.
@@ -36,11 +44,11 @@ func DecompressJPEGLS(inputPath string) (string, error) {
 	// Check if dcmdjpls is available
 	_, err := exec.LookPath("dcmdjpls")
 	if err != nil {
-		return "", fmt.Errorf("dcmtk not installed. Run: brew install dcmtk (macOS) or apt install dcmtk (Linux)")
+		return "", fmt.Errorf("%w. %s", ErrDcmtkNotInstalled, DcmtkInstallHint())
 	}
 
 	// Create temporary file
-	tempFile, err := os.CreateTemp("", "dicom-*.dcm")
+	tempFile, err := os.CreateTemp(TempDir(), tempFilePrefix+"*.dcm")
 	if err != nil {
 		return "", fmt.Errorf("could not create temp file: %w", err)
 	}
@@ -104,25 +112,100 @@ func CheckDcmtkInstalled() bool {
 //   - pixels: raw pixel data in row-major order
 //   - width, height: image dimensions
 //   - samples: samples per pixel (1 for grayscale, 3 for RGB)
-//   - bitsAllocated: bits allocated per sample (8, 12, or 16)
+//   - bitsAllocated: bits allocated per sample (8 or 16)
+//   - bitsStored: bits used per sample (e.g. 10 or 12), the coded precision
+//   - order: byte order of 16-bit samples
 //
 // Returns the JPEG-LS compressed bitstream.
-func CompressJPEGLS(pixels []byte, width, height, samples, bitsAllocated int) ([]byte, error) {
-	return jpegls.EncodeFromBytes(pixels, width, height, samples, bitsAllocated)
+func CompressJPEGLS(pixels []byte, width, height, samples, bitsAllocated, bitsStored int, order binary.ByteOrder) ([]byte, error) {
+	return jpegls.EncodeFromBytesWithOrder(pixels, width, height, samples, bitsAllocated, bitsStored, order)
 }
 
 // CompressJPEGLSMultiFrame compresses multiple frames using JPEG-LS and returns
-// encapsulated pixel data suitable for DICOM.
-func CompressJPEGLSMultiFrame(frames [][]byte, width, height, samples, bitsAllocated int) ([]byte, error) {
+// encapsulated pixel data suitable for DICOM. Frames are independent, so they
+// are compressed concurrently on up to runtime.NumCPU() goroutines.
+func CompressJPEGLSMultiFrame(frames [][]byte, width, height, samples, bitsAllocated, bitsStored int, order binary.ByteOrder) ([]byte, error) {
+	return CompressJPEGLSMultiFrameWithProgress(frames, width, height, samples, bitsAllocated, bitsStored, order, nil)
+}
+
+// CompressJPEGLSMultiFrameWithProgress is CompressJPEGLSMultiFrame with a
+// progress callback. If progress is non-nil it is called after every encoded
+// row with the rows done and the total rows over all frames; calls are
+// serialized. Returning false stops all frames and the error wraps
+// jpegls.ErrAborted.
+func CompressJPEGLSMultiFrameWithProgress(frames [][]byte, width, height, samples, bitsAllocated, bitsStored int, order binary.ByteOrder, progress func(done, total int) bool) ([]byte, error) {
+	compressedFrames, err := compressJPEGLSFrames(frames, runtime.NumCPU(), width, height, samples, bitsAllocated, bitsStored, order, progress)
+	if err != nil {
+		return nil, err
+	}
+	return EncapsulateFrames(compressedFrames), nil
+}
+
+// compressJPEGLSFrames compresses frames on a pool of at most workers
+// goroutines. Each worker reuses one encoder for its frames and results
+// keep frame order. If several frames fail, the error of the lowest frame
+// index is returned. progress, if non-nil, is reported rows over all frames
+// as described for CompressJPEGLSMultiFrameWithProgress.
+func compressJPEGLSFrames(frames [][]byte, workers, width, height, samples, bitsAllocated, bitsStored int, order binary.ByteOrder, progress func(done, total int) bool) ([][]byte, error) {
 	compressedFrames := make([][]byte, len(frames))
+	errs := make([]error, len(frames))
+
+	if workers > len(frames) {
+		workers = len(frames)
+	}
+	if workers < 1 {
+		workers = 1
+	}
 
-	for i, frame := range frames {
-		compressed, err := CompressJPEGLS(frame, width, height, samples, bitsAllocated)
+	if bitsStored <= 0 || bitsStored > bitsAllocated {
+		bitsStored = bitsAllocated
+	}
+
+	// Rows are counted over all frames so that the callback sees one
+	// monotonic total however the frames are spread over the workers.
+	// Once it returns false every worker aborts at its next row.
+	var opts jpegls.EncoderOptions
+	if progress != nil {
+		var mu sync.Mutex
+		done, total := 0, height*len(frames)
+		aborted := false
+		opts.OnRow = func(row, rows int) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			if aborted {
+				return false
+			}
+			done++
+			if !progress(done, total) {
+				aborted = true
+				return false
+			}
+			return true
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			enc := jpegls.NewEncoderWithOptions(width, height, samples, bitsStored, opts)
+			for i := range jobs {
+				compressedFrames[i], errs[i] = enc.EncodeBytes(frames[i], bitsAllocated, order)
+			}
+		}()
+	}
+	for i := range frames {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
 		if err != nil {
 			return nil, fmt.Errorf("failed to compress frame %d: %w", i, err)
 		}
-		compressedFrames[i] = compressed
 	}
-
-	return EncapsulateFrames(compressedFrames), nil
+	return compressedFrames, nil
 }
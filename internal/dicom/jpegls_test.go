@@ -0,0 +1,94 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+
+	"dicom-anonymizer/internal/jpegls"
+)
+
+// syntheticFrames returns n distinct 8-bit grayscale frames.
+func syntheticFrames(n, width, height int) [][]byte {
+	frames := make([][]byte, n)
+	for f := range frames {
+		frame := make([]byte, width*height)
+		for i := range frame {
+			frame[i] = byte((i*7 + f*13) % 251)
+		}
+		frames[f] = frame
+	}
+	return frames
+}
+
+func TestCompressJPEGLSFramesOrder(t *testing.T) {
+	frames := syntheticFrames(9, 32, 16)
+
+	sequential, err := compressJPEGLSFrames(frames, 1, 32, 16, 1, 8, 8, binary.LittleEndian, nil)
+	if err != nil {
+		t.Fatalf("sequential compression failed: %v", err)
+	}
+	parallel, err := compressJPEGLSFrames(frames, 4, 32, 16, 1, 8, 8, binary.LittleEndian, nil)
+	if err != nil {
+		t.Fatalf("parallel compression failed: %v", err)
+	}
+
+	for i := range frames {
+		if !bytes.Equal(sequential[i], parallel[i]) {
+			t.Errorf("frame %d differs between sequential and parallel compression", i)
+		}
+	}
+
+	frames[5] = frames[5][:10]
+	if _, err := compressJPEGLSFrames(frames, 4, 32, 16, 1, 8, 8, binary.LittleEndian, nil); err == nil {
+		t.Error("expected error for truncated frame")
+	}
+}
+
+func TestCompressJPEGLSFramesProgress(t *testing.T) {
+	frames := syntheticFrames(6, 32, 16)
+
+	last, calls := 0, 0
+	_, err := compressJPEGLSFrames(frames, 3, 32, 16, 1, 8, 8, binary.LittleEndian, func(done, total int) bool {
+		calls++
+		if done != last+1 || total != 16*len(frames) {
+			t.Errorf("progress(%d, %d) after %d", done, total, last)
+		}
+		last = done
+		return true
+	})
+	if err != nil {
+		t.Fatalf("compression failed: %v", err)
+	}
+	if calls != 16*len(frames) {
+		t.Errorf("progress called %d times, want %d", calls, 16*len(frames))
+	}
+
+	// Stopping aborts every frame, not only the one being reported
+	calls = 0
+	_, err = compressJPEGLSFrames(frames, 3, 32, 16, 1, 8, 8, binary.LittleEndian, func(done, total int) bool {
+		calls++
+		return done < 20
+	})
+	if !errors.Is(err, jpegls.ErrAborted) {
+		t.Fatalf("err = %v, want ErrAborted", err)
+	}
+	if calls != 20 {
+		t.Errorf("progress called %d times after aborting, want 20", calls)
+	}
+}
+
+func benchmarkCompressFrames(b *testing.B, workers int) {
+	frames := syntheticFrames(64, 256, 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := compressJPEGLSFrames(frames, workers, 256, 256, 1, 8, 8, binary.LittleEndian, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompressFramesSequential(b *testing.B) { benchmarkCompressFrames(b, 1) }
+func BenchmarkCompressFramesParallel(b *testing.B)   { benchmarkCompressFrames(b, runtime.NumCPU()) }
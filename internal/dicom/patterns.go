@@ -0,0 +1,67 @@
+package dicom
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// MatchPattern reports whether relPath, a path relative to the input
+// folder, matches a glob pattern. Patterns use '/' separators and the
+// syntax of path.Match, plus "**" for any number of directories
+// ("**/US/*.dcm"). A pattern without '/' is matched against the file name
+// only, so "*.dcm" matches at any depth.
+func MatchPattern(pattern, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(relPath))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, letting
+// "**" stand for zero or more segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// ValidatePatterns returns an error for the first malformed pattern.
+func ValidatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		for _, segment := range strings.Split(p, "/") {
+			if _, err := path.Match(segment, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchAny reports whether relPath matches any of patterns
+func matchAny(patterns []string, relPath string) bool {
+	for _, p := range patterns {
+		if MatchPattern(p, relPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package dicom
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// privateBlock identifies a block of private elements: (gggg,xx00-xxFF) is
+// reserved by the private creator element (gggg,00xx).
+type privateBlock struct {
+	group uint16
+	block uint16
+}
+
+// isPrivateGroup reports whether a group number is private (odd)
+func isPrivateGroup(group uint16) bool {
+	return group%2 == 1
+}
+
+// RemovePrivateTags drops all elements with odd group numbers except the
+// blocks reserved by one of the keep private creators (matched ignoring
+// surrounding spaces), and returns the number of elements removed.
+// Sequence items are cleaned too; each item reserves its own blocks.
+func (d *Dataset) RemovePrivateTags(keep []string) int {
+	removed := d.removePrivateTags(keep)
+	d.WalkSequences(func(item *Dataset) {
+		removed += item.removePrivateTags(keep)
+	})
+	return removed
+}
+
+// removePrivateTags is RemovePrivateTags for the top-level elements
+func (d *Dataset) removePrivateTags(keep []string) int {
+	kept := d.keptPrivateBlocks(keep)
+
+	elements := d.Data.Elements[:0]
+	removed := 0
+	for _, elem := range d.Data.Elements {
+		if isPrivateGroup(elem.Tag.Group) && !kept[blockOf(elem.Tag)] {
+			removed++
+			continue
+		}
+		elements = append(elements, elem)
+	}
+	d.Data.Elements = elements
+	return removed
+}
+
+// PrivateTags returns the top-level private elements RemovePrivateTags
+// would drop with the same keep list, in file order. Callers check the
+// items of sequences themselves, e.g. to report where a tag was found.
+func (d *Dataset) PrivateTags(keep []string) []tag.Tag {
+	kept := d.keptPrivateBlocks(keep)
+
+	var tags []tag.Tag
+	for _, elem := range d.Data.Elements {
+		if isPrivateGroup(elem.Tag.Group) && !kept[blockOf(elem.Tag)] {
+			tags = append(tags, elem.Tag)
+		}
+	}
+	return tags
+}
+
+// RemovePrivateText drops the elements of the blocks reserved by one of
+// creators whose values are not numbers, including inside sequence items,
+// and returns the number removed. Blocks of acquisition parameters are
+// kept for their numbers; text in them, such as a protocol or coil name,
+// may still have been typed in with patient details.
+func (d *Dataset) RemovePrivateText(creators []string) int {
+	removed := d.removePrivateText(creators)
+	d.WalkSequences(func(item *Dataset) {
+		removed += item.removePrivateText(creators)
+	})
+	return removed
+}
+
+// PrivateText returns the top-level elements RemovePrivateText would drop
+// with the same creators, in file order
+func (d *Dataset) PrivateText(creators []string) []tag.Tag {
+	blocks := d.keptPrivateBlocks(creators)
+
+	var tags []tag.Tag
+	for _, elem := range d.Data.Elements {
+		t := elem.Tag
+		if isPrivateGroup(t.Group) && t.Element > 0x00FF && blocks[blockOf(t)] && !numericValue(elem) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// removePrivateText is RemovePrivateText for the top-level elements
+func (d *Dataset) removePrivateText(creators []string) int {
+	blocks := d.keptPrivateBlocks(creators)
+
+	elements := d.Data.Elements[:0]
+	removed := 0
+	for _, elem := range d.Data.Elements {
+		t := elem.Tag
+		if isPrivateGroup(t.Group) && t.Element > 0x00FF && blocks[blockOf(t)] && !numericValue(elem) {
+			removed++
+			continue
+		}
+		elements = append(elements, elem)
+	}
+	d.Data.Elements = elements
+	return removed
+}
+
+// numericValue reports whether elem holds only numbers, or a sequence
+// whose items are checked on their own. Values read without a known VR
+// count as numbers when they are numbers written as text.
+func numericValue(elem *dicom.Element) bool {
+	if elem.Value == nil {
+		return true
+	}
+	switch v := elem.Value.GetValue().(type) {
+	case []int, []float64, []*dicom.SequenceItemValue:
+		return true
+	case []string:
+		return allNumbers(v)
+	case []byte:
+		return allNumbers([]string{strings.TrimRight(string(v), "\x00 ")})
+	}
+	return false
+}
+
+// allNumbers reports whether every value, including the parts of values
+// still joined by backslashes, is empty or a decimal number
+func allNumbers(values []string) bool {
+	for _, joined := range values {
+		for _, v := range strings.Split(joined, "\\") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// keptPrivateBlocks returns the private blocks reserved by one of the keep
+// private creators
+func (d *Dataset) keptPrivateBlocks(keep []string) map[privateBlock]bool {
+	kept := make(map[privateBlock]bool)
+	for _, elem := range d.Data.Elements {
+		t := elem.Tag
+		if !isPrivateGroup(t.Group) || t.Element < 0x0010 || t.Element > 0x00FF {
+			continue
+		}
+		if creator := privateCreator(elem); creator != "" && containsCreator(keep, creator) {
+			kept[privateBlock{t.Group, t.Element}] = true
+		}
+	}
+	return kept
+}
+
+// blockOf returns the private block an element belongs to. Creator
+// elements (gggg,00xx) belong to their own block.
+func blockOf(t tag.Tag) privateBlock {
+	if t.Element <= 0x00FF {
+		return privateBlock{t.Group, t.Element}
+	}
+	return privateBlock{t.Group, t.Element >> 8}
+}
+
+// privateCreator returns the value of a private creator element
+func privateCreator(elem *dicom.Element) string {
+	if elem.Value == nil {
+		return ""
+	}
+	if v, ok := elem.Value.GetValue().([]string); ok && len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// containsCreator reports whether creator is in list
+func containsCreator(list []string, creator string) bool {
+	for _, c := range list {
+		if strings.TrimSpace(c) == creator {
+			return true
+		}
+	}
+	return false
+}
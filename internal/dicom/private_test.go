@@ -0,0 +1,123 @@
+package dicom
+
+import (
+	"testing"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// privateElement builds an LO element for a tag missing from the dictionary
+func privateElement(t *testing.T, tg tag.Tag, value string) *dicom.Element {
+	t.Helper()
+	v, err := dicom.NewValue([]string{value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dicom.Element{
+		Tag:                    tg,
+		ValueRepresentation:    tag.VRString,
+		RawValueRepresentation: "LO",
+		ValueLength:            uint32(len(value)),
+		Value:                  v,
+	}
+}
+
+func TestRemovePrivateTags(t *testing.T) {
+	name, err := dicom.NewElement(tag.PatientName, []string{"SMITH^JOHN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := &Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{
+		name,
+		// A vendor block holding what amounts to PatientComments
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT "),
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x1001}, "Seen by Dr Jones, lives at 1 Main St"),
+		// An allowlisted acquisition block
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x0010}, "GEMS_ACQU_01"),
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x1023}, "1.5"),
+		// Same group, different block without an allowlisted creator
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x1101}, "orphan"),
+	}}}
+
+	private := ds.PrivateTags([]string{"GEMS_ACQU_01"})
+	if len(private) != 3 || private[0] != (tag.Tag{Group: 0x0009, Element: 0x0010}) || private[2] != (tag.Tag{Group: 0x0019, Element: 0x1101}) {
+		t.Errorf("PrivateTags = %v, want the ACME block and the orphan", private)
+	}
+
+	if removed := ds.RemovePrivateTags([]string{"GEMS_ACQU_01"}); removed != 3 {
+		t.Errorf("removed = %d, want 3", removed)
+	}
+
+	var got []tag.Tag
+	for _, elem := range ds.Data.Elements {
+		got = append(got, elem.Tag)
+	}
+	want := []tag.Tag{tag.PatientName, {Group: 0x0019, Element: 0x0010}, {Group: 0x0019, Element: 0x1023}}
+	if len(got) != len(want) {
+		t.Fatalf("remaining tags = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("remaining tags = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestRemovePrivateTagsInSequences(t *testing.T) {
+	newElement := func(tg tag.Tag, data interface{}) *dicom.Element {
+		t.Helper()
+		elem, err := dicom.NewElement(tg, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return elem
+	}
+	overlay, err := dicom.NewValue([]int{8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ReferencedStudySequence > item > ACME block, a kept block with a
+	// number and a name, an overlay and a nested sequence with another
+	// ACME block
+	nested := newElement(tag.ReferencedSeriesSequence, [][]*dicom.Element{{
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT"),
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x1001}, "SMITH^JOHN"),
+	}})
+	sequence := newElement(tag.ReferencedStudySequence, [][]*dicom.Element{{
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x0010}, "ACME PATIENT"),
+		privateElement(t, tag.Tag{Group: 0x0009, Element: 0x1001}, "Seen by Dr Jones"),
+		nested,
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x0010}, "GEMS_ACQU_01"),
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x1023}, "1.5\\2"),
+		privateElement(t, tag.Tag{Group: 0x0019, Element: 0x1024}, "SMITH HEAD COIL"),
+		{Tag: tag.Tag{Group: 0x6000, Element: 0x0010}, ValueRepresentation: tag.VRUInt16List, RawValueRepresentation: "US", Value: overlay},
+	}})
+	ds := &Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{newElement(tag.PatientName, []string{""}), sequence}}}
+
+	keep := []string{"GEMS_ACQU_01"}
+	if removed := ds.RemovePrivateTags(keep); removed != 4 {
+		t.Errorf("RemovePrivateTags removed %d, want the 4 ACME elements", removed)
+	}
+	if removed := ds.RemovePrivateText(keep); removed != 1 {
+		t.Errorf("RemovePrivateText removed %d, want the coil name", removed)
+	}
+	if removed := ds.RemoveOverlays(); removed != 1 {
+		t.Errorf("RemoveOverlays removed %d, want 1", removed)
+	}
+
+	var left []tag.Tag
+	ds.WalkSequences(func(item *Dataset) {
+		for _, elem := range item.Data.Elements {
+			if elem.Tag.Group%2 == 1 || elem.Tag.Group == 0x6000 {
+				left = append(left, elem.Tag)
+			}
+		}
+	})
+	want := []tag.Tag{{Group: 0x0019, Element: 0x0010}, {Group: 0x0019, Element: 0x1023}}
+	if len(left) != len(want) || left[0] != want[0] || left[1] != want[1] {
+		t.Errorf("private and overlay tags left in items = %v, want %v", left, want)
+	}
+}
@@ -1,21 +1,49 @@
 package dicom
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/internal/logging"
 )
 
 // Dataset wraps a DICOM dataset for easier access
 type Dataset struct {
 	Data     dicom.Dataset
 	FilePath string
+
+	// Receives warnings about edits, e.g. values truncated to the maximum
+	// length of their VR (nil = none)
+	Logger logging.Logger
+
+	// Transfer syntax the dataset was read with (empty = built in memory)
+	sourceTransferSyntax string
 }
 
 // ReadDicom reads a DICOM file and returns the dataset.
 func ReadDicom(path string) (*Dataset, error) {
+	return readFile(path)
+}
+
+// ReadDicomMetadataOnly reads only the metadata (no pixel data).
+func ReadDicomMetadataOnly(path string) (*Dataset, error) {
+	return readFile(path, dicom.SkipPixelData())
+}
+
+// ReadDicomFromReader reads a DICOM dataset of the given size from r,
+// e.g. an object streamed from cloud storage or a pipe.
+func ReadDicomFromReader(r io.Reader, size int64) (*Dataset, error) {
+	return readFrom(r, size)
+}
+
+func readFile(path string, opts ...dicom.ParseOption) (*Dataset, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("could not open file: %w", err)
@@ -27,39 +55,72 @@ func ReadDicom(path string) (*Dataset, error) {
 		return nil, fmt.Errorf("could not stat file: %w", err)
 	}
 
-	ds, err := dicom.Parse(file, info.Size(), nil)
+	ds, err := readFrom(file, info.Size(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	ds.FilePath = path
+	return ds, nil
+}
+
+func readFrom(r io.Reader, size int64, opts ...dicom.ParseOption) (*Dataset, error) {
+	r, size = withPreamble(r, size)
+	ds, err := dicom.Parse(r, size, nil, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse DICOM: %w", err)
 	}
 
-	return &Dataset{
-		Data:     ds,
-		FilePath: path,
-	}, nil
+	d := &Dataset{Data: ds}
+	// Datasets without file meta information are parsed as implicit VR
+	d.sourceTransferSyntax = d.GetTransferSyntax()
+	if d.sourceTransferSyntax == "" {
+		d.sourceTransferSyntax = ImplicitVRLittleEndian
+	}
+	return d, nil
 }
 
-// ReadDicomMetadataOnly reads only the metadata (no pixel data).
-func ReadDicomMetadataOnly(path string) (*Dataset, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("could not open file: %w", err)
+// withPreamble prepares a stream for the parser. Some older devices write
+// files without the 128-byte preamble and "DICM" prefix, starting directly
+// with the explicit VR file meta information; those get a synthesized
+// preamble. Other streams are returned as they are, the parser reads bare
+// implicit VR datasets on its own.
+func withPreamble(r io.Reader, size int64) (io.Reader, int64) {
+	br := bufio.NewReaderSize(r, dicomHeaderSize)
+	head, _ := br.Peek(dicomHeaderSize)
+	if !startsWithMetaElement(head) {
+		return br, size
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("could not stat file: %w", err)
+	preamble := make([]byte, dicomHeaderSize)
+	copy(preamble[128:], "DICM")
+	return io.MultiReader(bytes.NewReader(preamble), br), size + dicomHeaderSize
+}
+
+// startsWithMetaElement reports whether head begins with an explicit VR
+// (0002,xxxx) file meta element instead of the preamble
+func startsWithMetaElement(head []byte) bool {
+	if len(head) < 8 || binary.LittleEndian.Uint16(head[0:2]) != 0x0002 {
+		return false
 	}
+	return isVRByte(head[4]) && isVRByte(head[5])
+}
 
-	ds, err := dicom.Parse(file, info.Size(), nil, dicom.SkipPixelData())
-	if err != nil {
-		return nil, fmt.Errorf("could not parse DICOM: %w", err)
+// startsWithImplicitElement reports whether head begins with an implicit VR
+// little endian element of the identifying group (0008,xxxx), the usual
+// start of a bare dataset without file meta information
+func startsWithImplicitElement(head []byte) bool {
+	if len(head) < 8 || binary.LittleEndian.Uint16(head[0:2]) != 0x0008 {
+		return false
 	}
+	if isVRByte(head[4]) && isVRByte(head[5]) {
+		return false // explicit VR, which the parser can't detect without meta information
+	}
+	length := binary.LittleEndian.Uint32(head[4:8])
+	return length%2 == 0 && length < 1<<16
+}
 
-	return &Dataset{
-		Data:     ds,
-		FilePath: path,
-	}, nil
+func isVRByte(b byte) bool {
+	return b >= 'A' && b <= 'Z'
 }
 
 // GetString returns a string value for a tag, or empty string if not found.
@@ -120,3 +181,15 @@ func (d *Dataset) IsUltrasound() bool {
 	modality := d.GetModality()
 	return modality == "US" || modality == "IVUS" // Intravascular ultrasound
 }
+
+// FrameSize returns the bytes of one uncompressed frame from Rows, Columns,
+// SamplesPerPixel and BitsAllocated, or 0 without image dimensions. It
+// works on datasets read without pixel data.
+func (d *Dataset) FrameSize() int64 {
+	width, height, err := d.getImageDimensions()
+	if err != nil {
+		return 0
+	}
+	bytesPerSample := (d.getBitsAllocated() + 7) / 8
+	return int64(width) * int64(height) * int64(d.getSamplesPerPixel()) * int64(bytesPerSample)
+}
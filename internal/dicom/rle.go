@@ -0,0 +1,258 @@
+package dicom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// RLELossless is the RLE Lossless Transfer Syntax UID
+const RLELossless = "1.2.840.10008.1.2.5"
+
+// RLE frame header: segment count followed by 15 segment offsets (PS3.5 G.5)
+const (
+	rleHeaderSize  = 64
+	rleMaxSegments = 15
+)
+
+// IsRLECompressed checks if a DICOM file uses RLE Lossless compression.
+func IsRLECompressed(path string) bool {
+	ds, err := ReadDicomMetadataOnly(path)
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(ds.GetTransferSyntax(), RLELossless)
+}
+
+// DecodeRLE decodes one RLE Lossless frame (PS3.5 Annex G) into native
+// little-endian pixel data with samples interleaved per pixel.
+func DecodeRLE(frame []byte, width, height, samples, bitsAllocated int) ([]byte, error) {
+	bytesPerSample := (bitsAllocated + 7) / 8
+	numSegments := samples * bytesPerSample
+	pixelCount := width * height
+
+	if len(frame) < rleHeaderSize {
+		return nil, fmt.Errorf("RLE frame too short for header: %d bytes", len(frame))
+	}
+	if got := int(binary.LittleEndian.Uint32(frame[0:4])); got != numSegments {
+		return nil, fmt.Errorf("RLE frame has %d segments, expected %d", got, numSegments)
+	}
+	if numSegments > rleMaxSegments {
+		return nil, fmt.Errorf("too many RLE segments: %d", numSegments)
+	}
+
+	offsets := make([]int, numSegments+1)
+	for i := 0; i < numSegments; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(frame[4+4*i:]))
+	}
+	offsets[numSegments] = len(frame)
+
+	out := make([]byte, pixelCount*numSegments)
+	plane := make([]byte, pixelCount)
+
+	for seg := 0; seg < numSegments; seg++ {
+		start, end := offsets[seg], offsets[seg+1]
+		if start < rleHeaderSize || start > end || end > len(frame) {
+			return nil, fmt.Errorf("invalid RLE segment %d offsets: %d-%d", seg, start, end)
+		}
+		if err := unpackBits(frame[start:end], plane); err != nil {
+			return nil, fmt.Errorf("RLE segment %d: %w", seg, err)
+		}
+
+		// Segments are ordered by sample, most significant byte first
+		sample := seg / bytesPerSample
+		byteIndex := bytesPerSample - 1 - seg%bytesPerSample
+		for i, b := range plane {
+			out[(i*samples+sample)*bytesPerSample+byteIndex] = b
+		}
+	}
+
+	return out, nil
+}
+
+// EncodeRLE encodes native little-endian pixel data (samples interleaved
+// per pixel) into one RLE Lossless frame (PS3.5 Annex G).
+func EncodeRLE(pixels []byte, width, height, samples, bitsAllocated int) ([]byte, error) {
+	bytesPerSample := (bitsAllocated + 7) / 8
+	numSegments := samples * bytesPerSample
+	pixelCount := width * height
+
+	if numSegments > rleMaxSegments {
+		return nil, fmt.Errorf("too many RLE segments: %d", numSegments)
+	}
+	if len(pixels) < pixelCount*numSegments {
+		return nil, fmt.Errorf("pixel data too short: expected %d bytes, got %d",
+			pixelCount*numSegments, len(pixels))
+	}
+
+	out := make([]byte, rleHeaderSize, rleHeaderSize+len(pixels))
+	binary.LittleEndian.PutUint32(out[0:4], uint32(numSegments))
+
+	plane := make([]byte, pixelCount)
+	for seg := 0; seg < numSegments; seg++ {
+		sample := seg / bytesPerSample
+		byteIndex := bytesPerSample - 1 - seg%bytesPerSample
+		for i := range plane {
+			plane[i] = pixels[(i*samples+sample)*bytesPerSample+byteIndex]
+		}
+
+		binary.LittleEndian.PutUint32(out[4+4*seg:], uint32(len(out)))
+		out = packBits(out, plane)
+
+		// Each segment is padded to an even length
+		if len(out)%2 != 0 {
+			out = append(out, 0)
+		}
+	}
+
+	return out, nil
+}
+
+// CompressRLEMultiFrame compresses multiple frames using RLE and returns
+// encapsulated pixel data suitable for DICOM.
+func CompressRLEMultiFrame(frames [][]byte, width, height, samples, bitsAllocated int) ([]byte, error) {
+	compressedFrames := make([][]byte, len(frames))
+
+	for i, frame := range frames {
+		compressed, err := EncodeRLE(frame, width, height, samples, bitsAllocated)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress frame %d: %w", i, err)
+		}
+		compressedFrames[i] = compressed
+	}
+
+	return EncapsulateFrames(compressedFrames), nil
+}
+
+// DecompressRLE replaces RLE encapsulated pixel data with native pixel data
+// and switches the dataset to Explicit VR Little Endian.
+func (d *Dataset) DecompressRLE() error {
+	pixelElem, err := d.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		return fmt.Errorf("no pixel data found: %w", err)
+	}
+	pdi, ok := pixelElem.Value.GetValue().(dicom.PixelDataInfo)
+	if !ok || !pdi.IsEncapsulated {
+		return fmt.Errorf("pixel data is not encapsulated")
+	}
+
+	width, height, err := d.getImageDimensions()
+	if err != nil {
+		return err
+	}
+	samples := d.getSamplesPerPixel()
+	bitsAllocated := d.getBitsAllocated()
+
+	var native []byte
+	for i, fr := range pdi.Frames {
+		decoded, err := DecodeRLE(fr.EncapsulatedData.Data, width, height, samples, bitsAllocated)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i, err)
+		}
+		native = append(native, decoded...)
+	}
+
+	vr := "OB"
+	if bitsAllocated > 8 {
+		vr = "OW"
+	}
+	if err := d.replaceElement(tag.PixelData, native, vr); err != nil {
+		return err
+	}
+	if err := d.replaceElement(tag.TransferSyntaxUID, []string{ExplicitVRLittleEndian}, "UI"); err != nil {
+		return err
+	}
+
+	// Decoded samples are interleaved per pixel
+	if _, err := d.Data.FindElementByTag(tag.PlanarConfiguration); err == nil {
+		return d.replaceElement(tag.PlanarConfiguration, []int{0}, "US")
+	}
+	return nil
+}
+
+// replaceElement replaces the value of an existing element.
+func (d *Dataset) replaceElement(t tag.Tag, data interface{}, vr string) error {
+	newElem, err := dicom.NewElement(t, data)
+	if err != nil {
+		return fmt.Errorf("could not create %v: %w", t, err)
+	}
+	newElem.RawValueRepresentation = vr
+
+	for i, e := range d.Data.Elements {
+		if e.Tag == t {
+			d.Data.Elements[i] = newElem
+			return nil
+		}
+	}
+	return fmt.Errorf("element %v not found", t)
+}
+
+// unpackBits decodes a PackBits segment into out, which must be filled exactly.
+func unpackBits(src, out []byte) error {
+	pos := 0
+	for i := 0; i < len(src) && pos < len(out); {
+		n := int(int8(src[i]))
+		i++
+
+		switch {
+		case n >= 0:
+			// Literal run of n+1 bytes
+			count := n + 1
+			if i+count > len(src) || pos+count > len(out) {
+				return fmt.Errorf("literal run overflows segment")
+			}
+			copy(out[pos:], src[i:i+count])
+			i += count
+			pos += count
+		case n > -128:
+			// Replicate next byte -n+1 times
+			count := -n + 1
+			if i >= len(src) || pos+count > len(out) {
+				return fmt.Errorf("replicate run overflows segment")
+			}
+			for j := 0; j < count; j++ {
+				out[pos+j] = src[i]
+			}
+			i++
+			pos += count
+		}
+		// n == -128 is a no-op
+	}
+
+	if pos != len(out) {
+		return fmt.Errorf("segment decoded to %d bytes, expected %d", pos, len(out))
+	}
+	return nil
+}
+
+// packBits appends the PackBits encoding of src to dst.
+func packBits(dst, src []byte) []byte {
+	for i := 0; i < len(src); {
+		// Replicate run of at least 2 identical bytes
+		run := 1
+		for i+run < len(src) && run < 128 && src[i+run] == src[i] {
+			run++
+		}
+		if run >= 2 {
+			dst = append(dst, byte(int8(1-run)), src[i])
+			i += run
+			continue
+		}
+
+		// Literal run until the next pair of identical bytes
+		start := i
+		for i < len(src) && i-start < 128 {
+			if i+1 < len(src) && src[i] == src[i+1] {
+				break
+			}
+			i++
+		}
+		dst = append(dst, byte(i-start-1))
+		dst = append(dst, src[start:i]...)
+	}
+	return dst
+}
@@ -0,0 +1,119 @@
+package dicom
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func TestPackBitsKnownVector(t *testing.T) {
+	// Example from the PackBits description: runs and literals mixed
+	src := []byte{0xAA, 0xAA, 0xAA, 0x80, 0x00, 0x2A, 0xAA, 0xAA, 0xAA, 0xAA}
+	encoded := packBits(nil, src)
+	want := []byte{0xFE, 0xAA, 0x02, 0x80, 0x00, 0x2A, 0xFD, 0xAA}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("packBits = % X, want % X", encoded, want)
+	}
+
+	decoded := make([]byte, len(src))
+	if err := unpackBits(encoded, decoded); err != nil {
+		t.Fatalf("unpackBits failed: %v", err)
+	}
+	if !bytes.Equal(decoded, src) {
+		t.Errorf("unpackBits = % X, want % X", decoded, src)
+	}
+}
+
+func TestRLERoundTrip(t *testing.T) {
+	width, height := 17, 5
+
+	tests := []struct {
+		name          string
+		samples       int
+		bitsAllocated int
+	}{
+		{"gray8", 1, 8},
+		{"gray16", 1, 16},
+		{"rgb8", 3, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := width * height * tt.samples * tt.bitsAllocated / 8
+			pixels := make([]byte, size)
+			for i := range pixels {
+				// Mix of flat areas and noise
+				if (i/7)%2 == 0 {
+					pixels[i] = 0x40
+				} else {
+					pixels[i] = byte(i * 31)
+				}
+			}
+
+			encoded, err := EncodeRLE(pixels, width, height, tt.samples, tt.bitsAllocated)
+			if err != nil {
+				t.Fatalf("EncodeRLE failed: %v", err)
+			}
+			if len(encoded)%2 != 0 {
+				t.Errorf("encoded length %d is odd", len(encoded))
+			}
+
+			decoded, err := DecodeRLE(encoded, width, height, tt.samples, tt.bitsAllocated)
+			if err != nil {
+				t.Fatalf("DecodeRLE failed: %v", err)
+			}
+			if !bytes.Equal(decoded, pixels) {
+				t.Errorf("round trip mismatch")
+			}
+		})
+	}
+}
+
+func TestSaveWithRLE(t *testing.T) {
+	rows, cols := 4, 6
+	frames := [][]int{make([]int, rows*cols), make([]int, rows*cols)}
+	for f := range frames {
+		for i := range frames[f] {
+			frames[f][i] = (i / 3) * (f + 1)
+		}
+	}
+	ds := newTestDataset(t, rows, cols, frames)
+
+	want, err := ds.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "rle.dcm")
+	if err := ds.SaveWithOptions(outputPath, SaveOptions{CompressRLE: true}); err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+	if !IsRLECompressed(outputPath) {
+		t.Fatalf("output is not RLE compressed")
+	}
+
+	read, err := ReadDicom(outputPath)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	if err := read.DecompressRLE(); err != nil {
+		t.Fatalf("DecompressRLE failed: %v", err)
+	}
+	if ts := read.GetTransferSyntax(); ts != ExplicitVRLittleEndian {
+		t.Errorf("transfer syntax = %q, want %q", ts, ExplicitVRLittleEndian)
+	}
+
+	elem, err := read.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		t.Fatalf("no pixel data: %v", err)
+	}
+	got, ok := elem.Value.GetValue().([]byte)
+	if !ok {
+		t.Fatalf("pixel data type = %T, want []byte", elem.Value.GetValue())
+	}
+	if !bytes.Equal(got, append(want[0], want[1]...)) {
+		t.Errorf("decoded pixel data mismatch")
+	}
+}
@@ -1,16 +1,82 @@
 package dicom
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/suyashkumar/dicom"
 	"github.com/suyashkumar/dicom/pkg/tag"
+	"github.com/suyashkumar/dicom/pkg/uid"
 )
 
-// SetString sets a string value for a tag in the dataset.
+// vrMaxLength is the maximum length in bytes of a value of the string VRs
+// that have one (PS3.5 Table 6.2-1). PN allows 64 per component group;
+// the whole name is held to 64.
+var vrMaxLength = map[string]int{
+	"AE": 16, "AS": 4, "CS": 16, "DA": 8, "DS": 16, "DT": 26, "IS": 12,
+	"LO": 64, "LT": 10240, "PN": 64, "SH": 16, "ST": 1024, "TM": 14, "UI": 64,
+}
+
+// clampToVR truncates each of values to the maximum length of vr, never
+// splitting a UTF-8 character, and reports whether any was truncated. The
+// limit applies per value, so values is never joined first. Values of VRs
+// without a limit are returned as is.
+func clampToVR(vr string, values []string) ([]string, bool) {
+	max, ok := vrMaxLength[vr]
+	if !ok {
+		return values, false
+	}
+	clamped, truncated := values, false
+	for i, value := range values {
+		if len(value) <= max {
+			continue
+		}
+		n := max
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+		if !truncated {
+			clamped = append([]string(nil), values...)
+			truncated = true
+		}
+		clamped[i] = value[:n]
+	}
+	return clamped, truncated
+}
+
+// splitValues splits value at the backslashes that separate the values of
+// a multi-valued element. The text VRs hold one value that may contain
+// backslashes, so theirs are kept.
+func splitValues(vr, value string) []string {
+	switch vr {
+	case "LT", "ST", "UT":
+		return []string{value}
+	}
+	return strings.Split(value, "\\")
+}
+
+// warnTruncated reports to d.Logger that a value of t was cut to the
+// maximum length of vr. The value itself may identify the patient and is
+// not logged.
+func (d *Dataset) warnTruncated(t tag.Tag, vr string) {
+	if d.Logger != nil {
+		d.Logger.Warnf("%s: %v value truncated to %d bytes, the maximum of VR %s", d.FilePath, t, vrMaxLength[vr], vr)
+	}
+}
+
+// SetString sets a string value for a tag in the dataset. Backslashes
+// separate the values of a multi-valued element. Each value is truncated
+// to the maximum length of the element's VR, e.g. 16 for an SH
+// AccessionNumber, with a warning, and the length padded to even as the
+// file will store it.
 func (d *Dataset) SetString(t tag.Tag, value string) error {
 	// Find the element
 	elem, err := d.Data.FindElementByTag(t)
@@ -21,9 +87,17 @@ func (d *Dataset) SetString(t tag.Tag, value string) error {
 
 	// Get the VR to determine how to set the value
 	vr := elem.RawValueRepresentation
+	values, truncated := clampToVR(vr, splitValues(vr, value))
+	if truncated {
+		d.warnTruncated(t, vr)
+	}
+	length := len(values) - 1
+	for _, v := range values {
+		length += len(v)
+	}
 
 	// Create new value
-	newValue, err := dicom.NewValue([]string{value})
+	newValue, err := dicom.NewValue(values)
 	if err != nil {
 		return fmt.Errorf("could not create value: %w", err)
 	}
@@ -33,7 +107,7 @@ func (d *Dataset) SetString(t tag.Tag, value string) error {
 		Tag:                    t,
 		ValueRepresentation:    elem.ValueRepresentation,
 		RawValueRepresentation: vr,
-		ValueLength:            uint32(len(value)),
+		ValueLength:            uint32(length + length%2), // Padded on write
 		Value:                  newValue,
 	}
 
@@ -48,9 +122,330 @@ func (d *Dataset) SetString(t tag.Tag, value string) error {
 	return nil
 }
 
-// ClearTag clears a tag value (sets to empty string).
+// ClearTag clears a tag value: strings are set to the empty string and
+// sequences emptied (see ZeroTag).
 func (d *Dataset) ClearTag(t tag.Tag) {
-	d.SetString(t, "")
+	d.ZeroTag(t)
+}
+
+// WalkSequences calls fn for every item of every sequence in the dataset,
+// including items of nested sequences. fn may change the item's elements;
+// each sequence is rebuilt from its items afterwards.
+func (d *Dataset) WalkSequences(fn func(item *Dataset)) {
+	for _, elem := range d.Data.Elements {
+		if elem.ValueRepresentation != tag.VRSequence || elem.Value == nil {
+			continue
+		}
+		items, ok := elem.Value.GetValue().([]*dicom.SequenceItemValue)
+		if !ok || len(items) == 0 {
+			continue
+		}
+
+		rebuilt := make([][]*dicom.Element, 0, len(items))
+		for _, item := range items {
+			elements, _ := item.GetValue().([]*dicom.Element)
+			itemDS := &Dataset{Data: dicom.Dataset{Elements: elements}, FilePath: d.FilePath, Logger: d.Logger}
+			fn(itemDS)
+			itemDS.WalkSequences(fn)
+			rebuilt = append(rebuilt, itemDS.Data.Elements)
+		}
+		if value, err := dicom.NewValue(rebuilt); err == nil {
+			elem.Value = value
+		}
+	}
+}
+
+// PutString sets a string value, adding the element in tag order if the
+// dataset does not have it yet.
+func (d *Dataset) PutString(t tag.Tag, values ...string) error {
+	elem, err := dicom.NewElement(t, values)
+	if err != nil {
+		return fmt.Errorf("could not create %v: %w", t, err)
+	}
+	d.putElement(elem)
+	return nil
+}
+
+// putElement replaces the element with elem's tag, or adds elem in tag
+// order.
+func (d *Dataset) putElement(elem *dicom.Element) {
+	t := elem.Tag
+	for i, e := range d.Data.Elements {
+		if e.Tag == t {
+			d.Data.Elements[i] = elem
+			return
+		}
+		if e.Tag.Group > t.Group || (e.Tag.Group == t.Group && e.Tag.Element > t.Element) {
+			d.Data.Elements = append(d.Data.Elements[:i], append([]*dicom.Element{elem}, d.Data.Elements[i:]...)...)
+			return
+		}
+	}
+	d.Data.Elements = append(d.Data.Elements, elem)
+}
+
+// CodeItem is one item of a code sequence, e.g. {"113100", "DCM", "Basic
+// Application Confidentiality Profile"}
+type CodeItem struct {
+	Value   string // CodeValue
+	Scheme  string // CodingSchemeDesignator
+	Meaning string // CodeMeaning
+}
+
+// WriteDeidentificationMethod records how the dataset was de-identified:
+// methods as the values of DeidentificationMethod, and codes as the items
+// of DeidentificationMethodCodeSequence. Without codes the sequence is
+// removed, so none left by an earlier tool describes the file wrongly.
+func (d *Dataset) WriteDeidentificationMethod(methods []string, codes []CodeItem) error {
+	if err := d.PutString(tag.DeidentificationMethod, methods...); err != nil {
+		return err
+	}
+	if len(codes) == 0 {
+		d.RemoveTag(tag.DeidentificationMethodCodeSequence)
+		return nil
+	}
+
+	items := make([][]*dicom.Element, 0, len(codes))
+	for _, code := range codes {
+		var item []*dicom.Element
+		for _, e := range []struct {
+			t     tag.Tag
+			value string
+		}{
+			{tag.CodeValue, code.Value},
+			{tag.CodingSchemeDesignator, code.Scheme},
+			{tag.CodeMeaning, code.Meaning},
+		} {
+			elem, err := dicom.NewElement(e.t, []string{e.value})
+			if err != nil {
+				return fmt.Errorf("could not create %v: %w", e.t, err)
+			}
+			item = append(item, elem)
+		}
+		items = append(items, item)
+	}
+	seq, err := dicom.NewElement(tag.DeidentificationMethodCodeSequence, items)
+	if err != nil {
+		return fmt.Errorf("could not create DeidentificationMethodCodeSequence: %w", err)
+	}
+	d.putElement(seq)
+	return nil
+}
+
+// RemoveTag deletes an element and reports whether it was present.
+func (d *Dataset) RemoveTag(t tag.Tag) bool {
+	for i, e := range d.Data.Elements {
+		if e.Tag == t {
+			d.Data.Elements = append(d.Data.Elements[:i], d.Data.Elements[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ZeroTag replaces a value with a zero-length one: strings are cleared and
+// sequences emptied. Binary and numeric values are removed instead.
+func (d *Dataset) ZeroTag(t tag.Tag) {
+	elem, err := d.Data.FindElementByTag(t)
+	if err != nil {
+		return
+	}
+	switch {
+	case elem.ValueRepresentation == tag.VRSequence:
+		empty, _ := dicom.NewValue([][]*dicom.Element{})
+		elem.Value = empty
+		elem.ValueLength = 0
+	case isStringValue(elem):
+		d.SetString(t, "")
+	default:
+		d.RemoveTag(t)
+	}
+}
+
+// ClearSequenceExcept empties the items of sequence t except for elements
+// with a tag in keep, found at any depth; items left without any are
+// dropped. It returns false, changing nothing, if t is not a sequence or
+// holds none of keep, so the caller can clear it as usual.
+func (d *Dataset) ClearSequenceExcept(t tag.Tag, keep []tag.Tag) bool {
+	elem, err := d.Data.FindElementByTag(t)
+	if err != nil || elem.ValueRepresentation != tag.VRSequence || elem.Value == nil {
+		return false
+	}
+	items := keptItems(elem, keep)
+	if len(items) == 0 {
+		return false
+	}
+	value, err := dicom.NewValue(items)
+	if err != nil {
+		return false
+	}
+	elem.Value = value
+	return true
+}
+
+// keptItems returns the items of a sequence reduced to the elements in
+// keep, without the items that hold none
+func keptItems(seq *dicom.Element, keep []tag.Tag) [][]*dicom.Element {
+	items, _ := seq.Value.GetValue().([]*dicom.SequenceItemValue)
+	var kept [][]*dicom.Element
+	for _, item := range items {
+		elements, _ := item.GetValue().([]*dicom.Element)
+		if reduced := keptElements(elements, keep); len(reduced) > 0 {
+			kept = append(kept, reduced)
+		}
+	}
+	return kept
+}
+
+// keptElements returns the elements in keep, and the nested sequences
+// that hold any of them reduced the same way
+func keptElements(elements []*dicom.Element, keep []tag.Tag) []*dicom.Element {
+	var kept []*dicom.Element
+	for _, elem := range elements {
+		if tagIn(keep, elem.Tag) {
+			kept = append(kept, elem)
+			continue
+		}
+		if elem.ValueRepresentation != tag.VRSequence || elem.Value == nil {
+			continue
+		}
+		if items := keptItems(elem, keep); len(items) > 0 {
+			if value, err := dicom.NewValue(items); err == nil {
+				elem.Value = value
+				kept = append(kept, elem)
+			}
+		}
+	}
+	return kept
+}
+
+func tagIn(tags []tag.Tag, t tag.Tag) bool {
+	for _, other := range tags {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}
+
+// dummyValues are the non-identifying replacements used by DummyTag, by VR
+var dummyValues = map[string]string{
+	"AE": "ANONYMOUS",
+	"AS": "000Y",
+	"CS": "ANONYMOUS",
+	"DA": "19000101",
+	"DS": "0",
+	"DT": "19000101000000",
+	"IS": "0",
+	"LO": "ANONYMOUS",
+	"LT": "ANONYMOUS",
+	"PN": "ANONYMOUS",
+	"SH": "ANONYMOUS",
+	"ST": "ANONYMOUS",
+	"TM": "000000",
+	"UC": "ANONYMOUS",
+	"UT": "ANONYMOUS",
+}
+
+// DummyTag replaces a value with a non-identifying dummy value of the same
+// VR, e.g. "ANONYMOUS" for names and 19000101 for dates. Sequences are
+// emptied and values without a dummy form are zeroed.
+func (d *Dataset) DummyTag(t tag.Tag) {
+	elem, err := d.Data.FindElementByTag(t)
+	if err != nil {
+		return
+	}
+	if dummy, ok := dummyValues[elem.RawValueRepresentation]; ok && isStringValue(elem) {
+		d.SetString(t, dummy)
+		return
+	}
+	d.ZeroTag(t)
+}
+
+// isStringValue reports whether an element holds string values
+func isStringValue(elem *dicom.Element) bool {
+	if elem.Value == nil {
+		return false
+	}
+	_, ok := elem.Value.GetValue().([]string)
+	return ok
+}
+
+// MapStrings replaces each value of the top-level string elements with
+// one of vrs by fn(value), clamped to the VR's maximum length with a
+// warning, and returns the number of elements changed. Multi-valued elements keep their
+// values separate.
+func (d *Dataset) MapStrings(vrs []string, fn func(string) string) int {
+	changed := 0
+	for i, elem := range d.Data.Elements {
+		if !containsString(vrs, elem.RawValueRepresentation) || !isStringValue(elem) {
+			continue
+		}
+		values := elem.Value.GetValue().([]string)
+		mapped := make([]string, len(values))
+		for j, value := range values {
+			mapped[j] = fn(value)
+		}
+		mapped, truncated := clampToVR(elem.RawValueRepresentation, mapped)
+		if truncated {
+			d.warnTruncated(elem.Tag, elem.RawValueRepresentation)
+		}
+		length, differs := len(values)-1, false
+		for j, value := range values {
+			length += len(mapped[j])
+			differs = differs || mapped[j] != value
+		}
+		if !differs {
+			continue
+		}
+		newValue, err := dicom.NewValue(mapped)
+		if err != nil {
+			continue
+		}
+		d.Data.Elements[i] = &dicom.Element{
+			Tag:                    elem.Tag,
+			ValueRepresentation:    elem.ValueRepresentation,
+			RawValueRepresentation: elem.RawValueRepresentation,
+			ValueLength:            uint32(length + length%2), // Padded on write
+			Value:                  newValue,
+		}
+		changed++
+	}
+	return changed
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveOverlays deletes all overlay plane elements (groups 6000-601E),
+// which can carry burned-in annotations that survive pixel redaction, and
+// returns the number of elements removed. Sequence items are cleaned too.
+func (d *Dataset) RemoveOverlays() int {
+	removed := d.removeOverlays()
+	d.WalkSequences(func(item *Dataset) {
+		removed += item.removeOverlays()
+	})
+	return removed
+}
+
+// removeOverlays is RemoveOverlays for the top-level elements
+func (d *Dataset) removeOverlays() int {
+	elements := d.Data.Elements[:0]
+	removed := 0
+	for _, elem := range d.Data.Elements {
+		if g := elem.Tag.Group; g >= 0x6000 && g <= 0x601E && g%2 == 0 {
+			removed++
+			continue
+		}
+		elements = append(elements, elem)
+	}
+	d.Data.Elements = elements
+	return removed
 }
 
 // TruncateDate truncates a date to YYYYMM01 format.
@@ -63,6 +458,22 @@ func (d *Dataset) TruncateDate(t tag.Tag) {
 	}
 }
 
+// ShiftDate moves a date (YYYYMMDD) by the given number of days.
+// Values that are not valid dates are cleared.
+func (d *Dataset) ShiftDate(t tag.Tag, days int) {
+	value := strings.TrimSpace(d.GetString(t))
+	if value == "" {
+		return
+	}
+
+	date, err := time.Parse("20060102", value)
+	if err != nil {
+		d.SetString(t, "")
+		return
+	}
+	d.SetString(t, date.AddDate(0, 0, days).Format("20060102"))
+}
+
 // Save writes the DICOM dataset to a file.
 func (d *Dataset) Save(outputPath string) error {
 	return d.SaveWithOptions(outputPath, SaveOptions{})
@@ -74,6 +485,22 @@ type SaveOptions struct {
 	// If true, the pixel data will be compressed and the transfer syntax
 	// will be updated to JPEG-LS Lossless.
 	CompressJPEGLS bool
+
+	// PreferPureGo forces the built-in JPEG-LS encoder even when dcmtk is
+	// installed. Without it, the built-in encoder is only used as a fallback
+	// when dcmcjpls is not available.
+	PreferPureGo bool
+
+	// CompressRLE enables RLE Lossless compression for pixel data.
+	// Ignored when CompressJPEGLS is set.
+	CompressRLE bool
+
+	// Progress, if set, is called by the built-in JPEG-LS encoder after
+	// every encoded row with the rows done and the total over all frames.
+	// Returning false aborts the write with an error wrapping
+	// jpegls.ErrAborted, e.g. when the run is cancelled. dcmtk and RLE
+	// compression do not report progress.
+	Progress func(done, total int) bool
 }
 
 // SaveWithOptions writes the DICOM dataset to a file with configurable options.
@@ -84,21 +511,51 @@ func (d *Dataset) SaveWithOptions(outputPath string, opts SaveOptions) error {
 		return fmt.Errorf("could not create output directory: %w", err)
 	}
 
-	// If JPEG-LS compression is requested, use custom writer
-	if opts.CompressJPEGLS {
-		return d.saveWithDcmtk(outputPath)
-	}
-
 	// Create output file
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("could not create output file: %w", err)
 	}
-	defer file.Close()
+
+	if err := d.Write(file, opts); err != nil {
+		file.Close()
+		os.Remove(outputPath)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not close output file: %w", err)
+	}
+	return nil
+}
+
+// Write encodes the DICOM dataset to w with configurable options.
+// Only the dcmtk JPEG-LS path uses temporary files.
+func (d *Dataset) Write(w io.Writer, opts SaveOptions) error {
+	// If JPEG-LS compression is requested, use dcmtk when available and
+	// fall back to the pure Go encoder otherwise
+	if opts.CompressJPEGLS {
+		if opts.PreferPureGo || !hasDcmcjpls() {
+			return d.writeWithPureGo(w, opts.Progress)
+		}
+		return d.writeWithDcmtk(w)
+	}
+
+	if opts.CompressRLE {
+		return d.writeWithRLE(w)
+	}
+
+	data, err := d.uncompressedData()
+	if err != nil {
+		return err
+	}
+	if data, err = withGroupLengths(data); err != nil {
+		return err
+	}
 
 	// Write DICOM with relaxed verification (many real-world DICOM files
 	// don't strictly follow VR specifications)
-	if err := dicom.Write(file, d.Data,
+	if err := dicom.Write(w, data,
 		dicom.SkipVRVerification(),
 		dicom.SkipValueTypeVerification(),
 		dicom.DefaultMissingTransferSyntax(),
@@ -109,47 +566,245 @@ func (d *Dataset) SaveWithOptions(outputPath string, opts SaveOptions) error {
 	return nil
 }
 
-func (d *Dataset) saveWithDcmtk(outputPath string) error {
+// uncompressedData returns the dataset to write as it is. A dataset that
+// lost its TransferSyntaxUID keeps the syntax it was read with instead of
+// falling back to the writer's default, so the output stays implicit or
+// explicit VR like the input. Datasets built in memory get the default.
+func (d *Dataset) uncompressedData() (dicom.Dataset, error) {
+	if d.sourceTransferSyntax == "" || d.GetTransferSyntax() != "" {
+		return d.Data, nil
+	}
+
+	tsElem, err := dicom.NewElement(tag.TransferSyntaxUID, []string{d.sourceTransferSyntax})
+	if err != nil {
+		return dicom.Dataset{}, fmt.Errorf("could not build transfer syntax: %w", err)
+	}
+	elements := append([]*dicom.Element{tsElem}, d.Data.Elements...)
+	return dicom.Dataset{Elements: elements}, nil
+}
+
+// withGroupLengths returns data with every group length element
+// (gggg,0000) set to the encoded length of its group in the transfer
+// syntax data declares, so values edited or compressed since the file was
+// read cannot leave them stale. It runs on the dataset about to be
+// written, after compression replaced the pixel data and transfer syntax.
+// The elements are replaced in a copy of the element list; the file meta
+// group length is left alone, since dicom.Write computes it itself.
+func withGroupLengths(data dicom.Dataset) (dicom.Dataset, error) {
+	ts := ImplicitVRLittleEndian
+	if elem, err := data.FindElementByTag(tag.TransferSyntaxUID); err == nil {
+		if values, ok := elem.Value.GetValue().([]string); ok && len(values) > 0 {
+			ts = strings.TrimRight(values[0], " \x00")
+		}
+	}
+	bo, implicit, err := uid.ParseTransferSyntaxUID(ts)
+	if err != nil {
+		// Encapsulated transfer syntaxes are explicit VR little endian
+		bo, implicit = binary.LittleEndian, false
+	}
+
+	elements := data.Elements
+	copied := false
+	for i, elem := range data.Elements {
+		if elem.Tag.Element != 0x0000 || elem.Tag.Group == tag.MetadataGroup {
+			continue
+		}
+		group := elem.Tag.Group
+		var counter byteCounter
+		w := dicom.NewWriter(&counter, dicom.SkipVRVerification(), dicom.SkipValueTypeVerification())
+		w.SetTransferSyntax(bo, implicit)
+		for _, e := range data.Elements {
+			if e.Tag.Group != group || e.Tag.Element == 0x0000 {
+				continue
+			}
+			if err := w.WriteElement(e); err != nil {
+				return dicom.Dataset{}, fmt.Errorf("could not measure group %04X: %w", group, err)
+			}
+		}
+
+		length, err := dicom.NewElement(elem.Tag, []int{int(counter)})
+		if err != nil {
+			return dicom.Dataset{}, fmt.Errorf("could not create %v: %w", elem.Tag, err)
+		}
+		length.RawValueRepresentation = "UL"
+		if !copied {
+			elements = append([]*dicom.Element(nil), data.Elements...)
+			copied = true
+		}
+		elements[i] = length
+	}
+	return dicom.Dataset{Elements: elements}, nil
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// hasDcmcjpls checks if the dcmtk JPEG-LS compressor is available in PATH.
+func hasDcmcjpls() bool {
+	_, err := exec.LookPath("dcmcjpls")
+	return err == nil
+}
+
+// writeWithPureGo compresses the pixel data with the built-in JPEG-LS encoder
+// and writes the dataset with the JPEG-LS Lossless transfer syntax.
+// The dataset itself is left unmodified.
+func (d *Dataset) writeWithPureGo(w io.Writer, progress func(done, total int) bool) error {
+	encapsulated, err := d.getCompressedPixelData(progress)
+	if err != nil {
+		return fmt.Errorf("JPEG-LS compression failed: %w", err)
+	}
+	return d.writeEncapsulated(w, encapsulated, JPEGLSLossless)
+}
+
+// writeWithRLE compresses the pixel data with RLE Lossless and writes the
+// dataset with the RLE transfer syntax. The dataset itself is left unmodified.
+func (d *Dataset) writeWithRLE(w io.Writer) error {
+	width, height, err := d.getImageDimensions()
+	if err != nil {
+		return err
+	}
+	if err := checkPhotometric(d.getPhotometric(), d.getSamplesPerPixel()); err != nil {
+		return err
+	}
+	frames, err := d.extractRawFrames()
+	if err != nil {
+		return err
+	}
+
+	encapsulated, err := CompressRLEMultiFrame(frames, width, height, d.getSamplesPerPixel(), d.getBitsAllocated())
+	if err != nil {
+		return fmt.Errorf("RLE compression failed: %w", err)
+	}
+	return d.writeEncapsulated(w, encapsulated, RLELossless)
+}
+
+// writeEncapsulated writes a copy of the dataset with the pixel data replaced
+// by encapsulated (compressed) frames and the given transfer syntax.
+func (d *Dataset) writeEncapsulated(w io.Writer, encapsulated []byte, transferSyntax string) error {
+	pixelElem, err := NewEncapsulatedPixelData(encapsulated)
+	if err != nil {
+		return fmt.Errorf("could not build pixel data: %w", err)
+	}
+	tsElem, err := dicom.NewElement(tag.TransferSyntaxUID, []string{transferSyntax})
+	if err != nil {
+		return fmt.Errorf("could not build transfer syntax: %w", err)
+	}
+	// Compressed frames decode to interleaved samples
+	planarElem, err := dicom.NewElement(tag.PlanarConfiguration, []int{0})
+	if err != nil {
+		return fmt.Errorf("could not build planar configuration: %w", err)
+	}
+
+	// Work on a copy of the element list with the replaced elements
+	elements := make([]*dicom.Element, 0, len(d.Data.Elements)+1)
+	hasTS := false
+	for _, e := range d.Data.Elements {
+		switch e.Tag {
+		case tag.PixelData:
+			elements = append(elements, pixelElem)
+		case tag.TransferSyntaxUID:
+			elements = append(elements, tsElem)
+			hasTS = true
+		case tag.PlanarConfiguration:
+			elements = append(elements, planarElem)
+		default:
+			elements = append(elements, e)
+		}
+	}
+	if !hasTS {
+		elements = append([]*dicom.Element{tsElem}, elements...)
+	}
+
+	data, err := withGroupLengths(dicom.Dataset{Elements: elements})
+	if err != nil {
+		return err
+	}
+	if err := dicom.Write(w, data,
+		dicom.SkipVRVerification(),
+		dicom.SkipValueTypeVerification(),
+	); err != nil {
+		return fmt.Errorf("could not write DICOM: %w", err)
+	}
+
+	return nil
+}
+
+// writeWithDcmtk compresses with dcmcjpls, which only works on files, so the
+// dataset is spilled to temporary files and the result copied to w.
+func (d *Dataset) writeWithDcmtk(w io.Writer) error {
 	_, err := exec.LookPath("dcmcjpls")
 	if err != nil {
-		return fmt.Errorf("dcmtk not installed (missing dcmcjpls)")
+		return fmt.Errorf("%w (missing dcmcjpls)", ErrDcmtkNotInstalled)
 	}
 
-	tmpFile, err := os.CreateTemp("", "dicom-uncompressed-*.dcm")
+	tmpFile, err := os.CreateTemp(TempDir(), tempFilePrefix+"uncompressed-*.dcm")
 	if err != nil {
 		return fmt.Errorf("could not create temp file: %w", err)
 	}
 	tmpPath := tmpFile.Name()
-	tmpFile.Close()
 	defer os.Remove(tmpPath)
 
-	file, err := os.Create(tmpPath)
+	data, err := d.uncompressedData()
+	if err == nil {
+		data, err = withGroupLengths(data)
+	}
 	if err != nil {
-		return fmt.Errorf("could not create temp DICOM: %w", err)
+		tmpFile.Close()
+		return err
 	}
-	if err := dicom.Write(file, d.Data,
+	if err := dicom.Write(tmpFile, data,
 		dicom.SkipVRVerification(),
 		dicom.SkipValueTypeVerification(),
 		dicom.DefaultMissingTransferSyntax(),
 	); err != nil {
-		file.Close()
+		tmpFile.Close()
 		return fmt.Errorf("could not write temp DICOM: %w", err)
 	}
-	if err := file.Close(); err != nil {
+	if err := tmpFile.Close(); err != nil {
 		return fmt.Errorf("could not close temp DICOM: %w", err)
 	}
 
-	cmd := exec.Command("dcmcjpls", tmpPath, outputPath)
+	// Reserve the output name too, so it cannot clash with another file and
+	// whatever dcmcjpls leaves behind when it fails is removed
+	outFile, err := os.CreateTemp(TempDir(), tempFilePrefix+"compressed-*.dcm")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	compressedPath := outFile.Name()
+	outFile.Close()
+	defer os.Remove(compressedPath)
+
+	cmd := exec.Command("dcmcjpls", tmpPath, compressedPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("dcmcjpls failed: %s", string(output))
 	}
 
+	compressed, err := os.Open(compressedPath)
+	if err != nil {
+		return fmt.Errorf("could not open compressed DICOM: %w", err)
+	}
+	defer compressed.Close()
+
+	if _, err := io.Copy(w, compressed); err != nil {
+		return fmt.Errorf("could not copy compressed DICOM: %w", err)
+	}
 	return nil
 }
 
-// getCompressedPixelData extracts and compresses the pixel data using JPEG-LS.
-func (d *Dataset) getCompressedPixelData() ([]byte, error) {
+// getCompressedPixelData extracts and compresses every frame of the pixel data
+// using JPEG-LS. Returns encapsulated pixel data suitable for DICOM.
+//
+// Samples are coded as stored and PhotometricInterpretation is copied to
+// the output unchanged, so MONOCHROME1 (low values are white) stays
+// inverted and decodes to what it declares. Nothing may be inverted here.
+// progress is passed on to CompressJPEGLSMultiFrameWithProgress.
+func (d *Dataset) getCompressedPixelData(progress func(done, total int) bool) ([]byte, error) {
 	// Get image dimensions and format
 	width, height, err := d.getImageDimensions()
 	if err != nil {
@@ -158,15 +813,37 @@ func (d *Dataset) getCompressedPixelData() ([]byte, error) {
 
 	samples := d.getSamplesPerPixel()
 	bitsAllocated := d.getBitsAllocated()
+	if err := checkPhotometric(d.getPhotometric(), samples); err != nil {
+		return nil, err
+	}
 
-	// Extract raw pixel data
-	pixelData, err := d.extractRawPixelData()
+	// Extract raw pixel data, one slice per frame
+	frames, err := d.extractRawFrames()
 	if err != nil {
 		return nil, err
 	}
+	order := d.rawFrameByteOrder()
 
-	// Compress using JPEG-LS
-	return CompressJPEGLS(pixelData, width, height, samples, bitsAllocated)
+	// Compress using JPEG-LS and encapsulate. Signed samples are coded as
+	// their two's-complement bits masked to BitsStored, which decoders
+	// sign-extend again by PixelRepresentation, so the attributes stay
+	// as they are.
+	return CompressJPEGLSMultiFrameWithProgress(frames, width, height, samples, bitsAllocated, d.getBitsStored(), order, progress)
+}
+
+// rawFrameByteOrder returns the byte order of 16-bit samples returned by
+// extractRawFrames. Parsed native frames are converted to little-endian;
+// unparsed pixel bytes keep the order of the transfer syntax, which is
+// big-endian for Explicit VR Big Endian.
+func (d *Dataset) rawFrameByteOrder() binary.ByteOrder {
+	pixelElem, err := d.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		return binary.LittleEndian
+	}
+	if _, raw := pixelElem.Value.GetValue().([]byte); raw && strings.Contains(d.GetTransferSyntax(), ExplicitVRBigEndian) {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
 }
 
 // getImageDimensions returns the width and height of the image.
@@ -203,6 +880,28 @@ func (d *Dataset) getSamplesPerPixel() int {
 	return val
 }
 
+// getPhotometric returns the PhotometricInterpretation ("" if absent).
+func (d *Dataset) getPhotometric() string {
+	return strings.ToUpper(strings.TrimSpace(d.GetString(tag.PhotometricInterpretation)))
+}
+
+// checkPhotometric checks that a PhotometricInterpretation fits the
+// samples per pixel, so compressed output never declares samples its
+// frames do not hold. Missing and unknown interpretations are accepted.
+func checkPhotometric(photometric string, samples int) error {
+	want := 0
+	switch {
+	case strings.HasPrefix(photometric, "MONOCHROME"), photometric == "PALETTE COLOR":
+		want = 1
+	case photometric == "RGB", strings.HasPrefix(photometric, "YBR_"):
+		want = 3
+	}
+	if want != 0 && samples != want {
+		return fmt.Errorf("PhotometricInterpretation %s needs %d samples per pixel, found %d", photometric, want, samples)
+	}
+	return nil
+}
+
 // getBitsAllocated returns the bits allocated per sample.
 func (d *Dataset) getBitsAllocated() int {
 	elem, err := d.Data.FindElementByTag(tag.BitsAllocated)
@@ -216,34 +915,143 @@ func (d *Dataset) getBitsAllocated() int {
 	return val
 }
 
-// extractRawPixelData extracts raw pixel data from the dataset.
-func (d *Dataset) extractRawPixelData() ([]byte, error) {
+// getBitsStored returns the bits stored per sample (BitsAllocated if absent).
+func (d *Dataset) getBitsStored() int {
+	elem, err := d.Data.FindElementByTag(tag.BitsStored)
+	if err != nil {
+		return d.getBitsAllocated()
+	}
+	val := getIntValueFromElem(elem)
+	if val <= 0 || val > d.getBitsAllocated() {
+		return d.getBitsAllocated()
+	}
+	return val
+}
+
+// isSigned reports whether samples are two's complement
+// (PixelRepresentation=1).
+func (d *Dataset) isSigned() bool {
+	elem, err := d.Data.FindElementByTag(tag.PixelRepresentation)
+	return err == nil && getIntValueFromElem(elem) == 1
+}
+
+// signedValue interprets the low bits of v as a two's-complement number.
+func signedValue(v, bits int) int {
+	v &= 1<<bits - 1
+	if v >= 1<<(bits-1) {
+		v -= 1 << bits
+	}
+	return v
+}
+
+// isPlanar reports whether multi-sample pixel data is stored color-by-plane
+// (PlanarConfiguration=1) rather than interleaved per pixel.
+func (d *Dataset) isPlanar() bool {
+	if d.getSamplesPerPixel() < 2 {
+		return false
+	}
+	elem, err := d.Data.FindElementByTag(tag.PlanarConfiguration)
+	if err != nil {
+		return false
+	}
+	return getIntValueFromElem(elem) == 1
+}
+
+// planarToInterleaved reorders one color-by-plane frame (RRR...GGG...BBB)
+// into samples interleaved per pixel (RGBRGB...).
+func planarToInterleaved(frame []byte, pixels, samples, bytesPerSample int) []byte {
+	out := make([]byte, len(frame))
+	for s := 0; s < samples; s++ {
+		for p := 0; p < pixels; p++ {
+			src := (s*pixels + p) * bytesPerSample
+			dst := (p*samples + s) * bytesPerSample
+			if src+bytesPerSample > len(frame) {
+				return out
+			}
+			copy(out[dst:dst+bytesPerSample], frame[src:src+bytesPerSample])
+		}
+	}
+	return out
+}
+
+// getNumberOfFrames returns the number of frames (1 if the tag is absent).
+func (d *Dataset) getNumberOfFrames() int {
+	n, err := strconv.Atoi(strings.TrimSpace(d.GetString(tag.NumberOfFrames)))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// extractRawFrames extracts raw pixel data from the dataset, one slice per
+// frame. Planar color data is converted to samples interleaved per pixel,
+// the layout the encoders expect.
+func (d *Dataset) extractRawFrames() ([][]byte, error) {
 	pixelElem, err := d.Data.FindElementByTag(tag.PixelData)
 	if err != nil {
 		return nil, fmt.Errorf("no pixel data found: %w", err)
 	}
 
+	var frames [][]byte
 	pixelInfo := pixelElem.Value.GetValue()
 
 	switch v := pixelInfo.(type) {
 	case dicom.PixelDataInfo:
 		// Handle native frames
-		if len(v.Frames) > 0 {
-			return d.extractFromNativeFrames(v)
+		if len(v.Frames) == 0 {
+			return nil, fmt.Errorf("no frames in pixel data")
 		}
-		return nil, fmt.Errorf("no frames in pixel data")
+		frames, err = d.extractFromNativeFrames(v)
 
 	case []byte:
-		// Already raw bytes
-		return v, nil
+		// Already raw bytes, split into frames
+		frames, err = d.splitRawFrames(v)
 
 	default:
 		return nil, fmt.Errorf("unsupported pixel data type: %T", pixelInfo)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.isPlanar() {
+		width, height, err := d.getImageDimensions()
+		if err != nil {
+			return nil, err
+		}
+		bytesPerSample := (d.getBitsAllocated() + 7) / 8
+		for i, frame := range frames {
+			frames[i] = planarToInterleaved(frame, width*height, d.getSamplesPerPixel(), bytesPerSample)
+		}
+	}
+
+	return frames, nil
+}
+
+// splitRawFrames splits contiguous raw pixel bytes into per-frame slices.
+func (d *Dataset) splitRawFrames(data []byte) ([][]byte, error) {
+	width, height, err := d.getImageDimensions()
+	if err != nil {
+		return nil, err
+	}
+	bytesPerSample := (d.getBitsAllocated() + 7) / 8
+	frameSize := width * height * d.getSamplesPerPixel() * bytesPerSample
+
+	numFrames := d.getNumberOfFrames()
+	if len(data) < numFrames*frameSize {
+		return nil, fmt.Errorf("pixel data too short: expected %d bytes for %d frame(s), got %d",
+			numFrames*frameSize, numFrames, len(data))
+	}
+
+	frames := make([][]byte, numFrames)
+	for i := range frames {
+		frames[i] = data[i*frameSize : (i+1)*frameSize]
+	}
+	return frames, nil
 }
 
-// extractFromNativeFrames converts native frame data to raw bytes.
-func (d *Dataset) extractFromNativeFrames(pdi dicom.PixelDataInfo) ([]byte, error) {
+// extractFromNativeFrames converts native frame data to raw bytes, one slice per frame.
+func (d *Dataset) extractFromNativeFrames(pdi dicom.PixelDataInfo) ([][]byte, error) {
 	if len(pdi.Frames) == 0 {
 		return nil, fmt.Errorf("no frames available")
 	}
@@ -258,31 +1066,40 @@ func (d *Dataset) extractFromNativeFrames(pdi dicom.PixelDataInfo) ([]byte, erro
 	pixelCount := width * height * samples
 	expectedSize := pixelCount * bytesPerSample
 
-	// For single frame, convert native data to bytes
-	frame := pdi.Frames[0]
-	if frame.NativeData.Data == nil {
-		return nil, fmt.Errorf("native frame data is nil")
-	}
-
-	result := make([]byte, expectedSize)
-
-	// Convert int values to bytes
-	idx := 0
-	for _, pixel := range frame.NativeData.Data {
-		for _, sample := range pixel {
-			if bytesPerSample == 1 {
-				result[idx] = byte(sample)
-				idx++
-			} else {
-				// Little-endian 16-bit
-				result[idx] = byte(sample)
-				result[idx+1] = byte(sample >> 8)
-				idx += 2
+	frames := make([][]byte, len(pdi.Frames))
+	for i, frame := range pdi.Frames {
+		if frame.Encapsulated {
+			return nil, fmt.Errorf("frame %d is encapsulated, expected native pixel data", i)
+		}
+		if frame.NativeData.Data == nil {
+			return nil, fmt.Errorf("native data for frame %d is nil", i)
+		}
+
+		result := make([]byte, expectedSize)
+
+		// Convert int values to bytes
+		idx := 0
+		for _, pixel := range frame.NativeData.Data {
+			for _, sample := range pixel {
+				if idx+bytesPerSample > expectedSize {
+					return nil, fmt.Errorf("frame %d has more samples than expected", i)
+				}
+				if bytesPerSample == 1 {
+					result[idx] = byte(sample)
+					idx++
+				} else {
+					// Little-endian 16-bit
+					result[idx] = byte(sample)
+					result[idx+1] = byte(sample >> 8)
+					idx += 2
+				}
 			}
 		}
+
+		frames[i] = result
 	}
 
-	return result, nil
+	return frames, nil
 }
 
 // getIntValueFromElem extracts an integer value from a DICOM element.
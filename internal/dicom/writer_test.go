@@ -0,0 +1,769 @@
+package dicom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/suyashkumar/dicom"
+	"github.com/suyashkumar/dicom/pkg/frame"
+	"github.com/suyashkumar/dicom/pkg/tag"
+
+	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/jpegls"
+	"dicom-anonymizer/internal/logging"
+)
+
+// newTestDataset builds an 8-bit grayscale dataset with the given native frames.
+func newTestDataset(t *testing.T, rows, cols int, frames [][]int) *Dataset {
+	t.Helper()
+
+	nativeFrames := make([]*frame.Frame, len(frames))
+	for f, values := range frames {
+		data := make([][]int, len(values))
+		for i, v := range values {
+			data[i] = []int{v}
+		}
+		nativeFrames[f] = &frame.Frame{
+			NativeData: frame.NativeFrame{Data: data, Rows: rows, Cols: cols, BitsPerSample: 8},
+		}
+	}
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.Rows, []int{rows}},
+		{tag.Columns, []int{cols}},
+		{tag.SamplesPerPixel, []int{1}},
+		{tag.BitsAllocated, []int{8}},
+		{tag.PixelData, dicom.PixelDataInfo{Frames: nativeFrames}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+
+	return &Dataset{Data: dicom.Dataset{Elements: elems}}
+}
+
+func TestCompressedPixelDataMultiFrame(t *testing.T) {
+	rows, cols := 4, 4
+	frames := make([][]int, 3)
+	for f := range frames {
+		frames[f] = make([]int, rows*cols)
+		for i := range frames[f] {
+			frames[f][i] = (f + 1) * i
+		}
+	}
+	ds := newTestDataset(t, rows, cols, frames)
+
+	raw, err := ds.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+	if len(raw) != len(frames) {
+		t.Fatalf("extractRawFrames returned %d frames, want %d", len(raw), len(frames))
+	}
+	for f := range frames {
+		for i, v := range frames[f] {
+			if int(raw[f][i]) != v {
+				t.Errorf("frame %d byte %d = %d, want %d", f, i, raw[f][i], v)
+			}
+		}
+	}
+
+	encapsulated, err := ds.getCompressedPixelData(nil)
+	if err != nil {
+		t.Fatalf("getCompressedPixelData failed: %v", err)
+	}
+	items, err := ExtractFramesFromEncapsulated(encapsulated)
+	if err != nil {
+		t.Fatalf("ExtractFramesFromEncapsulated failed: %v", err)
+	}
+	if len(items) != len(frames) {
+		t.Errorf("encapsulated %d frames, want %d", len(items), len(frames))
+	}
+}
+
+func TestWriteProgressAborts(t *testing.T) {
+	frames := [][]int{make([]int, 16), make([]int, 16)}
+	ds := newTestDataset(t, 4, 4, frames)
+
+	var rows int
+	opts := SaveOptions{CompressJPEGLS: true, PreferPureGo: true, Progress: func(done, total int) bool {
+		rows = total
+		return true
+	}}
+	if err := ds.Write(&bytes.Buffer{}, opts); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if rows != 8 {
+		t.Errorf("progress total = %d rows, want 8", rows)
+	}
+
+	opts.Progress = func(done, total int) bool { return false }
+	if err := ds.Write(&bytes.Buffer{}, opts); !errors.Is(err, jpegls.ErrAborted) {
+		t.Errorf("Write = %v, want ErrAborted", err)
+	}
+}
+
+func TestCompressedPixelDataBigEndian(t *testing.T) {
+	rows, cols := 4, 6
+	values := make([]uint16, rows*cols)
+	le := make([]byte, len(values)*2)
+	be := make([]byte, len(values)*2)
+	for i := range values {
+		values[i] = uint16(i * 2731)
+		binary.LittleEndian.PutUint16(le[2*i:], values[i])
+		binary.BigEndian.PutUint16(be[2*i:], values[i])
+	}
+
+	// Unparsed pixel bytes in the byte order of the transfer syntax
+	newRaw := func(ts string, data []byte) *Dataset {
+		var elems []*dicom.Element
+		for _, e := range []struct {
+			t    tag.Tag
+			data interface{}
+		}{
+			{tag.TransferSyntaxUID, []string{ts}},
+			{tag.Rows, []int{rows}},
+			{tag.Columns, []int{cols}},
+			{tag.BitsAllocated, []int{16}},
+		} {
+			elem, err := dicom.NewElement(e.t, e.data)
+			if err != nil {
+				t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+			}
+			elems = append(elems, elem)
+		}
+		value, err := dicom.NewValue(data)
+		if err != nil {
+			t.Fatalf("NewValue failed: %v", err)
+		}
+		elems = append(elems, &dicom.Element{Tag: tag.PixelData, RawValueRepresentation: "OW", Value: value})
+		return &Dataset{Data: dicom.Dataset{Elements: elems}}
+	}
+
+	leDS := newRaw(ExplicitVRLittleEndian, le)
+	beDS := newRaw(ExplicitVRBigEndian, be)
+	if beDS.rawFrameByteOrder() != binary.BigEndian || leDS.rawFrameByteOrder() != binary.LittleEndian {
+		t.Fatal("byte order not taken from the transfer syntax")
+	}
+
+	want, err := leDS.getCompressedPixelData(nil)
+	if err != nil {
+		t.Fatalf("getCompressedPixelData (little-endian) failed: %v", err)
+	}
+	got, err := beDS.getCompressedPixelData(nil)
+	if err != nil {
+		t.Fatalf("getCompressedPixelData (big-endian) failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("big-endian pixel data compressed differently from the same little-endian samples")
+	}
+}
+
+func TestSaveWithPureGoJPEGLS(t *testing.T) {
+	rows, cols := 8, 8
+	values := make([]int, rows*cols)
+	for i := range values {
+		values[i] = i * 3
+	}
+	ds := newTestDataset(t, rows, cols, [][]int{values})
+
+	outputPath := filepath.Join(t.TempDir(), "out.dcm")
+	if err := ds.SaveWithOptions(outputPath, SaveOptions{CompressJPEGLS: true, PreferPureGo: true}); err != nil {
+		t.Fatalf("SaveWithOptions failed: %v", err)
+	}
+
+	saved, err := ReadDicom(outputPath)
+	if err != nil {
+		t.Fatalf("ReadDicom failed: %v", err)
+	}
+	if ts := saved.GetTransferSyntax(); ts != JPEGLSLossless {
+		t.Errorf("TransferSyntaxUID = %q, want %q", ts, JPEGLSLossless)
+	}
+
+	elem, err := saved.Data.FindElementByTag(tag.PixelData)
+	if err != nil {
+		t.Fatalf("no pixel data: %v", err)
+	}
+	pdi, ok := elem.Value.GetValue().(dicom.PixelDataInfo)
+	if !ok || !pdi.IsEncapsulated || len(pdi.Frames) != 1 {
+		t.Fatalf("expected one encapsulated frame, got %+v", elem.Value.GetValue())
+	}
+	data := pdi.Frames[0].EncapsulatedData.Data
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8}) {
+		t.Errorf("frame does not start with a JPEG-LS SOI marker: % x", data[:min(4, len(data))])
+	}
+
+	// The in-memory dataset must not be modified
+	if orig, _ := ds.Data.FindElementByTag(tag.PixelData); orig.Value.GetValue().(dicom.PixelDataInfo).IsEncapsulated {
+		t.Error("SaveWithOptions modified the source dataset")
+	}
+}
+
+func TestShiftDatePreservesInterval(t *testing.T) {
+	newDateDataset := func(date string) *Dataset {
+		elem, err := dicom.NewElement(tag.StudyDate, []string{date})
+		if err != nil {
+			t.Fatalf("NewElement failed: %v", err)
+		}
+		return &Dataset{Data: dicom.Dataset{Elements: []*dicom.Element{elem}}}
+	}
+
+	first := newDateDataset("20240115")
+	second := newDateDataset("20240214") // 30 days later
+
+	shift := identity.CreateDateShift("ANON-000001", "secret")
+	if shift == 0 || shift < -identity.MaxDateShiftDays || shift > identity.MaxDateShiftDays {
+		t.Fatalf("shift %d out of range", shift)
+	}
+	if again := identity.CreateDateShift("ANON-000001", "secret"); again != shift {
+		t.Fatalf("shift not stable: %d then %d", shift, again)
+	}
+
+	first.ShiftDate(tag.StudyDate, shift)
+	second.ShiftDate(tag.StudyDate, shift)
+
+	a, err := time.Parse("20060102", first.GetString(tag.StudyDate))
+	if err != nil {
+		t.Fatalf("first date invalid: %v", err)
+	}
+	b, err := time.Parse("20060102", second.GetString(tag.StudyDate))
+	if err != nil {
+		t.Fatalf("second date invalid: %v", err)
+	}
+
+	if a.Format("20060102") == "20240115" {
+		t.Errorf("date was not shifted")
+	}
+	if days := int(b.Sub(a).Hours() / 24); days != 30 {
+		t.Errorf("interval after shift = %d days, want 30", days)
+	}
+}
+
+func TestSetStringClampsToVR(t *testing.T) {
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+	for _, e := range []struct {
+		t     tag.Tag
+		value string
+	}{
+		{tag.AccessionNumber, "ACC1"},         // SH
+		{tag.InstitutionName, "GENERAL HOSP"}, // LO
+		{tag.OtherPatientIDs, "MRN1"},         // LO, multi-valued
+	} {
+		elem, err := dicom.NewElement(e.t, []string{e.value})
+		if err != nil {
+			t.Fatalf("NewElement failed: %v", err)
+		}
+		ds.Data.Elements = append(ds.Data.Elements, elem)
+	}
+
+	var warnings []string
+	ds.Logger = logging.NewFunc(func(s string) { warnings = append(warnings, s) }, logging.LevelWarn)
+
+	// A hash is longer than SH allows
+	ds.SetString(tag.AccessionNumber, "0123456789abcdef0123456789abcdef")
+	if got := ds.GetString(tag.AccessionNumber); got != "0123456789abcdef" {
+		t.Errorf("AccessionNumber = %q, want the first 16 characters", got)
+	}
+	if len(warnings) != 1 || strings.Contains(warnings[0], "0123") {
+		t.Errorf("warnings = %q, want one without the value", warnings)
+	}
+	ds.SetString(tag.InstitutionName, "ODD")
+
+	// The limit applies to each value of a multi-valued element
+	ds.SetString(tag.OtherPatientIDs, "SHORT\\0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdefXYZ")
+	elem, err := ds.Data.FindElementByTag(tag.OtherPatientIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SHORT", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
+	if got := elem.Value.GetValue().([]string); !reflect.DeepEqual(got, want) {
+		t.Errorf("OtherPatientIDs = %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := ds.Write(&buf, SaveOptions{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	read, err := ReadDicomFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadDicomFromReader failed: %v", err)
+	}
+	for _, want := range []struct {
+		t      tag.Tag
+		value  string
+		length uint32
+	}{
+		{tag.AccessionNumber, "0123456789abcdef", 16},
+		{tag.InstitutionName, "ODD", 4},
+	} {
+		elem, err := read.Data.FindElementByTag(want.t)
+		if err != nil {
+			t.Fatalf("%v missing after write", want.t)
+		}
+		if got := read.GetString(want.t); got != want.value || elem.ValueLength != want.length {
+			t.Errorf("%v = %q (length %d), want %q (length %d)", want.t, got, elem.ValueLength, want.value, want.length)
+		}
+	}
+}
+
+func TestWriteAndReadInMemory(t *testing.T) {
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+
+	var buf bytes.Buffer
+	if err := ds.Write(&buf, SaveOptions{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	read, err := ReadDicomFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadDicomFromReader failed: %v", err)
+	}
+	if read.FilePath != "" {
+		t.Errorf("FilePath = %q, want empty for stream input", read.FilePath)
+	}
+
+	frames, err := read.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+	if want := []byte{1, 2, 3, 4}; len(frames) != 1 || !bytes.Equal(frames[0], want) {
+		t.Errorf("frames = %v, want [%v]", frames, want)
+	}
+}
+
+func TestPlanarRGBRoundTrip(t *testing.T) {
+	rows, cols, samples := 2, 3, 3
+	pixels := rows * cols
+
+	// Stream order of a color-by-plane frame: all R, then all G, then all B
+	stream := make([]int, 0, pixels*samples)
+	for s := 0; s < samples; s++ {
+		for p := 0; p < pixels; p++ {
+			stream = append(stream, (s+1)*10+p)
+		}
+	}
+	data := make([][]int, pixels)
+	for i := range data {
+		data[i] = stream[i*samples : (i+1)*samples]
+	}
+	fr := &frame.Frame{NativeData: frame.NativeFrame{Data: data, Rows: rows, Cols: cols, BitsPerSample: 8}}
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.TransferSyntaxUID, []string{ExplicitVRLittleEndian}},
+		{tag.Rows, []int{rows}},
+		{tag.Columns, []int{cols}},
+		{tag.SamplesPerPixel, []int{samples}},
+		{tag.PlanarConfiguration, []int{1}},
+		{tag.BitsAllocated, []int{8}},
+		{tag.PixelData, dicom.PixelDataInfo{Frames: []*frame.Frame{fr}}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	ds := &Dataset{Data: dicom.Dataset{Elements: elems}}
+
+	want := make([]byte, 0, pixels*samples)
+	for p := 0; p < pixels; p++ {
+		for s := 0; s < samples; s++ {
+			want = append(want, byte((s+1)*10+p))
+		}
+	}
+
+	raw, err := ds.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+	if len(raw) != 1 || !bytes.Equal(raw[0], want) {
+		t.Fatalf("extractRawFrames = %v, want interleaved %v", raw, want)
+	}
+
+	var buf bytes.Buffer
+	if err := ds.Write(&buf, SaveOptions{CompressRLE: true}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	saved, err := ReadDicomFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadDicomFromReader failed: %v", err)
+	}
+	if saved.isPlanar() {
+		t.Errorf("compressed output still declares PlanarConfiguration=1")
+	}
+	if err := saved.DecompressRLE(); err != nil {
+		t.Fatalf("DecompressRLE failed: %v", err)
+	}
+	roundTrip, err := saved.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames after round trip failed: %v", err)
+	}
+	if len(roundTrip) != 1 || !bytes.Equal(roundTrip[0], want) {
+		t.Errorf("round trip = %v, want %v", roundTrip, want)
+	}
+}
+
+func TestSignedPixelsJPEGLS(t *testing.T) {
+	rows, cols := 4, 8
+	values := make([]int, rows*cols)
+	data := make([][]int, rows*cols)
+	for i := range values {
+		values[i] = -2000 + i*125 // Signed 12-bit gradient through zero
+		data[i] = []int{values[i]}
+	}
+	fr := &frame.Frame{NativeData: frame.NativeFrame{Data: data, Rows: rows, Cols: cols, BitsPerSample: 16}}
+
+	var elems []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.TransferSyntaxUID, []string{ExplicitVRLittleEndian}},
+		{tag.SamplesPerPixel, []int{1}},
+		{tag.Rows, []int{rows}},
+		{tag.Columns, []int{cols}},
+		{tag.BitsAllocated, []int{16}},
+		{tag.BitsStored, []int{12}},
+		{tag.PixelRepresentation, []int{1}},
+		{tag.RescaleIntercept, []string{"-1024"}},
+		{tag.PixelData, dicom.PixelDataInfo{Frames: []*frame.Frame{fr}}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		elems = append(elems, elem)
+	}
+	ds := &Dataset{Data: dicom.Dataset{Elements: elems}}
+
+	// The encoder sees the two's-complement bits masked to BitsStored
+	raw, err := ds.extractRawFrames()
+	if err != nil {
+		t.Fatalf("extractRawFrames failed: %v", err)
+	}
+	masked := make([]byte, len(raw[0]))
+	for i := 0; i < len(masked); i += 2 {
+		binary.LittleEndian.PutUint16(masked[i:], binary.LittleEndian.Uint16(raw[0][i:])&0x0FFF)
+	}
+	frame, err := jpegls.EncodeFromBytes(masked, cols, rows, 1, 16, 12)
+	if err != nil {
+		t.Fatalf("EncodeFromBytes failed: %v", err)
+	}
+	compressed, err := ds.getCompressedPixelData(nil)
+	if err != nil {
+		t.Fatalf("getCompressedPixelData failed: %v", err)
+	}
+	if !bytes.Equal(compressed, EncapsulateFrames([][]byte{frame})) {
+		t.Error("compressed pixel data differs from the masked samples")
+	}
+
+	var buf bytes.Buffer
+	if err := ds.Write(&buf, SaveOptions{CompressJPEGLS: true, PreferPureGo: true}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	saved, err := ReadDicomFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadDicomFromReader failed: %v", err)
+	}
+	// Decoders sign-extend by PixelRepresentation, so it stays as it is
+	if !saved.isSigned() {
+		t.Errorf("output no longer declares signed pixels")
+	}
+	if got := saved.GetString(tag.RescaleIntercept); got != "-1024" {
+		t.Errorf("RescaleIntercept = %q, want -1024", got)
+	}
+}
+
+func TestWritePreservesTransferSyntax(t *testing.T) {
+	// Rows (0028,0010) in explicit VR carries "US" after the tag; implicit
+	// VR has the 4-byte length there
+	explicitRows := []byte{0x28, 0x00, 0x10, 0x00, 'U', 'S'}
+	implicitRows := []byte{0x28, 0x00, 0x10, 0x00, 0x02, 0x00, 0x00, 0x00}
+
+	for _, tc := range []struct {
+		ts   string
+		rows []byte
+	}{
+		{ExplicitVRLittleEndian, explicitRows},
+		{ImplicitVRLittleEndian, implicitRows},
+	} {
+		ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+		tsElem, err := dicom.NewElement(tag.TransferSyntaxUID, []string{tc.ts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds.Data.Elements = append([]*dicom.Element{tsElem}, ds.Data.Elements...)
+
+		var input bytes.Buffer
+		if err := ds.Write(&input, SaveOptions{}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		read, err := ReadDicomFromReader(bytes.NewReader(input.Bytes()), int64(input.Len()))
+		if err != nil {
+			t.Fatalf("ReadDicomFromReader failed: %v", err)
+		}
+
+		// Drop the element, as a profile clearing group 0002 would
+		elements := read.Data.Elements[:0]
+		for _, e := range read.Data.Elements {
+			if e.Tag != tag.TransferSyntaxUID {
+				elements = append(elements, e)
+			}
+		}
+		read.Data.Elements = elements
+
+		var output bytes.Buffer
+		if err := read.Write(&output, SaveOptions{}); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if !bytes.Contains(output.Bytes(), tc.rows) {
+			t.Errorf("%s: output is not encoded with the input's VR encoding", tc.ts)
+		}
+		reread, err := ReadDicomFromReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+		if err != nil {
+			t.Fatalf("ReadDicomFromReader failed: %v", err)
+		}
+		if got := reread.GetTransferSyntax(); got != tc.ts {
+			t.Errorf("transfer syntax = %q, want %q", got, tc.ts)
+		}
+	}
+}
+
+func TestWriteRecomputesGroupLengths(t *testing.T) {
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+	var extra []*dicom.Element
+	for _, e := range []struct {
+		t    tag.Tag
+		data interface{}
+	}{
+		{tag.TransferSyntaxUID, []string{ImplicitVRLittleEndian}},
+		{tag.FileMetaInformationGroupLength, []int{4}},
+		{tag.PatientName, []string{"SMITH^JOHN"}},
+	} {
+		elem, err := dicom.NewElement(e.t, e.data)
+		if err != nil {
+			t.Fatalf("NewElement(%v) failed: %v", e.t, err)
+		}
+		extra = append(extra, elem)
+	}
+	// Stale, retired group lengths for the patient and pixel data groups
+	for _, group := range []uint16{0x0010, 0x7FE0} {
+		stale, err := dicom.NewValue([]int{10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		extra = append(extra, &dicom.Element{
+			Tag:                    tag.Tag{Group: group, Element: 0x0000},
+			ValueRepresentation:    tag.VRUInt32List,
+			RawValueRepresentation: "UL",
+			Value:                  stale,
+		})
+	}
+	ds.Data.Elements = append(extra, ds.Data.Elements...)
+	sort.SliceStable(ds.Data.Elements, func(i, j int) bool {
+		a, b := ds.Data.Elements[i].Tag, ds.Data.Elements[j].Tag
+		return a.Group < b.Group || (a.Group == b.Group && a.Element < b.Element)
+	})
+	ds.SetString(tag.PatientName, "ANONYMOUS^PATIENT")
+
+	// Compression switches to an explicit VR transfer syntax and replaces
+	// the pixel data, so the lengths are only known when writing
+	for _, tt := range []struct {
+		name string
+		opts SaveOptions
+	}{
+		{"native", SaveOptions{}},
+		{"RLE", SaveOptions{CompressRLE: true}},
+		{"JPEG-LS", SaveOptions{CompressJPEGLS: true, PreferPureGo: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := ds.Write(&buf, tt.opts); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			path := filepath.Join(t.TempDir(), "a.dcm")
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadDicom(path)
+			if err != nil {
+				t.Fatalf("ReadDicom failed: %v", err)
+			}
+
+			var counter byteCounter
+			w := dicom.NewWriter(&counter, dicom.SkipVRVerification(), dicom.SkipValueTypeVerification())
+			w.SetTransferSyntax(binary.LittleEndian, tt.name == "native")
+			for _, e := range read.Data.Elements {
+				if e.Tag.Group == 0x7FE0 && e.Tag.Element != 0x0000 {
+					if err := w.WriteElement(e); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			for group, want := range map[uint16]int{0x0010: 8 + 18, 0x7FE0: int(counter)} {
+				elem, err := read.Data.FindElementByTag(tag.Tag{Group: group, Element: 0x0000})
+				if err != nil {
+					t.Fatalf("group %04X length missing: %v", group, err)
+				}
+				if got := elem.Value.GetValue().([]int)[0]; got != want {
+					t.Errorf("group %04X length = %d, want %d", group, got, want)
+				}
+			}
+			if got := read.GetString(tag.PatientName); got != "ANONYMOUS^PATIENT" {
+				t.Errorf("PatientName = %q, want ANONYMOUS^PATIENT", got)
+			}
+		})
+	}
+	if got := ds.Data.Elements[2].Value.GetValue().([]int)[0]; got != 10 {
+		t.Errorf("Write changed the dataset's group length to %d", got)
+	}
+}
+
+func TestMonochrome1SurvivesCompression(t *testing.T) {
+	rows, cols := 8, 8
+	values := make([]int, rows*cols)
+	for i := range values {
+		values[i] = i * 3
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts SaveOptions
+	}{
+		{"JPEG-LS", SaveOptions{CompressJPEGLS: true, PreferPureGo: true}},
+		{"RLE", SaveOptions{CompressRLE: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := newTestDataset(t, rows, cols, [][]int{values})
+			elem, err := dicom.NewElement(tag.PhotometricInterpretation, []string{"MONOCHROME1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds.Data.Elements = append(ds.Data.Elements, elem)
+			want, err := ds.extractRawFrames()
+			if err != nil {
+				t.Fatalf("extractRawFrames failed: %v", err)
+			}
+
+			outputPath := filepath.Join(t.TempDir(), "out.dcm")
+			if err := ds.SaveWithOptions(outputPath, tt.opts); err != nil {
+				t.Fatalf("SaveWithOptions failed: %v", err)
+			}
+
+			saved, err := ReadDicom(outputPath)
+			if err != nil {
+				t.Fatalf("ReadDicom failed: %v", err)
+			}
+			if got := saved.GetString(tag.PhotometricInterpretation); got != "MONOCHROME1" {
+				t.Errorf("PhotometricInterpretation = %q, want MONOCHROME1", got)
+			}
+
+			decoded, err := ReadDecoded(outputPath)
+			if errors.Is(err, ErrDcmtkNotInstalled) {
+				t.Skip("dcmtk not installed; pixels not checked")
+			}
+			if err != nil {
+				t.Fatalf("ReadDecoded failed: %v", err)
+			}
+			got, err := decoded.extractRawFrames()
+			if err != nil {
+				t.Fatalf("extractRawFrames after round trip failed: %v", err)
+			}
+			if len(got) != 1 || !bytes.Equal(got[0], want[0]) {
+				t.Errorf("round trip changed the stored samples")
+			}
+
+			// The lowest stored value still displays as white
+			img, err := decoded.FrameImage(0)
+			if err != nil {
+				t.Fatalf("FrameImage failed: %v", err)
+			}
+			if r, _, _, _ := img.At(0, 0).RGBA(); r>>8 != 255 {
+				t.Errorf("lowest value displays as %d, want 255", r>>8)
+			}
+		})
+	}
+}
+
+func TestCompressionRejectsMismatchedPhotometric(t *testing.T) {
+	ds := newTestDataset(t, 4, 4, [][]int{make([]int, 16)})
+	elem, err := dicom.NewElement(tag.PhotometricInterpretation, []string{"RGB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.Data.Elements = append(ds.Data.Elements, elem)
+
+	for _, opts := range []SaveOptions{{CompressJPEGLS: true, PreferPureGo: true}, {CompressRLE: true}} {
+		var buf bytes.Buffer
+		if err := ds.Write(&buf, opts); err == nil {
+			t.Errorf("Write(%+v) accepted RGB with 1 sample per pixel", opts)
+		}
+	}
+}
+
+func TestWriteDeidentificationMethod(t *testing.T) {
+	ds := newTestDataset(t, 2, 2, [][]int{{1, 2, 3, 4}})
+	codes := []CodeItem{
+		{"113100", "DCM", "Basic Application Confidentiality Profile"},
+		{"113107", "DCM", "Retain Longitudinal Temporal Information Modified Dates Option"},
+	}
+	if err := ds.WriteDeidentificationMethod([]string{"Basic Application Confidentiality Profile", "Modified Dates"}, codes); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ds.Write(&buf, SaveOptions{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	read, err := ReadDicomFromReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("ReadDicomFromReader failed: %v", err)
+	}
+
+	elem, err := read.Data.FindElementByTag(tag.DeidentificationMethod)
+	if err != nil {
+		t.Fatal("DeidentificationMethod not written")
+	}
+	if got := elem.Value.GetValue().([]string); len(got) != 2 || got[1] != "Modified Dates" {
+		t.Errorf("DeidentificationMethod = %q", got)
+	}
+	var got []CodeItem
+	read.WalkSequences(func(item *Dataset) {
+		got = append(got, CodeItem{item.GetString(tag.CodeValue), item.GetString(tag.CodingSchemeDesignator), item.GetString(tag.CodeMeaning)})
+	})
+	if len(got) != len(codes) {
+		t.Fatalf("code items = %v, want %v", got, codes)
+	}
+	for i := range codes {
+		if got[i] != codes[i] {
+			t.Errorf("code item %d = %v, want %v", i, got[i], codes[i])
+		}
+	}
+
+	// Without codes, the sequence of an earlier run is removed
+	if err := read.WriteDeidentificationMethod([]string{"tag profile"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := read.Data.FindElementByTag(tag.DeidentificationMethodCodeSequence); err == nil {
+		t.Error("DeidentificationMethodCodeSequence was not removed")
+	}
+}
@@ -0,0 +1,182 @@
+// Package fsutil writes state files (patient mappings, progress) and files
+// anonymized in place so that a crash or power loss never leaves them half
+// written.
+package fsutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BackupSuffix is appended to the file name of the previous version kept by
+// WriteFileAtomic
+const BackupSuffix = ".bak"
+
+// BackupPath returns where WriteFileAtomic keeps the previous version of path
+func BackupPath(path string) string {
+	return path + BackupSuffix
+}
+
+// WriteFileAtomic replaces path with data. The data is written to a
+// temporary file in the same directory, synced to disk and renamed over
+// path, so readers see either the old or the new contents, never a partial
+// write. The previous version is kept at BackupPath(path).
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, data, perm, true)
+}
+
+// WriteFileAtomicNoBackup is WriteFileAtomic for contents whose previous
+// version must not be kept, e.g. a plaintext file being replaced by its
+// encrypted version. Any earlier backup is removed too.
+func WriteFileAtomicNoBackup(path string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(path, data, perm, false)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode, keepBackup bool) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+
+	if keepBackup {
+		if err := backup(path); err != nil {
+			return fmt.Errorf("could not back up %s: %w", path, err)
+		}
+	} else if err := os.Remove(BackupPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove backup of %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// ReplaceFile renames newPath, a complete file in the directory of path,
+// over path. The data of newPath is synced first, so after a crash path
+// holds either its old or its new contents. Unless backupPath is empty
+// the old version is kept there first; an existing backupPath is never
+// overwritten, and any failure leaves path untouched.
+func ReplaceFile(path, newPath, backupPath string) error {
+	f, err := os.Open(newPath)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	if backupPath != "" {
+		if _, err := os.Lstat(backupPath); err == nil {
+			return fmt.Errorf("backup %s already exists", backupPath)
+		}
+		if err := keep(path, backupPath); err != nil {
+			return fmt.Errorf("could not back up %s: %w", path, err)
+		}
+	}
+	if err := os.Rename(newPath, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// backup keeps the current version of path at BackupPath(path)
+func backup(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	bak := BackupPath(path)
+	os.Remove(bak)
+	return keep(path, bak)
+}
+
+// keep makes dst a copy of src. A hard link is tried first since it costs
+// no I/O; file systems without links get a copy, readable only by the
+// owner since the files kept hold patient data. The copy and its directory
+// entry are synced before returning, so the caller's rename never
+// replaces src while its only other copy is still in the page cache.
+func keep(src, dst string) error {
+	if err := link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+	return nil
+}
+
+// link is os.Link, replaced in tests to force the copy
+var link = os.Link
+
+// syncDir flushes a rename to disk. Not supported on Windows, where the
+// error is ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// ReadWithBackup reads path and passes its contents to parse. If parse
+// fails, e.g. because a crash truncated the file, the version kept at
+// BackupPath(path) is parsed instead and recovered is true. The error of the
+// original file is returned when the backup is missing or invalid too; a
+// missing path returns an error satisfying os.IsNotExist.
+func ReadWithBackup(path string, parse func(data []byte) error) (recovered bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	parseErr := parse(data)
+	if parseErr == nil {
+		return false, nil
+	}
+
+	backupData, err := os.ReadFile(BackupPath(path))
+	if err != nil || parse(backupData) != nil {
+		return false, parseErr
+	}
+	return true, nil
+}
@@ -0,0 +1,139 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomicKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	if err := WriteFileAtomic(path, []byte(`{"v":1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte(`{"v":2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != `{"v":2}` {
+		t.Errorf("file = %s, want the new version", data)
+	}
+	if data, _ := os.ReadFile(BackupPath(path)); string(data) != `{"v":1}` {
+		t.Errorf("backup = %s, want the previous version", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory has %d entries, want the file and its backup only", len(entries))
+	}
+}
+
+func TestWriteFileAtomicNoBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	for _, v := range []string{`{"v":1}`, `{"v":2}`} {
+		if err := WriteFileAtomic(path, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteFileAtomicNoBackup(path, []byte(`{"v":3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"v":3}` {
+		t.Errorf("file = %s, want the new version", data)
+	}
+	if _, err := os.Stat(BackupPath(path)); !os.IsNotExist(err) {
+		t.Errorf("backup still exists: %v", err)
+	}
+}
+
+func TestKeepCopyIsPrivate(t *testing.T) {
+	link = func(string, string) error { return os.ErrPermission }
+	defer func() { link = os.Link }()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "mapping.json")
+	if err := os.WriteFile(src, []byte("phi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := BackupPath(src)
+	if err := keep(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("copy has mode %o, want 600", perm)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "phi" {
+		t.Errorf("copy = %q", data)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "IM0001.dcm")
+	backup := path + ".orig"
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(path, "original")
+	write(path+".new", "anonymized")
+	if err := ReplaceFile(path, path+".new", backup); err != nil {
+		t.Fatalf("ReplaceFile failed: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "anonymized" {
+		t.Errorf("file = %s, want the new version", data)
+	}
+	if data, _ := os.ReadFile(backup); string(data) != "original" {
+		t.Errorf("backup = %s, want the original", data)
+	}
+
+	// An existing backup is never overwritten
+	write(path+".new", "again")
+	if err := ReplaceFile(path, path+".new", backup); err == nil {
+		t.Error("ReplaceFile overwrote an existing backup")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "anonymized" {
+		t.Errorf("file = %s after a failed replace, want it untouched", data)
+	}
+}
+
+func TestReadWithBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	valid := func(data []byte) error {
+		if string(data) != "good" {
+			return os.ErrInvalid
+		}
+		return nil
+	}
+
+	if _, err := ReadWithBackup(path, valid); !os.IsNotExist(err) {
+		t.Errorf("missing file error = %v, want not exist", err)
+	}
+
+	os.WriteFile(path, []byte("good"), 0644)
+	if recovered, err := ReadWithBackup(path, valid); err != nil || recovered {
+		t.Errorf("valid file = %v, %v; want false, nil", recovered, err)
+	}
+
+	// Truncated file with a good backup
+	os.WriteFile(BackupPath(path), []byte("good"), 0644)
+	os.WriteFile(path, []byte("go"), 0644)
+	if recovered, err := ReadWithBackup(path, valid); err != nil || !recovered {
+		t.Errorf("damaged file = %v, %v; want true, nil", recovered, err)
+	}
+
+	os.WriteFile(BackupPath(path), []byte("bad"), 0644)
+	if _, err := ReadWithBackup(path, valid); err != os.ErrInvalid {
+		t.Errorf("damaged file and backup error = %v, want the file's error", err)
+	}
+}
@@ -20,6 +20,9 @@ const (
 	AppHeight = 600
 )
 
+// prefLightTheme is the preference key storing the theme choice
+const prefLightTheme = "light_theme"
+
 // App represents the GUI application
 type App struct {
 	fyneApp    fyne.App
@@ -36,7 +39,7 @@ type App struct {
 func NewApp() *App {
 	a := app.New()
 	a.SetIcon(resourceIconPng)
-	a.Settings().SetTheme(&ModernTheme{})
+	applyTheme(a, a.Preferences().BoolWithFallback(prefLightTheme, false))
 
 	return &App{
 		fyneApp: a,
@@ -50,12 +53,15 @@ func (a *App) Run() {
 	// Position window near top of screen (50 pixels from top)
 	a.mainWindow.CenterOnScreen()
 
+	// Temporary files of runs that crashed or were killed
+	go dcm.RemoveStaleTempFiles(dcm.StaleTempFileAge)
+
 	// Create wizard
 	a.wizard = NewWizard(a.mainWindow)
 
-	// Create and set dcmtk status indicator
+	// Create and set dcmtk status indicator, with the theme toggle beside it
 	dcmtkStatus := a.createDcmtkStatusIndicator()
-	a.wizard.SetStatusIndicator(dcmtkStatus)
+	a.wizard.SetStatusIndicator(container.NewHBox(dcmtkStatus, a.createThemeToggle()))
 
 	// Set initial dcmtk status (this will enable/disable the wizard)
 	a.wizard.SetDcmtkInstalled(dcm.CheckDcmtkInstalled())
@@ -117,13 +123,25 @@ func (a *App) Run() {
 
 	a.maybePromptDcmtkInstall()
 
+	// Accept a folder (or a DICOM file inside one) dropped onto Step 1
+	a.mainWindow.SetOnDropped(func(_ fyne.Position, uris []fyne.URI) {
+		if a.wizard.GetCurrentStep() != StepInput || len(uris) == 0 {
+			return
+		}
+		a.steps.SetInputFromDrop(uris[0])
+	})
+
 	// Confirm before closing if processing
 	a.mainWindow.SetCloseIntercept(func() {
 		if a.steps.IsProcessing() {
-			dialog.ShowConfirm("Confirm Exit",
-				"Processing is in progress. Are you sure you want to exit?",
+			message := "Processing is in progress. Are you sure you want to exit?"
+			if a.steps.IsPaused() {
+				message = "Processing is paused. Progress has been saved; run again to resume where it stopped.\n\nAre you sure you want to exit?"
+			}
+			dialog.ShowConfirm("Confirm Exit", message,
 				func(confirm bool) {
 					if confirm {
+						a.steps.CancelProcess()
 						a.mainWindow.Close()
 					}
 				}, a.mainWindow)
@@ -154,29 +172,24 @@ func (a *App) maybePromptDcmtkInstall() {
 	// On subsequent runs, users can click the status indicator to see the dialog
 }
 
-func getDcmtkInstallCommand() string {
+// openFolder opens dir in the system file manager
+func openFolder(dir string) error {
+	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "darwin":
-		return "brew install dcmtk"
-	case "linux":
-		return "sudo apt-get update && sudo apt-get install -y dcmtk"
+		cmd = exec.Command("open", dir)
+	case "windows":
+		cmd = exec.Command("explorer", dir)
 	default:
-		return ""
-	}
-}
-
-func getDcmtkInstallHint() string {
-	cmd := getDcmtkInstallCommand()
-	if cmd == "" {
-		return "Install dcmtk using your system package manager."
+		cmd = exec.Command("xdg-open", dir)
 	}
-	return cmd
+	return cmd.Start()
 }
 
 // createDcmtkStatusIndicator creates a clickable dcmtk status indicator with a colored circle
 func (a *App) createDcmtkStatusIndicator() fyne.CanvasObject {
 	// Create status circle (green if installed, red if not)
-	a.dcmtkStatusCircle = canvas.NewCircle(ColorStatusRed)
+	a.dcmtkStatusCircle = canvas.NewCircle(CurrentPalette().StatusRed)
 	a.dcmtkStatusCircle.StrokeWidth = 0
 
 	// Create label
@@ -185,12 +198,13 @@ func (a *App) createDcmtkStatusIndicator() fyne.CanvasObject {
 
 	// Update initial status (without wizard update since wizard doesn't exist yet)
 	if dcm.CheckDcmtkInstalled() {
-		a.dcmtkStatusCircle.FillColor = ColorStatusGreen
+		a.dcmtkStatusCircle.FillColor = CurrentPalette().StatusGreen
 		a.dcmtkStatusLabel.SetText("dcmtk: OK")
 	} else {
-		a.dcmtkStatusCircle.FillColor = ColorStatusRed
+		a.dcmtkStatusCircle.FillColor = CurrentPalette().StatusRed
 		a.dcmtkStatusLabel.SetText("dcmtk: Missing")
 	}
+	onPaletteChange(func(*Palette) { a.updateDcmtkStatus() })
 
 	// Create a button that shows the status and is clickable
 	statusBtn := widget.NewButton("", func() {
@@ -210,6 +224,29 @@ func (a *App) createDcmtkStatusIndicator() fyne.CanvasObject {
 	return clickableStatus
 }
 
+// createThemeToggle creates a button switching between the dark and light
+// themes. The choice is stored in preferences for the next launch.
+func (a *App) createThemeToggle() fyne.CanvasObject {
+	prefs := a.fyneApp.Preferences()
+
+	var toggle *widget.Button
+	label := func(light bool) string {
+		if light {
+			return "Dark theme"
+		}
+		return "Light theme"
+	}
+	toggle = widget.NewButton(label(prefs.Bool(prefLightTheme)), func() {
+		light := !prefs.Bool(prefLightTheme)
+		prefs.SetBool(prefLightTheme, light)
+		applyTheme(a.fyneApp, light)
+		toggle.SetText(label(light))
+	})
+	toggle.Importance = widget.LowImportance
+
+	return toggle
+}
+
 // dcmtkStatusLayout is a custom layout that vertically centers a circle with a label
 type dcmtkStatusLayout struct{}
 
@@ -246,10 +283,10 @@ func (l *dcmtkStatusLayout) Layout(objects []fyne.CanvasObject, size fyne.Size)
 func (a *App) updateDcmtkStatus() {
 	installed := dcm.CheckDcmtkInstalled()
 	if installed {
-		a.dcmtkStatusCircle.FillColor = ColorStatusGreen
+		a.dcmtkStatusCircle.FillColor = CurrentPalette().StatusGreen
 		a.dcmtkStatusLabel.SetText("dcmtk: OK")
 	} else {
-		a.dcmtkStatusCircle.FillColor = ColorStatusRed
+		a.dcmtkStatusCircle.FillColor = CurrentPalette().StatusRed
 		a.dcmtkStatusLabel.SetText("dcmtk: Missing")
 	}
 	a.dcmtkStatusCircle.Refresh()
@@ -278,7 +315,7 @@ func (a *App) showDcmtkInstallDialog() {
 	}
 	status.Wrapping = fyne.TextWrapWord
 
-	commandLabel := widget.NewLabel(getDcmtkInstallHint())
+	commandLabel := widget.NewLabel(dcm.DcmtkInstallHint())
 	commandLabel.Wrapping = fyne.TextWrapWord
 
 	var installBtn *widget.Button
@@ -287,16 +324,16 @@ func (a *App) showDcmtkInstallDialog() {
 		status.SetText("Installing dcmtk. This may take a minute...")
 		status.Refresh()
 
-		command := getDcmtkInstallCommand()
+		command := dcm.DcmtkInstallCommand()
 		if command == "" {
-			status.SetText("Automatic install is not available on this OS. Use the command below.")
+			status.SetText("Automatic install is not available here. Follow the instructions below.")
 			status.Refresh()
 			installBtn.Enable()
 			return
 		}
 
 		go func() {
-			cmd := exec.Command("bash", "-lc", command)
+			cmd := dcm.ShellCommand(command)
 			output, err := cmd.CombinedOutput()
 			if err != nil {
 				status.SetText("Install failed. See details in the console output.")
@@ -322,7 +359,7 @@ func (a *App) showDcmtkInstallDialog() {
 	content := container.NewVBox(
 		status,
 		widget.NewSeparator(),
-		widget.NewLabel("Manual installation command:"),
+		widget.NewLabel("Manual installation:"),
 		commandLabel,
 		installBtn,
 	)
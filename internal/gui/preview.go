@@ -0,0 +1,69 @@
+package gui
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"strconv"
+
+	"dicom-anonymizer/internal/anonymizer"
+)
+
+// redactionTint is blended over pixels that will be redacted
+var redactionTint = color.NRGBA{R: 0xFA, G: 0x52, B: 0x52, A: 0x99}
+
+// overlayRedaction returns a copy of img with every pixel for which
+// redact(x, y) is true tinted, to preview ultrasound redaction.
+func overlayRedaction(img image.Image, redact func(x, y int) bool) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+
+	tint := [3]uint32{uint32(redactionTint.R), uint32(redactionTint.G), uint32(redactionTint.B)}
+	alpha := uint32(redactionTint.A)
+	for y := 0; y < out.Bounds().Dy(); y++ {
+		for x := 0; x < out.Bounds().Dx(); x++ {
+			if !redact(x, y) {
+				continue
+			}
+			i := out.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				out.Pix[i+c] = uint8((uint32(out.Pix[i+c])*(255-alpha) + tint[c]*alpha) / 255)
+			}
+		}
+	}
+	return out
+}
+
+// previewColumns are the headers of the patient preview table
+var previewColumns = []string{"Anon ID", "Patient", "Match", "Files"}
+
+// previewCell returns the text of column col of row
+func previewCell(row anonymizer.PatientPreviewRow, col int) string {
+	switch col {
+	case 0:
+		return row.AnonID
+	case 1:
+		return row.Display
+	case 2:
+		return row.Method
+	default:
+		return strconv.Itoa(row.FileCount)
+	}
+}
+
+// sortPreviewRows sorts rows by column col, numerically for the file
+// count. Rows that compare equal keep their order.
+func sortPreviewRows(rows []anonymizer.PatientPreviewRow, col int, descending bool) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if descending {
+			a, b = b, a
+		}
+		if col == len(previewColumns)-1 {
+			return a.FileCount < b.FileCount
+		}
+		return previewCell(a, col) < previewCell(b, col)
+	})
+}
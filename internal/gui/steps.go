@@ -1,9 +1,13 @@
 package gui
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -18,6 +22,8 @@ import (
 	"dicom-anonymizer/internal/anonymizer"
 	dcm "dicom-anonymizer/internal/dicom"
 	"dicom-anonymizer/internal/identity"
+	"dicom-anonymizer/internal/logging"
+	"dicom-anonymizer/internal/progress"
 )
 
 // StepBuilder handles creating UI content for each wizard step
@@ -26,31 +32,57 @@ type StepBuilder struct {
 	wizard *Wizard
 
 	// Step 1: Input fields
-	inputFolderEntry  *widget.Entry
-	secretKeyEntry    *widget.Entry
-	secretKeyShowBtn  *widget.Button
-	secretKeyGenBtn   *widget.Button
-	fileCountLabel    *widget.Label
+	inputFolderEntry *widget.Entry
+	secretKeyEntry   *widget.Entry
+	secretKeyShowBtn *widget.Button
+	secretKeyGenBtn  *widget.Button
+	fileCountLabel   *widget.Label
 
 	// Step 2: Settings fields
-	metadataCheck     *widget.Check  // CT/MRI/X-Ray
-	ultrasoundCheck   *widget.Check  // Ultrasound
-	redactRowsEntry   *widget.Entry
-	redactRowsLabel   *widget.Label
-	redactRowsPixels  *widget.Label
-	recursiveCheck    *widget.Check
-	mappingFileEntry  *widget.Entry
-	retryFailedCheck  *widget.Check
+	metadataCheck         *widget.Check // CT/MRI/X-Ray
+	ultrasoundCheck       *widget.Check // Ultrasound
+	redactRowsEntry       *widget.Entry
+	redactRowsLabel       *widget.Label
+	redactRowsPixels      *widget.Label
+	redactRegions         []image.Rectangle
+	redactRegionsBox      *fyne.Container
+	redactRegionsRow      *fyne.Container
+	metadataFallbackCheck *widget.Check // Write JPEG-LS ultrasound without redaction when dcmtk is missing
+	recursiveCheck        *widget.Check
+	maxDepthEntry         *widget.Entry // Directory levels searched, blank = unlimited
+	mappingFileEntry      *widget.Entry
+	retryFailedCheck      *widget.Check
+	inPlaceCheck          *widget.Check // Overwrite originals, confirmed in a dialog
+	inPlaceBackupCheck    *widget.Check
+	keepSexCheck          *widget.Check
+	removePrivateCheck    *widget.Check
+	removeOverlaysCheck   *widget.Check
+	confidentialityCheck  *widget.Check
+	keepInstitutionCheck  *widget.Check
+	keepStudyDescCheck    *widget.Check
 
 	// Step 3: Preview
-	previewProgress    *widget.ProgressBar
-	previewStatus      *widget.Label
-	previewFilesList   *widget.Label
-	previewPatients    *widget.Label
-	previewContainer   *fyne.Container
-	dryRunComplete     bool
-	dryRunStats        *anonymizer.Stats
-	patientPreviewData string
+	previewProgress  *widget.ProgressBar
+	previewStatus    *widget.Label
+	previewFilesList *widget.Label
+	previewPatients  *widget.Label // Tag changes below the patient table
+	previewMatches   *widget.Label
+	previewTable     *widget.Table
+	previewRows      []anonymizer.PatientPreviewRow
+	previewSortCol   int // Column the table is sorted by, -1 = plan order
+	previewSortDesc  bool
+	previewContainer *fyne.Container
+	dryRunComplete   bool
+	dryRunStats      *anonymizer.Stats
+	plan             *anonymizer.Plan // Built by the preview, executed by RunProcess
+
+	// Step 3: Redaction preview of the first ultrasound frame
+	redactPreviewBox     *fyne.Container
+	redactPreviewImage   *canvas.Image
+	redactPreviewLabel   *widget.Label
+	redactSlider         *widget.Slider
+	redactPreviewFrame   image.Image
+	redactPreviewDataset *dcm.Dataset
 
 	// Step 4: Process
 	processProgress    *widget.ProgressBar
@@ -60,7 +92,15 @@ type StepBuilder struct {
 	processStats       *widget.Label
 	processSummary     *widget.Label
 	processContainer   *fyne.Container
+	processCancelBtn   *widget.Button
+	processPauseBtn    *widget.Button
+	processResumeBtn   *widget.Button
+	processErrorsBtn   *widget.Button
+	processFailures    []progress.ErrorEntry
+	processErrorLog    string
+	processPauser      *anonymizer.Pauser
 	processing         bool
+	processCancel      context.CancelFunc
 	processingMu       sync.Mutex
 }
 
@@ -75,7 +115,7 @@ func NewStepBuilder(window fyne.Window, wizard *Wizard) *StepBuilder {
 // BuildStep1 creates the Input step content
 func (s *StepBuilder) BuildStep1() fyne.CanvasObject {
 	// Title
-	titleLabel := canvas.NewText("Select Input", ColorTextPrimary)
+	titleLabel := newThemedText("Select Input", textPrimary)
 	titleLabel.TextSize = 18
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 
@@ -184,7 +224,7 @@ func (s *StepBuilder) generateSecretKey() string {
 // BuildStep2 creates the Settings step content
 func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 	// Title
-	titleLabel := canvas.NewText("Configure Settings", ColorTextPrimary)
+	titleLabel := newThemedText("Configure Settings", textPrimary)
 	titleLabel.TextSize = 18
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 
@@ -201,6 +241,28 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 		s.redactRowsPixels,
 	)
 
+	// Extra redaction regions (x,y,w,h)
+	regionEntry := widget.NewEntry()
+	regionEntry.SetPlaceHolder("x,y,w,h")
+	addRegionBtn := widget.NewButton("Add Region", func() {
+		region, err := anonymizer.ParseRegion(regionEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, s.window)
+			return
+		}
+		s.redactRegions = append(s.redactRegions, region)
+		regionEntry.SetText("")
+		s.refreshRedactRegions()
+	})
+	s.redactRegionsBox = container.NewVBox()
+	s.redactRegionsRow = container.NewVBox(
+		container.NewBorder(nil, nil, widget.NewLabel("Also redact region:"), addRegionBtn, regionEntry),
+		s.redactRegionsBox,
+	)
+
+	// Off by default: users must opt in to un-redacted pixels
+	s.metadataFallbackCheck = widget.NewCheck("Without dcmtk, anonymize JPEG-LS metadata only (pixels NOT redacted)", nil)
+
 	// Modality selection - checkboxes
 	s.metadataCheck = widget.NewCheck("CT / MRI / X-Ray (metadata only)", nil)
 	s.metadataCheck.SetChecked(true) // Default selected
@@ -210,10 +272,14 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 			s.redactRowsLabel.Show()
 			s.redactRowsEntry.Show()
 			s.redactRowsPixels.Show()
+			s.redactRegionsRow.Show()
+			s.metadataFallbackCheck.Show()
 		} else {
 			s.redactRowsLabel.Hide()
 			s.redactRowsEntry.Hide()
 			s.redactRowsPixels.Hide()
+			s.redactRegionsRow.Hide()
+			s.metadataFallbackCheck.Hide()
 		}
 	})
 	s.ultrasoundCheck.SetChecked(true) // Default selected (shows redact rows)
@@ -221,10 +287,48 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 	// Recursive check
 	s.recursiveCheck = widget.NewCheck("Search subdirectories", nil)
 	s.recursiveCheck.SetChecked(true)
+	s.maxDepthEntry = widget.NewEntry()
+	s.maxDepthEntry.SetPlaceHolder("Max depth (all)")
+	s.maxDepthEntry.OnChanged = func(string) { s.updateFileCount() }
 
 	// Retry failed check
 	s.retryFailedCheck = widget.NewCheck("Retry failed files", nil)
 
+	// Off by default and confirmed when turned on: originals are lost
+	s.inPlaceBackupCheck = widget.NewCheck("Keep each original as <name>"+anonymizer.InPlaceBackupSuffix, nil)
+	s.inPlaceBackupCheck.SetChecked(true)
+	s.inPlaceBackupCheck.Hide()
+	s.inPlaceCheck = widget.NewCheck("Anonymize in place (OVERWRITE the original files)", func(checked bool) {
+		if !checked {
+			s.inPlaceBackupCheck.Hide()
+			return
+		}
+		s.inPlaceBackupCheck.Show()
+		dialog.ShowConfirm("Overwrite Original Files?",
+			"In-place anonymization REPLACES every input file with its anonymized version.\n"+
+				"There is no output folder, and without a backup the originals\n"+
+				"and their patient details cannot be recovered.\n\n"+
+				"Only continue if the input folder is itself a copy, or keep the backups.",
+			func(ok bool) {
+				if !ok {
+					s.inPlaceCheck.SetChecked(false)
+				}
+			}, s.window)
+	})
+
+	// Clinical context kept by default (some IRBs require removal)
+	s.keepSexCheck = widget.NewCheck("Patient sex", nil)
+	s.keepSexCheck.SetChecked(true)
+	s.keepInstitutionCheck = widget.NewCheck("Institution name", nil)
+	s.keepInstitutionCheck.SetChecked(true)
+	s.keepStudyDescCheck = widget.NewCheck("Study description", nil)
+	s.keepStudyDescCheck.SetChecked(true)
+	s.removePrivateCheck = widget.NewCheck("Remove private tags", nil)
+	s.removePrivateCheck.SetChecked(true)
+	s.removeOverlaysCheck = widget.NewCheck("Remove overlays", nil)
+	s.removeOverlaysCheck.SetChecked(true)
+	s.confidentialityCheck = widget.NewCheck("PS3.15 Basic Profile", nil)
+
 	// Mapping file (auto-set)
 	s.mappingFileEntry = widget.NewEntry()
 	s.mappingFileEntry.SetPlaceHolder("Auto-set to parent directory")
@@ -241,6 +345,14 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 
 	mappingRow := container.NewBorder(nil, nil, nil, mappingBrowseBtn, s.mappingFileEntry)
 
+	// Presets (the secret key is never saved)
+	savePresetBtn := widget.NewButton("Save Preset", func() {
+		s.savePreset()
+	})
+	loadPresetBtn := widget.NewButton("Load Preset", func() {
+		s.loadPreset()
+	})
+
 	// Build form
 	content := container.NewVBox(
 		titleLabel,
@@ -249,11 +361,20 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 			widget.NewLabelWithStyle("Modality Types", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 			container.NewHBox(s.metadataCheck, s.ultrasoundCheck),
 			redactRow,
+			s.redactRegionsRow,
+			s.metadataFallbackCheck,
 		),
 		widget.NewSeparator(),
 		container.NewVBox(
 			widget.NewLabelWithStyle("Options", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
-			container.NewHBox(s.recursiveCheck, s.retryFailedCheck),
+			container.NewHBox(s.recursiveCheck, s.maxDepthEntry, s.retryFailedCheck, s.removePrivateCheck, s.removeOverlaysCheck),
+			s.confidentialityCheck,
+			container.NewHBox(s.inPlaceCheck, s.inPlaceBackupCheck),
+		),
+		widget.NewSeparator(),
+		container.NewVBox(
+			widget.NewLabelWithStyle("Keep Clinical Context", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			container.NewHBox(s.keepSexCheck, s.keepInstitutionCheck, s.keepStudyDescCheck),
 		),
 		widget.NewSeparator(),
 		container.NewVBox(
@@ -261,15 +382,99 @@ func (s *StepBuilder) BuildStep2() fyne.CanvasObject {
 			widget.NewLabel("Stores patient ID mappings for consistency"),
 			mappingRow,
 		),
+		widget.NewSeparator(),
+		container.NewHBox(savePresetBtn, loadPresetBtn),
 	)
 
 	return container.NewPadded(content)
 }
 
+// presetKeyNote explains why the secret key is not part of a preset
+const presetKeyNote = "The secret key is not stored in presets. Re-enter it on the Input step."
+
+// savePreset writes the current Step 1 and Step 2 settings to a JSON file
+func (s *StepBuilder) savePreset() {
+	dialog.ShowFileSave(func(writer fyne.URIWriteCloser, err error) {
+		if err != nil || writer == nil {
+			return
+		}
+		defer writer.Close()
+
+		if err := anonymizer.WritePreset(writer, s.GetConfig()); err != nil {
+			dialog.ShowError(err, s.window)
+			return
+		}
+		dialog.ShowInformation("Preset Saved", "Settings saved to "+writer.URI().Name()+".\n\n"+presetKeyNote, s.window)
+	}, s.window)
+}
+
+// loadPreset repopulates the Step 1 and Step 2 widgets from a preset file
+func (s *StepBuilder) loadPreset() {
+	dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+		if err != nil || reader == nil {
+			return
+		}
+		defer reader.Close()
+
+		cfg, err := anonymizer.ReadPreset(reader)
+		if err != nil {
+			dialog.ShowError(err, s.window)
+			return
+		}
+		s.applyPreset(cfg)
+		dialog.ShowInformation("Preset Loaded", "Settings loaded from "+reader.URI().Name()+".\n\n"+presetKeyNote, s.window)
+	}, s.window)
+}
+
+// applyPreset sets the form widgets from cfg. The secret key is left as is.
+func (s *StepBuilder) applyPreset(cfg anonymizer.Config) {
+	s.inputFolderEntry.SetText(cfg.InputFolder)
+	s.mappingFileEntry.SetText(cfg.MappingFile) // after the input folder, which auto-sets it
+	s.metadataCheck.SetChecked(cfg.ProcessMetadata)
+	s.ultrasoundCheck.SetChecked(cfg.ProcessUltrasound)
+	if cfg.RedactRows > 0 {
+		s.redactRowsEntry.SetText(strconv.Itoa(cfg.RedactRows))
+	}
+	s.redactRegions = append([]image.Rectangle(nil), cfg.RedactRegions...)
+	s.refreshRedactRegions()
+	s.recursiveCheck.SetChecked(cfg.Recursive)
+	if cfg.MaxDepth > 0 {
+		s.maxDepthEntry.SetText(strconv.Itoa(cfg.MaxDepth))
+	} else {
+		s.maxDepthEntry.SetText("")
+	}
+	s.retryFailedCheck.SetChecked(cfg.RetryFailed)
+	s.keepSexCheck.SetChecked(cfg.KeepSex)
+	s.keepInstitutionCheck.SetChecked(cfg.KeepInstitutionName)
+	s.keepStudyDescCheck.SetChecked(cfg.KeepStudyDescription)
+	s.removePrivateCheck.SetChecked(cfg.RemovePrivateTags)
+	s.removeOverlaysCheck.SetChecked(cfg.RemoveOverlays)
+	s.confidentialityCheck.SetChecked(cfg.ConfidentialityProfile)
+	s.metadataFallbackCheck.SetChecked(cfg.AllowMetadataOnlyFallback)
+	s.inPlaceCheck.SetChecked(false) // Never saved in presets
+}
+
+// refreshRedactRegions rebuilds the list of extra redaction regions
+func (s *StepBuilder) refreshRedactRegions() {
+	s.redactRegionsBox.RemoveAll()
+	for i, region := range s.redactRegions {
+		index := i
+		removeBtn := widget.NewButton("Remove", func() {
+			s.redactRegions = append(s.redactRegions[:index], s.redactRegions[index+1:]...)
+			s.refreshRedactRegions()
+		})
+		s.redactRegionsBox.Add(container.NewHBox(
+			widget.NewLabel(fmt.Sprintf("x=%d y=%d  %dx%d", region.Min.X, region.Min.Y, region.Dx(), region.Dy())),
+			removeBtn,
+		))
+	}
+	s.redactRegionsBox.Refresh()
+}
+
 // BuildStep3 creates the Preview step content
 func (s *StepBuilder) BuildStep3() fyne.CanvasObject {
 	// Title
-	titleLabel := canvas.NewText("Preview (Dry Run)", ColorTextPrimary)
+	titleLabel := newThemedText("Preview (Dry Run)", textPrimary)
 	titleLabel.TextSize = 18
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 
@@ -286,9 +491,61 @@ func (s *StepBuilder) BuildStep3() fyne.CanvasObject {
 	s.previewPatients = widget.NewLabel("")
 	s.previewPatients.Wrapping = fyne.TextWrapWord
 
-	// Scrollable container for preview results
-	previewScroll := container.NewVScroll(s.previewPatients)
-	previewScroll.SetMinSize(fyne.NewSize(0, 200))
+	// Patient mapping, sorted by tapping a column header
+	s.previewMatches = widget.NewLabel("")
+	s.previewSortCol = -1
+	s.previewTable = widget.NewTable(
+		func() (int, int) { return len(s.previewRows), len(previewColumns) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, cell fyne.CanvasObject) {
+			if id.Row < len(s.previewRows) {
+				cell.(*widget.Label).SetText(previewCell(s.previewRows[id.Row], id.Col))
+			}
+		},
+	)
+	s.previewTable.ShowHeaderRow = true
+	s.previewTable.CreateHeader = func() fyne.CanvasObject { return widget.NewButton("", nil) }
+	s.previewTable.UpdateHeader = func(id widget.TableCellID, header fyne.CanvasObject) {
+		button := header.(*widget.Button)
+		title := previewColumns[id.Col]
+		if id.Col == s.previewSortCol && s.previewSortDesc {
+			title += " ▼"
+		} else if id.Col == s.previewSortCol {
+			title += " ▲"
+		}
+		button.SetText(title)
+		button.OnTapped = func() { s.sortPreview(id.Col) }
+	}
+	for col, width := range []float32{130, 260, 260, 70} {
+		s.previewTable.SetColumnWidth(col, width)
+	}
+
+	// Redaction preview, shown when an ultrasound file is found
+	s.redactPreviewImage = canvas.NewImageFromImage(nil)
+	s.redactPreviewImage.FillMode = canvas.ImageFillContain
+	s.redactPreviewImage.SetMinSize(fyne.NewSize(0, 180))
+	s.redactPreviewLabel = widget.NewLabel("")
+	s.redactSlider = widget.NewSlider(1, 1)
+	s.redactSlider.Step = 1
+	s.redactSlider.OnChanged = func(rows float64) {
+		s.redactRowsEntry.SetText(strconv.Itoa(int(rows)))
+		s.updateRedactionPreview()
+	}
+	s.redactPreviewBox = container.NewVBox(
+		widget.NewLabelWithStyle("Redaction Preview", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		s.redactPreviewImage,
+		container.NewBorder(nil, nil, s.redactPreviewLabel, nil, s.redactSlider),
+	)
+	s.redactPreviewBox.Hide()
+
+	// The patient table scrolls on its own above the other results
+	previewScroll := container.NewVScroll(container.NewVBox(s.redactPreviewBox, s.previewPatients))
+	previewScroll.SetMinSize(fyne.NewSize(0, 100))
+	previewSplit := container.NewVSplit(
+		container.NewBorder(s.previewMatches, nil, nil, nil, s.previewTable),
+		previewScroll,
+	)
+	previewSplit.Offset = 0.6
 
 	// Container to update
 	s.previewContainer = container.NewVBox(
@@ -307,14 +564,14 @@ func (s *StepBuilder) BuildStep3() fyne.CanvasObject {
 		nil, // bottom
 		nil, // left
 		nil, // right
-		container.NewPadded(previewScroll), // center (fills remaining space)
+		container.NewPadded(previewSplit), // center (fills remaining space)
 	)
 }
 
 // BuildStep4 creates the Process step content
 func (s *StepBuilder) BuildStep4() fyne.CanvasObject {
 	// Title
-	titleLabel := canvas.NewText("Processing", ColorTextPrimary)
+	titleLabel := newThemedText("Processing", textPrimary)
 	titleLabel.TextSize = 18
 	titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 
@@ -331,21 +588,44 @@ func (s *StepBuilder) BuildStep4() fyne.CanvasObject {
 	s.processSummary = widget.NewLabel("")
 	s.processSummary.Wrapping = fyne.TextWrapWord
 
+	s.processCancelBtn = widget.NewButton("Cancel", func() {
+		s.CancelProcess()
+	})
+	s.processCancelBtn.Disable()
+
+	s.processPauseBtn = widget.NewButton("Pause", func() {
+		s.PauseProcess()
+	})
+	s.processPauseBtn.Disable()
+
+	s.processResumeBtn = widget.NewButton("Resume", func() {
+		s.ResumeProcess()
+	})
+	s.processResumeBtn.Hide()
+
+	processButtons := container.NewHBox(s.processPauseBtn, s.processResumeBtn, s.processCancelBtn)
+
 	// Fixed header content (progress area)
 	headerContent := container.NewVBox(
 		titleLabel,
 		widget.NewSeparator(),
 		s.processProgress,
-		s.processStatus,
+		container.NewBorder(nil, nil, nil, processButtons, s.processStatus),
 		s.processFileCount,
 		s.processCurrentFile,
 		widget.NewSeparator(),
 	)
 
+	s.processErrorsBtn = widget.NewButton("View Errors", func() {
+		s.showErrors()
+	})
+	s.processErrorsBtn.Hide()
+
 	// Scrollable content (stats and summary that can grow)
 	scrollableContent := container.NewVBox(
 		s.processStats,
 		s.processSummary,
+		container.NewHBox(s.processErrorsBtn),
 	)
 	processScroll := container.NewVScroll(scrollableContent)
 	processScroll.SetMinSize(fyne.NewSize(0, 150))
@@ -375,9 +655,14 @@ func (s *StepBuilder) updateFileCount() {
 	}
 
 	s.fileCountLabel.SetText("Scanning...")
+	maxDepth := s.maxDepth()
 
 	go func() {
-		files, err := dcm.FindDicomFiles(inputFolder, true)
+		files, err := dcm.FindDicomFilesWithOptions(inputFolder, dcm.FindOptions{
+			Recursive: true,
+			MaxDepth:  maxDepth,
+			OutputDir: filepath.Join(inputFolder, dcm.DefaultOutputDirName),
+		})
 		count := 0
 		if err == nil {
 			count = len(files)
@@ -392,6 +677,28 @@ func (s *StepBuilder) updateFileCount() {
 	}()
 }
 
+// SetInputFromDrop sets the input folder from an item dropped onto the
+// window. A dropped DICOM file selects its parent directory. Setting the
+// entry text refreshes the file count and mapping file path.
+func (s *StepBuilder) SetInputFromDrop(uri fyne.URI) {
+	path := uri.Path()
+	info, err := os.Stat(path)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("could not open dropped item: %w", err), s.window)
+		return
+	}
+
+	if !info.IsDir() {
+		if ok, _ := dcm.ProbeDicom(path); !ok {
+			dialog.ShowError(fmt.Errorf("%s is not a folder or DICOM file", filepath.Base(path)), s.window)
+			return
+		}
+		path = filepath.Dir(path)
+	}
+
+	s.inputFolderEntry.SetText(path)
+}
+
 // autoSetMappingFile auto-sets the mapping file path based on input folder
 func (s *StepBuilder) autoSetMappingFile() {
 	inputFolder := strings.TrimSpace(s.inputFolderEntry.Text)
@@ -429,37 +736,54 @@ func (s *StepBuilder) ValidateStep2() bool {
 	return true // Settings have defaults
 }
 
+// previewExplainFiles is the number of files whose tag changes the preview lists
+const previewExplainFiles = 3
+
 // RunDryRun executes the dry run scan when entering step 3
 func (s *StepBuilder) RunDryRun() {
 	s.dryRunComplete = false
 	s.dryRunStats = nil
-	s.patientPreviewData = ""
+	s.plan = nil
+	s.previewRows = nil
+	s.previewSortCol = -1
+	s.previewTable.Refresh()
+	s.previewMatches.SetText("")
 
 	s.previewProgress.SetValue(0)
 	s.previewStatus.SetText("Scanning files...")
 	s.previewFilesList.SetText("")
 	s.previewPatients.SetText("")
+	s.redactPreviewBox.Hide()
+	s.redactPreviewFrame = nil
+	s.redactPreviewDataset = nil
 	s.wizard.SetNextEnabled(false)
 
-	inputFolder := strings.TrimSpace(s.inputFolderEntry.Text)
-	mappingFile := strings.TrimSpace(s.mappingFileEntry.Text)
-	if mappingFile == "" {
-		mappingFile = filepath.Join(filepath.Dir(inputFolder), "patient_mapping.json")
-	}
-	salt := s.secretKeyEntry.Text
-	recursive := s.recursiveCheck.Checked
+	previewRedaction := s.ultrasoundCheck.Checked
+	cfg := s.GetConfig()
 
 	go func() {
 		// Find files
 		s.previewStatus.SetText("Finding DICOM files...")
 		s.previewProgress.SetValue(0.1)
 
-		files, err := dcm.FindDicomFiles(inputFolder, recursive)
+		// Build the plan the process step executes: files, patient
+		// groups and their anonymous IDs, saved to the mapping
+		var skipped []string
+		planCfg := cfg
+		planCfg.OutputWriter = func(string) {}
+		planCfg.Logger = logging.NewFunc(func(msg string) {
+			skipped = append(skipped, "  "+strings.TrimSpace(strings.TrimPrefix(msg, "Warning: ")))
+		}, logging.LevelWarn)
+		plan, err := anonymizer.BuildPlan(planCfg)
 		if err != nil {
 			s.previewStatus.SetText(fmt.Sprintf("Error: %v", err))
 			return
 		}
 
+		var files []string
+		for _, patient := range plan.Patients {
+			files = append(files, patient.Files...)
+		}
 		if len(files) == 0 {
 			s.previewStatus.SetText("No DICOM files found")
 			s.previewFilesList.SetText("Please go back and check your input folder path.")
@@ -469,44 +793,63 @@ func (s *StepBuilder) RunDryRun() {
 		s.previewStatus.SetText("Analyzing patient identities...")
 		s.previewProgress.SetValue(0.3)
 		s.previewFilesList.SetText(fmt.Sprintf("Found %d DICOM file(s)", len(files)))
-
-		// Group files by patient
-		mapper := identity.NewPseudonymizationMapper(mappingFile, salt)
-		patients := groupFilesForPreview(files, salt)
+		patients := plan.Patients
 
 		s.previewProgress.SetValue(0.7)
 
-		// Generate preview of patient mappings
-		var previewLines []string
+		// Preview of patient mappings, the rows the CLI dry run prints
 		identityCount := 0
 		pidCount := 0
-
 		for _, patient := range patients {
-			anonID, method := mapper.GetAnonID(patient.PID, patient.Name, patient.DOB)
-
-			if method == identity.MatchIdentity {
+			if patient.Method == identity.MatchIdentity {
 				identityCount++
-				previewLines = append(previewLines, fmt.Sprintf("  %s <- '%s' + DOB (%d files)",
-					anonID, patient.Name, len(patient.Files)))
 			} else {
 				pidCount++
-				previewLines = append(previewLines, fmt.Sprintf("  %s <- PID '%s' (%d files)",
-					anonID, patient.PID, len(patient.Files)))
 			}
 		}
 
-		s.patientPreviewData = strings.Join(previewLines, "\n")
+		if previewRedaction {
+			s.loadRedactionPreview(files)
+		}
+
+		// Tag-level edits for the first few files
+		var changesText string
+		explanations, err := anonymizer.ExplainFiles(cfg, files, previewExplainFiles)
+		if err != nil {
+			changesText = fmt.Sprintf("Tag changes unavailable: %v", err)
+		} else {
+			var lines []string
+			for _, e := range explanations {
+				lines = append(lines, filepath.Base(e.Path)+":")
+				if e.Error != "" {
+					lines = append(lines, "  Error: "+e.Error)
+				}
+				for _, c := range e.Changes {
+					lines = append(lines, "  "+c.String())
+				}
+			}
+			changesText = fmt.Sprintf("Tag Changes (first %d file(s)):\n%s", len(explanations), strings.Join(lines, "\n"))
+		}
 
 		s.previewProgress.SetValue(1.0)
 		s.previewStatus.SetText("Scan complete!")
 
 		filesText := fmt.Sprintf("Files to process: %d\nUnique patients: %d", len(files), len(patients))
+		if r := anonymizer.ResumeSummary(cfg, files); r != nil {
+			filesText += fmt.Sprintf("\n\nEarlier run found: %d new, %d to retry, %d changed, %d already done", r.New, r.Retry, r.Changed, r.Done)
+		}
+		if len(skipped) > 0 {
+			filesText += fmt.Sprintf("\n\nSkipped %d file(s):\n%s", len(skipped), strings.Join(skipped, "\n"))
+		}
 		s.previewFilesList.SetText(filesText)
 
-		patientsText := fmt.Sprintf("Patient ID Mapping Preview:\n(Identity match: %d, PID match: %d)\n\n%s\n\nLooks good? Click \"Process\" to continue.",
-			identityCount, pidCount, s.patientPreviewData)
-		s.previewPatients.SetText(patientsText)
+		s.previewRows = anonymizer.PreviewRows(patients)
+		s.previewTable.Refresh()
+		s.previewMatches.SetText(fmt.Sprintf("Patient ID Mapping Preview (Identity match: %d, PID match: %d). Click a column to sort.",
+			identityCount, pidCount))
+		s.previewPatients.SetText(changesText + "\n\nLooks good? Click \"Process\" to continue.")
 
+		s.plan = plan
 		s.dryRunComplete = true
 		s.dryRunStats = &anonymizer.Stats{
 			TotalPatients:   len(patients),
@@ -517,60 +860,77 @@ func (s *StepBuilder) RunDryRun() {
 	}()
 }
 
-// PatientGroupPreview represents files grouped by patient for preview
-type PatientGroupPreview struct {
-	Key   string
-	Name  string
-	DOB   string
-	PID   string
-	Files []string
+// sortPreview sorts the patient table by column col, reversing the order
+// when it is already sorted by col
+func (s *StepBuilder) sortPreview(col int) {
+	if col == s.previewSortCol {
+		s.previewSortDesc = !s.previewSortDesc
+	} else {
+		s.previewSortCol, s.previewSortDesc = col, false
+	}
+	sortPreviewRows(s.previewRows, s.previewSortCol, s.previewSortDesc)
+	s.previewTable.Refresh()
 }
 
-// groupFilesForPreview groups DICOM files by patient for preview
-func groupFilesForPreview(files []string, salt string) []*PatientGroupPreview {
-	patients := make(map[string]*PatientGroupPreview)
-
-	for _, filePath := range files {
-		ds, err := dcm.ReadDicomMetadataOnly(filePath)
-		if err != nil {
-			if patients["UNKNOWN"] == nil {
-				patients["UNKNOWN"] = &PatientGroupPreview{Key: "UNKNOWN"}
-			}
-			patients["UNKNOWN"].Files = append(patients["UNKNOWN"].Files, filePath)
+// loadRedactionPreview shows the first frame of the first ultrasound file
+// with the area that will be redacted tinted.
+func (s *StepBuilder) loadRedactionPreview(files []string) {
+	for _, path := range files {
+		meta, err := dcm.ReadDicomMetadataOnly(path)
+		if err != nil || !meta.IsUltrasound() {
 			continue
 		}
 
-		name := ds.GetPatientName()
-		dob := ds.GetPatientBirthDate()
-		pid := ds.GetPatientID()
-		if pid == "" {
-			pid = "UNKNOWN"
+		img, ds, err := dcm.ReadFrameImage(path, 0)
+		if err != nil {
+			s.redactPreviewLabel.SetText(fmt.Sprintf("Could not load %s: %v", filepath.Base(path), err))
+			s.redactPreviewBox.Show()
+			return
 		}
 
-		var key string
-		if identity.IsValidIdentity(name, dob) {
-			key = identity.CreateIdentityHash(name, dob, salt)
-		} else {
-			key = "PID:" + pid
-		}
+		s.redactPreviewFrame = img
+		s.redactPreviewDataset = ds
+		s.redactSlider.Max = float64(max(img.Bounds().Dy(), 1))
+		s.redactSlider.SetValue(float64(s.redactRows()))
+		s.updateRedactionPreview()
+		s.redactPreviewBox.Show()
+		return
+	}
+}
 
-		if patients[key] == nil {
-			patients[key] = &PatientGroupPreview{
-				Key:  key,
-				Name: name,
-				DOB:  dob,
-				PID:  pid,
-			}
-		}
-		patients[key].Files = append(patients[key].Files, filePath)
+// updateRedactionPreview redraws the preview frame for the current settings
+func (s *StepBuilder) updateRedactionPreview() {
+	if s.redactPreviewFrame == nil {
+		return
 	}
+	rows := s.redactRows()
+	redact := anonymizer.RedactionMask(s.redactPreviewDataset, rows, s.redactRegions)
+	s.redactPreviewImage.Image = overlayRedaction(s.redactPreviewFrame, redact)
+	s.redactPreviewImage.Refresh()
+	s.redactPreviewLabel.SetText(fmt.Sprintf("Top rows: %d", rows))
+}
 
-	result := make([]*PatientGroupPreview, 0, len(patients))
-	for _, p := range patients {
-		result = append(result, p)
+// redactRows returns the top rows to redact from the settings step
+func (s *StepBuilder) redactRows() int {
+	redactRows := 75
+	if s.redactRowsEntry.Text != "" {
+		if val, err := strconv.Atoi(s.redactRowsEntry.Text); err == nil && val > 0 {
+			redactRows = val
+		}
 	}
+	return redactRows
+}
 
-	return result
+// maxDepth returns the directory levels to search (0 = unlimited). The
+// settings step may not be built yet when step 1 counts files.
+func (s *StepBuilder) maxDepth() int {
+	if s.maxDepthEntry == nil {
+		return 0
+	}
+	if val, err := strconv.Atoi(strings.TrimSpace(s.maxDepthEntry.Text)); err == nil && val > 0 {
+		return val
+	}
+	return 0
 }
 
 // RunProcess executes the actual anonymization
@@ -581,6 +941,10 @@ func (s *StepBuilder) RunProcess() {
 		return
 	}
 	s.processing = true
+	ctx, cancel := context.WithCancel(context.Background())
+	s.processCancel = cancel
+	pauser := anonymizer.NewPauser()
+	s.processPauser = pauser
 	s.processingMu.Unlock()
 
 	s.processProgress.SetValue(0)
@@ -589,8 +953,15 @@ func (s *StepBuilder) RunProcess() {
 	s.processCurrentFile.SetText("")
 	s.processStats.SetText("")
 	s.processSummary.SetText("")
+	s.processErrorsBtn.Hide()
+	s.processFailures = nil
+	s.processErrorLog = ""
 	s.wizard.SetBackEnabled(false)
 	s.wizard.SetNextEnabled(false)
+	s.processCancelBtn.Enable()
+	s.processPauseBtn.Enable()
+	s.processPauseBtn.Show()
+	s.processResumeBtn.Hide()
 
 	// Build config
 	inputFolder := strings.TrimSpace(s.inputFolderEntry.Text)
@@ -599,31 +970,48 @@ func (s *StepBuilder) RunProcess() {
 		mappingFile = filepath.Join(filepath.Dir(inputFolder), "patient_mapping.json")
 	}
 
-	redactRows := 75
-	if s.redactRowsEntry.Text != "" {
-		if val, err := strconv.Atoi(s.redactRowsEntry.Text); err == nil && val > 0 {
-			redactRows = val
-		}
-	}
+	redactRows := s.redactRows()
 
 	cfg := anonymizer.Config{
-		InputFolder:       inputFolder,
-		MappingFile:       mappingFile,
-		Salt:              s.secretKeyEntry.Text,
-		RedactRows:        redactRows,
-		DryRun:            false,
-		RetryFailed:       s.retryFailedCheck.Checked,
-		Recursive:         s.recursiveCheck.Checked,
-		ProcessMetadata:   s.metadataCheck.Checked,
-		ProcessUltrasound: s.ultrasoundCheck.Checked,
-		OutputWriter:      func(msg string) {}, // We use progress callback instead
+		InputFolder:          inputFolder,
+		MappingFile:          mappingFile,
+		Salt:                 s.secretKeyEntry.Text,
+		RedactRows:           redactRows,
+		RedactRegions:        append([]image.Rectangle(nil), s.redactRegions...),
+		DryRun:               false,
+		RetryFailed:          s.retryFailedCheck.Checked,
+		Recursive:            s.recursiveCheck.Checked,
+		MaxDepth:             s.maxDepth(),
+		ProcessMetadata:      s.metadataCheck.Checked,
+		ProcessUltrasound:    s.ultrasoundCheck.Checked,
+		KeepSex:              s.keepSexCheck.Checked,
+		KeepInstitutionName:  s.keepInstitutionCheck.Checked,
+		KeepStudyDescription: s.keepStudyDescCheck.Checked,
+		RemovePrivateTags:    s.removePrivateCheck.Checked,
+		RemoveOverlays:       s.removeOverlaysCheck.Checked,
+
+		ConfidentialityProfile:    s.confidentialityCheck.Checked,
+		AllowMetadataOnlyFallback: s.metadataFallbackCheck.Checked,
+		InPlace:                   s.inPlaceCheck.Checked,
+		InPlaceBackup:             s.inPlaceCheck.Checked && s.inPlaceBackupCheck.Checked,
+		Pauser:                    pauser,
+		OutputWriter:              func(msg string) {}, // We use progress callback instead
 	}
 
+	plan := s.plan
+
 	go func() {
 		defer func() {
 			s.processingMu.Lock()
 			s.processing = false
+			s.processCancel = nil
+			s.processPauser = nil
 			s.processingMu.Unlock()
+			cancel()
+			s.processCancelBtn.Disable()
+			s.processPauseBtn.Disable()
+			s.processPauseBtn.Show()
+			s.processResumeBtn.Hide()
 		}()
 
 		// Progress callback
@@ -644,16 +1032,44 @@ func (s *StepBuilder) RunProcess() {
 			// Update UI - Fyne v2.4 handles thread safety for widget updates
 			progress := float64(current) / float64(total)
 			s.processProgress.SetValue(progress)
-			s.processFileCount.SetText(fmt.Sprintf("Processing %d/%d files", current, total))
-			s.processCurrentFile.SetText(fmt.Sprintf("Current: %s", filename))
+			if pauser.Paused() {
+				s.processFileCount.SetText(fmt.Sprintf("Paused at %d/%d files", current, total))
+			} else {
+				s.processFileCount.SetText(fmt.Sprintf("Processing %d/%d files", current, total))
+			}
+			if strings.HasPrefix(status, "compressing") {
+				s.processCurrentFile.SetText(fmt.Sprintf("Current: %s (%s)", filename, status))
+			} else {
+				s.processCurrentFile.SetText(fmt.Sprintf("Current: %s", filename))
+			}
 			s.processStats.SetText(fmt.Sprintf("Success: %d | Skipped: %d | Failed: %d",
 				successCount, skippedCount, failedCount))
 		}
 
-		stats, err := anonymizer.ProcessFolderWithProgress(cfg, progressCallback)
+		// Execute the plan the preview showed, so the IDs processed are
+		// the ones the user confirmed
+		var stats *anonymizer.Stats
+		var err error
+		if plan != nil {
+			stats, err = anonymizer.ExecutePlanWithContext(ctx, plan, cfg, progressCallback)
+		} else {
+			stats, err = anonymizer.ProcessFolderWithContext(ctx, cfg, progressCallback)
+		}
+
+		// Keep the failed files for the View Errors dialog
+		if stats != nil && stats.Failed > 0 {
+			s.processFailures = stats.Failures
+			s.processErrorLog = stats.ErrorLog
+			s.processErrorsBtn.Show()
+		}
 
 		// Update UI with final state
-		if err != nil {
+		if errors.Is(err, context.Canceled) {
+			s.processStatus.SetText("Cancelled")
+			s.processStats.SetText(fmt.Sprintf("Success: %d | Skipped: %d | Failed: %d",
+				stats.Success, stats.Skipped, stats.Failed))
+			s.processSummary.SetText("Processing was cancelled. Progress has been saved; run again to resume where it stopped.")
+		} else if err != nil {
 			s.processStatus.SetText("Error!")
 			s.processSummary.SetText(fmt.Sprintf("Error: %v", err))
 		} else {
@@ -661,10 +1077,14 @@ func (s *StepBuilder) RunProcess() {
 			s.processStatus.SetText("Complete!")
 			s.processStats.SetText(fmt.Sprintf("Success: %d | Skipped: %d | Failed: %d",
 				stats.Success, stats.Skipped, stats.Failed))
-			s.processSummary.SetText(fmt.Sprintf(
-				"Processed %d patient(s)\nIdentity matched: %d\nPatientID matched: %d\n\nOutput: %s/anonymized\nMapping: %s",
+			summary := fmt.Sprintf(
+				"Processed %d patient(s)\nIdentity matched: %d\nPatientID matched: %d\n\nOutput: %s\nMapping: %s",
 				stats.TotalPatients, stats.IdentityMatched, stats.PIDMatched,
-				inputFolder, mappingFile))
+				cfg.OutputDir(), mappingFile)
+			if stats.PixelsNotRedacted > 0 {
+				summary += fmt.Sprintf("\n\nWARNING: %d ultrasound file(s) have pixels NOT redacted (dcmtk not installed)", stats.PixelsNotRedacted)
+			}
+			s.processSummary.SetText(summary)
 		}
 
 		s.wizard.SetNextText("Done")
@@ -672,6 +1092,42 @@ func (s *StepBuilder) RunProcess() {
 	}()
 }
 
+// showErrors lists the files that failed in the last run
+func (s *StepBuilder) showErrors() {
+	var lines []string
+	for _, entry := range s.processFailures {
+		lines = append(lines, fmt.Sprintf("%s\n    [%s] %s", entry.File, entry.Category, entry.Error))
+	}
+	text := strings.Join(lines, "\n")
+
+	list := widget.NewLabel(text)
+	list.Wrapping = fyne.TextWrapWord
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(520, 280))
+
+	copyBtn := widget.NewButton("Copy", func() {
+		s.window.Clipboard().SetContent(text)
+	})
+	openBtn := widget.NewButton("Open Containing Folder", func() {
+		if err := openFolder(filepath.Dir(s.processErrorLog)); err != nil {
+			dialog.ShowError(err, s.window)
+		}
+	})
+	if s.processErrorLog == "" {
+		openBtn.Disable()
+	}
+
+	content := container.NewBorder(
+		widget.NewLabel(fmt.Sprintf("%d file(s) failed. Full log: %s", len(s.processFailures), s.processErrorLog)),
+		container.NewHBox(copyBtn, openBtn),
+		nil, nil,
+		scroll,
+	)
+	d := dialog.NewCustom("Failed Files", "Close", content, s.window)
+	d.Resize(fyne.NewSize(600, 420))
+	d.Show()
+}
+
 // GetConfig builds the anonymizer config from the current form values
 func (s *StepBuilder) GetConfig() anonymizer.Config {
 	inputFolder := strings.TrimSpace(s.inputFolderEntry.Text)
@@ -680,26 +1136,85 @@ func (s *StepBuilder) GetConfig() anonymizer.Config {
 		mappingFile = filepath.Join(filepath.Dir(inputFolder), "patient_mapping.json")
 	}
 
-	redactRows := 75
-	if s.redactRowsEntry.Text != "" {
-		if val, err := strconv.Atoi(s.redactRowsEntry.Text); err == nil && val > 0 {
-			redactRows = val
-		}
-	}
+	redactRows := s.redactRows()
 
 	return anonymizer.Config{
-		InputFolder:       inputFolder,
-		MappingFile:       mappingFile,
-		Salt:              s.secretKeyEntry.Text,
-		RedactRows:        redactRows,
-		DryRun:            false,
-		RetryFailed:       s.retryFailedCheck.Checked,
-		Recursive:         s.recursiveCheck.Checked,
-		ProcessMetadata:   s.metadataCheck.Checked,
-		ProcessUltrasound: s.ultrasoundCheck.Checked,
+		InputFolder:          inputFolder,
+		MappingFile:          mappingFile,
+		Salt:                 s.secretKeyEntry.Text,
+		RedactRows:           redactRows,
+		RedactRegions:        append([]image.Rectangle(nil), s.redactRegions...),
+		DryRun:               false,
+		RetryFailed:          s.retryFailedCheck.Checked,
+		Recursive:            s.recursiveCheck.Checked,
+		MaxDepth:             s.maxDepth(),
+		ProcessMetadata:      s.metadataCheck.Checked,
+		ProcessUltrasound:    s.ultrasoundCheck.Checked,
+		KeepSex:              s.keepSexCheck.Checked,
+		KeepInstitutionName:  s.keepInstitutionCheck.Checked,
+		KeepStudyDescription: s.keepStudyDescCheck.Checked,
+		RemovePrivateTags:    s.removePrivateCheck.Checked,
+		RemoveOverlays:       s.removeOverlaysCheck.Checked,
+
+		ConfidentialityProfile:    s.confidentialityCheck.Checked,
+		AllowMetadataOnlyFallback: s.metadataFallbackCheck.Checked,
+		InPlace:                   s.inPlaceCheck.Checked,
+		InPlaceBackup:             s.inPlaceCheck.Checked && s.inPlaceBackupCheck.Checked,
 	}
 }
 
+// CancelProcess stops a running process after the files in flight finish
+func (s *StepBuilder) CancelProcess() {
+	s.processingMu.Lock()
+	cancel := s.processCancel
+	s.processingMu.Unlock()
+
+	if cancel != nil {
+		s.processStatus.SetText("Cancelling...")
+		s.processCancelBtn.Disable()
+		cancel()
+	}
+}
+
+// PauseProcess pauses a running process once the files in flight finish
+func (s *StepBuilder) PauseProcess() {
+	s.processingMu.Lock()
+	pauser := s.processPauser
+	s.processingMu.Unlock()
+
+	if pauser == nil {
+		return
+	}
+	pauser.Pause()
+	s.processStatus.SetText("Paused")
+	s.processFileCount.SetText(strings.Replace(s.processFileCount.Text, "Processing", "Paused at", 1))
+	s.processPauseBtn.Hide()
+	s.processResumeBtn.Show()
+}
+
+// ResumeProcess continues a paused process with the remaining files
+func (s *StepBuilder) ResumeProcess() {
+	s.processingMu.Lock()
+	pauser := s.processPauser
+	s.processingMu.Unlock()
+
+	if pauser == nil {
+		return
+	}
+	pauser.Resume()
+	s.processStatus.SetText("Processing...")
+	s.processFileCount.SetText(strings.Replace(s.processFileCount.Text, "Paused at", "Processing", 1))
+	s.processResumeBtn.Hide()
+	s.processPauseBtn.Show()
+}
+
+// IsPaused returns whether a running process is paused
+func (s *StepBuilder) IsPaused() bool {
+	s.processingMu.Lock()
+	defer s.processingMu.Unlock()
+	return s.processPauser.Paused()
+}
+
 // IsProcessing returns whether processing is in progress
 func (s *StepBuilder) IsProcessing() bool {
 	s.processingMu.Lock()
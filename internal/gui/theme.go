@@ -4,6 +4,7 @@ import (
 	"image/color"
 
 	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/theme"
 )
 
@@ -27,6 +28,153 @@ var (
 	ColorStatusRed       = color.NRGBA{R: 0xFA, G: 0x52, B: 0x52, A: 0xFF} // #FA5252 - bright red
 )
 
+// Light theme colors
+var (
+	ColorLightBackground      = color.NRGBA{R: 0xEF, G: 0xF1, B: 0xF5, A: 0xFF} // #EFF1F5
+	ColorLightCardBackground  = color.NRGBA{R: 0xE6, G: 0xE9, B: 0xEF, A: 0xFF} // #E6E9EF
+	ColorLightPrimaryAccent   = color.NRGBA{R: 0x1E, G: 0x66, B: 0xF5, A: 0xFF} // #1E66F5
+	ColorLightSuccess         = color.NRGBA{R: 0x40, G: 0xA0, B: 0x2B, A: 0xFF} // #40A02B
+	ColorLightWarning         = color.NRGBA{R: 0xDF, G: 0x8E, B: 0x1D, A: 0xFF} // #DF8E1D
+	ColorLightError           = color.NRGBA{R: 0xD2, G: 0x0F, B: 0x39, A: 0xFF} // #D20F39
+	ColorLightTextPrimary     = color.NRGBA{R: 0x4C, G: 0x4F, B: 0x69, A: 0xFF} // #4C4F69
+	ColorLightTextSecondary   = color.NRGBA{R: 0x6C, G: 0x6F, B: 0x85, A: 0xFF} // #6C6F85
+	ColorLightDisabled        = color.NRGBA{R: 0x9C, G: 0xA0, B: 0xB0, A: 0xFF} // #9CA0B0
+	ColorLightInputBackground = color.NRGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF} // #FFFFFF
+	ColorLightBorder          = color.NRGBA{R: 0xBC, G: 0xC0, B: 0xCC, A: 0xFF} // #BCC0CC
+	ColorLightHover           = color.NRGBA{R: 0x1A, G: 0x56, B: 0xD6, A: 0xFF} // #1A56D6 - slightly darker blue for hover
+)
+
+// Palette is the set of colors a theme draws with
+type Palette struct {
+	Background      color.Color
+	CardBackground  color.Color
+	PrimaryAccent   color.Color
+	Success         color.Color
+	Warning         color.Color
+	Error           color.Color
+	TextPrimary     color.Color
+	TextSecondary   color.Color
+	Disabled        color.Color
+	InputBackground color.Color
+	Border          color.Color
+	Hover           color.Color
+	Pressed         color.Color
+	Selection       color.Color
+	Shadow          color.Color
+	StepInactive    color.Color
+	StepComplete    color.Color
+	StatusGreen     color.Color
+	StatusRed       color.Color
+	Variant         fyne.ThemeVariant // Fallback variant for colors not in the palette
+}
+
+// DarkPalette is the palette of ModernTheme
+var DarkPalette = Palette{
+	Background:      ColorBackground,
+	CardBackground:  ColorCardBackground,
+	PrimaryAccent:   ColorPrimaryAccent,
+	Success:         ColorSuccess,
+	Warning:         ColorWarning,
+	Error:           ColorError,
+	TextPrimary:     ColorTextPrimary,
+	TextSecondary:   ColorTextSecondary,
+	Disabled:        ColorDisabled,
+	InputBackground: ColorInputBackground,
+	Border:          ColorBorder,
+	Hover:           ColorHover,
+	Pressed:         color.NRGBA{R: 0x2E, G: 0xCC, B: 0x71, A: 0xFF}, // Bright green #2ECC71
+	Selection:       color.NRGBA{R: 0x89, G: 0xB4, B: 0xFA, A: 0x66},
+	Shadow:          color.NRGBA{R: 0x00, G: 0x00, B: 0x00, A: 0x66},
+	StepInactive:    ColorStepInactive,
+	StepComplete:    ColorStepComplete,
+	StatusGreen:     ColorStatusGreen,
+	StatusRed:       ColorStatusRed,
+	Variant:         theme.VariantDark,
+}
+
+// LightPalette is the palette of LightTheme
+var LightPalette = Palette{
+	Background:      ColorLightBackground,
+	CardBackground:  ColorLightCardBackground,
+	PrimaryAccent:   ColorLightPrimaryAccent,
+	Success:         ColorLightSuccess,
+	Warning:         ColorLightWarning,
+	Error:           ColorLightError,
+	TextPrimary:     ColorLightTextPrimary,
+	TextSecondary:   ColorLightTextSecondary,
+	Disabled:        ColorLightDisabled,
+	InputBackground: ColorLightInputBackground,
+	Border:          ColorLightBorder,
+	Hover:           ColorLightHover,
+	Pressed:         ColorLightSuccess,
+	Selection:       color.NRGBA{R: 0x1E, G: 0x66, B: 0xF5, A: 0x44},
+	Shadow:          color.NRGBA{R: 0x00, G: 0x00, B: 0x00, A: 0x33},
+	StepInactive:    ColorLightBorder,
+	StepComplete:    ColorLightSuccess,
+	StatusGreen:     ColorStatusGreen,
+	StatusRed:       ColorStatusRed,
+	Variant:         theme.VariantLight,
+}
+
+// The active palette and the objects that redraw when it changes. Only
+// touched from the UI goroutine.
+var (
+	activePalette    = &DarkPalette
+	paletteListeners []func(*Palette)
+)
+
+// CurrentPalette returns the palette of the active theme
+func CurrentPalette() *Palette {
+	return activePalette
+}
+
+// onPaletteChange registers fn to be called after the theme is switched.
+// Custom-drawn canvas objects use it to pick up the new colors.
+func onPaletteChange(fn func(*Palette)) {
+	paletteListeners = append(paletteListeners, fn)
+}
+
+// applyTheme switches the app to the light or dark theme and recolors
+// custom-drawn canvas objects.
+func applyTheme(app fyne.App, light bool) {
+	if light {
+		activePalette = &LightPalette
+		app.Settings().SetTheme(&LightTheme{})
+	} else {
+		activePalette = &DarkPalette
+		app.Settings().SetTheme(&ModernTheme{})
+	}
+	for _, fn := range paletteListeners {
+		fn(activePalette)
+	}
+}
+
+// Palette color pickers for themed canvas objects
+func textPrimary(p *Palette) color.Color    { return p.TextPrimary }
+func textSecondary(p *Palette) color.Color  { return p.TextSecondary }
+func cardBackground(p *Palette) color.Color { return p.CardBackground }
+func borderColor(p *Palette) color.Color    { return p.Border }
+
+// newThemedText creates canvas text whose color follows theme switches
+func newThemedText(text string, pick func(*Palette) color.Color) *canvas.Text {
+	t := canvas.NewText(text, pick(CurrentPalette()))
+	onPaletteChange(func(p *Palette) {
+		t.Color = pick(p)
+		t.Refresh()
+	})
+	return t
+}
+
+// newThemedRectangle creates a rectangle whose fill follows theme switches
+func newThemedRectangle(pick func(*Palette) color.Color) *canvas.Rectangle {
+	r := canvas.NewRectangle(pick(CurrentPalette()))
+	onPaletteChange(func(p *Palette) {
+		r.FillColor = pick(p)
+		r.Refresh()
+	})
+	return r
+}
+
 // ModernTheme implements the modern dark theme
 type ModernTheme struct{}
 
@@ -34,55 +182,73 @@ var _ fyne.Theme = (*ModernTheme)(nil)
 
 // Color returns the color for the given theme color name
 func (m *ModernTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	return paletteColor(&DarkPalette, name)
+}
+
+// LightTheme implements the light theme. Fonts, icons and sizes are
+// shared with ModernTheme.
+type LightTheme struct {
+	ModernTheme
+}
+
+var _ fyne.Theme = (*LightTheme)(nil)
+
+// Color returns the color for the given theme color name
+func (l *LightTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
+	return paletteColor(&LightPalette, name)
+}
+
+// paletteColor maps a theme color name to a palette color
+func paletteColor(p *Palette, name fyne.ThemeColorName) color.Color {
 	switch name {
 	case theme.ColorNameBackground:
-		return ColorBackground
+		return p.Background
 	case theme.ColorNameButton:
-		return ColorPrimaryAccent
+		return p.PrimaryAccent
 	case theme.ColorNameDisabledButton:
-		return ColorDisabled
+		return p.Disabled
 	case theme.ColorNameDisabled:
-		return ColorDisabled
+		return p.Disabled
 	case theme.ColorNameError:
-		return ColorError
+		return p.Error
 	case theme.ColorNameFocus:
-		return ColorPrimaryAccent
+		return p.PrimaryAccent
 	case theme.ColorNameForeground:
-		return ColorTextPrimary
+		return p.TextPrimary
 	case theme.ColorNameHeaderBackground:
-		return ColorCardBackground
+		return p.CardBackground
 	case theme.ColorNameHover:
-		return ColorHover
+		return p.Hover
 	case theme.ColorNameHyperlink:
-		return ColorPrimaryAccent
+		return p.PrimaryAccent
 	case theme.ColorNameInputBackground:
-		return ColorInputBackground
+		return p.InputBackground
 	case theme.ColorNameInputBorder:
-		return ColorBorder
+		return p.Border
 	case theme.ColorNameMenuBackground:
-		return ColorCardBackground
+		return p.CardBackground
 	case theme.ColorNameOverlayBackground:
-		return ColorCardBackground
+		return p.CardBackground
 	case theme.ColorNamePlaceHolder:
-		return ColorTextSecondary
+		return p.TextSecondary
 	case theme.ColorNamePressed:
-		return color.NRGBA{R: 0x2E, G: 0xCC, B: 0x71, A: 0xFF} // Bright green #2ECC71
+		return p.Pressed
 	case theme.ColorNamePrimary:
-		return ColorPrimaryAccent
+		return p.PrimaryAccent
 	case theme.ColorNameScrollBar:
-		return ColorBorder
+		return p.Border
 	case theme.ColorNameSelection:
-		return color.NRGBA{R: 0x89, G: 0xB4, B: 0xFA, A: 0x66}
+		return p.Selection
 	case theme.ColorNameSeparator:
-		return ColorBorder
+		return p.Border
 	case theme.ColorNameShadow:
-		return color.NRGBA{R: 0x00, G: 0x00, B: 0x00, A: 0x66}
+		return p.Shadow
 	case theme.ColorNameSuccess:
-		return ColorSuccess
+		return p.Success
 	case theme.ColorNameWarning:
-		return ColorWarning
+		return p.Warning
 	default:
-		return theme.DefaultTheme().Color(name, theme.VariantDark)
+		return theme.DefaultTheme().Color(name, p.Variant)
 	}
 }
 
@@ -106,13 +106,13 @@ func (w *Wizard) createStepIndicator() {
 
 	for i, info := range stepInfos {
 		// Create circle for step indicator
-		circle := canvas.NewCircle(ColorStepInactive)
-		circle.StrokeColor = ColorBorder
+		circle := canvas.NewCircle(CurrentPalette().StepInactive)
+		circle.StrokeColor = CurrentPalette().Border
 		circle.StrokeWidth = 2
 		w.stepIndicators[i] = circle
 
 		// Create label for step
-		label := canvas.NewText(info.Title, ColorTextSecondary)
+		label := canvas.NewText(info.Title, CurrentPalette().TextSecondary)
 		label.TextSize = 12
 		label.Alignment = fyne.TextAlignCenter
 		w.stepLabels[i] = label
@@ -128,7 +128,7 @@ func (w *Wizard) createStepIndicator() {
 
 		// Add connecting line between steps (except after last)
 		if i < len(stepInfos)-1 {
-			line := canvas.NewRectangle(ColorBorder)
+			line := newThemedRectangle(borderColor)
 			lineContainer := container.New(&stepLineLayout{}, line)
 			items = append(items, lineContainer)
 		}
@@ -136,6 +136,7 @@ func (w *Wizard) createStepIndicator() {
 
 	w.stepIndicator = container.NewHBox(items...)
 	w.updateStepIndicator()
+	onPaletteChange(func(*Palette) { w.updateStepIndicator() })
 }
 
 // stepCircleLayout is a custom layout for step indicator circles
@@ -168,23 +169,24 @@ func (l *stepLineLayout) Layout(objects []fyne.CanvasObject, size fyne.Size) {
 
 // updateStepIndicator updates the visual state of step indicators
 func (w *Wizard) updateStepIndicator() {
+	p := CurrentPalette()
 	for i := range stepInfos {
 		step := WizardStep(i)
 		if step < w.currentStep {
 			// Completed step
-			w.stepIndicators[i].FillColor = ColorStepComplete
-			w.stepIndicators[i].StrokeColor = ColorStepComplete
-			w.stepLabels[i].Color = ColorTextPrimary
+			w.stepIndicators[i].FillColor = p.StepComplete
+			w.stepIndicators[i].StrokeColor = p.StepComplete
+			w.stepLabels[i].Color = p.TextPrimary
 		} else if step == w.currentStep {
 			// Current step
-			w.stepIndicators[i].FillColor = ColorPrimaryAccent
-			w.stepIndicators[i].StrokeColor = ColorPrimaryAccent
-			w.stepLabels[i].Color = ColorTextPrimary
+			w.stepIndicators[i].FillColor = p.PrimaryAccent
+			w.stepIndicators[i].StrokeColor = p.PrimaryAccent
+			w.stepLabels[i].Color = p.TextPrimary
 		} else {
 			// Future step
-			w.stepIndicators[i].FillColor = ColorStepInactive
-			w.stepIndicators[i].StrokeColor = ColorBorder
-			w.stepLabels[i].Color = ColorTextSecondary
+			w.stepIndicators[i].FillColor = p.StepInactive
+			w.stepIndicators[i].StrokeColor = p.Border
+			w.stepLabels[i].Color = p.TextSecondary
 		}
 		w.stepIndicators[i].Refresh()
 		w.stepLabels[i].Refresh()
@@ -351,7 +353,7 @@ func (w *Wizard) Build() fyne.CanvasObject {
 	}
 
 	// Card background for content
-	contentBg := canvas.NewRectangle(ColorCardBackground)
+	contentBg := newThemedRectangle(cardBackground)
 	contentBg.CornerRadius = 8
 
 	contentCard := container.NewStack(
@@ -371,7 +373,7 @@ func (w *Wizard) Build() fyne.CanvasObject {
 	stepIndicatorCentered := container.NewCenter(w.stepIndicator)
 
 	// Separator line
-	separator := canvas.NewRectangle(ColorBorder)
+	separator := newThemedRectangle(borderColor)
 	separator.SetMinSize(fyne.NewSize(0, 1))
 
 	// Navigation row with status indicator in the center
@@ -403,12 +405,12 @@ func (w *Wizard) Build() fyne.CanvasObject {
 
 // createCard creates a styled card container
 func createCard(title string, content fyne.CanvasObject) fyne.CanvasObject {
-	bg := canvas.NewRectangle(ColorCardBackground)
+	bg := newThemedRectangle(cardBackground)
 	bg.CornerRadius = 8
 
 	var header fyne.CanvasObject
 	if title != "" {
-		titleLabel := canvas.NewText(title, ColorTextPrimary)
+		titleLabel := newThemedText(title, textPrimary)
 		titleLabel.TextSize = 16
 		titleLabel.TextStyle = fyne.TextStyle{Bold: true}
 		header = container.NewVBox(
@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// nameSignalLength is the hex length of a name signal: enough to tell
+// nearly all different names apart, too short to identify anyone
+const nameSignalLength = 4
+
+// CollisionAudit describes an anonymous ID with more than one identity
+// hash: one patient seen with name or birth date variants, or several
+// patients merged by a hash collision or a shared PatientID
+type CollisionAudit struct {
+	AnonID         string
+	IdentityHashes int
+	NameSignals    int // Distinct name signals recorded; more than one means different names
+	PatientIDs     int
+	Conflicts      []string // Identity hashes that matched or were linked with a different name
+}
+
+// AuditCollisions returns the anonymous IDs with several identity hashes
+// or recorded conflicts, sorted by ID
+func (m *PseudonymizationMapper) AuditCollisions() []CollisionAudit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var audits []CollisionAudit
+	for anonID, entry := range m.reverseMap {
+		if len(entry.IdentityHashes) < 2 && len(entry.Conflicts) == 0 {
+			continue
+		}
+		signals := make(map[string]bool)
+		for _, signal := range entry.NameSignals {
+			signals[signal] = true
+		}
+		audits = append(audits, CollisionAudit{
+			AnonID:         anonID,
+			IdentityHashes: len(entry.IdentityHashes),
+			NameSignals:    len(signals),
+			PatientIDs:     len(entry.PatientIDs),
+			Conflicts:      append([]string{}, entry.Conflicts...),
+		})
+	}
+	sort.Slice(audits, func(i, j int) bool { return audits[i].AnonID < audits[j].AnonID })
+	return audits
+}
+
+// nameSignal returns a short keyed hash of the normalized name. It is
+// stored next to each identity hash, so a later patient whose Name+DOB
+// produces the same truncated hash but whose name differs is noticed.
+func (m *PseudonymizationMapper) nameSignal(name string) string {
+	mac := hmac.New(sha256.New, []byte(m.salt))
+	mac.Write([]byte("name-signal|" + NormalizeNameFuzzy(name, m.nicknames)))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil))[:nameSignalLength])
+}
+
+// checkMatchedSignal records the name signal of a patient found under
+// identityHash, or warns once when that hash was recorded for a different
+// name: two patients then share the hash and the anonymous ID
+func (m *PseudonymizationMapper) checkMatchedSignal(anonID, identityHash, signal string) {
+	entry := m.reverseEntry(anonID)
+	stored, ok := entry.NameSignals[identityHash]
+	if !ok {
+		entry.NameSignals[identityHash] = signal
+		m.markDirty()
+		return
+	}
+	if stored != signal && !contains(entry.Conflicts, identityHash) {
+		m.log.Warnf("Identity hash %s of %s was recorded for a different name; two patients may share this anonymous ID (see --audit-collisions)", identityHash, anonID)
+		entry.Conflicts = append(entry.Conflicts, identityHash)
+		m.markDirty()
+	}
+}
+
+// checkLinkedSignal records the name signal of a new identityHash linked
+// to anonID, and warns when anonID only has other names recorded
+func (m *PseudonymizationMapper) checkLinkedSignal(anonID, identityHash, signal string) {
+	entry := m.reverseEntry(anonID)
+	conflict := len(entry.NameSignals) > 0
+	for _, stored := range entry.NameSignals {
+		if stored == signal {
+			conflict = false
+			break
+		}
+	}
+	if conflict && !contains(entry.Conflicts, identityHash) {
+		m.log.Warnf("A new identity %s linked to %s by PatientID has a different name than the patient recorded there (see --audit-collisions)", identityHash, anonID)
+		entry.Conflicts = append(entry.Conflicts, identityHash)
+	}
+	entry.NameSignals[identityHash] = signal
+	m.markDirty()
+}
+
+// reverseEntry returns the reverse map entry of anonID, creating it and
+// its NameSignals as needed
+func (m *PseudonymizationMapper) reverseEntry(anonID string) *ReverseMapEntry {
+	m.updateReverseMap(anonID, "", "")
+	entry := m.reverseMap[anonID]
+	if entry.NameSignals == nil {
+		entry.NameSignals = make(map[string]string)
+	}
+	return entry
+}
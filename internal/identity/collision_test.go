@@ -0,0 +1,70 @@
+package identity
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dicom-anonymizer/internal/logging"
+)
+
+func TestCollisionWarnings(t *testing.T) {
+	var warnings strings.Builder
+	m, err := NewPseudonymizationMapperWithLogger(filepath.Join(t.TempDir(), "mapping.json"), "salt",
+		logging.NewFunc(func(s string) { warnings.WriteString(s) }, logging.LevelWarn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Name variants of one patient are not conflicts
+	variant, _ := m.GetAnonID("MRN1", "SMITH^JOHN", "19800101")
+	m.GetAnonID("MRN1", "John Smith", "19800101")
+	m.GetAnonID("MRN1", "SMITH^JOHN", "19800102")
+	if warnings.Len() > 0 {
+		t.Fatalf("unexpected warnings for name variants:\n%s", warnings.String())
+	}
+
+	// A PatientID linking a different name to an existing ID
+	linked, _ := m.GetAnonID("MRN2", "DOE^JANE", "19700101")
+	m.GetAnonID("MRN2", "ROE^RICHARD", "19600101")
+	if !strings.Contains(warnings.String(), "linked to "+linked+" by PatientID") {
+		t.Errorf("no warning for a different name linked by PatientID:\n%s", warnings.String())
+	}
+
+	// Simulate a truncated hash shared by two names: the stored hash of
+	// BROWN^ANN was recorded with the name signal of another patient
+	collided, _ := m.GetAnonID("", "BROWN^ANN", "19500101")
+	hash := CreateIdentityHash("BROWN^ANN", "19500101", "salt")
+	m.reverseMap[collided].NameSignals[hash] = m.nameSignal("GREEN^TOM")
+	warnings.Reset()
+	for i := 0; i < 2; i++ {
+		if got, _ := m.GetAnonID("", "BROWN^ANN", "19500101"); got != collided {
+			t.Fatalf("got %s, want the existing %s", got, collided)
+		}
+	}
+	if n := strings.Count(warnings.String(), "Identity hash "+hash); n != 1 {
+		t.Errorf("collision warned %d times, want once:\n%s", n, warnings.String())
+	}
+
+	audits := m.AuditCollisions()
+	byID := make(map[string]CollisionAudit)
+	for _, audit := range audits {
+		byID[audit.AnonID] = audit
+	}
+	if audit := byID[variant]; audit.IdentityHashes != 2 || audit.NameSignals != 1 || len(audit.Conflicts) != 0 {
+		t.Errorf("variant audit = %+v, want 2 hashes, 1 name, no conflicts", audit)
+	}
+	if audit := byID[linked]; audit.NameSignals != 2 || len(audit.Conflicts) != 1 {
+		t.Errorf("linked audit = %+v, want 2 names and 1 conflict", audit)
+	}
+	if audit := byID[collided]; len(audit.Conflicts) != 1 || audit.Conflicts[0] != hash {
+		t.Errorf("collided audit = %+v, want conflict %s", audit, hash)
+	}
+
+	// Conflicts survive a reload
+	m.Flush()
+	reloaded := newTestMapper(t, m.mappingFile, "salt")
+	if entry, _ := reloaded.Reverse(collided); len(entry.Conflicts) != 1 {
+		t.Errorf("reloaded conflicts = %v, want 1", entry.Conflicts)
+	}
+}
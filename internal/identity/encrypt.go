@@ -0,0 +1,167 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+
+	"dicom-anonymizer/internal/fsutil"
+)
+
+// Encrypted mapping file layout, shared by the patient and UID mappings:
+// magic | scrypt salt (16) | GCM nonce (12) | AES-256-GCM ciphertext
+var encryptedMappingMagic = []byte("DCMANON-ENC1\n")
+
+const mappingSaltSize = 16
+
+// scrypt cost parameters for the mapping key (32 MiB, ~100ms)
+const (
+	mappingScryptN = 1 << 15
+	mappingScryptR = 8
+	mappingScryptP = 1
+)
+
+// IsEncryptedMapping reports whether data is an encrypted mapping file.
+func IsEncryptedMapping(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMappingMagic)
+}
+
+// isPlaintextFile reports whether path exists and is not an encrypted
+// mapping file
+func isPlaintextFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(encryptedMappingMagic))
+	n, _ := io.ReadFull(f, magic)
+	return !IsEncryptedMapping(magic[:n])
+}
+
+// writeMappingFile writes data, a mapping file encrypted when encrypted is
+// set, to path. An encrypted mapping never keeps a plaintext version as
+// its backup: the first encrypted write removes the backup instead.
+func writeMappingFile(path string, data []byte, encrypted bool) error {
+	if encrypted && (isPlaintextFile(path) || isPlaintextFile(fsutil.BackupPath(path))) {
+		return fsutil.WriteFileAtomicNoBackup(path, data, 0644)
+	}
+	return fsutil.WriteFileAtomic(path, data, 0644)
+}
+
+// mappingCipher encrypts mapping files with a key derived from the secret.
+type mappingCipher struct {
+	salt []byte
+	aead cipher.AEAD
+}
+
+// newMappingCipher derives the mapping key from secret and salt. A nil salt
+// generates a new random one.
+func newMappingCipher(secret string, salt []byte) (*mappingCipher, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("encrypted mapping requires a secret key")
+	}
+
+	if salt == nil {
+		salt = make([]byte, mappingSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("could not generate salt: %w", err)
+		}
+	}
+
+	key, err := scrypt.Key([]byte(secret), salt, mappingScryptN, mappingScryptR, mappingScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mappingCipher{salt: salt, aead: aead}, nil
+}
+
+// openMappingCipher derives the key for an existing encrypted mapping file.
+func openMappingCipher(secret string, data []byte) (*mappingCipher, error) {
+	header := len(encryptedMappingMagic)
+	if !IsEncryptedMapping(data) || len(data) < header+mappingSaltSize {
+		return nil, fmt.Errorf("not an encrypted mapping file")
+	}
+
+	salt := append([]byte(nil), data[header:header+mappingSaltSize]...)
+	return newMappingCipher(secret, salt)
+}
+
+// mappingReader decrypts a mapping file and, when it is damaged, its
+// backup for fsutil.ReadWithBackup. The backup of an encrypted file must
+// be encrypted too, so the wrong key never falls back to an older
+// plaintext copy.
+type mappingReader struct {
+	secret    string
+	read      bool           // The file itself, always read first, was seen
+	encrypted bool           // The file itself is encrypted
+	cipher    *mappingCipher // Of the version returned last
+}
+
+// plaintext returns the JSON of a mapping file or its backup
+func (r *mappingReader) plaintext(data []byte) ([]byte, error) {
+	if !r.read {
+		r.read, r.encrypted = true, IsEncryptedMapping(data)
+	}
+	r.cipher = nil
+	if !IsEncryptedMapping(data) {
+		if r.encrypted {
+			return nil, fmt.Errorf("backup of an encrypted mapping is not encrypted")
+		}
+		return data, nil
+	}
+
+	c, err := openMappingCipher(r.secret, data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.decrypt(data); err != nil {
+		return nil, err
+	}
+	r.cipher = c
+	return data, nil
+}
+
+func (c *mappingCipher) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(encryptedMappingMagic)+len(c.salt)+len(nonce)+len(plaintext)+c.aead.Overhead())
+	out = append(out, encryptedMappingMagic...)
+	out = append(out, c.salt...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plaintext, encryptedMappingMagic), nil
+}
+
+func (c *mappingCipher) decrypt(data []byte) ([]byte, error) {
+	header := len(encryptedMappingMagic) + mappingSaltSize
+	nonceSize := c.aead.NonceSize()
+	if len(data) < header+nonceSize {
+		return nil, fmt.Errorf("encrypted mapping file is truncated")
+	}
+
+	nonce := data[header : header+nonceSize]
+	plaintext, err := c.aead.Open(nil, nonce, data[header+nonceSize:], encryptedMappingMagic)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt mapping: wrong key?")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,52 @@
+package identity
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CSVListSeparator joins multiple PatientIDs or identity hashes in one CSV field
+const CSVListSeparator = ";"
+
+// ExportCSV writes one row per anonymous ID with its original PatientIDs,
+// identity hashes and date shift, preceded by a header row.
+func (m *PseudonymizationMapper) ExportCSV(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.reverseMap))
+	for id := range m.reverseMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"anon_id", "original_pid", "identity_hash", "date_shift"}); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		entry := m.reverseMap[id]
+
+		shift := ""
+		if days, ok := m.dateShifts[id]; ok {
+			shift = strconv.Itoa(days)
+		}
+
+		record := []string{
+			id,
+			strings.Join(entry.PatientIDs, CSVListSeparator),
+			strings.Join(entry.IdentityHashes, CSVListSeparator),
+			shift,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,46 @@
+package identity
+
+import (
+	"encoding/csv"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExportCSV(t *testing.T) {
+	m := newTestMapper(t, filepath.Join(t.TempDir(), "mapping.json"), "salt")
+
+	first, _ := m.GetAnonID("PID,1", "DOE^JOHN", "19800101")
+	m.GetAnonID("PID2", "DOE^JOHN", "19800101")
+	days := m.GetDateShift(first)
+	m.GetAnonID("PID3", "", "")
+
+	var b strings.Builder
+	if err := m.ExportCSV(&b); err != nil {
+		t.Fatalf("ExportCSV failed: %v", err)
+	}
+
+	if !strings.Contains(b.String(), `"PID,1;PID2"`) {
+		t.Errorf("field with comma is not quoted:\n%s", b.String())
+	}
+
+	records, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+
+	want := [][]string{
+		{"anon_id", "original_pid", "identity_hash", "date_shift"},
+		{first, "PID,1;PID2", CreateIdentityHash("DOE^JOHN", "19800101", "salt"), strconv.Itoa(days)},
+		{"ANON-000002", "PID3", "", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d rows, want %d:\n%s", len(records), len(want), b.String())
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("row %d = %q, want %q", i, records[i], want[i])
+		}
+	}
+}
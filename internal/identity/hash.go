@@ -1,7 +1,9 @@
 package identity
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"regexp"
@@ -35,9 +37,65 @@ func NormalizeName(name string) string {
 	return strings.Join(parts, "")
 }
 
-// CreateIdentityHash creates a consistent hash from patient name, DOB, and optional salt.
-// Returns uppercase 12-character hex string.
+// NormalizeNameFuzzy normalizes like NormalizeName, but also drops
+// single-letter initials and replaces nicknames with their canonical name
+// (nil nicknames = DefaultNicknames), so "JON A SMITH" matches "SMITH^JONATHAN".
+func NormalizeNameFuzzy(name string, nicknames map[string]string) string {
+	if nicknames == nil {
+		nicknames = DefaultNicknames
+	}
+
+	name = strings.ToUpper(name)
+	name = strings.ReplaceAll(name, "^", " ")
+	name = strings.ReplaceAll(name, ",", " ")
+	name = nonAlphaRegex.ReplaceAllString(name, "")
+
+	var parts []string
+	for _, part := range strings.Fields(name) {
+		if len(part) == 1 {
+			continue // Middle or first initial
+		}
+		if canonical, ok := nicknames[part]; ok {
+			part = canonical
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return NormalizeName(name)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, "")
+}
+
+// CreateIdentityHash creates a consistent HMAC-SHA256 of patient name and
+// DOB keyed by the salt. The DOB is hashed as NormalizeDOB returns it, so
+// 1980-01-02 and 19800102 give the same hash. Returns uppercase
+// 12-character hex string.
 func CreateIdentityHash(name, dob, salt string) string {
+	return identityHMAC(NormalizeName(name), dob, salt)
+}
+
+// CreateFuzzyIdentityHash is CreateIdentityHash over NormalizeNameFuzzy.
+// Names without initials or nicknames hash the same as CreateIdentityHash.
+func CreateFuzzyIdentityHash(name, dob, salt string, nicknames map[string]string) string {
+	return identityHMAC(NormalizeNameFuzzy(name, nicknames), dob, salt)
+}
+
+func identityHMAC(normalizedName, dob, salt string) string {
+	dobStr := NormalizeDOB(dob)
+	if dobStr == "" {
+		dobStr = strings.TrimSpace(dob) // Not a valid identity; hash it as before
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(fmt.Sprintf("%s|%s", normalizedName, dobStr)))
+	return strings.ToUpper(hex.EncodeToString(mac.Sum(nil))[:12])
+}
+
+// CreateLegacyIdentityHash creates the plain SHA-256 identity hash used by
+// mapping files written before HMAC hashing. Only used to resolve existing
+// entries in those files.
+func CreateLegacyIdentityHash(name, dob, salt string) string {
 	nameNormalized := NormalizeName(name)
 	dobStr := strings.TrimSpace(dob)
 
@@ -45,3 +103,25 @@ func CreateIdentityHash(name, dob, salt string) string {
 	hash := sha256.Sum256([]byte(identityString))
 	return strings.ToUpper(hex.EncodeToString(hash[:])[:12])
 }
+
+// CreateValueHash replaces an arbitrary tag value with a salted hash.
+// Returns uppercase 16-character hex string (fits SH/LO/CS tags).
+func CreateValueHash(value, salt string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s", strings.TrimSpace(value), salt)))
+	return strings.ToUpper(hex.EncodeToString(hash[:])[:16])
+}
+
+// MaxDateShiftDays bounds the per-patient date shift in either direction.
+const MaxDateShiftDays = 365
+
+// CreateDateShift derives a stable per-patient date offset from the
+// anonymous ID and salt, in the range ±MaxDateShiftDays. Zero is never
+// returned so shifted dates always differ from the originals.
+func CreateDateShift(anonID, salt string) int {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s", anonID, salt)))
+	n := int(binary.BigEndian.Uint32(hash[:4]) % (2 * MaxDateShiftDays))
+	if n < MaxDateShiftDays {
+		return n - MaxDateShiftDays // -365..-1
+	}
+	return n - MaxDateShiftDays + 1 // 1..365
+}
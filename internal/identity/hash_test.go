@@ -0,0 +1,42 @@
+package identity
+
+import "testing"
+
+func TestNormalizeNameFuzzy(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"JONATHAN SMITH", "JON SMITH"},
+		{"SMITH JOHN A", "SMITH JOHN"},
+		{"SMITH^JOHN^A", "John Smith"},
+		{"Smith, Jon A.", "SMITH^JONATHAN"},
+		{"BILL O'NEIL", "WILLIAM ONEIL"},
+	}
+
+	for _, tt := range tests {
+		a, b := NormalizeNameFuzzy(tt.a, nil), NormalizeNameFuzzy(tt.b, nil)
+		if a != b {
+			t.Errorf("NormalizeNameFuzzy(%q) = %q, NormalizeNameFuzzy(%q) = %q, want equal", tt.a, a, tt.b, b)
+		}
+	}
+
+	if NormalizeNameFuzzy("JOHN SMITH", nil) == NormalizeNameFuzzy("JANE SMITH", nil) {
+		t.Errorf("different given names normalized equal")
+	}
+	if got := NormalizeNameFuzzy("A B", nil); got != "AB" {
+		t.Errorf("initials-only name = %q, want AB", got)
+	}
+
+	custom := map[string]string{"JOHNNY": "JONATHAN"}
+	if NormalizeNameFuzzy("JOHNNY SMITH", custom) != NormalizeNameFuzzy("JONATHAN SMITH", custom) {
+		t.Errorf("custom nickname table not applied")
+	}
+}
+
+func TestFuzzyHashMatchesExactForPlainNames(t *testing.T) {
+	exact := CreateIdentityHash("SMITH^JOHN", "19800101", "salt")
+	fuzzy := CreateFuzzyIdentityHash("SMITH^JOHN", "19800101", "salt", nil)
+	if exact != fuzzy {
+		t.Errorf("fuzzy hash %s differs from exact hash %s for a plain name", fuzzy, exact)
+	}
+}
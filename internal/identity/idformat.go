@@ -0,0 +1,87 @@
+package identity
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Default anonymous ID layout: ANON-000001
+const (
+	DefaultIDPrefix = "ANON-"
+	DefaultIDFormat = "%06d"
+)
+
+// fmtVerbRegex matches one fmt verb with optional flags and width
+var fmtVerbRegex = regexp.MustCompile(`%[-+# 0]*([0-9]*)(\.[0-9]*)?([a-zA-Z%])`)
+
+// ValidateIDFormat checks that format has exactly one integer verb
+// (%d, %x, %X, %o or %b, with optional flags and width).
+func ValidateIDFormat(format string) error {
+	_, err := idFormatWidth(format)
+	return err
+}
+
+// idFormatWidth validates format and returns the width of its integer verb
+// (0 if unpadded).
+func idFormatWidth(format string) (int, error) {
+	verbs := 0
+	width := 0
+
+	for _, match := range fmtVerbRegex.FindAllStringSubmatch(format, -1) {
+		switch match[3] {
+		case "%":
+			continue // Literal percent sign
+		case "d", "x", "X", "o", "b":
+			verbs++
+			width, _ = strconv.Atoi(match[1])
+		default:
+			return 0, fmt.Errorf("invalid ID format %q: %%%s is not an integer verb", format, match[3])
+		}
+	}
+
+	if verbs != 1 {
+		return 0, fmt.Errorf("invalid ID format %q: need exactly one integer verb such as %%06d", format)
+	}
+	return width, nil
+}
+
+// SetIDFormat sets the prefix and format used for new anonymous IDs
+// (empty = the ones stored in the mapping, DefaultIDPrefix/DefaultIDFormat
+// for a new one). Once a mapping has issued IDs its format is fixed, so a
+// different format is rejected rather than mixing ID styles within one
+// mapping.
+func (m *PseudonymizationMapper) SetIDFormat(prefix, format string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prefix == "" {
+		prefix = m.idPrefix
+	}
+	if format == "" {
+		format = m.idFormat
+	}
+
+	width, err := idFormatWidth(format)
+	if err != nil {
+		return err
+	}
+
+	if prefix == m.idPrefix && format == m.idFormat {
+		return nil
+	}
+
+	if m.counter > 0 {
+		current := m.idPrefix + fmt.Sprintf(m.idFormat, m.counter)
+		requested := prefix + fmt.Sprintf(format, m.counter)
+		if width > 0 && len(fmt.Sprintf(format, m.counter)) > width {
+			return fmt.Errorf("ID format %q is too narrow for the %d IDs already issued", format, m.counter)
+		}
+		return fmt.Errorf("mapping already issues IDs like %s; switching to %s would desync existing IDs", current, requested)
+	}
+
+	m.idPrefix = prefix
+	m.idFormat = format
+	m.pending++ // Record the format on the next save
+	return nil
+}
@@ -0,0 +1,55 @@
+package identity
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateIDFormat(t *testing.T) {
+	valid := []string{"%06d", "%d", "%07d", "-%x", "%%%05d"}
+	for _, format := range valid {
+		if err := ValidateIDFormat(format); err != nil {
+			t.Errorf("ValidateIDFormat(%q) = %v, want nil", format, err)
+		}
+	}
+
+	invalid := []string{"", "ID", "%s", "%d-%d", "%06d%v", "%f"}
+	for _, format := range invalid {
+		if err := ValidateIDFormat(format); err == nil {
+			t.Errorf("ValidateIDFormat(%q) = nil, want error", format)
+		}
+	}
+}
+
+func TestIDFormatStoredInMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	if err := m.SetIDFormat("SITE3-", "%07d"); err != nil {
+		t.Fatalf("SetIDFormat failed: %v", err)
+	}
+	if got, _ := m.GetAnonID("PID1", "", ""); got != "SITE3-0000001" {
+		t.Errorf("anon ID = %q, want SITE3-0000001", got)
+	}
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got, _ := reloaded.GetAnonID("PID2", "", ""); got != "SITE3-0000002" {
+		t.Errorf("anon ID after reload = %q, want SITE3-0000002", got)
+	}
+
+	if err := reloaded.SetIDFormat("SITE3-", "%07d"); err != nil {
+		t.Errorf("same format rejected: %v", err)
+	}
+	if err := reloaded.SetIDFormat("", ""); err != nil {
+		t.Errorf("empty format should use the stored one: %v", err)
+	}
+	if got, _ := reloaded.GetAnonID("PID3", "", ""); got != "SITE3-0000003" {
+		t.Errorf("anon ID with the stored format = %q, want SITE3-0000003", got)
+	}
+	if err := reloaded.SetIDFormat(DefaultIDPrefix, DefaultIDFormat); err == nil {
+		t.Errorf("switching to the default format should be rejected")
+	}
+	if err := reloaded.SetIDFormat("SITE3-", "%01d"); err == nil {
+		t.Errorf("format narrower than the counter should be rejected")
+	}
+}
@@ -2,12 +2,16 @@ package identity
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
+
+	"dicom-anonymizer/internal/fsutil"
+	"dicom-anonymizer/internal/logging"
 )
 
 // MatchMethod indicates how a patient was matched
@@ -17,20 +21,40 @@ const (
 	MatchIdentity MatchMethod = "identity"
 	MatchPID      MatchMethod = "pid"
 	MatchNone     MatchMethod = "none"
+	MatchFile     MatchMethod = "file" // No identity or PID; keyed by the file itself (see GetFileAnonID)
+)
+
+// Identity hash schemes recorded in MapperData.HashVersion
+const (
+	HashVersionSHA256 = 1 // Plain SHA-256 of name|dob|salt (files without a version)
+	HashVersionHMAC   = 2 // HMAC-SHA256 of name|dob keyed by the salt
 )
 
 // ReverseMapEntry stores reverse lookup info for audit trail
 type ReverseMapEntry struct {
 	IdentityHashes []string `json:"identity_hashes"`
 	PatientIDs     []string `json:"patient_ids"`
+	MergedFrom     []string `json:"merged_from,omitempty"` // "file:ANON-ID" entries folded in by Merge
+
+	// Identity hash -> short keyed hash of the normalized name, and the
+	// identity hashes found with a different name (see AuditCollisions)
+	NameSignals map[string]string `json:"name_signals,omitempty"`
+	Conflicts   []string          `json:"conflicts,omitempty"`
 }
 
 // MapperData is the JSON structure for persistence
 type MapperData struct {
 	IdentityMap map[string]string           `json:"identity_map"`
 	PIDMap      map[string]string           `json:"pid_map"`
+	FileMap     map[string]string           `json:"file_map,omitempty"` // File key -> anon ID for files without identity or PID
 	ReverseMap  map[string]*ReverseMapEntry `json:"reverse_map"`
+	DateShifts  map[string]int              `json:"date_shifts,omitempty"`
 	Counter     int                         `json:"counter"`
+	HashVersion int                         `json:"hash_version,omitempty"`
+	FuzzyNames  bool                        `json:"fuzzy_names,omitempty"` // identity_map has fuzzy-normalized hashes
+	IDPrefix    string                      `json:"id_prefix,omitempty"`
+	IDFormat    string                      `json:"id_format,omitempty"`
+	UIDRoot     string                      `json:"uid_root,omitempty"` // Root of generated UIDs (absent = DefaultUIDRoot if IDs were issued)
 	Updated     string                      `json:"updated"`
 	Note        string                      `json:"note"`
 }
@@ -42,38 +66,110 @@ type PseudonymizationMapper struct {
 	salt        string
 	identityMap map[string]string           // identity_hash -> anon_id
 	pidMap      map[string]string           // patient_id -> anon_id
+	fileMap     map[string]string           // file key -> anon_id
 	reverseMap  map[string]*ReverseMapEntry // anon_id -> info
+	dateShifts  map[string]int              // anon_id -> date shift in days
 	counter     int
+	hashVersion int               // Oldest identity hash scheme present in identityMap
+	cipher      *mappingCipher    // Non-nil when the mapping file is encrypted
+	fuzzy       bool              // New identities use fuzzy name hashes
+	fuzzyNames  bool              // identityMap may contain fuzzy name hashes
+	nicknames   map[string]string // Nickname table for fuzzy hashes (nil = DefaultNicknames)
+	idPrefix    string
+	idFormat    string
+	uidRoot     string // Root of generated UIDs ("" = not recorded yet)
+	toolVersion string // Build that writes the mapping, recorded in the note
+
+	deferSave bool // Batch writes instead of saving on every change
+	pending   int  // Unsaved changes since the last write
+
+	log logging.Logger
 }
 
+// SaveEvery is how many changes a deferred-save mapper accumulates before
+// writing the mapping file, so a crash loses at most this many patients.
+const SaveEvery = 100
+
 // NewPseudonymizationMapper creates a new mapper, loading from file if it exists.
-func NewPseudonymizationMapper(mappingFile, salt string) *PseudonymizationMapper {
+// Encrypted mapping files are detected and decrypted with the salt.
+func NewPseudonymizationMapper(mappingFile, salt string) (*PseudonymizationMapper, error) {
+	return NewPseudonymizationMapperWithLogger(mappingFile, salt, nil)
+}
+
+// NewPseudonymizationMapperWithLogger is NewPseudonymizationMapper with
+// load and save messages sent to log (nil = logging.Default()).
+func NewPseudonymizationMapperWithLogger(mappingFile, salt string, log logging.Logger) (*PseudonymizationMapper, error) {
 	m := &PseudonymizationMapper{
+		log:         logging.OrDefault(log),
 		mappingFile: mappingFile,
 		salt:        salt,
 		identityMap: make(map[string]string),
 		pidMap:      make(map[string]string),
+		fileMap:     make(map[string]string),
 		reverseMap:  make(map[string]*ReverseMapEntry),
+		dateShifts:  make(map[string]int),
 		counter:     0,
+		hashVersion: HashVersionHMAC,
+		idPrefix:    DefaultIDPrefix,
+		idFormat:    DefaultIDFormat,
 	}
 
 	if mappingFile != "" {
-		m.load()
+		if err := m.load(); err != nil {
+			return nil, err
+		}
 	}
 
-	return m
+	return m, nil
 }
 
-func (m *PseudonymizationMapper) load() {
-	data, err := os.ReadFile(m.mappingFile)
+// EnableEncryption encrypts the mapping file from the next save on, with
+// a key derived from the salt. Files loaded encrypted stay encrypted.
+func (m *PseudonymizationMapper) EnableEncryption() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cipher != nil {
+		return nil
+	}
+
+	c, err := newMappingCipher(m.salt, nil)
 	if err != nil {
-		return // File doesn't exist, start fresh
+		return err
+	}
+	m.cipher = c
+	m.pending++ // Rewrite a plaintext file encrypted on the next flush
+	return nil
+}
+
+func (m *PseudonymizationMapper) load() error {
+	if _, err := os.Stat(m.mappingFile); err != nil {
+		return nil // File doesn't exist, start fresh
 	}
 
 	var mapData MapperData
-	if err := json.Unmarshal(data, &mapData); err != nil {
-		fmt.Printf("Warning: Could not load mapping file: %v\n", err)
-		return
+	reader := &mappingReader{secret: m.salt}
+	recovered, err := fsutil.ReadWithBackup(m.mappingFile, func(data []byte) error {
+		data, err := reader.plaintext(data)
+		if err != nil {
+			return fmt.Errorf("cannot decrypt mapping %s: %w", m.mappingFile, err)
+		}
+		mapData = MapperData{}
+		return json.Unmarshal(data, &mapData)
+	})
+	m.cipher = reader.cipher
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			m.log.Warnf("Could not load mapping file: %v", err)
+			return nil
+		}
+		return err
+	}
+	if recovered {
+		m.log.Warnf("Mapping file %s is damaged; loaded the previous version from %s", m.mappingFile, fsutil.BackupPath(m.mappingFile))
+		m.pending++ // Rewrite the damaged file on the next save
 	}
 
 	m.identityMap = mapData.IdentityMap
@@ -86,13 +182,40 @@ func (m *PseudonymizationMapper) load() {
 		m.pidMap = make(map[string]string)
 	}
 
+	m.fileMap = mapData.FileMap
+	if m.fileMap == nil {
+		m.fileMap = make(map[string]string)
+	}
+
 	m.reverseMap = mapData.ReverseMap
 	if m.reverseMap == nil {
 		m.reverseMap = make(map[string]*ReverseMapEntry)
 	}
 
+	m.dateShifts = mapData.DateShifts
+	if m.dateShifts == nil {
+		m.dateShifts = make(map[string]int)
+	}
+
 	m.counter = mapData.Counter
 
+	// Files without a version hold plain SHA-256 identity hashes
+	m.hashVersion = mapData.HashVersion
+	if m.hashVersion == 0 {
+		m.hashVersion = HashVersionSHA256
+	}
+	m.fuzzyNames = mapData.FuzzyNames
+
+	// Files without a format use the original ANON-%06d IDs
+	if mapData.IDFormat != "" {
+		if err := ValidateIDFormat(mapData.IDFormat); err != nil {
+			return fmt.Errorf("mapping %s: %w", m.mappingFile, err)
+		}
+		m.idPrefix = mapData.IDPrefix
+		m.idFormat = mapData.IDFormat
+	}
+	m.uidRoot = mapData.UIDRoot
+
 	// Count unique patients
 	uniqueIDs := make(map[string]bool)
 	for _, id := range m.identityMap {
@@ -101,45 +224,164 @@ func (m *PseudonymizationMapper) load() {
 	for _, id := range m.pidMap {
 		uniqueIDs[id] = true
 	}
+	for _, id := range m.fileMap {
+		uniqueIDs[id] = true
+	}
 
-	fmt.Printf("Loaded %d patient mappings from %s\n", len(uniqueIDs), m.mappingFile)
+	m.log.Infof("Loaded %d patient mappings from %s", len(uniqueIDs), m.mappingFile)
+	return nil
+}
+
+// EnableFuzzyNames makes new identities hash NormalizeNameFuzzy names
+// (nil nicknames = DefaultNicknames). Patients already mapped by their
+// exact name keep their IDs; the mapping file records that fuzzy hashes
+// are present so later runs keep matching them.
+func (m *PseudonymizationMapper) EnableFuzzyNames(nicknames map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fuzzy = true
+	m.fuzzyNames = true
+	m.nicknames = nicknames
+}
+
+// identityHashes returns the hashes to look a patient up by, in order:
+// exact name, legacy SHA-256 (older files), then fuzzy name. The last
+// return value is the hash new identities are stored under.
+func (m *PseudonymizationMapper) identityHashes(name, dob string) ([]string, string) {
+	exact := CreateIdentityHash(name, dob, m.salt)
+	hashes := []string{exact}
+	if m.hashVersion < HashVersionHMAC {
+		hashes = append(hashes, CreateLegacyIdentityHash(name, dob, m.salt))
+	}
+	if !m.fuzzyNames {
+		return hashes, exact
+	}
+
+	fuzzy := CreateFuzzyIdentityHash(name, dob, m.salt, m.nicknames)
+	if fuzzy != exact {
+		hashes = append(hashes, fuzzy)
+	}
+	if m.fuzzy {
+		return hashes, fuzzy
+	}
+	return hashes, exact
+}
+
+// SetToolVersion records the tool version in the note of the mapping file
+// so it can be traced to the build that wrote it.
+func (m *PseudonymizationMapper) SetToolVersion(version string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.toolVersion = version
+}
+
+// SetDeferSave enables batched writes: changes are saved every SaveEvery
+// changes and on Flush instead of after each one.
+func (m *PseudonymizationMapper) SetDeferSave(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deferSave = enabled
+}
+
+// Flush writes any unsaved changes to the mapping file. The changes stay
+// pending when the write fails, so a later Flush retries them.
+func (m *PseudonymizationMapper) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pending > 0 {
+		return m.save()
+	}
+	return nil
+}
+
+// markDirty records a change and saves it now or, with deferred saving,
+// once enough changes have accumulated.
+func (m *PseudonymizationMapper) markDirty() {
+	m.pending++
+	if !m.deferSave || m.pending >= SaveEvery {
+		if err := m.save(); err != nil {
+			m.log.Warnf("%v", err)
+		}
+	}
 }
 
-func (m *PseudonymizationMapper) save() {
+func (m *PseudonymizationMapper) save() error {
 	if m.mappingFile == "" {
-		return
+		return nil
 	}
+	if err := m.write(m.mappingFile); err != nil {
+		return err
+	}
+	m.pending = 0
+	return nil
+}
+
+// SaveAs writes the mapping to path, which becomes the mapper's file for
+// later saves.
+func (m *PseudonymizationMapper) SaveAs(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.write(path); err != nil {
+		return err
+	}
+	m.mappingFile = path
+	m.pending = 0
+	return nil
+}
 
+func (m *PseudonymizationMapper) write(path string) error {
 	// Ensure parent directory exists
-	dir := filepath.Dir(m.mappingFile)
+	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		fmt.Printf("Warning: Could not create mapping directory: %v\n", err)
-		return
+		return fmt.Errorf("could not create mapping directory: %w", err)
+	}
+
+	note := "identity_map uses HMAC(Name+DOB) (plain SHA-256 for entries before hash_version 2), pid_map is fallback for missing identity"
+	if m.toolVersion != "" {
+		note += "; written by dicom-anonymizer " + m.toolVersion
 	}
 
 	mapData := MapperData{
 		IdentityMap: m.identityMap,
 		PIDMap:      m.pidMap,
+		FileMap:     m.fileMap,
 		ReverseMap:  m.reverseMap,
+		DateShifts:  m.dateShifts,
 		Counter:     m.counter,
+		HashVersion: m.hashVersion,
+		FuzzyNames:  m.fuzzyNames,
+		IDPrefix:    m.idPrefix,
+		IDFormat:    m.idFormat,
+		UIDRoot:     m.uidRoot,
 		Updated:     time.Now().Format(time.RFC3339),
-		Note:        "identity_map uses hash(Name+DOB), pid_map is fallback for missing identity",
+		Note:        note,
 	}
 
 	data, err := json.MarshalIndent(mapData, "", "  ")
 	if err != nil {
-		fmt.Printf("Warning: Could not marshal mapping data: %v\n", err)
-		return
+		return fmt.Errorf("could not marshal mapping data: %w", err)
+	}
+
+	if m.cipher != nil {
+		if data, err = m.cipher.encrypt(data); err != nil {
+			return fmt.Errorf("could not encrypt mapping data: %w", err)
+		}
 	}
 
-	if err := os.WriteFile(m.mappingFile, data, 0644); err != nil {
-		fmt.Printf("Warning: Could not save mapping file: %v\n", err)
+	if err := writeMappingFile(path, data, m.cipher != nil); err != nil {
+		return fmt.Errorf("could not save mapping file: %w", err)
 	}
+	return nil
 }
 
 func (m *PseudonymizationMapper) generateID() string {
 	m.counter++
-	return fmt.Sprintf("ANON-%06d", m.counter)
+	return m.idPrefix + fmt.Sprintf(m.idFormat, m.counter)
 }
 
 func (m *PseudonymizationMapper) updateReverseMap(anonID string, identityHash, patientID string) {
@@ -182,15 +424,35 @@ func (m *PseudonymizationMapper) GetAnonID(patientID, patientName, patientDOB st
 
 	// Try identity-based matching first
 	if IsValidIdentity(patientName, patientDOB) {
-		identityHash := CreateIdentityHash(patientName, patientDOB, m.salt)
+		hashes, identityHash := m.identityHashes(patientName, patientDOB)
+		signal := m.nameSignal(patientName)
 
 		// Check if identity already mapped
-		if anonID, ok := m.identityMap[identityHash]; ok {
+		var anonID, matched string
+		var ok bool
+		for _, h := range hashes {
+			if anonID, ok = m.identityMap[h]; ok {
+				matched = h
+				break
+			}
+		}
+		if ok {
+			m.checkMatchedSignal(anonID, matched, signal)
+
+			// Link the current hash so later variants match it directly
+			if _, linked := m.identityMap[identityHash]; !linked {
+				m.identityMap[identityHash] = anonID
+				m.updateReverseMap(anonID, identityHash, "")
+				m.checkMatchedSignal(anonID, identityHash, signal)
+				m.markDirty()
+			}
+
 			// Also store PID mapping for reference
 			if patientID != "" {
 				if _, exists := m.pidMap[patientID]; !exists {
 					m.pidMap[patientID] = anonID
-					m.save()
+					m.updateReverseMap(anonID, "", patientID)
+					m.markDirty()
 				}
 			}
 			return anonID, MatchIdentity
@@ -198,20 +460,22 @@ func (m *PseudonymizationMapper) GetAnonID(patientID, patientName, patientDOB st
 
 		// Check if PID was already mapped (link identity to existing)
 		if anonID, ok := m.pidMap[patientID]; ok {
+			m.checkLinkedSignal(anonID, identityHash, signal)
 			m.identityMap[identityHash] = anonID
 			m.updateReverseMap(anonID, identityHash, patientID)
-			m.save()
+			m.markDirty()
 			return anonID, MatchIdentity
 		}
 
 		// New patient - create new ID
-		anonID := m.generateID()
+		anonID = m.generateID()
 		m.identityMap[identityHash] = anonID
 		if patientID != "" {
 			m.pidMap[patientID] = anonID
 		}
 		m.updateReverseMap(anonID, identityHash, patientID)
-		m.save()
+		m.checkLinkedSignal(anonID, identityHash, signal)
+		m.markDirty()
 		return anonID, MatchIdentity
 	}
 
@@ -224,16 +488,116 @@ func (m *PseudonymizationMapper) GetAnonID(patientID, patientName, patientDOB st
 		anonID := m.generateID()
 		m.pidMap[patientID] = anonID
 		m.updateReverseMap(anonID, "", patientID)
-		m.save()
+		m.markDirty()
 		return anonID, MatchPID
 	}
 
 	// No identity and no PID - generate unique ID
 	anonID := m.generateID()
-	m.save()
+	m.markDirty()
 	return anonID, MatchNone
 }
 
+// LinkAnonID records a patient's identity and PatientID under an existing
+// anonymous ID, for patients known to be the same person by other means
+// (such as a shared StudyInstanceUID), so later runs match them directly.
+// Identities or PatientIDs already mapped to another ID keep that ID; a
+// warning is logged.
+func (m *PseudonymizationMapper) LinkAnonID(anonID, patientID, patientName, patientDOB string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	patientID = strings.TrimSpace(patientID)
+	patientName = strings.TrimSpace(patientName)
+	patientDOB = strings.TrimSpace(patientDOB)
+
+	if IsValidIdentity(patientName, patientDOB) {
+		hashes, identityHash := m.identityHashes(patientName, patientDOB)
+		if existing := firstMapped(m.identityMap, hashes); existing == "" {
+			m.identityMap[identityHash] = anonID
+			m.updateReverseMap(anonID, identityHash, "")
+			m.markDirty()
+		} else if existing != anonID {
+			m.log.Warnf("A patient linked to %s is already mapped to %s; the mapping is unchanged", anonID, existing)
+		}
+	}
+
+	if patientID != "" {
+		if existing, ok := m.pidMap[patientID]; !ok {
+			m.pidMap[patientID] = anonID
+			m.updateReverseMap(anonID, "", patientID)
+			m.markDirty()
+		} else if existing != anonID {
+			m.log.Warnf("A PatientID linked to %s is already mapped to %s; the mapping is unchanged", anonID, existing)
+		}
+	}
+}
+
+// GetFileAnonID gets or creates the anonymized ID for a file that has
+// neither a valid identity nor a PatientID, keyed by something stable
+// about the file itself such as its SOPInstanceUID or content hash. The
+// key is recorded in file_map, so the same file gets the same ID on
+// later runs.
+func (m *PseudonymizationMapper) GetFileAnonID(key string) (string, MatchMethod) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if anonID, ok := m.fileMap[key]; ok {
+		return anonID, MatchFile
+	}
+
+	anonID := m.generateID()
+	m.fileMap[key] = anonID
+	m.updateReverseMap(anonID, "", "")
+	m.markDirty()
+	return anonID, MatchFile
+}
+
+// GetDateShift returns the date shift in days for an anonymous ID, deriving
+// and recording it on first use so it can be reversed later.
+func (m *PseudonymizationMapper) GetDateShift(anonID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if days, ok := m.dateShifts[anonID]; ok {
+		return days
+	}
+
+	days := CreateDateShift(anonID, m.salt)
+	m.dateShifts[anonID] = days
+	m.markDirty()
+	return days
+}
+
+// LookupDateShift returns the recorded date shift for an anonymous ID
+// without creating one.
+func (m *PseudonymizationMapper) LookupDateShift(anonID string) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	days, ok := m.dateShifts[anonID]
+	return days, ok
+}
+
+// Reverse returns the original PatientIDs and identity hashes recorded for
+// an anonymous ID. The returned entry is a copy.
+func (m *PseudonymizationMapper) Reverse(anonID string) (*ReverseMapEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.reverseMap[strings.TrimSpace(anonID)]
+	if !ok {
+		return nil, false
+	}
+
+	return &ReverseMapEntry{
+		IdentityHashes: append([]string{}, entry.IdentityHashes...),
+		PatientIDs:     append([]string{}, entry.PatientIDs...),
+		MergedFrom:     append([]string{}, entry.MergedFrom...),
+		Conflicts:      append([]string{}, entry.Conflicts...),
+	}, true
+}
+
 // Stats returns mapping statistics
 type Stats struct {
 	TotalPatients   int
@@ -0,0 +1,292 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dicom-anonymizer/internal/fsutil"
+)
+
+func newTestMapper(tb testing.TB, mappingFile, salt string) *PseudonymizationMapper {
+	tb.Helper()
+
+	m, err := NewPseudonymizationMapper(mappingFile, salt)
+	if err != nil {
+		tb.Fatalf("NewPseudonymizationMapper failed: %v", err)
+	}
+	return m
+}
+
+func TestLegacyMappingKeepsExistingIDs(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	// Mapping file written before HMAC hashing (no hash_version)
+	legacy := MapperData{
+		IdentityMap: map[string]string{CreateLegacyIdentityHash("DOE^JOHN", "19800101", "salt"): "ANON-000001"},
+		PIDMap:      map[string]string{},
+		ReverseMap:  map[string]*ReverseMapEntry{},
+		Counter:     1,
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mappingFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMapper(t, mappingFile, "salt")
+	if got, _ := m.GetAnonID("", "DOE^JOHN", "19800101"); got != "ANON-000001" {
+		t.Errorf("existing patient got %q, want ANON-000001", got)
+	}
+
+	newID, _ := m.GetAnonID("", "ROE^JANE", "19900101")
+	if newID != "ANON-000002" {
+		t.Errorf("new patient got %q, want ANON-000002", newID)
+	}
+	if m.identityMap[CreateIdentityHash("ROE^JANE", "19900101", "salt")] != newID {
+		t.Errorf("new patient not stored under HMAC hash")
+	}
+	if m.hashVersion != HashVersionSHA256 {
+		t.Errorf("hash version = %d, want %d", m.hashVersion, HashVersionSHA256)
+	}
+}
+
+func TestFlushWritesDeferredChanges(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	m.SetDeferSave(true)
+	anonID, _ := m.GetAnonID("PID1", "DOE^JOHN", "19800101")
+
+	if got := newTestMapper(t, mappingFile, "salt").GetStats().TotalPatients; got != 0 {
+		t.Fatalf("mapping saved before flush: %d patients", got)
+	}
+
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got, _ := reloaded.GetAnonID("PID1", "DOE^JOHN", "19800101"); got != anonID {
+		t.Errorf("reloaded anon ID = %q, want %q", got, anonID)
+	}
+}
+
+func TestFlushKeepsChangesWhenWriteFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mappings")
+	mappingFile := filepath.Join(dir, "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	m.SetDeferSave(true)
+	anonID, _ := m.GetAnonID("PID1", "DOE^JOHN", "19800101")
+
+	// A file in place of the mapping directory makes the write fail
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err == nil {
+		t.Fatal("Flush succeeded although the mapping could not be written")
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("second Flush failed: %v", err)
+	}
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got, _ := reloaded.GetAnonID("PID1", "DOE^JOHN", "19800101"); got != anonID {
+		t.Errorf("reloaded anon ID = %q, want %q", got, anonID)
+	}
+}
+
+func TestDeferredSaveIsPeriodic(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	m.SetDeferSave(true)
+	for i := 0; i < SaveEvery; i++ {
+		m.GetAnonID(fmt.Sprintf("PID%d", i), "", "")
+	}
+
+	if got := newTestMapper(t, mappingFile, "salt").GetStats().TotalPatients; got != SaveEvery {
+		t.Errorf("saved %d patients after %d changes, want %d", got, SaveEvery, SaveEvery)
+	}
+}
+
+func benchmarkMapper(b *testing.B, deferSave bool) {
+	const patients = 5000
+
+	for n := 0; n < b.N; n++ {
+		m := newTestMapper(b, filepath.Join(b.TempDir(), "mapping.json"), "salt")
+		m.SetDeferSave(deferSave)
+		for i := 0; i < patients; i++ {
+			m.GetAnonID(fmt.Sprintf("PID%d", i), fmt.Sprintf("DOE^PATIENT%d", i), "19800101")
+		}
+		m.Flush()
+	}
+}
+
+func BenchmarkMapperSaveEachChange(b *testing.B) { benchmarkMapper(b, false) }
+func BenchmarkMapperDeferredSave(b *testing.B)   { benchmarkMapper(b, true) }
+
+func TestEncryptedMappingRoundTrip(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "secret")
+	if err := m.EnableEncryption(); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	anonID, _ := m.GetAnonID("PID1", "DOE^JOHN", "19800101")
+
+	data, err := os.ReadFile(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedMapping(data) {
+		t.Fatalf("mapping file is not encrypted")
+	}
+	if strings.Contains(string(data), "PID1") {
+		t.Errorf("mapping file contains plaintext PatientID")
+	}
+
+	reloaded := newTestMapper(t, mappingFile, "secret")
+	if got, _ := reloaded.GetAnonID("PID1", "DOE^JOHN", "19800101"); got != anonID {
+		t.Errorf("reloaded anon ID = %q, want %q", got, anonID)
+	}
+
+	_, err = NewPseudonymizationMapper(mappingFile, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "wrong key?") {
+		t.Errorf("wrong key error = %v, want \"wrong key?\"", err)
+	}
+}
+
+func TestEncryptionDropsPlaintextBackup(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	// Two plaintext saves leave a plaintext backup
+	m := newTestMapper(t, mappingFile, "secret")
+	m.GetAnonID("PID1", "", "")
+	m.GetAnonID("PID2", "", "")
+	if _, err := os.Stat(fsutil.BackupPath(mappingFile)); err != nil {
+		t.Fatalf("no backup before encrypting: %v", err)
+	}
+
+	m = newTestMapper(t, mappingFile, "secret")
+	if err := m.EnableEncryption(); err != nil {
+		t.Fatal(err)
+	}
+	m.Flush()
+	if _, err := os.Stat(fsutil.BackupPath(mappingFile)); !os.IsNotExist(err) {
+		t.Errorf("plaintext backup kept after encrypting: %v", err)
+	}
+
+	// Later saves back up the encrypted version as usual
+	m.GetAnonID("PID3", "", "")
+	data, err := os.ReadFile(fsutil.BackupPath(mappingFile))
+	if err != nil || !IsEncryptedMapping(data) {
+		t.Errorf("backup after encrypting is not encrypted: %v", err)
+	}
+}
+
+func TestFuzzyNamesPreserveExistingIDs(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	// Two variants mapped separately before fuzzy matching was enabled
+	m := newTestMapper(t, mappingFile, "salt")
+	jon, _ := m.GetAnonID("", "SMITH^JON", "19800101")
+	jonathan, _ := m.GetAnonID("", "SMITH^JONATHAN", "19800101")
+
+	m = newTestMapper(t, mappingFile, "salt")
+	m.EnableFuzzyNames(nil)
+
+	if got, _ := m.GetAnonID("", "SMITH^JONATHAN", "19800101"); got != jonathan {
+		t.Errorf("existing patient got %q, want %q", got, jonathan)
+	}
+	if got, _ := m.GetAnonID("", "SMITH^JON", "19800101"); got != jon {
+		t.Errorf("existing patient got %q, want %q", got, jon)
+	}
+
+	// A new variant resolves to one of them instead of a new ID
+	if got, _ := m.GetAnonID("", "SMITH^JON^A", "19800101"); got != jon && got != jonathan {
+		t.Errorf("fuzzy variant got new ID %q", got)
+	}
+
+	// New patients are stored under fuzzy hashes and keep matching later
+	// even without fuzzy matching enabled
+	roe, _ := m.GetAnonID("", "ROE^BILL^K", "19900101")
+	m.Flush()
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got, _ := reloaded.GetAnonID("", "ROE^WILLIAM", "19900101"); got != roe {
+		t.Errorf("fuzzy patient after reload got %q, want %q", got, roe)
+	}
+}
+
+func TestMappingNoteRecordsToolVersion(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "secret")
+	m.SetToolVersion("1.2.3 (commit abcdef0)")
+	m.GetAnonID("PID1", "DOE^JOHN", "19800101")
+
+	data, err := os.ReadFile(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved MapperData
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(saved.Note, "written by dicom-anonymizer 1.2.3 (commit abcdef0)") {
+		t.Errorf("note = %q, want tool version", saved.Note)
+	}
+}
+
+func TestMappingRecoversFromPartialWrite(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	first, _ := m.GetAnonID("MRN1", "DOE^JOHN", "19800101")
+	m.GetAnonID("MRN2", "ROE^JANE", "19900101") // Second save keeps the first as .bak
+
+	// Simulate a crash half way through a write that bypassed the rename
+	data, err := os.ReadFile(mappingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mappingFile, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered := newTestMapper(t, mappingFile, "salt")
+	if id, _ := recovered.GetAnonID("MRN1", "DOE^JOHN", "19800101"); id != first {
+		t.Errorf("recovered ID = %s, want %s from the backup", id, first)
+	}
+}
+
+func TestFileAnonIDIsStable(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	first, method := m.GetFileAnonID("sop:1.2.3")
+	if method != MatchFile {
+		t.Errorf("method = %q, want %q", method, MatchFile)
+	}
+	if other, _ := m.GetFileAnonID("sop:1.2.4"); other == first {
+		t.Errorf("different files share %s", first)
+	}
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got, _ := reloaded.GetFileAnonID("sop:1.2.3"); got != first {
+		t.Errorf("reloaded anon ID = %q, want %q", got, first)
+	}
+	if got := reloaded.GetStats().TotalPatients; got != 2 {
+		t.Errorf("TotalPatients = %d, want 2", got)
+	}
+}
@@ -0,0 +1,165 @@
+package identity
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Merge folds another mapping into m. Incoming patients are matched to
+// existing ones by identity hash, then by PatientID (as GetAnonID does),
+// then by file key (GetFileAnonID); unmatched patients get new IDs from m's counter. Each incoming ID is
+// recorded in the target entry's MergedFrom. Identity hashes, PatientIDs
+// or date shifts that already point elsewhere in m are kept as they are
+// and reported as conflicts. Both mappings must use the same secret key.
+func (m *PseudonymizationMapper) Merge(other *PseudonymizationMapper) (conflicts []string, err error) {
+	if other == m {
+		return nil, fmt.Errorf("cannot merge a mapping into itself")
+	}
+
+	// Snapshot the incoming mapping so only one lock is held at a time
+	other.mu.Lock()
+	source := filepath.Base(other.mappingFile)
+	identityMap := copyStringMap(other.identityMap)
+	pidMap := copyStringMap(other.pidMap)
+	fileMap := copyStringMap(other.fileMap)
+	dateShifts := make(map[string]int, len(other.dateShifts))
+	for id, days := range other.dateShifts {
+		dateShifts[id] = days
+	}
+	ids := make(map[string]bool, len(other.reverseMap))
+	for id := range other.reverseMap {
+		ids[id] = true
+	}
+	otherVersion := other.hashVersion
+	other.mu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hashesByID := invertMap(identityMap)
+	pidsByID := invertMap(pidMap)
+	keysByID := invertMap(fileMap)
+	for id := range hashesByID {
+		ids[id] = true
+	}
+	for id := range pidsByID {
+		ids[id] = true
+	}
+	for id := range keysByID {
+		ids[id] = true
+	}
+	for id := range dateShifts {
+		ids[id] = true
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	// Resolve each incoming ID to an existing or new ID
+	renamed := make(map[string]string, len(sorted))
+	for _, id := range sorted {
+		target := firstMapped(m.identityMap, hashesByID[id])
+		if target == "" {
+			target = firstMapped(m.pidMap, pidsByID[id])
+		}
+		if target == "" {
+			target = firstMapped(m.fileMap, keysByID[id])
+		}
+		if target == "" {
+			target = m.generateID()
+		}
+		renamed[id] = target
+
+		m.updateReverseMap(target, "", "")
+		entry := m.reverseMap[target]
+		if from := fmt.Sprintf("%s:%s", source, id); !contains(entry.MergedFrom, from) {
+			entry.MergedFrom = append(entry.MergedFrom, from)
+		}
+	}
+
+	for _, id := range sorted {
+		target := renamed[id]
+
+		for _, hash := range hashesByID[id] {
+			if existing, ok := m.identityMap[hash]; ok && existing != target {
+				conflicts = append(conflicts, fmt.Sprintf("identity %s maps to %s and to %s:%s (merged as %s)",
+					hash, existing, source, id, target))
+				continue
+			}
+			m.identityMap[hash] = target
+			m.updateReverseMap(target, hash, "")
+		}
+
+		for _, pid := range pidsByID[id] {
+			if existing, ok := m.pidMap[pid]; ok && existing != target {
+				conflicts = append(conflicts, fmt.Sprintf("PatientID %s maps to %s and to %s:%s (merged as %s)",
+					pid, existing, source, id, target))
+				continue
+			}
+			m.pidMap[pid] = target
+			m.updateReverseMap(target, "", pid)
+		}
+
+		for _, key := range keysByID[id] {
+			if existing, ok := m.fileMap[key]; ok && existing != target {
+				conflicts = append(conflicts, fmt.Sprintf("file %s maps to %s and to %s:%s (merged as %s)",
+					key, existing, source, id, target))
+				continue
+			}
+			m.fileMap[key] = target
+		}
+
+		if days, ok := dateShifts[id]; ok {
+			if existing, ok := m.dateShifts[target]; ok && existing != days {
+				conflicts = append(conflicts, fmt.Sprintf("date shift for %s is %+d, but %+d for %s:%s",
+					target, existing, days, source, id))
+			} else {
+				m.dateShifts[target] = days
+			}
+		}
+	}
+
+	if otherVersion < m.hashVersion {
+		m.hashVersion = otherVersion
+	}
+
+	if len(sorted) > 0 {
+		m.markDirty()
+	}
+
+	return conflicts, nil
+}
+
+// firstMapped returns the value of the first key present in mapping.
+func firstMapped(mapping map[string]string, keys []string) string {
+	for _, k := range keys {
+		if v, ok := mapping[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// invertMap groups the keys of mapping by value, each group sorted.
+func invertMap(mapping map[string]string) map[string][]string {
+	inverted := make(map[string][]string)
+	for k, v := range mapping {
+		inverted[v] = append(inverted[v], k)
+	}
+	for _, keys := range inverted {
+		sort.Strings(keys)
+	}
+	return inverted
+}
+
+func copyStringMap(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
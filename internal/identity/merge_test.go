@@ -0,0 +1,105 @@
+package identity
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeDisjoint(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestMapper(t, filepath.Join(dir, "a.json"), "salt")
+	b := newTestMapper(t, filepath.Join(dir, "b.json"), "salt")
+
+	a.GetAnonID("A1", "DOE^JOHN", "19800101")
+	b.GetAnonID("B1", "ROE^JANE", "19900101")
+	b.GetAnonID("B2", "", "")
+
+	conflicts, err := a.Merge(b)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	// Incoming IDs are renumbered after the existing ones
+	if got, _ := a.GetAnonID("B1", "ROE^JANE", "19900101"); got != "ANON-000002" {
+		t.Errorf("B1 = %q, want ANON-000002", got)
+	}
+	if got, _ := a.GetAnonID("B2", "", ""); got != "ANON-000003" {
+		t.Errorf("B2 = %q, want ANON-000003", got)
+	}
+
+	entry, _ := a.Reverse("ANON-000003")
+	if len(entry.MergedFrom) != 1 || entry.MergedFrom[0] != "b.json:ANON-000002" {
+		t.Errorf("MergedFrom = %v, want [b.json:ANON-000002]", entry.MergedFrom)
+	}
+	if got := a.GetStats().TotalPatients; got != 3 {
+		t.Errorf("TotalPatients = %d, want 3", got)
+	}
+}
+
+func TestMergeOverlappingIdentical(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestMapper(t, filepath.Join(dir, "a.json"), "salt")
+	b := newTestMapper(t, filepath.Join(dir, "b.json"), "salt")
+
+	// Same patient, registered in a different order at each site
+	a.GetAnonID("P1", "DOE^JOHN", "19800101")
+	b.GetAnonID("P2", "ROE^JANE", "19900101")
+	b.GetAnonID("P1", "DOE^JOHN", "19800101")
+	b.GetDateShift("ANON-000002")
+
+	conflicts, err := a.Merge(b)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+
+	if got, _ := a.GetAnonID("P1", "DOE^JOHN", "19800101"); got != "ANON-000001" {
+		t.Errorf("shared patient = %q, want ANON-000001", got)
+	}
+	if got := a.GetStats().TotalPatients; got != 2 {
+		t.Errorf("TotalPatients = %d, want 2", got)
+	}
+	if _, ok := a.LookupDateShift("ANON-000001"); !ok {
+		t.Errorf("date shift of shared patient was not merged")
+	}
+
+	// Merging the same mapping again changes nothing
+	conflicts, _ = a.Merge(b)
+	if len(conflicts) != 0 || a.GetStats().TotalPatients != 2 {
+		t.Errorf("second merge: conflicts %v, %d patients", conflicts, a.GetStats().TotalPatients)
+	}
+}
+
+func TestMergeConflicting(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestMapper(t, filepath.Join(dir, "a.json"), "salt")
+	b := newTestMapper(t, filepath.Join(dir, "b.json"), "salt")
+
+	// Two patients in a are known as one patient in b
+	a.GetAnonID("", "DOE^JOHN", "19800101")
+	a.GetAnonID("", "DOE^JON", "19800101")
+	b.GetAnonID("P1", "DOE^JOHN", "19800101")
+	b.GetAnonID("P1", "DOE^JON", "19800101")
+
+	conflicts, err := a.Merge(b)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %v, want 1", conflicts)
+	}
+
+	// Existing links are kept
+	if got, _ := a.GetAnonID("", "DOE^JON", "19800101"); got != "ANON-000002" {
+		t.Errorf("conflicting identity = %q, want ANON-000002", got)
+	}
+
+	if _, err := a.Merge(a); err == nil {
+		t.Errorf("merging a mapping into itself should fail")
+	}
+}
@@ -0,0 +1,77 @@
+package identity
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultNicknames maps common given-name short forms to a canonical name
+// for fuzzy name matching.
+var DefaultNicknames = map[string]string{
+	"ALEX":   "ALEXANDER",
+	"ANDY":   "ANDREW",
+	"DREW":   "ANDREW",
+	"TONY":   "ANTHONY",
+	"BEN":    "BENJAMIN",
+	"CHRIS":  "CHRISTOPHER",
+	"DAN":    "DANIEL",
+	"DANNY":  "DANIEL",
+	"DAVE":   "DAVID",
+	"ED":     "EDWARD",
+	"EDDIE":  "EDWARD",
+	"BETH":   "ELIZABETH",
+	"BETTY":  "ELIZABETH",
+	"LIZ":    "ELIZABETH",
+	"JIM":    "JAMES",
+	"JIMMY":  "JAMES",
+	"JEN":    "JENNIFER",
+	"JENNY":  "JENNIFER",
+	"JACK":   "JOHN",
+	"JOHNNY": "JOHN",
+	"JON":    "JONATHAN",
+	"JOE":    "JOSEPH",
+	"JOEY":   "JOSEPH",
+	"KATE":   "KATHERINE",
+	"KATIE":  "KATHERINE",
+	"CATHY":  "KATHERINE",
+	"MAGGIE": "MARGARET",
+	"PEGGY":  "MARGARET",
+	"MATT":   "MATTHEW",
+	"MIKE":   "MICHAEL",
+	"NICK":   "NICHOLAS",
+	"DICK":   "RICHARD",
+	"RICK":   "RICHARD",
+	"RICH":   "RICHARD",
+	"BOB":    "ROBERT",
+	"BOBBY":  "ROBERT",
+	"ROB":    "ROBERT",
+	"SAM":    "SAMUEL",
+	"STEVE":  "STEVEN",
+	"SUE":    "SUSAN",
+	"TOM":    "THOMAS",
+	"TOMMY":  "THOMAS",
+	"BILL":   "WILLIAM",
+	"BILLY":  "WILLIAM",
+	"WILL":   "WILLIAM",
+}
+
+// LoadNicknames reads a JSON object of nickname -> canonical name pairs.
+func LoadNicknames(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read nickname table: %w", err)
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid nickname table %s: %w", path, err)
+	}
+
+	nicknames := make(map[string]string, len(raw))
+	for nick, name := range raw {
+		nicknames[strings.ToUpper(strings.TrimSpace(nick))] = strings.ToUpper(strings.TrimSpace(name))
+	}
+	return nicknames, nil
+}
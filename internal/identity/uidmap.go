@@ -0,0 +1,316 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"dicom-anonymizer/internal/fsutil"
+	"dicom-anonymizer/internal/logging"
+)
+
+// DefaultUIDRoot is the UUID-derived root (ISO/IEC 9834-8) used when no
+// organization root is configured.
+const DefaultUIDRoot = "2.25"
+
+// maxUIDLength is the maximum length of a DICOM UI value.
+const maxUIDLength = 64
+
+// minUIDSuffixDigits is the number of hash digits a generated UID keeps at
+// least, so UIDs under a long root stay unique. It limits roots to 39
+// characters.
+const minUIDSuffixDigits = 24
+
+// ValidateUIDRoot checks that root is a DICOM UID prefix: components of
+// digits separated by dots, without leading zeros, short enough to leave
+// room for the generated part of the UID.
+func ValidateUIDRoot(root string) error {
+	root = strings.TrimSuffix(root, ".")
+	if root == "" {
+		return fmt.Errorf("invalid UID root: empty")
+	}
+	if maxRoot := maxUIDLength - minUIDSuffixDigits - 1; len(root) > maxRoot {
+		return fmt.Errorf("invalid UID root %q: longer than %d characters", root, maxRoot)
+	}
+	for _, component := range strings.Split(root, ".") {
+		if component == "" {
+			return fmt.Errorf("invalid UID root %q: empty component", root)
+		}
+		if strings.Trim(component, "0123456789") != "" {
+			return fmt.Errorf("invalid UID root %q: only digits and dots are allowed", root)
+		}
+		if len(component) > 1 && component[0] == '0' {
+			return fmt.Errorf("invalid UID root %q: component %s has a leading zero", root, component)
+		}
+	}
+	return nil
+}
+
+// SetUIDRoot sets the root of generated UIDs recorded in the mapping
+// (empty = the stored root, DefaultUIDRoot for a new mapping). Generated
+// UIDs depend on the root, so once a mapping has issued IDs a different
+// root is rejected; mappings from before the root was recorded used
+// DefaultUIDRoot.
+func (m *PseudonymizationMapper) SetUIDRoot(root string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if root == "" {
+		root = m.uidRoot
+	}
+	if root == "" {
+		root = DefaultUIDRoot
+	}
+	root = strings.TrimSuffix(root, ".")
+	if err := ValidateUIDRoot(root); err != nil {
+		return err
+	}
+
+	stored := m.uidRoot
+	if stored == "" && m.counter > 0 {
+		stored = DefaultUIDRoot
+	}
+	if stored == root {
+		if m.uidRoot == "" {
+			m.uidRoot = root
+			m.pending++ // Record the root on the next save
+		}
+		return nil
+	}
+	if stored != "" {
+		return fmt.Errorf("mapping was written with UID root %s; using %s would change the UIDs of files already anonymized", stored, root)
+	}
+
+	m.uidRoot = root
+	m.pending++
+	return nil
+}
+
+// UIDRoot returns the root of generated UIDs recorded in the mapping, or
+// "" if none has been set
+func (m *PseudonymizationMapper) UIDRoot() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.uidRoot
+}
+
+// UIDMapData is the JSON structure for persistence
+type UIDMapData struct {
+	Root    string            `json:"root"`
+	UIDMap  map[string]string `json:"uid_map"`
+	Updated string            `json:"updated"`
+	Note    string            `json:"note"`
+}
+
+// UIDMapper replaces DICOM UIDs with deterministic pseudonymous UIDs.
+// The same original UID always maps to the same new UID for a given salt
+// and root, so study/series/instance references stay intact across files.
+type UIDMapper struct {
+	mu          sync.Mutex
+	mappingFile string
+	salt        string
+	root        string
+	uidMap      map[string]string // original_uid -> anon_uid
+	dirty       bool
+	cipher      *mappingCipher // Non-nil when the mapping file is encrypted
+	loadErr     error          // Set when an existing file could not be read; Save refuses to replace it
+	log         logging.Logger
+}
+
+// UIDMappingFile returns the UID mapping path stored next to a patient
+// mapping file, e.g. patient_mapping.json -> patient_mapping_uids.json.
+func UIDMappingFile(mappingFile string) string {
+	if mappingFile == "" {
+		return ""
+	}
+	ext := filepath.Ext(mappingFile)
+	return strings.TrimSuffix(mappingFile, ext) + "_uids" + ext
+}
+
+// NewUIDMapper creates a new UID mapper, loading from file if it exists.
+// An empty root uses DefaultUIDRoot. Encrypted mapping files are
+// decrypted with the salt, as for the patient mapping.
+func NewUIDMapper(mappingFile, salt, root string) *UIDMapper {
+	return NewUIDMapperWithLogger(mappingFile, salt, root, nil)
+}
+
+// NewUIDMapperWithLogger is NewUIDMapper with load warnings sent to log
+// (nil = logging.Default()).
+func NewUIDMapperWithLogger(mappingFile, salt, root string, log logging.Logger) *UIDMapper {
+	if root == "" {
+		root = DefaultUIDRoot
+	}
+
+	m := &UIDMapper{
+		log:         logging.OrDefault(log),
+		mappingFile: mappingFile,
+		salt:        salt,
+		root:        strings.TrimSuffix(root, "."),
+		uidMap:      make(map[string]string),
+	}
+
+	if mappingFile != "" {
+		m.load()
+	}
+
+	return m
+}
+
+func (m *UIDMapper) load() {
+	var mapData UIDMapData
+	reader := &mappingReader{secret: m.salt}
+	recovered, err := fsutil.ReadWithBackup(m.mappingFile, func(data []byte) error {
+		data, err := reader.plaintext(data)
+		if err != nil {
+			return fmt.Errorf("cannot decrypt UID mapping %s: %w", m.mappingFile, err)
+		}
+		mapData = UIDMapData{}
+		return json.Unmarshal(data, &mapData)
+	})
+	if os.IsNotExist(err) {
+		return // File doesn't exist, start fresh
+	}
+	if err != nil {
+		m.log.Warnf("Could not load UID mapping file: %v", err)
+		if !errors.As(err, new(*json.SyntaxError)) && !errors.As(err, new(*json.UnmarshalTypeError)) {
+			m.loadErr = err // e.g. the wrong key: keep the file as it is
+		}
+		return
+	}
+	if recovered {
+		m.log.Warnf("UID mapping file %s is damaged; loaded the previous version from %s", m.mappingFile, fsutil.BackupPath(m.mappingFile))
+		m.dirty = true
+	}
+	m.cipher = reader.cipher
+
+	if mapData.UIDMap != nil {
+		m.uidMap = mapData.UIDMap
+	}
+}
+
+// EnableEncryption encrypts the UID mapping file from the next save on,
+// with a key derived from the salt. Files loaded encrypted stay encrypted.
+func (m *UIDMapper) EnableEncryption() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cipher != nil {
+		return nil
+	}
+
+	c, err := newMappingCipher(m.salt, nil)
+	if err != nil {
+		return err
+	}
+	m.cipher = c
+	m.dirty = true // Rewrite a plaintext file encrypted on the next save
+	return nil
+}
+
+// Save writes the UID mapping to disk if it changed since the last save.
+func (m *UIDMapper) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mappingFile == "" || !m.dirty {
+		return nil
+	}
+	if m.loadErr != nil {
+		return fmt.Errorf("UID mapping not saved, %s could not be read: %w", m.mappingFile, m.loadErr)
+	}
+
+	dir := filepath.Dir(m.mappingFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create mapping directory: %w", err)
+	}
+
+	mapData := UIDMapData{
+		Root:    m.root,
+		UIDMap:  m.uidMap,
+		Updated: time.Now().Format(time.RFC3339),
+		Note:    "uid_map maps original UIDs to generated UIDs, derived from hash(UID+salt)",
+	}
+
+	data, err := json.MarshalIndent(mapData, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal UID mapping: %w", err)
+	}
+
+	if m.cipher != nil {
+		if data, err = m.cipher.encrypt(data); err != nil {
+			return fmt.Errorf("could not encrypt UID mapping: %w", err)
+		}
+	}
+
+	if err := writeMappingFile(m.mappingFile, data, m.cipher != nil); err != nil {
+		return fmt.Errorf("could not save UID mapping: %w", err)
+	}
+
+	m.dirty = false
+	return nil
+}
+
+// Map returns the pseudonymous UID for an original UID, recording it in the
+// mapping. Empty UIDs map to empty strings.
+func (m *UIDMapper) Map(originalUID string) string {
+	// UI values are padded with NUL to an even length
+	originalUID = strings.TrimRight(strings.TrimSpace(originalUID), "\x00")
+	if originalUID == "" {
+		return ""
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if anonUID, ok := m.uidMap[originalUID]; ok {
+		return anonUID
+	}
+
+	anonUID := DeriveUID(originalUID, m.salt, m.root)
+	m.uidMap[originalUID] = anonUID
+	m.dirty = true
+	return anonUID
+}
+
+// Original returns the original UID for a generated UID.
+func (m *UIDMapper) Original(anonUID string) (string, bool) {
+	anonUID = strings.TrimRight(strings.TrimSpace(anonUID), "\x00")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for original, anon := range m.uidMap {
+		if anon == anonUID {
+			return original, true
+		}
+	}
+	return "", false
+}
+
+// Len returns the number of mapped UIDs.
+func (m *UIDMapper) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.uidMap)
+}
+
+// DeriveUID creates a valid DICOM UID under root from hash(originalUID+salt).
+// The suffix is the decimal form of the first 128 bits of the hash, trimmed
+// so the whole UID fits in 64 characters.
+func DeriveUID(originalUID, salt, root string) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s", originalUID, salt)))
+	suffix := new(big.Int).SetBytes(hash[:16]).String()
+
+	if room := maxUIDLength - len(root) - 1; len(suffix) > room {
+		suffix = suffix[:room]
+	}
+
+	return root + "." + suffix
+}
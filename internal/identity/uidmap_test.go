@@ -0,0 +1,115 @@
+package identity
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dicom-anonymizer/internal/logging"
+)
+
+func TestValidateUIDRoot(t *testing.T) {
+	valid := []string{"2.25", "1.2.840.99999", "1.2.0.3", "1.2.3."}
+	for _, root := range valid {
+		if err := ValidateUIDRoot(root); err != nil {
+			t.Errorf("ValidateUIDRoot(%q) = %v, want nil", root, err)
+		}
+	}
+
+	invalid := []string{"", "1..2", ".1.2", "1.02.3", "1.2a", "1.2.3 ", "1." + strings.Repeat("9", 40)}
+	for _, root := range invalid {
+		if err := ValidateUIDRoot(root); err == nil {
+			t.Errorf("ValidateUIDRoot(%q) = nil, want error", root)
+		}
+	}
+}
+
+func TestUIDRootStoredInMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	m := newTestMapper(t, mappingFile, "salt")
+	if err := m.SetUIDRoot("1.2.840.99999"); err != nil {
+		t.Fatalf("SetUIDRoot failed: %v", err)
+	}
+	m.GetAnonID("PID1", "", "")
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if got := reloaded.UIDRoot(); got != "1.2.840.99999" {
+		t.Errorf("UIDRoot() after reload = %q, want 1.2.840.99999", got)
+	}
+	if err := reloaded.SetUIDRoot("1.2.840.99999."); err != nil {
+		t.Errorf("same root rejected: %v", err)
+	}
+	for _, root := range []string{"1.2.840.88888", DefaultUIDRoot} {
+		if err := reloaded.SetUIDRoot(root); err == nil {
+			t.Errorf("SetUIDRoot(%q) on a mapping written with another root should fail", root)
+		}
+	}
+	if err := reloaded.SetUIDRoot(""); err != nil {
+		t.Errorf("empty root should use the stored one: %v", err)
+	}
+	if got := reloaded.UIDRoot(); got != "1.2.840.99999" {
+		t.Errorf("UIDRoot() after SetUIDRoot(\"\") = %q, want 1.2.840.99999", got)
+	}
+}
+
+func TestUIDRootOfLegacyMapping(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+
+	// IDs issued before the root was recorded used DefaultUIDRoot
+	m := newTestMapper(t, mappingFile, "salt")
+	m.GetAnonID("PID1", "", "")
+
+	reloaded := newTestMapper(t, mappingFile, "salt")
+	if err := reloaded.SetUIDRoot("1.2.840.99999"); err == nil {
+		t.Error("switching a legacy mapping away from the default root should fail")
+	}
+	if err := reloaded.SetUIDRoot(""); err != nil {
+		t.Errorf("default root rejected for a legacy mapping: %v", err)
+	}
+	if got := reloaded.UIDRoot(); got != DefaultUIDRoot {
+		t.Errorf("UIDRoot() = %q, want %q", got, DefaultUIDRoot)
+	}
+}
+
+func TestEncryptedUIDMapping(t *testing.T) {
+	uidFile := filepath.Join(t.TempDir(), "mapping_uids.json")
+
+	// A plaintext file from an earlier run is rewritten encrypted
+	m := NewUIDMapperWithLogger(uidFile, "secret", "", logging.Discard())
+	anonUID := m.Map("1.2.3.4")
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	m = NewUIDMapperWithLogger(uidFile, "secret", "", logging.Discard())
+	if err := m.EnableEncryption(); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(uidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedMapping(data) || strings.Contains(string(data), "1.2.3.4") {
+		t.Fatalf("UID mapping is not encrypted:\n%s", data)
+	}
+
+	reloaded := NewUIDMapperWithLogger(uidFile, "secret", "", logging.Discard())
+	if got, ok := reloaded.Original(anonUID); !ok || got != "1.2.3.4" {
+		t.Errorf("Original(%s) = %q, %v after reload", anonUID, got, ok)
+	}
+
+	// The wrong key must not replace the file with a fresh mapping
+	wrong := NewUIDMapperWithLogger(uidFile, "wrong", "", logging.Discard())
+	wrong.Map("5.6.7.8")
+	if err := wrong.Save(); err == nil {
+		t.Error("Save with the wrong key succeeded")
+	}
+	if after, _ := os.ReadFile(uidFile); string(after) != string(data) {
+		t.Error("UID mapping changed after loading it with the wrong key")
+	}
+}
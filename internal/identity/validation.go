@@ -1,6 +1,9 @@
 package identity
 
-import "strings"
+import (
+	"regexp"
+	"strings"
+)
 
 // PlaceholderNames are values that indicate missing/test data
 var PlaceholderNames = map[string]bool{
@@ -22,20 +25,77 @@ var PlaceholderDOBs = map[string]bool{
 	"99999999": true,
 }
 
+// IdentityReason is why a Name+DOB cannot identify a patient, so files
+// grouped by PatientID instead can be traced back to the source data
+type IdentityReason string
+
+const (
+	ReasonValid           IdentityReason = ""                 // Name and DOB identify the patient
+	ReasonMissingName     IdentityReason = "missing-name"     // PatientName is empty
+	ReasonPlaceholderName IdentityReason = "placeholder-name" // e.g. UNKNOWN or ANONYMOUS, see PlaceholderNames
+	ReasonShortName       IdentityReason = "short-name"       // Fewer than 3 characters after normalization
+	ReasonMissingDOB      IdentityReason = "missing-dob"      // PatientBirthDate is empty
+	ReasonPlaceholderDOB  IdentityReason = "placeholder-dob"  // e.g. 19000101, see PlaceholderDOBs
+	ReasonInvalidDOB      IdentityReason = "invalid-dob"      // Not a date NormalizeDOB accepts
+)
+
+var (
+	// 1980-01-02, 1980/01/02 or 1980.01.02; year first, so day and month
+	// cannot be swapped
+	separatedDOBRegex = regexp.MustCompile(`^(\d{4})([-/.])(\d{2})([-/.])(\d{2})$`)
+	// 19800102, or a DT value such as 19800102.000000 or 19800102120000
+	timedDOBRegex = regexp.MustCompile(`^(\d{8})(\d{6})?(\.\d{1,6})?$`)
+)
+
+// NormalizeDOB reduces a birth date to the 8 digits YYYYMMDD. Accepts
+// YYYY-MM-DD with a consistent separator and a DICOM DT value, whose time
+// is dropped. Returns "" for anything else, such as 02.01.1980 or 1980,
+// rather than guessing the order of day and month.
+func NormalizeDOB(s string) string {
+	s = strings.TrimSpace(s)
+	if m := separatedDOBRegex.FindStringSubmatch(s); m != nil {
+		if m[2] != m[4] {
+			return ""
+		}
+		return m[1] + m[3] + m[5]
+	}
+	if m := timedDOBRegex.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
 // IsValidIdentity checks if name and DOB are real values, not placeholders.
 func IsValidIdentity(name, dob string) bool {
+	valid, _ := CheckIdentity(name, dob)
+	return valid
+}
+
+// CheckIdentity is IsValidIdentity with the reason an identity is not
+// valid. The name is checked before the DOB.
+func CheckIdentity(name, dob string) (bool, IdentityReason) {
 	nameNormalized := strings.ToLower(NormalizeName(name))
-	dobStr := strings.TrimSpace(dob)
+	dobStr := NormalizeDOB(dob)
 
 	// Check if name is placeholder or too short
-	if PlaceholderNames[nameNormalized] || len(nameNormalized) < 3 {
-		return false
+	switch {
+	case nameNormalized == "":
+		return false, ReasonMissingName
+	case PlaceholderNames[nameNormalized]:
+		return false, ReasonPlaceholderName
+	case len(nameNormalized) < 3:
+		return false, ReasonShortName
 	}
 
-	// Check if DOB is placeholder or wrong length
-	if PlaceholderDOBs[dobStr] || len(dobStr) != 8 {
-		return false
+	// Check if DOB is missing, not a date or a placeholder
+	switch {
+	case strings.TrimSpace(dob) == "":
+		return false, ReasonMissingDOB
+	case dobStr == "":
+		return false, ReasonInvalidDOB
+	case PlaceholderDOBs[dobStr]:
+		return false, ReasonPlaceholderDOB
 	}
 
-	return true
+	return true, ReasonValid
 }
@@ -0,0 +1,66 @@
+package identity
+
+import "testing"
+
+func TestCheckIdentity(t *testing.T) {
+	tests := []struct {
+		name, dob string
+		want      IdentityReason
+	}{
+		{"SMITH^JOHN", "19800101", ReasonValid},
+		{"", "19800101", ReasonMissingName},
+		{"^^", "19800101", ReasonMissingName},
+		{"UNKNOWN", "19800101", ReasonPlaceholderName},
+		{"Anonymous", "", ReasonPlaceholderName}, // Name is checked first
+		{"LI", "19800101", ReasonShortName},
+		{"SMITH^JOHN", "", ReasonMissingDOB},
+		{"SMITH^JOHN", "19000101", ReasonPlaceholderDOB},
+		{"SMITH^JOHN", "1980", ReasonInvalidDOB},
+		{"SMITH^JOHN", "1980-01-02", ReasonValid},
+		{"SMITH^JOHN", "1900-01-01", ReasonPlaceholderDOB},
+		{"SMITH^JOHN", "02.01.1980", ReasonInvalidDOB},
+	}
+	for _, tt := range tests {
+		valid, reason := CheckIdentity(tt.name, tt.dob)
+		if reason != tt.want || valid != (tt.want == ReasonValid) {
+			t.Errorf("CheckIdentity(%q, %q) = %v, %q, want %q", tt.name, tt.dob, valid, reason, tt.want)
+		}
+		if IsValidIdentity(tt.name, tt.dob) != valid {
+			t.Errorf("IsValidIdentity(%q, %q) disagrees with CheckIdentity", tt.name, tt.dob)
+		}
+	}
+}
+
+func TestNormalizeDOB(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"19800102", "19800102"},
+		{" 19800102 ", "19800102"},
+		{"1980-01-02", "19800102"},
+		{"1980/01/02", "19800102"},
+		{"1980.01.02", "19800102"},
+		{"19800102.000000", "19800102"},
+		{"19800102120000", "19800102"},
+		{"", ""},
+		{"1980", ""},
+		{"1980-01/02", ""}, // Mixed separators
+		{"02.01.1980", ""}, // Day and month order unknown
+		{"01/02/1980", ""},
+		{"1980-1-2", ""},
+		{"1980ABCD", ""},
+		{"198001021", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeDOB(tt.in); got != tt.want {
+			t.Errorf("NormalizeDOB(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIdentityHashIgnoresDOBFormat(t *testing.T) {
+	want := CreateIdentityHash("SMITH^JOHN", "19800102", "salt")
+	for _, dob := range []string{"1980-01-02", "19800102.000000", " 19800102"} {
+		if got := CreateIdentityHash("SMITH^JOHN", dob, "salt"); got != want {
+			t.Errorf("CreateIdentityHash with DOB %q = %s, want %s", dob, got, want)
+		}
+	}
+}
@@ -1,34 +1,72 @@
 package jpegls
 
-import "io"
+import (
+	"io"
+	"sync"
+)
+
+// bufferPool recycles BitWriter output buffers, which batch encoding would
+// otherwise allocate for every scan.
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
 
 // BitWriter provides bit-level writing to an underlying byte stream.
 // Bits are written MSB-first (most significant bit first), which is
 // the standard for JPEG-LS encoding.
+//
+// JPEG-LS stuffs bits rather than bytes (ITU-T T.87 A.1): after a 0xFF
+// byte, the next byte carries only 7 data bits behind a 0 bit, so coded
+// data can never look like a marker.
 type BitWriter struct {
 	w        io.Writer
 	buf      []byte // output buffer
 	bitBuf   uint32 // current bits being accumulated
 	bitCount int    // number of bits in bitBuf (0-32)
+	afterFF  bool   // last byte written was 0xFF
 }
 
-// NewBitWriter creates a new BitWriter that writes to w.
+// NewBitWriter creates a new BitWriter that writes to w. Its buffer comes
+// from a pool; call Release once done to return it.
 func NewBitWriter(w io.Writer) *BitWriter {
+	buf := bufferPool.Get().(*[]byte)
 	return &BitWriter{
 		w:   w,
-		buf: make([]byte, 0, 4096),
+		buf: (*buf)[:0],
+	}
+}
+
+// Release returns the buffer to the pool. Bits not yet flushed are lost,
+// so call Flush first. The BitWriter must not be used afterwards.
+func (bw *BitWriter) Release() {
+	if bw.buf == nil {
+		return
 	}
+	buf := bw.buf[:0]
+	bw.buf = nil
+	bufferPool.Put(&buf)
 }
 
 // WriteBit writes a single bit (0 or 1).
 func (bw *BitWriter) WriteBit(bit int) {
 	bw.bitBuf = (bw.bitBuf << 1) | uint32(bit&1)
 	bw.bitCount++
-	if bw.bitCount >= 8 {
+	if bw.bitCount >= bw.byteBits() {
 		bw.flushByte()
 	}
 }
 
+// byteBits returns the number of data bits in the next output byte
+func (bw *BitWriter) byteBits() int {
+	if bw.afterFF {
+		return 7
+	}
+	return 8
+}
+
 // WriteBits writes n bits from val (MSB first).
 // The top n bits of val are ignored; only the low n bits are written.
 func (bw *BitWriter) WriteBits(val, n int) {
@@ -71,23 +109,19 @@ func (bw *BitWriter) WriteByteSlice(data []byte) {
 
 // flushByte writes one complete byte from the bit buffer.
 func (bw *BitWriter) flushByte() {
-	if bw.bitCount < 8 {
+	n := bw.byteBits()
+	if bw.bitCount < n {
 		return
 	}
 
-	// Extract the top 8 bits
-	shift := bw.bitCount - 8
+	// Extract the top n bits; after 0xFF the stuffed MSB is 0
+	shift := bw.bitCount - n
 	b := byte(bw.bitBuf >> shift)
 	bw.bitBuf &= (1 << shift) - 1
 	bw.bitCount = shift
 
 	bw.buf = append(bw.buf, b)
-
-	// JPEG-LS requires byte stuffing after 0xFF
-	// Insert a 0x00 byte after each 0xFF to prevent marker confusion
-	if b == 0xFF {
-		bw.buf = append(bw.buf, 0x00)
-	}
+	bw.afterFF = b == 0xFF
 
 	// Flush buffer if it gets large
 	if len(bw.buf) >= 4000 {
@@ -104,16 +138,13 @@ func (bw *BitWriter) flushBuffer() {
 }
 
 // Flush writes any remaining bits and the buffer to the output.
-// Partial bytes are padded with 1 bits (per JPEG standard).
+// Partial bytes are padded with 0 bits, and a final 0xFF byte is followed
+// by a 0x00 so the next marker is not mistaken for stuffed data.
 func (bw *BitWriter) Flush() error {
-	// Pad with 1 bits to byte boundary (JPEG convention)
-	for bw.bitCount > 0 && bw.bitCount < 8 {
-		bw.WriteBit(1)
-	}
-
-	// Flush any remaining complete byte
-	if bw.bitCount >= 8 {
-		bw.flushByte()
+	bw.ByteAlign()
+	if bw.afterFF {
+		bw.buf = append(bw.buf, 0x00)
+		bw.afterFF = false
 	}
 
 	// Write remaining buffer
@@ -126,13 +157,10 @@ func (bw *BitWriter) Flush() error {
 	return nil
 }
 
-// ByteAlign pads the current byte with 1 bits and moves to the next byte.
+// ByteAlign pads the current byte with 0 bits and moves to the next byte.
 func (bw *BitWriter) ByteAlign() {
-	if bw.bitCount > 0 {
-		for bw.bitCount < 8 {
-			bw.WriteBit(1)
-		}
-		bw.flushByte()
+	for bw.bitCount > 0 {
+		bw.WriteBit(0)
 	}
 }
 
@@ -11,12 +11,18 @@ type Context struct {
 	C int
 	// N is the occurrence count
 	N int
+	// Nn counts negative errors (run interruption contexts only)
+	Nn int
 }
 
+// contextSlots is the size of the regular context table. GetContextIndex
+// spreads the 365 contexts over [0, 404], leaving some slots unused.
+const contextSlots = 5 * 81
+
 // ContextModel manages all encoding contexts and their statistics.
 type ContextModel struct {
-	// Regular mode contexts (365 total)
-	contexts [ContextCount]Context
+	// Regular mode contexts (365 used)
+	contexts [contextSlots]Context
 
 	// Run mode contexts (2 total)
 	runContexts [RunContextCount]Context
@@ -30,10 +36,16 @@ type ContextModel struct {
 
 // NewContextModel creates a new context model with initialized statistics.
 func NewContextModel(params *Params) *ContextModel {
-	cm := &ContextModel{
-		params:   params,
-		runIndex: 0,
-	}
+	cm := &ContextModel{}
+	cm.Reset(params)
+	return cm
+}
+
+// Reset restores the initial statistics for params in place, so an
+// encoder reused across frames does not reallocate its contexts.
+func (cm *ContextModel) Reset(params *Params) {
+	cm.params = params
+	cm.runIndex = 0
 
 	// Initialize all regular contexts (ITU-T T.87 A.2.1)
 	initA := max((params.Range+32)/64, 2)
@@ -55,46 +67,44 @@ func NewContextModel(params *Params) *ContextModel {
 			N: 1,
 		}
 	}
-
-	return cm
 }
 
-// QuantizeGradient quantizes a gradient value to the range [-4, 4].
-// This is the core of JPEG-LS context determination.
-// Per ITU-T T.87 Table A.7:
+// QuantizeGradient quantizes a gradient value to the range [-4, 4] for
+// lossless coding. This is the core of JPEG-LS context determination.
+// Per ITU-T T.87 A.3.3 with NEAR=0:
 //
-//	D < -T3          => Q = -4
-//	-T3 ≤ D < -T2    => Q = -3
-//	-T2 ≤ D < -T1    => Q = -2
-//	-T1 ≤ D < 0      => Q = -1
+//	D ≤ -T3          => Q = -4
+//	-T3 < D ≤ -T2    => Q = -3
+//	-T2 < D ≤ -T1    => Q = -2
+//	-T1 < D < 0      => Q = -1
 //	D = 0            => Q = 0
-//	0 < D ≤ T1       => Q = 1
-//	T1 < D ≤ T2      => Q = 2
-//	T2 < D ≤ T3      => Q = 3
-//	T3 < D           => Q = 4
+//	0 < D < T1       => Q = 1
+//	T1 ≤ D < T2      => Q = 2
+//	T2 ≤ D < T3      => Q = 3
+//	T3 ≤ D           => Q = 4
 func QuantizeGradient(g, t1, t2, t3 int) int {
-	if g < -t3 {
+	return quantizeGradient(g, t1, t2, t3, 0)
+}
+
+// quantizeGradient quantizes a gradient with the NEAR tolerance: gradients
+// within ±NEAR count as flat.
+func quantizeGradient(g, t1, t2, t3, near int) int {
+	switch {
+	case g <= -t3:
 		return -4
-	}
-	if g < -t2 {
+	case g <= -t2:
 		return -3
-	}
-	if g < -t1 {
+	case g <= -t1:
 		return -2
-	}
-	if g < 0 {
+	case g < -near:
 		return -1
-	}
-	if g == 0 {
+	case g <= near:
 		return 0
-	}
-	if g <= t1 {
+	case g < t1:
 		return 1
-	}
-	if g <= t2 {
+	case g < t2:
 		return 2
-	}
-	if g <= t3 {
+	case g < t3:
 		return 3
 	}
 	return 4
@@ -128,7 +138,7 @@ func GetContextIndex(q1, q2, q3 int) (idx int, sign int) {
 
 // GetContext returns the context for the given index.
 func (cm *ContextModel) GetContext(idx int) *Context {
-	if idx < 0 || idx >= ContextCount {
+	if idx < 0 || idx >= contextSlots {
 		return &cm.contexts[0]
 	}
 	return &cm.contexts[idx]
@@ -163,28 +173,21 @@ func (ctx *Context) ComputeK(maxK int) int {
 	return k
 }
 
-// UpdateStatistics updates the context statistics after encoding a sample.
-// errval is the (possibly mapped) prediction error.
+// UpdateStatistics updates the context statistics after encoding a sample
+// (ITU-T T.87 A.6). errval is the quantized, modulo-reduced prediction
+// error before mapping.
 func (ctx *Context) UpdateStatistics(errval, near, reset int) {
-	// Update B (bias accumulator)
-	ctx.B += errval
+	// Update B (bias accumulator, in units of the reconstructed error)
+	ctx.B += errval * (2*near + 1)
 
 	// Update A (error magnitude accumulator)
-	absErr := errval
-	if absErr < 0 {
-		absErr = -absErr
-	}
-	ctx.A += absErr
+	ctx.A += iabs(errval)
 
-	// Check for reset (when N reaches RESET threshold)
+	// Halve the statistics when N reaches RESET
 	if ctx.N == reset {
-		ctx.A = (ctx.A + 1) >> 1
-		if ctx.B >= 0 {
-			ctx.B = (ctx.B + 1) >> 1
-		} else {
-			ctx.B = -((1 - ctx.B) >> 1)
-		}
-		ctx.N = (ctx.N + 1) >> 1
+		ctx.A >>= 1
+		ctx.B >>= 1
+		ctx.N >>= 1
 	}
 
 	// Increment occurrence count
@@ -234,6 +237,12 @@ func (cm *ContextModel) DecrementRunIndex() {
 	}
 }
 
+// SetRunIndex restores a run index saved with GetRunIndex. Line-interleaved
+// scans keep a separate run index per component.
+func (cm *ContextModel) SetRunIndex(idx int) {
+	cm.runIndex = idx
+}
+
 // ResetRunIndex resets the run index to 0.
 func (cm *ContextModel) ResetRunIndex() {
 	cm.runIndex = 0
@@ -241,8 +250,16 @@ func (cm *ContextModel) ResetRunIndex() {
 
 // ComputeContextFromGradients computes the context index from raw gradients.
 func (cm *ContextModel) ComputeContextFromGradients(g1, g2, g3 int) (idx int, sign int) {
-	q1 := QuantizeGradient(g1, cm.params.T1, cm.params.T2, cm.params.T3)
-	q2 := QuantizeGradient(g2, cm.params.T1, cm.params.T2, cm.params.T3)
-	q3 := QuantizeGradient(g3, cm.params.T1, cm.params.T2, cm.params.T3)
+	p := cm.params
+	q1 := quantizeGradient(g1, p.T1, p.T2, p.T3, p.Near)
+	q2 := quantizeGradient(g2, p.T1, p.T2, p.T3, p.Near)
+	q3 := quantizeGradient(g3, p.T1, p.T2, p.T3, p.Near)
 	return GetContextIndex(q1, q2, q3)
 }
+
+// InRunMode reports whether the gradients select run mode: all of them
+// within ±NEAR (ITU-T T.87 A.3.1).
+func (cm *ContextModel) InRunMode(g1, g2, g3 int) bool {
+	near := cm.params.Near
+	return iabs(g1) <= near && iabs(g2) <= near && iabs(g3) <= near
+}
@@ -2,9 +2,11 @@ package jpegls
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -141,6 +143,10 @@ func getMarkerName(marker byte) string {
 		return "SOS"
 	case MarkerLSE:
 		return "LSE"
+	case MarkerAPP8:
+		return "APP8"
+	case MarkerCOM:
+		return "COM"
 	default:
 		return "UNKNOWN"
 	}
@@ -172,3 +178,75 @@ func TestEncoderOutputValidity(t *testing.T) {
 		t.Error("Missing SOS marker")
 	}
 }
+
+// TestEncoderCommentAndSPIFF checks that the optional SPIFF header and COM
+// segment are well formed and leave the frame and scan untouched
+func TestEncoderCommentAndSPIFF(t *testing.T) {
+	width, height := 8, 4
+	pixels := make([]int, width*height)
+	for i := range pixels {
+		pixels[i] = (i * 29) % 256
+	}
+	plain, err := NewEncoder(width, height, 1, 8).Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	comment := "dicom-anonymizer \xFF\xD9" // Marker-like bytes need no stuffing
+	encoded, err := NewEncoderWithOptions(width, height, 1, 8, EncoderOptions{
+		SPIFF:   true,
+		Comment: comment,
+	}).Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	// Walk the segments up to the frame
+	var markers []string
+	var segments [][]byte
+	pos := 2
+	for pos+4 <= len(encoded) && encoded[pos] == 0xFF && encoded[pos+1] != MarkerSOF55 {
+		length := int(binary.BigEndian.Uint16(encoded[pos+2:]))
+		markers = append(markers, getMarkerName(encoded[pos+1]))
+		segments = append(segments, encoded[pos+4:pos+2+length])
+		pos += 2 + length
+	}
+	if got := strings.Join(markers, " "); got != "APP8 APP8 COM" {
+		t.Fatalf("segments before SOF55 = %q, want APP8 APP8 COM", got)
+	}
+	spiff := segments[0]
+	if len(spiff) != 30 || string(spiff[:6]) != "SPIFF\x00" {
+		t.Errorf("SPIFF header = % X", spiff)
+	}
+	if h, w := binary.BigEndian.Uint32(spiff[10:]), binary.BigEndian.Uint32(spiff[14:]); h != uint32(height) || w != uint32(width) {
+		t.Errorf("SPIFF size = %dx%d, want %dx%d", w, h, width, height)
+	}
+	if spiff[18] != SPIFFColorSpaceGrayscale || spiff[19] != 8 || spiff[20] != spiffCompressionJPEGLS {
+		t.Errorf("SPIFF color space, bits, compression = %d, %d, %d", spiff[18], spiff[19], spiff[20])
+	}
+	if !bytes.Equal(segments[1], []byte{0, 0, 0, spiffEndOfDirectory, 0xFF, MarkerSOI}) {
+		t.Errorf("SPIFF end of directory = % X", segments[1])
+	}
+	if string(segments[2]) != comment {
+		t.Errorf("COM = %q, want %q", segments[2], comment)
+	}
+
+	// The rest of the stream is the plain encoding after its SOI
+	if !bytes.Equal(encoded[pos:], plain[2:]) {
+		t.Error("SOF55/SOS sequence differs from the encoding without extra segments")
+	}
+	img, err := refDecode(encoded)
+	if err != nil {
+		t.Fatalf("reference decode failed: %v", err)
+	}
+	for i, want := range pixels {
+		if img.pixels[i] != want {
+			t.Fatalf("pixel %d = %d, want %d", i, img.pixels[i], want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCOM(&buf, strings.Repeat("x", MaxCommentLength+1)); err == nil {
+		t.Error("WriteCOM accepted a comment longer than a segment")
+	}
+}
@@ -2,9 +2,48 @@ package jpegls
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"image"
 )
 
+// ErrAborted is returned by Encode when the OnRow callback returns false.
+var ErrAborted = errors.New("jpegls: encoding aborted")
+
+// Interleave modes for multi-component scans (ILV in the SOS marker).
+const (
+	ILVNone   = 0 // Non-interleaved: one component per scan
+	ILVLine   = 1 // Line-interleaved: one full row of each component in turn
+	ILVSample = 2 // Sample-interleaved: all components of a pixel in turn
+)
+
+// EncoderOptions holds optional encoder settings.
+type EncoderOptions struct {
+	// Interleave selects the interleave mode for multi-component images.
+	// ILVNone (the zero value) falls back to ILVSample because separate
+	// scans per component are not supported. Ignored for grayscale.
+	Interleave int
+
+	// Near is the NEAR parameter of near-lossless coding: every decoded
+	// sample is within ±Near of the original. 0 (the default) is lossless.
+	Near int
+
+	// OnRow, if set, is called once after each image row is encoded with
+	// the number of rows done and the image height. Returning false stops
+	// encoding and Encode returns ErrAborted. Used for progress reporting
+	// and to honour cancellation on large frames.
+	OnRow func(row, total int) bool
+
+	// SPIFF adds a SPIFF header (APP8) after SOI, for decoders that
+	// expect one to identify the image.
+	SPIFF bool
+
+	// Comment, if set, is written as a COM segment before the frame,
+	// e.g. to name the encoder. At most MaxCommentLength bytes.
+	Comment string
+}
+
 // Encoder encodes image data using JPEG-LS compression.
 type Encoder struct {
 	params  *Params
@@ -14,25 +53,73 @@ type Encoder struct {
 	height  int
 	samples int // samples per pixel (1 for grayscale, 3 for RGB)
 	bpp     int // bits per pixel/sample
+	ilv     int // interleave mode for multi-component images
+	onRow   func(row, total int) bool
+	spiff   bool
+	comment string
+	planes  []int // reconstruction buffer, kept across Reset
+	input   []int // EncodeBytes conversion buffer, kept across Reset
+
+	// Trace, if set, is called for every sample coded in regular mode with
+	// its position, context index, Golomb parameter k and mapped error
+	// value; run mode samples are not reported. It is meant for diagnostics,
+	// e.g. comparing coding decisions against a CharLS run to find where
+	// two bitstreams diverge, and slows encoding down while set. In
+	// multi-component images it is called once per component sample.
+	Trace func(x, y, ctxIdx, k, mapped int)
 }
 
 // NewEncoder creates a new JPEG-LS encoder.
 func NewEncoder(width, height, samples, bpp int) *Encoder {
-	params := NewParams(bpp, 0) // Lossless
-	cm := NewContextModel(params)
-	runEnc := NewRunModeEncoder(cm, params)
+	return NewEncoderWithOptions(width, height, samples, bpp, EncoderOptions{})
+}
+
+// NewEncoderWithOptions creates a new JPEG-LS encoder with the given options.
+func NewEncoderWithOptions(width, height, samples, bpp int, opts EncoderOptions) *Encoder {
+	params := NewParams(bpp, opts.Near)
+
+	ilv := opts.Interleave
+	if ilv != ILVLine {
+		ilv = ILVSample
+	}
 
 	return &Encoder{
 		params:  params,
-		cm:      cm,
-		runEnc:  runEnc,
 		width:   width,
 		height:  height,
 		samples: samples,
 		bpp:     bpp,
+		ilv:     ilv,
+		onRow:   opts.OnRow,
+		spiff:   opts.SPIFF,
+		comment: opts.Comment,
 	}
 }
 
+// Reset prepares the encoder for an image of another geometry, keeping its
+// options, contexts and buffers, so encoding many frames allocates little
+// beyond each output stream. An Encoder must not be used concurrently.
+func (e *Encoder) Reset(width, height, samples, bpp int) {
+	if bpp != e.bpp {
+		// The thresholds depend on MAXVAL; NEAR and RESET are the caller's
+		params := NewParams(bpp, e.params.Near)
+		params.Reset = e.params.Reset
+		e.params = params
+	}
+	e.width = width
+	e.height = height
+	e.samples = samples
+	e.bpp = bpp
+}
+
+// scratch returns a buffer of n ints for reconstructed samples
+func (e *Encoder) scratch(n int) []int {
+	if cap(e.planes) < n {
+		e.planes = make([]int, n)
+	}
+	return e.planes[:n]
+}
+
 // Encode compresses the given pixel data and returns the JPEG-LS bitstream.
 // pixels should be in row-major order.
 // For grayscale, pixels is a single []int.
@@ -42,6 +129,18 @@ func (e *Encoder) Encode(pixels []int) ([]byte, error) {
 		return nil, fmt.Errorf("pixel count mismatch: expected %d, got %d",
 			e.width*e.height*e.samples, len(pixels))
 	}
+	if near := e.params.Near; near < 0 || near > min(255, e.params.MaxVal/2) {
+		return nil, fmt.Errorf("NEAR %d out of range [0, %d]", near, min(255, e.params.MaxVal/2))
+	}
+
+	// Contexts and RUNindex start fresh for every image
+	if e.cm == nil {
+		e.cm = NewContextModel(e.params)
+		e.runEnc = NewRunModeEncoder(e.cm, e.params)
+	} else {
+		e.cm.Reset(e.params)
+		e.runEnc.params = e.params
+	}
 
 	var buf bytes.Buffer
 
@@ -66,6 +165,14 @@ func (e *Encoder) Encode(pixels []int) ([]byte, error) {
 	}
 
 	WriteSOI(&buf)
+	if e.spiff {
+		WriteSPIFFHeader(&buf, frameInfo)
+	}
+	if e.comment != "" {
+		if err := WriteCOM(&buf, e.comment); err != nil {
+			return nil, err
+		}
+	}
 	WriteSOF55(&buf, frameInfo)
 	if scanInfo.UsePreset {
 		WriteLSEPreset(&buf, scanInfo)
@@ -79,14 +186,18 @@ func (e *Encoder) Encode(pixels []int) ([]byte, error) {
 			return nil, err
 		}
 	} else {
-		// Multi-component: sample-interleaved single scan (ILV=2)
-		scanInfo.ILV = 2
+		// Multi-component: single interleaved scan (ILV=1 or ILV=2)
+		scanInfo.ILV = e.ilv
 		componentIDs := make([]int, e.samples)
 		for i := 0; i < e.samples; i++ {
 			componentIDs[i] = i + 1
 		}
 		WriteSOSComponents(&buf, scanInfo, componentIDs)
-		if err := e.encodeSampleInterleaved(&buf, pixels); err != nil {
+		if e.ilv == ILVLine {
+			if err := e.encodeLineInterleaved(&buf, pixels); err != nil {
+				return nil, err
+			}
+		} else if err := e.encodeSampleInterleaved(&buf, pixels); err != nil {
 			return nil, err
 		}
 	}
@@ -101,37 +212,19 @@ func (e *Encoder) Encode(pixels []int) ([]byte, error) {
 func (e *Encoder) encodeComponent(buf *bytes.Buffer, pixels []int) error {
 	// Create bit writer
 	bw := NewBitWriter(buf)
+	defer bw.Release()
 
 	// Create a working copy for reconstruction
-	recon := make([]int, len(pixels))
+	recon := e.scratch(len(pixels))
 	copy(recon, pixels)
+	ng := NewNeighborGetter(recon, e.width, e.height, 0)
 
-	defaultVal := (e.params.MaxVal + 1) / 2
-	ng := NewNeighborGetter(recon, e.width, e.height, defaultVal)
-
-	// Process each row
+	// Process each row. RUNindex carries over from one line to the next.
 	for y := 0; y < e.height; y++ {
-		// RUNindex is reset at the start of each line (A.2.1 in ITU-T T.87)
-		e.cm.ResetRunIndex()
+		e.encodeLine(bw, ng, y)
 
-		x := 0
-		for x < e.width {
-			// Get neighbors for current position
-			a, b, c, d := ng.GetNeighbors(x, y)
-
-			// Compute gradients
-			g1, g2, g3 := ComputeGradients(a, b, c, d)
-
-			// Check for run mode
-			if DetectRunMode(g1, g2, g3) && x < e.width {
-				// Run mode: encode a sequence of similar pixels
-				consumed := e.runEnc.EncodeRun(bw, ng.pixels, x, y, e.width, a)
-				x += consumed
-			} else {
-				// Regular mode: encode single pixel
-				e.encodeRegularSample(bw, ng, x, y, a, b, c, g1, g2, g3)
-				x++
-			}
+		if err := e.rowDone(y); err != nil {
+			return err
 		}
 	}
 
@@ -139,180 +232,117 @@ func (e *Encoder) encodeComponent(buf *bytes.Buffer, pixels []int) error {
 	return bw.Flush()
 }
 
-// encodeSampleInterleaved encodes multi-component images in ILV=2 mode.
-// Each pixel is encoded with components in sequence.
-func (e *Encoder) encodeSampleInterleaved(buf *bytes.Buffer, pixels []int) error {
+// encodeLineInterleaved encodes multi-component images in ILV=1 mode.
+// For each image line, the full row of every component is encoded in turn.
+// Context statistics are shared across components (ITU-T T.87 A.2.1), while
+// each component keeps its own RUNindex.
+func (e *Encoder) encodeLineInterleaved(buf *bytes.Buffer, pixels []int) error {
 	bw := NewBitWriter(buf)
+	defer bw.Release()
+	ngs := e.splitComponents(pixels)
+	runIndex := make([]int, e.samples)
 
-	defaultVal := (e.params.MaxVal + 1) / 2
-	componentSize := e.width * e.height
-
-	recon := make([][]int, e.samples)
-	ngs := make([]*NeighborGetter, e.samples)
-	cms := make([]*ContextModel, e.samples)
-
-	for comp := 0; comp < e.samples; comp++ {
-		compPixels := make([]int, componentSize)
-		for i := 0; i < componentSize; i++ {
-			compPixels[i] = pixels[i*e.samples+comp]
+	for y := 0; y < e.height; y++ {
+		for comp, ng := range ngs {
+			e.cm.SetRunIndex(runIndex[comp])
+			e.encodeLine(bw, ng, y)
+			runIndex[comp] = e.cm.GetRunIndex()
 		}
 
-		recon[comp] = compPixels
-		ngs[comp] = NewNeighborGetter(recon[comp], e.width, e.height, defaultVal)
-		cms[comp] = NewContextModel(e.params)
+		if err := e.rowDone(y); err != nil {
+			return err
+		}
 	}
 
-	for y := 0; y < e.height; y++ {
-		for x := 0; x < e.width; x++ {
-			for comp := 0; comp < e.samples; comp++ {
-				ng := ngs[comp]
-				cm := cms[comp]
+	return bw.Flush()
+}
 
-				// RUNindex resets once per image line.
-				if x == 0 {
-					cm.ResetRunIndex()
-				}
+// encodeSampleInterleaved encodes multi-component images in ILV=2 mode.
+// Each pixel is encoded with components in sequence, sharing one set of
+// contexts. Run mode is used when every component is flat.
+func (e *Encoder) encodeSampleInterleaved(buf *bytes.Buffer, pixels []int) error {
+	bw := NewBitWriter(buf)
+	defer bw.Release()
+	ngs := e.splitComponents(pixels)
+	gradients := make([][3]int, e.samples)
 
+	for y := 0; y < e.height; y++ {
+		x := 0
+		for x < e.width {
+			run := true
+			for comp, ng := range ngs {
 				a, b, c, d := ng.GetNeighbors(x, y)
 				g1, g2, g3 := ComputeGradients(a, b, c, d)
+				gradients[comp] = [3]int{g1, g2, g3}
+				run = run && e.cm.InRunMode(g1, g2, g3)
+			}
 
-				// Run mode is not used for interleaved multi-component scans.
-				e.encodeRegularSampleWithContext(bw, ng, cm, x, y, a, b, c, g1, g2, g3)
+			if run {
+				x += e.runEnc.EncodeRunInterleaved(bw, ngs, x, y)
+				continue
+			}
+			for comp, ng := range ngs {
+				g := gradients[comp]
+				e.encodeRegularSample(bw, ng, x, y, g[0], g[1], g[2])
 			}
+			x++
+		}
+
+		if err := e.rowDone(y); err != nil {
+			return err
 		}
 	}
 
 	return bw.Flush()
 }
 
-// encodeRunMode encodes pixels using run-length mode.
-func (e *Encoder) encodeRunMode(bw *BitWriter, ng *NeighborGetter, x, y, refVal int) int {
-	// Get the pixels for run detection
-	pixels := ng.pixels
-	startIdx := y*e.width + x
-
-	// Count run length
-	runLength := 0
-	remaining := e.width - x
-
-	for i := 0; i < remaining; i++ {
-		if pixels[startIdx+i] == refVal {
-			runLength++
-		} else {
-			break
+// splitComponents copies each component of interleaved pixels into its own
+// plane for reconstruction.
+func (e *Encoder) splitComponents(pixels []int) []*NeighborGetter {
+	componentSize := e.width * e.height
+	planes := e.scratch(componentSize * e.samples)
+	ngs := make([]*NeighborGetter, e.samples)
+	for comp := 0; comp < e.samples; comp++ {
+		compPixels := planes[comp*componentSize : (comp+1)*componentSize]
+		for i := 0; i < componentSize; i++ {
+			compPixels[i] = pixels[i*e.samples+comp]
 		}
+		ngs[comp] = NewNeighborGetter(compPixels, e.width, e.height, 0)
 	}
+	return ngs
+}
 
-	// Encode run segments using J-table
-	encoded := 0
-	for encoded < runLength {
-		runIdx := e.cm.GetRunIndex()
-		if runIdx >= len(JTable) {
-			runIdx = len(JTable) - 1
-		}
-
-		rk := JTable[runIdx].RK
-		segmentSize := 1 << rk
-
-		if runLength-encoded >= segmentSize {
-			// Complete segment
-			bw.WriteBit(1)
-			encoded += segmentSize
-			e.cm.IncrementRunIndex()
+// encodeLine encodes row y of one component, switching between regular
+// and run mode.
+func (e *Encoder) encodeLine(bw *BitWriter, ng *NeighborGetter, y int) {
+	x := 0
+	for x < e.width {
+		a, b, c, d := ng.GetNeighbors(x, y)
+		g1, g2, g3 := ComputeGradients(a, b, c, d)
+
+		if e.cm.InRunMode(g1, g2, g3) {
+			// Run mode: encode a sequence of similar pixels
+			x += e.runEnc.EncodeRun(bw, ng, x, y)
 		} else {
-			// Partial segment
-			remainder := runLength - encoded
-			bw.WriteBit(0)
-			bw.WriteBits(remainder, rk)
-			encoded = runLength
-			e.cm.ResetRunIndex()
+			// Regular mode: encode single pixel
+			e.encodeRegularSample(bw, ng, x, y, g1, g2, g3)
+			x++
 		}
 	}
-
-	// Check if run ended before end of row
-	if runLength < remaining {
-		// Encode the run interruption sample
-		interruptIdx := startIdx + runLength
-		sample := pixels[interruptIdx]
-
-		// Get Rb (pixel above the interruption)
-		rb := refVal
-		if y > 0 {
-			rb = ng.Get(x+runLength, y-1)
-		}
-
-		e.encodeRunInterruption(bw, sample, refVal, rb)
-
-		return runLength + 1
-	}
-
-	return runLength
 }
 
-// encodeRunInterruption encodes the sample that interrupts a run.
-func (e *Encoder) encodeRunInterruption(bw *BitWriter, sample, ra, rb int) {
-	// Determine context (0 or 1 based on |Ra-Rb|)
-	ctxIdx := 0
-	diff := ra - rb
-	if diff < 0 {
-		diff = -diff
-	}
-	if diff > e.params.Near {
-		ctxIdx = 1
-	}
-
-	// Get prediction and sign
-	predicted := rb
-	sign := 1
-	if ra < rb {
-		predicted = ra
-		sign = -1
-	} else if ra > rb {
-		// Keep predicted = rb, sign = 1
-	}
-
-	// Compute error
-	errval := sample - predicted
-	errval *= sign
-
-	// Modulo reduction
-	errval = ReduceError(errval, e.params.Range)
-
-	// Map error
-	mapped := MapErrorValue(errval, e.params.Near)
-
-	// Get run context
-	ctx := e.cm.GetRunContext(ctxIdx)
-
-	// Compute k
-	k := ctx.ComputeK(LimitK)
-
-	// Adjust k for run mode (A.7.2 in ITU-T T.87)
-	runIdx := e.cm.GetRunIndex()
-	if runIdx > 0 {
-		k = max(0, k-1)
-	}
-
-	// Encode using modified limit for run interruption (A.7.2.1)
-	// The limit is LIMIT - J[RUNindex] - 1, where J[] is the RK value
-	rk := 0
-	if runIdx < len(JTable) {
-		rk = JTable[runIdx].RK
+// rowDone reports row y as finished to the OnRow callback.
+func (e *Encoder) rowDone(y int) error {
+	if e.onRow != nil && !e.onRow(y+1, e.height) {
+		return ErrAborted
 	}
-	limit := max(2, e.params.Limit-rk-1)
-	EncodeGolomb(bw, mapped, k, limit, e.params.Qbpp)
-
-	// Update statistics
-	ctx.UpdateStatistics(errval, e.params.Near, e.params.Reset)
-
-	// Decrement run index
-	e.cm.DecrementRunIndex()
+	return nil
 }
 
-// encodeRegularSample encodes a single sample in regular mode.
-func (e *Encoder) encodeRegularSample(bw *BitWriter, ng *NeighborGetter, x, y, a, b, c, g1, g2, g3 int) {
-	// Get actual pixel value
-	actual := ng.Get(x, y)
+// encodeRegularSample encodes a single sample in regular mode and stores its
+// reconstructed value (ITU-T T.87 A.4 - A.6).
+func (e *Encoder) encodeRegularSample(bw *BitWriter, ng *NeighborGetter, x, y, g1, g2, g3 int) {
+	a, b, c, _ := ng.GetNeighbors(x, y)
 
 	// Get context index and sign
 	idx, sign := e.cm.ComputeContextFromGradients(g1, g2, g3)
@@ -322,63 +352,13 @@ func (e *Encoder) encodeRegularSample(bw *BitWriter, ng *NeighborGetter, x, y, a
 	px := Predict(a, b, c)
 	px = CorrectPrediction(px, ctx.GetBiasCorrection(), sign, e.params.MaxVal)
 
-	// Compute error
-	errval := actual - px
-	errval *= sign
-
-	// Modulo reduction
-	errval = ReduceError(errval, e.params.Range)
-
-	// Map error
-	mapped := MapErrorValue(errval, e.params.Near)
-
-	// Compute k parameter
-	k := ctx.ComputeK(LimitK)
-
-	// Encode using Golomb-Rice
-	EncodeGolomb(bw, mapped, k, e.params.Limit, e.params.Qbpp)
-
-	// Update context statistics
-	ctx.UpdateStatistics(errval, e.params.Near, e.params.Reset)
-
-	// Reconstruct sample (for use as neighbor)
-	reconstructed := ReconstructSample(px, errval, sign, e.params.Near, e.params.MaxVal)
-	ng.SetPixel(x, y, reconstructed)
-}
-
-func (e *Encoder) encodeRegularSampleWithContext(bw *BitWriter, ng *NeighborGetter, cm *ContextModel, x, y, a, b, c, g1, g2, g3 int) {
-	// Get actual pixel value
-	actual := ng.Get(x, y)
-
-	// Get context index and sign
-	idx, sign := cm.ComputeContextFromGradients(g1, g2, g3)
-	ctx := cm.GetContext(idx)
-
-	// Compute prediction with bias correction
-	px := Predict(a, b, c)
-	px = CorrectPrediction(px, ctx.GetBiasCorrection(), sign, e.params.MaxVal)
-
-	// Compute error
-	errval := actual - px
-	errval *= sign
-
-	// Modulo reduction
-	errval = ReduceError(errval, e.params.Range)
-
-	// Map error
-	mapped := MapErrorValue(errval, e.params.Near)
-
-	// Compute k parameter
-	k := ctx.ComputeK(LimitK)
-
-	// Encode using Golomb-Rice
-	EncodeGolomb(bw, mapped, k, e.params.Limit, e.params.Qbpp)
-
-	// Update context statistics
-	ctx.UpdateStatistics(errval, e.params.Near, e.params.Reset)
+	// Compute the quantized, modulo-reduced error and code it
+	reconstructed, k, mapped := encodeRegularMode(bw, ctx, ng.Get(x, y), px, sign, e.params)
+	if e.Trace != nil {
+		e.Trace(x, y, idx, k, mapped)
+	}
 
-	// Reconstruct sample (for use as neighbor)
-	reconstructed := ReconstructSample(px, errval, sign, e.params.Near, e.params.MaxVal)
+	// Store the reconstructed sample for use as neighbor
 	ng.SetPixel(x, y, reconstructed)
 }
 
@@ -406,34 +386,107 @@ func EncodeGrayscale16(pixels []uint16, width, height, bpp int) ([]byte, error)
 	return enc.Encode(intPixels)
 }
 
+// EncodeImage encodes a Go image losslessly. Supported types:
+//   - *image.Gray: 8-bit grayscale
+//   - *image.Gray16: 16-bit grayscale
+//   - *image.RGBA: 8-bit RGB, sample-interleaved; the image must be opaque,
+//     as JPEG-LS has no alpha channel
+//
+// Other image types return an error; convert them with image/draw first.
+func EncodeImage(img image.Image) ([]byte, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("empty image")
+	}
+
+	var pixels []int
+	var samples, bpp int
+	switch m := img.(type) {
+	case *image.Gray:
+		samples, bpp = 1, 8
+		pixels = make([]int, 0, width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for _, v := range m.Pix[m.PixOffset(b.Min.X, y):][:width] {
+				pixels = append(pixels, int(v))
+			}
+		}
+	case *image.Gray16:
+		samples, bpp = 1, 16
+		pixels = make([]int, 0, width*height)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(b.Min.X, y):][:width*2]
+			for i := 0; i < len(row); i += 2 {
+				pixels = append(pixels, int(row[i])<<8|int(row[i+1]))
+			}
+		}
+	case *image.RGBA:
+		if !m.Opaque() {
+			return nil, fmt.Errorf("image has transparency, which JPEG-LS cannot encode")
+		}
+		samples, bpp = 3, 8
+		pixels = make([]int, 0, width*height*3)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			row := m.Pix[m.PixOffset(b.Min.X, y):][:width*4]
+			for i := 0; i < len(row); i += 4 {
+				pixels = append(pixels, int(row[i]), int(row[i+1]), int(row[i+2]))
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported image type %T (use *image.Gray, *image.Gray16 or *image.RGBA)", img)
+	}
+
+	enc := NewEncoder(width, height, samples, bpp)
+	return enc.Encode(pixels)
+}
+
 // EncodeFromBytes encodes pixel data from a byte slice.
-// bytesPerSample should be 1 for 8-bit, 2 for 16-bit data.
-// For 16-bit, little-endian byte order is assumed.
-func EncodeFromBytes(data []byte, width, height, samples, bpp int) ([]byte, error) {
-	bytesPerSample := (bpp + 7) / 8
-	expectedLen := width * height * samples * bytesPerSample
-
-	if len(data) != expectedLen {
-		return nil, fmt.Errorf("data length mismatch: expected %d, got %d", expectedLen, len(data))
+// bitsAllocated sets the sample size: 1 byte up to 8 bits, otherwise 2
+// bytes in little-endian order. Samples are masked to their low bitsStored
+// bits, which also set the coded precision (e.g. 12 bits stored in 16).
+// A bitsStored of 0 or above bitsAllocated means bitsAllocated.
+func EncodeFromBytes(data []byte, width, height, samples, bitsAllocated, bitsStored int) ([]byte, error) {
+	return EncodeFromBytesWithOrder(data, width, height, samples, bitsAllocated, bitsStored, binary.LittleEndian)
+}
+
+// EncodeFromBytesWithOrder is EncodeFromBytes for 2-byte samples in the
+// given byte order (e.g. binary.BigEndian for Explicit VR Big Endian data).
+// The order is ignored for 1-byte samples.
+func EncodeFromBytesWithOrder(data []byte, width, height, samples, bitsAllocated, bitsStored int, order binary.ByteOrder) ([]byte, error) {
+	if bitsStored <= 0 || bitsStored > bitsAllocated {
+		bitsStored = bitsAllocated
 	}
+	return NewEncoder(width, height, samples, bitsStored).EncodeBytes(data, bitsAllocated, order)
+}
 
-	// Convert to int
-	pixelCount := width * height * samples
-	intPixels := make([]int, pixelCount)
+// EncodeBytes is Encode for raw samples of bitsAllocated bits, as
+// EncodeFromBytesWithOrder takes them; the encoder's bpp is the bits
+// stored. The samples are converted in a buffer that is kept across
+// Reset, so encoding the frames of a multi-frame image with one encoder
+// allocates little beyond each output stream.
+func (e *Encoder) EncodeBytes(data []byte, bitsAllocated int, order binary.ByteOrder) ([]byte, error) {
+	bytesPerSample := (bitsAllocated + 7) / 8
+	pixelCount := e.width * e.height * e.samples
+	if len(data) != pixelCount*bytesPerSample {
+		return nil, fmt.Errorf("data length mismatch: expected %d, got %d", pixelCount*bytesPerSample, len(data))
+	}
+
+	// Convert to int, dropping bits above bitsStored (overlays or sign
+	// extension) that would exceed MAXVAL
+	if cap(e.input) < pixelCount {
+		e.input = make([]int, pixelCount)
+	}
+	intPixels := e.input[:pixelCount]
+	mask := 1<<e.bpp - 1
 
 	if bytesPerSample == 1 {
 		for i := 0; i < pixelCount; i++ {
-			intPixels[i] = int(data[i])
+			intPixels[i] = int(data[i]) & mask
 		}
 	} else {
-		// 16-bit, little-endian
 		for i := 0; i < pixelCount; i++ {
-			lo := data[i*2]
-			hi := data[i*2+1]
-			intPixels[i] = int(lo) | (int(hi) << 8)
+			intPixels[i] = int(order.Uint16(data[i*2:])) & mask
 		}
 	}
-
-	enc := NewEncoder(width, height, samples, bpp)
-	return enc.Encode(intPixels)
+	return e.Encode(intPixels)
 }
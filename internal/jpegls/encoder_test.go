@@ -2,6 +2,10 @@ package jpegls
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
 	"testing"
 )
 
@@ -339,7 +343,7 @@ func TestEncode16Bit(t *testing.T) {
 func TestEncodeFromBytes(t *testing.T) {
 	// Test 8-bit encoding
 	data8 := []byte{100, 101, 102, 103, 100, 101, 102, 103}
-	encoded8, err := EncodeFromBytes(data8, 4, 2, 1, 8)
+	encoded8, err := EncodeFromBytes(data8, 4, 2, 1, 8, 8)
 	if err != nil {
 		t.Fatalf("EncodeFromBytes (8-bit) failed: %v", err)
 	}
@@ -354,7 +358,7 @@ func TestEncodeFromBytes(t *testing.T) {
 		0x02, 0x01, // 258
 		0x03, 0x01, // 259
 	}
-	encoded16, err := EncodeFromBytes(data16, 2, 2, 1, 16)
+	encoded16, err := EncodeFromBytes(data16, 2, 2, 1, 16, 16)
 	if err != nil {
 		t.Fatalf("EncodeFromBytes (16-bit) failed: %v", err)
 	}
@@ -363,6 +367,203 @@ func TestEncodeFromBytes(t *testing.T) {
 	}
 }
 
+func TestEncodeFromBytesBitsStored(t *testing.T) {
+	// 12 bits stored in 16 bits allocated; the top nibble holds overlay bits
+	width, height := 4, 2
+	raw := make([]byte, width*height*2)
+	masked := make([]byte, len(raw))
+	for i := 0; i < width*height; i++ {
+		v := 0x0100 + i*300
+		raw[2*i], raw[2*i+1] = byte(v), byte(v>>8)|0xF0
+		masked[2*i], masked[2*i+1] = byte(v), byte(v>>8)
+	}
+
+	encoded, err := EncodeFromBytes(raw, width, height, 1, 16, 12)
+	if err != nil {
+		t.Fatalf("EncodeFromBytes failed: %v", err)
+	}
+	want, err := EncodeFromBytes(masked, width, height, 1, 16, 12)
+	if err != nil {
+		t.Fatalf("EncodeFromBytes (masked) failed: %v", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("bits above BitsStored were not masked")
+	}
+
+	// SOF55 sample precision follows BitsStored: SOI(2) marker(2) length(2) P
+	if p := encoded[6]; p != 12 {
+		t.Errorf("SOF55 precision = %d, want 12", p)
+	}
+	enc := NewEncoder(width, height, 1, 12)
+	if enc.params.MaxVal != 4095 || enc.params.Range != 4096 {
+		t.Errorf("MaxVal/Range = %d/%d, want 4095/4096", enc.params.MaxVal, enc.params.Range)
+	}
+}
+
+func TestEncodeFromBytesByteOrder(t *testing.T) {
+	// The same 16-bit samples in both byte orders, RGB with 12 bits stored
+	width, height, samples := 5, 3, 3
+	le := make([]byte, width*height*samples*2)
+	be := make([]byte, len(le))
+	for i := 0; i < width*height*samples; i++ {
+		v := uint16(i*263) & 0x0FFF
+		binary.LittleEndian.PutUint16(le[2*i:], v)
+		binary.BigEndian.PutUint16(be[2*i:], v)
+	}
+
+	want, err := EncodeFromBytes(le, width, height, samples, 16, 12)
+	if err != nil {
+		t.Fatalf("EncodeFromBytes failed: %v", err)
+	}
+	got, err := EncodeFromBytesWithOrder(be, width, height, samples, 16, 12, binary.BigEndian)
+	if err != nil {
+		t.Fatalf("EncodeFromBytesWithOrder failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("big-endian input encoded differently from the same little-endian samples")
+	}
+
+	// 8-bit samples have no byte order
+	data8 := []byte{1, 2, 3, 4, 5, 6}
+	want, _ = EncodeFromBytes(data8, 3, 2, 1, 8, 8)
+	got, _ = EncodeFromBytesWithOrder(data8, 3, 2, 1, 8, 8, binary.BigEndian)
+	if !bytes.Equal(got, want) {
+		t.Error("byte order changed 8-bit output")
+	}
+}
+
+func TestEncodeImage(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 6, 4))
+	gray16 := image.NewGray16(image.Rect(0, 0, 6, 4))
+	rgba := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	var grayPixels []byte
+	var gray16Pixels []uint16
+	var rgbPixels []int
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			v := x*40 + y*9
+			gray.SetGray(x, y, color.Gray{uint8(v)})
+			gray16.SetGray16(x, y, color.Gray16{uint16(v * 211)})
+			rgba.SetRGBA(x, y, color.RGBA{uint8(v), uint8(255 - v), uint8(x * y), 255})
+			grayPixels = append(grayPixels, uint8(v))
+			gray16Pixels = append(gray16Pixels, uint16(v*211))
+			rgbPixels = append(rgbPixels, v, 255-v, x*y)
+		}
+	}
+
+	got, err := EncodeImage(gray)
+	want, _ := EncodeGrayscale(grayPixels, 6, 4)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Gray: err=%v, output differs from EncodeGrayscale", err)
+	}
+
+	got, err = EncodeImage(gray16)
+	want, _ = EncodeGrayscale16(gray16Pixels, 6, 4, 16)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Gray16: err=%v, output differs from EncodeGrayscale16", err)
+	}
+
+	got, err = EncodeImage(rgba)
+	if err != nil {
+		t.Fatalf("RGBA: %v", err)
+	}
+	img, err := refDecode(got)
+	if err != nil {
+		t.Fatalf("RGBA decode failed: %v", err)
+	}
+	if img.components != 3 || len(img.pixels) != len(rgbPixels) {
+		t.Fatalf("RGBA decoded %d components, %d samples", img.components, len(img.pixels))
+	}
+	for i, v := range rgbPixels {
+		if img.pixels[i] != v {
+			t.Fatalf("RGBA sample %d = %d, want %d", i, img.pixels[i], v)
+		}
+	}
+
+	// Sub-images encode only their bounds
+	sub := gray.SubImage(image.Rect(2, 1, 5, 3))
+	got, err = EncodeImage(sub)
+	want, _ = EncodeGrayscale([]byte{89, 129, 169, 98, 138, 178}, 3, 2)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("SubImage: err=%v, output differs from its pixels", err)
+	}
+
+	rgba.SetRGBA(0, 0, color.RGBA{0, 0, 0, 128})
+	if _, err := EncodeImage(rgba); err == nil {
+		t.Error("expected an error for a transparent RGBA image")
+	}
+	if _, err := EncodeImage(image.NewPaletted(image.Rect(0, 0, 2, 2), nil)); err == nil {
+		t.Error("expected an error for an unsupported image type")
+	}
+}
+
+// syntheticRGBGradient builds an interleaved RGB image with a horizontal
+// gradient in R, a vertical gradient in G and a constant B plane.
+func syntheticRGBGradient(width, height int) []int {
+	pixels := make([]int, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * 3
+			pixels[i] = (x * 4) % 256
+			pixels[i+1] = (y * 4) % 256
+			pixels[i+2] = 128
+		}
+	}
+	return pixels
+}
+
+func TestEncodeLineInterleaved(t *testing.T) {
+	width, height := 64, 64
+	pixels := syntheticRGBGradient(width, height)
+
+	lineEnc := NewEncoderWithOptions(width, height, 3, 8, EncoderOptions{Interleave: ILVLine})
+	lineOut, err := lineEnc.Encode(pixels)
+	if err != nil {
+		t.Fatalf("ILV=1 encode failed: %v", err)
+	}
+
+	sampleEnc := NewEncoderWithOptions(width, height, 3, 8, EncoderOptions{Interleave: ILVSample})
+	sampleOut, err := sampleEnc.Encode(pixels)
+	if err != nil {
+		t.Fatalf("ILV=2 encode failed: %v", err)
+	}
+
+	t.Logf("RGB gradient: ILV=1 %d bytes, ILV=2 %d bytes", len(lineOut), len(sampleOut))
+
+	if len(lineOut) >= len(sampleOut) {
+		t.Errorf("ILV=1 output (%d bytes) should be smaller than ILV=2 output (%d bytes)",
+			len(lineOut), len(sampleOut))
+	}
+
+	// SOS layout: marker(2) length(2) Ns(1) Ns*(Cs,Tm) NEAR ILV Pt
+	sos := bytes.Index(lineOut, []byte{0xFF, MarkerSOS})
+	if sos < 0 {
+		t.Fatal("Missing SOS marker")
+	}
+	ns := int(lineOut[sos+4])
+	if ilv := lineOut[sos+4+2+ns*2]; ilv != ILVLine {
+		t.Errorf("SOS ILV = %d, want %d", ilv, ILVLine)
+	}
+}
+
+func TestEncoderDefaultInterleave(t *testing.T) {
+	pixels := syntheticRGBGradient(8, 8)
+
+	encoded, err := NewEncoder(8, 8, 3, 8).Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	sos := bytes.Index(encoded, []byte{0xFF, MarkerSOS})
+	if sos < 0 {
+		t.Fatal("Missing SOS marker")
+	}
+	ns := int(encoded[sos+4])
+	if ilv := encoded[sos+4+2+ns*2]; ilv != ILVSample {
+		t.Errorf("default SOS ILV = %d, want %d", ilv, ILVSample)
+	}
+}
+
 func TestNeighborGetter(t *testing.T) {
 	// Create a 4x4 test image
 	pixels := []int{
@@ -387,10 +588,13 @@ func TestNeighborGetter(t *testing.T) {
 		t.Errorf("GetNeighbors(1,0) upper neighbors should be 128, got b=%d c=%d d=%d", b, c, d)
 	}
 
-	// Test first column
+	// Test first column: a is b, c is the first sample two rows up
 	a, b, c, d = ng.GetNeighbors(0, 2)
-	if a != b || c != b {
-		t.Errorf("GetNeighbors(0,2) first column: a=%d b=%d c=%d (a and c should equal b)", a, b, c)
+	if a != b || c != 1 {
+		t.Errorf("GetNeighbors(0,2) first column: a=%d b=%d c=%d (a should equal b, c should be 1)", a, b, c)
+	}
+	if _, _, c, _ = ng.GetNeighbors(0, 1); c != 128 {
+		t.Errorf("GetNeighbors(0,1) first column: c=%d, want default 128", c)
 	}
 }
 
@@ -406,6 +610,56 @@ func TestRunModeDetection(t *testing.T) {
 	}
 }
 
+func TestEncodeRunSegments(t *testing.T) {
+	// Run length bits per ITU-T T.87 A.7.1.2. A run ending on a segment
+	// boundary before the end of the line is still terminated by a 0 bit
+	// and J[RUNindex] zero bits; at the end of the line it is not.
+	tests := []struct {
+		name         string
+		runIndex     int
+		runLength    int
+		endOfLine    bool
+		bits         string
+		wantRunIndex int
+	}{
+		{"empty run", 0, 0, false, "0", 0},
+		{"partial segment", 0, 3, false, "1110", 3},
+		{"boundary mid-line", 0, 4, false, "11110" + "0", 4},
+		{"boundary mid-line J=1", 4, 2, false, "1" + "0" + "0", 5},
+		{"boundary mid-line J=3", 12, 16, false, "11" + "0" + "000", 14},
+		{"boundary at end of line", 4, 2, true, "1", 5},
+		{"partial at end of line", 4, 1, true, "1", 4},
+		{"remainder mid-line", 4, 1, false, "0" + "1", 4},
+		{"remainder after segments", 8, 9, false, "11" + "0" + "01", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := NewParams(8, 0)
+			cm := NewContextModel(params)
+			cm.SetRunIndex(tt.runIndex)
+
+			var buf bytes.Buffer
+			bw := NewBitWriter(&buf)
+			NewRunModeEncoder(cm, params).encodeRunSegments(bw, tt.runLength, tt.endOfLine)
+			bw.Flush()
+
+			want := make([]byte, (len(tt.bits)+7)/8)
+			for i, c := range tt.bits {
+				if c == '1' {
+					want[i/8] |= 0x80 >> (i % 8)
+				}
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("bits = %08b, want %s", buf.Bytes(), tt.bits)
+			}
+			if got := cm.GetRunIndex(); got != tt.wantRunIndex {
+				t.Errorf("RUNindex = %d, want %d", got, tt.wantRunIndex)
+			}
+		})
+	}
+}
+
 func BenchmarkEncodeGrayscale(b *testing.B) {
 	// Create a 256x256 test image
 	width, height := 256, 256
@@ -439,3 +693,180 @@ func BenchmarkEncode16Bit(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkEncoderReuse encodes a series of frames with one Encoder, as a
+// multi-frame ultrasound file does; compare allocations with
+// BenchmarkEncoderPerFrame.
+func BenchmarkEncoderReuse(b *testing.B) {
+	width, height := 256, 256
+	pixels := make([]int, width*height)
+	for i := range pixels {
+		pixels[i] = i % 251
+	}
+
+	enc := NewEncoder(width, height, 1, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		enc.Reset(width, height, 1, 8)
+		if _, err := enc.Encode(pixels); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoderPerFrame(b *testing.B) {
+	width, height := 256, 256
+	pixels := make([]int, width*height)
+	for i := range pixels {
+		pixels[i] = i % 251
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewEncoder(width, height, 1, 8).Encode(pixels); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	frames := []struct {
+		width, height, samples, bpp int
+	}{
+		{16, 8, 1, 8},
+		{16, 8, 1, 8},
+		{7, 5, 3, 8},
+		{9, 4, 1, 12},
+	}
+
+	enc := NewEncoder(1, 1, 1, 8)
+	for i, f := range frames {
+		pixels := make([]int, f.width*f.height*f.samples)
+		for j := range pixels {
+			pixels[j] = (j*31 + i*7) % (1 << f.bpp)
+		}
+
+		want, err := NewEncoder(f.width, f.height, f.samples, f.bpp).Encode(pixels)
+		if err != nil {
+			t.Fatalf("frame %d: Encode failed: %v", i, err)
+		}
+		enc.Reset(f.width, f.height, f.samples, f.bpp)
+		got, err := enc.Encode(pixels)
+		if err != nil {
+			t.Fatalf("frame %d: Encode after Reset failed: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d: reused encoder output differs from a new encoder", i)
+		}
+	}
+}
+
+func TestEncoderResetKeepsParameters(t *testing.T) {
+	enc := NewEncoderWithOptions(4, 4, 1, 8, EncoderOptions{Near: 2})
+	enc.params.Reset = 32
+	enc.Reset(4, 4, 1, 12)
+	if enc.params.Near != 2 || enc.params.Reset != 32 {
+		t.Errorf("after Reset NEAR = %d, RESET = %d; want 2 and 32", enc.params.Near, enc.params.Reset)
+	}
+	if want := NewParams(12, 2); enc.params.MaxVal != want.MaxVal || enc.params.T1 != want.T1 {
+		t.Errorf("after Reset MAXVAL = %d, T1 = %d; want %d and %d", enc.params.MaxVal, enc.params.T1, want.MaxVal, want.T1)
+	}
+}
+
+func TestEncodeBytesReuse(t *testing.T) {
+	width, height := 6, 4
+	enc := NewEncoder(width, height, 1, 12)
+	for i := 0; i < 3; i++ {
+		data := make([]byte, width*height*2)
+		for j := range data {
+			data[j] = byte(j*13 + i*29)
+		}
+		want, err := EncodeFromBytes(data, width, height, 1, 16, 12)
+		if err != nil {
+			t.Fatalf("frame %d: EncodeFromBytes failed: %v", i, err)
+		}
+		got, err := enc.EncodeBytes(data, 16, binary.LittleEndian)
+		if err != nil {
+			t.Fatalf("frame %d: EncodeBytes failed: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d: reused encoder output differs from EncodeFromBytes", i)
+		}
+	}
+}
+
+func TestEncoderOnRow(t *testing.T) {
+	width, height := 8, 5
+	pixels := make([]int, width*height)
+	for i := range pixels {
+		pixels[i] = i % 256
+	}
+
+	var rows []int
+	enc := NewEncoderWithOptions(width, height, 1, 8, EncoderOptions{
+		OnRow: func(row, total int) bool {
+			if total != height {
+				t.Errorf("total = %d, want %d", total, height)
+			}
+			rows = append(rows, row)
+			return true
+		},
+	})
+	if _, err := enc.Encode(pixels); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if len(rows) != height || rows[0] != 1 || rows[height-1] != height {
+		t.Errorf("rows = %v, want 1..%d once each", rows, height)
+	}
+
+	calls := 0
+	enc = NewEncoderWithOptions(width, height, 1, 8, EncoderOptions{
+		OnRow: func(row, total int) bool {
+			calls++
+			return row < 2
+		},
+	})
+	if _, err := enc.Encode(pixels); !errors.Is(err, ErrAborted) {
+		t.Errorf("err = %v, want ErrAborted", err)
+	}
+	if calls != 2 {
+		t.Errorf("callback called %d times after abort, want 2", calls)
+	}
+}
+
+func TestEncoderTrace(t *testing.T) {
+	width, height := 16, 8
+	pixels := make([]int, width*height)
+	for i := range pixels {
+		pixels[i] = (i * 37) % 256
+	}
+
+	want, err := NewEncoder(width, height, 1, 8).Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	calls := 0
+	enc := NewEncoder(width, height, 1, 8)
+	enc.Trace = func(x, y, ctxIdx, k, mapped int) {
+		calls++
+		if x < 0 || x >= width || y < 0 || y >= height {
+			t.Errorf("trace at (%d,%d), outside the image", x, y)
+		}
+		if k < 0 || k > LimitK || mapped < 0 {
+			t.Errorf("trace at (%d,%d): k = %d, mapped = %d", x, y, k, mapped)
+		}
+	}
+	got, err := enc.Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if calls == 0 {
+		t.Error("Trace was never called")
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("tracing changed the encoded bitstream")
+	}
+}
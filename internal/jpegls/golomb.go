@@ -28,6 +28,20 @@ func MapErrorValue(errval, near int) int {
 	return 2*(-errval) - 1
 }
 
+// mapRegularError maps a regular-mode error for ctx (ITU-T T.87 A.5.2).
+// In lossless mode with k=0, contexts with a negative bias (2B ≤ -N) swap
+// errval and -(errval+1), which gives the more likely sign the shorter code.
+// Must be called before the context statistics are updated.
+func (ctx *Context) mapRegularError(errval, k, near int) int {
+	if near == 0 && k == 0 && 2*ctx.B <= -ctx.N {
+		if errval >= 0 {
+			return 2*errval + 1
+		}
+		return -2 * (errval + 1)
+	}
+	return MapErrorValue(errval, near)
+}
+
 // UnmapErrorValue reverses the error mapping.
 func UnmapErrorValue(mapped int) int {
 	if mapped%2 == 0 {
@@ -67,25 +81,20 @@ func ComputePredictionError(actual, predicted, sign, near, rangeVal int) int {
 
 // ReconstructSample reconstructs the sample value from prediction and error.
 // This is needed to maintain the same reference values as the decoder.
+// The modulo reduction of the error is undone first (ITU-T T.87 A.4.5),
+// then the value is clamped to [0, maxVal].
 func ReconstructSample(predicted, errval, sign, near, maxVal int) int {
-	// Reverse sign correction
-	errval *= sign
-
-	// For near-lossless, dequantize
-	if near > 0 {
-		errval *= (2*near + 1)
-	}
-
-	reconstructed := predicted + errval
-
-	// Clamp to valid range
-	if reconstructed < 0 {
-		reconstructed = 0
-	} else if reconstructed > maxVal {
-		reconstructed = maxVal
+	step := 2*near + 1
+	rangeVal := (maxVal+2*near)/step + 1
+
+	reconstructed := predicted + sign*errval*step
+	if reconstructed < -near {
+		reconstructed += rangeVal * step
+	} else if reconstructed > maxVal+near {
+		reconstructed -= rangeVal * step
 	}
 
-	return reconstructed
+	return clampSample(reconstructed, maxVal)
 }
 
 // EncodeGolomb encodes a mapped error value using limited-length Golomb-Rice coding.
@@ -114,14 +123,21 @@ func EncodeGolomb(bw *BitWriter, mapped, k, limit, qbpp int) {
 
 // EncodeRegularMode encodes a sample using regular (non-run) mode.
 func EncodeRegularMode(bw *BitWriter, ctx *Context, actual, predicted, sign int, params *Params) int {
+	reconstructed, _, _ := encodeRegularMode(bw, ctx, actual, predicted, sign, params)
+	return reconstructed
+}
+
+// encodeRegularMode is EncodeRegularMode, also returning the Golomb
+// parameter k and the mapped error value it coded.
+func encodeRegularMode(bw *BitWriter, ctx *Context, actual, predicted, sign int, params *Params) (reconstructed, k, mapped int) {
 	// Compute prediction error with modulo reduction
 	errval := ComputePredictionError(actual, predicted, sign, params.Near, params.Range)
 
-	// Map error to non-negative value
-	mapped := MapErrorValue(errval, params.Near)
-
 	// Compute k parameter for Golomb coding
-	k := ctx.ComputeK(LimitK)
+	k = ctx.ComputeK(LimitK)
+
+	// Map error to non-negative value
+	mapped = ctx.mapRegularError(errval, k, params.Near)
 
 	// Encode using Golomb-Rice
 	EncodeGolomb(bw, mapped, k, params.Limit, params.Qbpp)
@@ -130,7 +146,7 @@ func EncodeRegularMode(bw *BitWriter, ctx *Context, actual, predicted, sign int,
 	ctx.UpdateStatistics(errval, params.Near, params.Reset)
 
 	// Return reconstructed value for reference
-	return ReconstructSample(predicted, errval, sign, params.Near, params.MaxVal)
+	return ReconstructSample(predicted, errval, sign, params.Near, params.MaxVal), k, mapped
 }
 
 // ReduceError applies modulo reduction to keep error in valid range.
@@ -3,6 +3,7 @@ package jpegls
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 )
 
 // JPEG-LS Marker codes
@@ -36,6 +37,20 @@ const (
 	LSEMappingTable = 2 // Mapping table specification
 )
 
+// SPIFF header fields (ITU-T T.84 Annex F) written by WriteSPIFFHeader
+const (
+	SPIFFColorSpaceNone      = 2
+	SPIFFColorSpaceGrayscale = 8
+	SPIFFColorSpaceRGB       = 10
+
+	spiffCompressionJPEGLS = 6
+	spiffEndOfDirectory    = 1 // Directory entry tag ending the header
+)
+
+// MaxCommentLength is the longest text a COM segment can hold: the
+// 16-bit segment length counts itself.
+const MaxCommentLength = 0xFFFF - 2
+
 // FrameInfo contains the information needed for frame header.
 type FrameInfo struct {
 	Width          int
@@ -67,6 +82,77 @@ func WriteEOI(buf *bytes.Buffer) {
 	buf.Write([]byte{0xFF, MarkerEOI})
 }
 
+// WriteCOM writes a comment marker segment, e.g. naming the encoder.
+// Marker segments are sized by their length field, so unlike scan data
+// the text needs no byte stuffing and may contain any byte.
+//
+// Structure:
+//   - Marker: 0xFF 0xFE
+//   - Length: 2 bytes (2 + text length)
+//   - Text
+func WriteCOM(buf *bytes.Buffer, text string) error {
+	if len(text) > MaxCommentLength {
+		return fmt.Errorf("comment of %d bytes exceeds the maximum of %d", len(text), MaxCommentLength)
+	}
+	buf.Write([]byte{0xFF, MarkerCOM})
+	binary.Write(buf, binary.BigEndian, uint16(2+len(text)))
+	buf.WriteString(text)
+	return nil
+}
+
+// WriteSPIFFHeader writes a SPIFF header and its end-of-directory entry
+// (T.84 F.2). It must directly follow SOI. The end-of-directory entry
+// ends with the SOI of the image proper, carried inside the segment so
+// the stream still has a single SOI for decoders that skip APP8.
+//
+// Structure:
+//   - Marker: 0xFF 0xE8
+//   - Length: 2 bytes (32)
+//   - Magic: "SPIFF" 0x00
+//   - Version: 2 bytes (2.0)
+//   - Profile: 1 byte (0 = none)
+//   - Components: 1 byte
+//   - Height, Width: 4 bytes each
+//   - Color space: 1 byte
+//   - Bits per sample: 1 byte
+//   - Compression: 1 byte (6 = JPEG-LS)
+//   - Resolution units: 1 byte (0 = aspect ratio)
+//   - Vertical, horizontal resolution: 4 bytes each (1:1)
+//   - End of directory: 0xFF 0xE8, length 8, tag 1, 0xFF 0xD8
+func WriteSPIFFHeader(buf *bytes.Buffer, frame FrameInfo) {
+	nc := frame.ComponentCount
+	if nc == 0 {
+		nc = 1
+	}
+	colorSpace := SPIFFColorSpaceNone
+	switch nc {
+	case 1:
+		colorSpace = SPIFFColorSpaceGrayscale
+	case 3:
+		colorSpace = SPIFFColorSpaceRGB
+	}
+
+	buf.Write([]byte{0xFF, MarkerAPP8})
+	binary.Write(buf, binary.BigEndian, uint16(32))
+	buf.WriteString("SPIFF\x00")
+	buf.Write([]byte{2, 0}) // Version
+	buf.WriteByte(0)        // Profile
+	buf.WriteByte(byte(nc))
+	binary.Write(buf, binary.BigEndian, uint32(frame.Height))
+	binary.Write(buf, binary.BigEndian, uint32(frame.Width))
+	buf.WriteByte(byte(colorSpace))
+	buf.WriteByte(byte(frame.BitsPerSample))
+	buf.WriteByte(spiffCompressionJPEGLS)
+	buf.WriteByte(0) // Resolution units
+	binary.Write(buf, binary.BigEndian, uint32(1))
+	binary.Write(buf, binary.BigEndian, uint32(1))
+
+	buf.Write([]byte{0xFF, MarkerAPP8})
+	binary.Write(buf, binary.BigEndian, uint16(8))
+	binary.Write(buf, binary.BigEndian, uint32(spiffEndOfDirectory))
+	buf.Write([]byte{0xFF, MarkerSOI})
+}
+
 // WriteSOF55 writes the JPEG-LS Start of Frame marker segment.
 // This identifies the image as JPEG-LS encoded.
 //
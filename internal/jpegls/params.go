@@ -48,16 +48,16 @@ const (
 // These values determine the number of bits used to encode run lengths
 var JTable = []struct {
 	RK int // Number of bits to use
-	RN int // Run interruption sample count
+	RN int // Run length of a complete segment (1 << RK)
 }{
-	{0, 0}, {0, 0}, {0, 0}, {0, 0},
-	{1, 1}, {1, 1}, {1, 1}, {1, 1},
-	{2, 2}, {2, 2}, {2, 2}, {2, 2},
-	{3, 3}, {3, 3}, {3, 3}, {3, 3},
-	{4, 4}, {4, 4}, {5, 5}, {5, 5},
-	{6, 6}, {7, 7}, {8, 8}, {9, 9},
-	{10, 10}, {11, 11}, {12, 12}, {13, 13},
-	{14, 14}, {15, 15}, {16, 16}, {17, 17},
+	{0, 1}, {0, 1}, {0, 1}, {0, 1},
+	{1, 2}, {1, 2}, {1, 2}, {1, 2},
+	{2, 4}, {2, 4}, {2, 4}, {2, 4},
+	{3, 8}, {3, 8}, {3, 8}, {3, 8},
+	{4, 16}, {4, 16}, {5, 32}, {5, 32},
+	{6, 64}, {6, 64}, {7, 128}, {7, 128},
+	{8, 256}, {9, 512}, {10, 1024}, {11, 2048},
+	{12, 4096}, {13, 8192}, {14, 16384}, {15, 32768},
 }
 
 // Params holds the encoding parameters
@@ -125,27 +125,30 @@ func NewParams(bpp, near int) *Params {
 	}
 }
 
-// calculateThresholds computes T1, T2, T3 based on MAXVAL and NEAR
-// This follows ITU-T T.87 Annex A.1
+// calculateThresholds computes the default T1, T2, T3 for MAXVAL and NEAR
+// (ITU-T T.87 C.2.4.1.1.1). Decoders use the same defaults when the
+// stream has no LSE marker, so these must match the standard exactly.
 func calculateThresholds(maxVal, near int) (t1, t2, t3 int) {
 	if maxVal >= 128 {
-		factor := maxVal
-		if factor > 4095 {
-			factor = 4095
-		}
-		t1 = clamp(near+1+factor/256, near+1, maxVal)
-		t2 = clamp(near+1+factor/64, t1, maxVal)
-		t3 = clamp(near+1+factor/16, t2, maxVal)
+		factor := (min(maxVal, 4095) + 128) / 256
+		t1 = clamp(factor*(DefaultT1-2)+2+3*near, near+1, maxVal)
+		t2 = clamp(factor*(DefaultT2-3)+3+5*near, t1, maxVal)
+		t3 = clamp(factor*(DefaultT3-4)+4+7*near, t2, maxVal)
 	} else {
-		// For small MAXVAL, use special formula
-		t1 = clamp(near+1+max((maxVal+1)/16, 1), near+1, maxVal)
-		t2 = clamp(near+1+max((maxVal+1)/8, 1), t1, maxVal)
-		t3 = clamp(near+1+max((maxVal+1)/4, 1), t2, maxVal)
+		// For small MAXVAL, the basic thresholds are scaled down
+		factor := 256 / (maxVal + 1)
+		t1 = clamp(max(2, DefaultT1/factor+3*near), near+1, maxVal)
+		t2 = clamp(max(3, DefaultT2/factor+5*near), t1, maxVal)
+		t3 = clamp(max(4, DefaultT3/factor+7*near), t2, maxVal)
 	}
 	return
 }
 
-// clamp returns val constrained to [minVal, maxVal]
+// clamp returns val, or minVal when val is outside [minVal, maxVal]
+// (the CLAMP function of ITU-T T.87 C.2.4.1.1.1)
 func clamp(val, minVal, maxVal int) int {
-	return max(minVal, min(val, maxVal))
+	if val < minVal || val > maxVal {
+		return minVal
+	}
+	return val
 }
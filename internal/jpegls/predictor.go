@@ -80,7 +80,7 @@ type NeighborGetter struct {
 }
 
 // NewNeighborGetter creates a new neighbor getter for the given pixel data.
-// defaultVal is the value used for out-of-bounds neighbors (2^(P-1) for lossless).
+// defaultVal is the value used for out-of-bounds neighbors (0 per ITU-T T.87).
 func NewNeighborGetter(pixels []int, width, height, defaultVal int) *NeighborGetter {
 	return &NeighborGetter{
 		pixels:     pixels,
@@ -104,13 +104,13 @@ func (ng *NeighborGetter) Get(x, y int) int {
 //	c b d
 //	a x
 //
-// Boundary conditions per ITU-T T.87:
+// Boundary conditions per ITU-T T.87 A.2.1:
 // - First row (y=0): b=c=d=defaultVal, a=previous pixel (or defaultVal if first column)
-// - First column (x=0): a=b, c=b (extended from above)
+// - First column (x=0): a=b, c=first sample two rows up (defaultVal if y<2)
 // - Last column: d=b (no pixel to the right above)
 func (ng *NeighborGetter) GetNeighbors(x, y int) (a, b, c, d int) {
 	if y == 0 {
-		// First row: all upper neighbors are defaultVal (half maxval)
+		// First row: all upper neighbors are defaultVal
 		if x == 0 {
 			a = ng.defaultVal
 		} else {
@@ -128,7 +128,7 @@ func (ng *NeighborGetter) GetNeighbors(x, y int) (a, b, c, d int) {
 	if x == 0 {
 		// First column
 		a = b // extend from above
-		c = b // extend from above
+		c = ng.Get(0, y-2)
 	} else {
 		a = ng.Get(x-1, y)
 		c = ng.Get(x-1, y-1)
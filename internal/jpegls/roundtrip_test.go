@@ -0,0 +1,714 @@
+package jpegls
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The reference decoder below follows the decoding procedure of ITU-T T.87
+// directly (with CharLS behaviour for sample-interleaved run mode) and
+// shares no code with the encoder, so a bug on either side shows up as a
+// round-trip mismatch.
+
+// refImage is a decoded image with interleaved samples
+type refImage struct {
+	width, height, components int
+	near, ilv                 int
+	pixels                    []int
+}
+
+var errRefShort = errors.New("unexpected end of scan data")
+
+// refJ is T.87 Table A.2
+var refJ = [32]int{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// refDecode decodes a single-scan JPEG-LS stream.
+func refDecode(data []byte) (*refImage, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("missing SOI")
+	}
+
+	var img *refImage
+	var bpp, maxVal, t1, t2, t3, reset int
+	pos := 2
+	for pos+2 <= len(data) {
+		if data[pos] != 0xFF {
+			return nil, fmt.Errorf("expected marker at %d, got %02X", pos, data[pos])
+		}
+		marker := data[pos+1]
+		if marker == 0xD9 {
+			if img == nil || img.pixels == nil {
+				return nil, errors.New("EOI before scan")
+			}
+			return img, nil
+		}
+		if pos+4 > len(data) {
+			return nil, fmt.Errorf("truncated marker %02X", marker)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if pos+2+length > len(data) {
+			return nil, fmt.Errorf("marker %02X overruns stream", marker)
+		}
+		seg := data[pos+4 : pos+2+length]
+		pos += 2 + length
+
+		switch marker {
+		case 0xF7: // SOF55
+			bpp = int(seg[0])
+			img = &refImage{
+				height:     int(binary.BigEndian.Uint16(seg[1:])),
+				width:      int(binary.BigEndian.Uint16(seg[3:])),
+				components: int(seg[5]),
+			}
+			maxVal = 1<<bpp - 1
+		case 0xF8: // LSE
+			if seg[0] == 1 {
+				maxVal = int(binary.BigEndian.Uint16(seg[1:]))
+				t1 = int(binary.BigEndian.Uint16(seg[3:]))
+				t2 = int(binary.BigEndian.Uint16(seg[5:]))
+				t3 = int(binary.BigEndian.Uint16(seg[7:]))
+				reset = int(binary.BigEndian.Uint16(seg[9:]))
+			}
+		case 0xDA: // SOS
+			if img == nil {
+				return nil, errors.New("SOS before SOF55")
+			}
+			ns := int(seg[0])
+			img.near = int(seg[1+2*ns])
+			img.ilv = int(seg[2+2*ns])
+			if ns != img.components {
+				return nil, fmt.Errorf("scan has %d of %d components", ns, img.components)
+			}
+			if ns > 1 && img.ilv == 0 {
+				return nil, errors.New("multi-component ILV=0 not supported")
+			}
+
+			s := newRefScan(img, maxVal, t1, t2, t3, reset)
+			r := &refBitReader{data: data, pos: pos}
+			if err := s.decode(r); err != nil {
+				return nil, err
+			}
+			if pos = r.end(); pos < 0 {
+				return nil, errors.New("scan data not followed by a marker")
+			}
+		}
+	}
+	return nil, errors.New("missing EOI")
+}
+
+// refBitReader reads scan data MSB first, dropping the stuffed 0 bit that
+// follows every 0xFF byte (T.87 A.1).
+type refBitReader struct {
+	data   []byte
+	pos    int
+	cur    byte
+	nbits  int
+	prevFF bool
+}
+
+func (r *refBitReader) bit() (int, error) {
+	if r.nbits == 0 {
+		if r.pos >= len(r.data) {
+			return 0, errRefShort
+		}
+		b := r.data[r.pos]
+		if r.prevFF {
+			if b&0x80 != 0 {
+				return 0, fmt.Errorf("marker %02X inside scan data", b)
+			}
+			r.cur, r.nbits = b<<1, 7
+		} else {
+			r.cur, r.nbits = b, 8
+		}
+		r.prevFF = b == 0xFF
+		r.pos++
+	}
+	bit := int(r.cur >> 7)
+	r.cur <<= 1
+	r.nbits--
+	return bit, nil
+}
+
+func (r *refBitReader) bits(n int) (int, error) {
+	v := 0
+	for i := 0; i < n; i++ {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+// end returns the position of the marker after the scan, or -1 if the scan
+// data has bytes left over.
+func (r *refBitReader) end() int {
+	pos := r.pos
+	if r.prevFF && pos < len(r.data) && r.data[pos]&0x80 == 0 {
+		pos++ // Padding byte after a final 0xFF
+	}
+	if pos+1 < len(r.data) && r.data[pos] == 0xFF && r.data[pos+1]&0x80 != 0 {
+		return pos
+	}
+	return -1
+}
+
+// refScan is the decoding state of one scan
+type refScan struct {
+	img                                   *refImage
+	maxVal, near, rng, qbpp, limit, reset int
+	t1, t2, t3                            int
+	a, b, c, n                            [365]int
+	runA, runN, runNn                     [2]int
+	runIndex                              []int
+	prev, cur                             [][]int // Per component, indexed x+1
+}
+
+func newRefScan(img *refImage, maxVal, t1, t2, t3, reset int) *refScan {
+	near := img.near
+	s := &refScan{img: img, maxVal: maxVal, near: near, reset: reset}
+	if s.reset == 0 {
+		s.reset = 64
+	}
+	s.rng = (maxVal+2*near)/(2*near+1) + 1
+	for 1<<s.qbpp < s.rng {
+		s.qbpp++
+	}
+	bpp := 2
+	for 1<<bpp < maxVal+1 {
+		bpp++
+	}
+	s.limit = 2 * (bpp + max(8, bpp))
+
+	// Default thresholds (C.2.4.1.1.1), unless set by LSE
+	clampT := func(i, j int) int {
+		if i > maxVal || i < j {
+			return j
+		}
+		return i
+	}
+	d1, d2, d3 := 0, 0, 0
+	if maxVal >= 128 {
+		f := (min(maxVal, 4095) + 128) / 256
+		d1 = clampT(f*1+2+3*near, near+1)
+		d2 = clampT(f*4+3+5*near, d1)
+		d3 = clampT(f*17+4+7*near, d2)
+	} else {
+		f := 256 / (maxVal + 1)
+		d1 = clampT(max(2, 3/f+3*near), near+1)
+		d2 = clampT(max(3, 7/f+5*near), d1)
+		d3 = clampT(max(4, 21/f+7*near), d2)
+	}
+	s.t1, s.t2, s.t3 = d1, d2, d3
+	if t1 != 0 {
+		s.t1, s.t2, s.t3 = t1, t2, t3
+	}
+
+	initA := max(2, (s.rng+32)/64)
+	for i := range s.a {
+		s.a[i], s.n[i] = initA, 1
+	}
+	s.runA = [2]int{initA, initA}
+	s.runN = [2]int{1, 1}
+	s.runIndex = make([]int, img.components)
+
+	for i := 0; i < img.components; i++ {
+		s.prev = append(s.prev, make([]int, img.width+2))
+		s.cur = append(s.cur, make([]int, img.width+2))
+	}
+	return s
+}
+
+func (s *refScan) decode(r *refBitReader) error {
+	img := s.img
+	img.pixels = make([]int, img.width*img.height*img.components)
+	for y := 0; y < img.height; y++ {
+		for comp := range s.cur {
+			s.cur[comp][0] = s.prev[comp][1]
+			s.prev[comp][img.width+1] = s.prev[comp][img.width]
+		}
+
+		var err error
+		if img.ilv == 2 {
+			err = s.decodeInterleavedLine(r)
+		} else {
+			for comp := range s.cur {
+				if err = s.decodeLine(r, comp); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %w", y, err)
+		}
+
+		for comp := range s.cur {
+			for x := 0; x < img.width; x++ {
+				img.pixels[(y*img.width+x)*img.components+comp] = s.cur[comp][x+1]
+			}
+			s.prev[comp], s.cur[comp] = s.cur[comp], s.prev[comp]
+		}
+	}
+	return nil
+}
+
+// quantize is T.87 A.3.3
+func (s *refScan) quantize(d int) int {
+	switch {
+	case d <= -s.t3:
+		return -4
+	case d <= -s.t2:
+		return -3
+	case d <= -s.t1:
+		return -2
+	case d < -s.near:
+		return -1
+	case d <= s.near:
+		return 0
+	case d < s.t1:
+		return 1
+	case d < s.t2:
+		return 2
+	case d < s.t3:
+		return 3
+	}
+	return 4
+}
+
+// context returns Q for the neighbours of x in component comp (0 = run mode)
+func (s *refScan) context(comp, x int) int {
+	prev, cur := s.prev[comp], s.cur[comp]
+	ra, rb, rc, rd := cur[x], prev[x+1], prev[x], prev[x+2]
+	return 81*s.quantize(rd-rb) + 9*s.quantize(rb-rc) + s.quantize(rc-ra)
+}
+
+func (s *refScan) decodeLine(r *refBitReader, comp int) error {
+	for x := 0; x < s.img.width; {
+		qs := s.context(comp, x)
+		if qs != 0 {
+			v, err := s.decodeRegular(r, comp, x, qs)
+			if err != nil {
+				return err
+			}
+			s.cur[comp][x+1] = v
+			x++
+			continue
+		}
+
+		ra := s.cur[comp][x]
+		count, interrupted, err := s.decodeRunLength(r, &s.runIndex[comp], s.img.width-x)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			s.cur[comp][x+1+i] = ra
+		}
+		x += count
+		if interrupted {
+			v, err := s.decodeRunInterruption(r, comp, x, ra, s.runIndex[comp])
+			if err != nil {
+				return err
+			}
+			s.cur[comp][x+1] = v
+			if s.runIndex[comp] > 0 {
+				s.runIndex[comp]--
+			}
+			x++
+		}
+	}
+	return nil
+}
+
+func (s *refScan) decodeInterleavedLine(r *refBitReader) error {
+	comps := s.img.components
+	qs := make([]int, comps)
+	for x := 0; x < s.img.width; {
+		run := true
+		for comp := range qs {
+			qs[comp] = s.context(comp, x)
+			run = run && qs[comp] == 0
+		}
+		if !run {
+			for comp := range qs {
+				v, err := s.decodeRegular(r, comp, x, qs[comp])
+				if err != nil {
+					return err
+				}
+				s.cur[comp][x+1] = v
+			}
+			x++
+			continue
+		}
+
+		count, interrupted, err := s.decodeRunLength(r, &s.runIndex[0], s.img.width-x)
+		if err != nil {
+			return err
+		}
+		for comp := range qs {
+			for i := 0; i < count; i++ {
+				s.cur[comp][x+1+i] = s.cur[comp][x]
+			}
+		}
+		x += count
+		if interrupted {
+			for comp := range qs {
+				ra, rb := s.cur[comp][x], s.prev[comp][x+1]
+				sign := 1
+				if rb < ra {
+					sign = -1
+				}
+				e, err := s.decodeRunError(r, 0, s.runIndex[0])
+				if err != nil {
+					return err
+				}
+				s.cur[comp][x+1] = s.reconstruct(rb + sign*e*(2*s.near+1))
+			}
+			if s.runIndex[0] > 0 {
+				s.runIndex[0]--
+			}
+			x++
+		}
+	}
+	return nil
+}
+
+// golomb decodes a limited-length Golomb code (A.5.3)
+func (s *refScan) golomb(r *refBitReader, k, limit int) (int, error) {
+	q := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b == 1 {
+			break
+		}
+		q++
+		if q > limit-s.qbpp-1 {
+			return 0, errors.New("unary code too long")
+		}
+	}
+	if q < limit-s.qbpp-1 {
+		rem, err := r.bits(k)
+		return q<<k | rem, err
+	}
+	m, err := r.bits(s.qbpp)
+	return m + 1, err
+}
+
+func (s *refScan) reconstruct(rx int) int {
+	step := 2*s.near + 1
+	if rx < -s.near {
+		rx += s.rng * step
+	} else if rx > s.maxVal+s.near {
+		rx -= s.rng * step
+	}
+	return min(max(rx, 0), s.maxVal)
+}
+
+func (s *refScan) decodeRegular(r *refBitReader, comp, x, qs int) (int, error) {
+	prev, cur := s.prev[comp], s.cur[comp]
+	ra, rb, rc := cur[x], prev[x+1], prev[x]
+
+	sign := 1
+	if qs < 0 {
+		sign, qs = -1, -qs
+	}
+
+	px := ra + rb - rc
+	if rc >= max(ra, rb) {
+		px = min(ra, rb)
+	} else if rc <= min(ra, rb) {
+		px = max(ra, rb)
+	}
+	px = min(max(px+sign*s.c[qs], 0), s.maxVal)
+
+	k := 0
+	for s.n[qs]<<k < s.a[qs] {
+		k++
+	}
+	m, err := s.golomb(r, k, s.limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var e int
+	if s.near == 0 && k == 0 && 2*s.b[qs] <= -s.n[qs] {
+		if m%2 == 1 {
+			e = (m - 1) / 2
+		} else {
+			e = -m/2 - 1
+		}
+	} else if m%2 == 0 {
+		e = m / 2
+	} else {
+		e = -(m + 1) / 2
+	}
+
+	// A.6
+	s.b[qs] += e * (2*s.near + 1)
+	s.a[qs] += max(e, -e)
+	if s.n[qs] == s.reset {
+		s.a[qs] >>= 1
+		s.b[qs] >>= 1
+		s.n[qs] >>= 1
+	}
+	s.n[qs]++
+	if s.b[qs] <= -s.n[qs] {
+		s.b[qs] += s.n[qs]
+		if s.c[qs] > -128 {
+			s.c[qs]--
+		}
+		if s.b[qs] <= -s.n[qs] {
+			s.b[qs] = -s.n[qs] + 1
+		}
+	} else if s.b[qs] > 0 {
+		s.b[qs] -= s.n[qs]
+		if s.c[qs] < 127 {
+			s.c[qs]++
+		}
+		if s.b[qs] > 0 {
+			s.b[qs] = 0
+		}
+	}
+
+	return s.reconstruct(px + sign*e*(2*s.near+1)), nil
+}
+
+// decodeRunLength decodes a run of at most remaining samples (A.7.1.2) and
+// reports whether it ends with an interruption sample.
+func (s *refScan) decodeRunLength(r *refBitReader, runIndex *int, remaining int) (int, bool, error) {
+	count := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, false, err
+		}
+		if b == 0 {
+			break
+		}
+		segment := min(1<<refJ[*runIndex], remaining-count)
+		count += segment
+		if segment == 1<<refJ[*runIndex] && *runIndex < 31 {
+			*runIndex++
+		}
+		if count == remaining {
+			return count, false, nil
+		}
+	}
+	rest, err := r.bits(refJ[*runIndex])
+	if err != nil {
+		return 0, false, err
+	}
+	count += rest
+	if count >= remaining {
+		return 0, false, errors.New("run length past end of line")
+	}
+	return count, true, nil
+}
+
+func (s *refScan) decodeRunInterruption(r *refBitReader, comp, x, ra, runIndex int) (int, error) {
+	rb := s.prev[comp][x+1]
+	riType, px, sign := 0, rb, 1
+	if max(ra-rb, rb-ra) <= s.near {
+		riType, px = 1, ra
+	} else if ra > rb {
+		sign = -1
+	}
+	e, err := s.decodeRunError(r, riType, runIndex)
+	if err != nil {
+		return 0, err
+	}
+	return s.reconstruct(px + sign*e*(2*s.near+1)), nil
+}
+
+// decodeRunError decodes the error of a run interruption sample (A.7.2)
+func (s *refScan) decodeRunError(r *refBitReader, riType, runIndex int) (int, error) {
+	temp := s.runA[riType]
+	if riType == 1 {
+		temp += s.runN[riType] >> 1
+	}
+	k := 0
+	for s.runN[riType]<<k < temp {
+		k++
+	}
+	m, err := s.golomb(r, k, s.limit-refJ[runIndex]-1)
+	if err != nil {
+		return 0, err
+	}
+
+	t := m + riType
+	mapBit := t & 1
+	e := (t + mapBit) / 2
+	if (k != 0 || 2*s.runNn[riType] >= s.runN[riType]) == (mapBit == 1) {
+		e = -e
+	}
+
+	if e < 0 {
+		s.runNn[riType]++
+	}
+	s.runA[riType] += (m + 1 - riType) >> 1
+	if s.runN[riType] == s.reset {
+		s.runA[riType] >>= 1
+		s.runN[riType] >>= 1
+		s.runNn[riType] >>= 1
+	}
+	s.runN[riType]++
+	return e, nil
+}
+
+// roundTripImage builds a test image of random noise, or of a gradient with
+// slight noise when smooth (for small Golomb parameters and bias
+// correction), with flat blocks so that run mode is exercised too,
+// including runs reaching the end of the line.
+func roundTripImage(rng *rand.Rand, width, height, components, bpp int, smooth bool) []int {
+	maxVal := 1<<bpp - 1
+	pixels := make([]int, width*height*components)
+	for i := range pixels {
+		if smooth {
+			x, y := i/components%width, i/components/width
+			pixels[i] = min(max((x+2*y)*maxVal/(width+2*height)+rng.Intn(3)-1, 0), maxVal)
+		} else {
+			pixels[i] = rng.Intn(maxVal + 1)
+		}
+	}
+	for blocks := rng.Intn(4) + 1; blocks > 0; blocks-- {
+		x0, y0 := rng.Intn(width), rng.Intn(height)
+		w, h := rng.Intn(width-x0)+1, rng.Intn(height-y0)+1
+		for comp := 0; comp < components; comp++ {
+			v := rng.Intn(maxVal + 1)
+			for y := y0; y < y0+h; y++ {
+				for x := x0; x < x0+w; x++ {
+					pixels[(y*width+x)*components+comp] = v
+				}
+			}
+		}
+	}
+	return pixels
+}
+
+func TestRoundTripAllModes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1555))
+	sizes := [][2]int{{1, 1}, {1, 17}, {17, 1}, {2, 2}, {13, 7}, {64, 48}, {256, 8}, {48, 64}}
+	stuffed := false
+
+	for _, bpp := range []int{8, 12, 16} {
+		for _, layout := range []struct{ components, ilv int }{{1, ILVNone}, {3, ILVLine}, {3, ILVSample}} {
+			for _, near := range []int{0, 2} {
+				for i, size := range sizes {
+					width, height := size[0], size[1]
+					smooth := i%2 == 1
+					name := fmt.Sprintf("%dbit/%dc/ilv%d/near%d/%dx%d", bpp, layout.components, layout.ilv, near, width, height)
+					t.Run(name, func(t *testing.T) {
+						pixels := roundTripImage(rng, width, height, layout.components, bpp, smooth)
+						enc := NewEncoderWithOptions(width, height, layout.components, bpp,
+							EncoderOptions{Interleave: layout.ilv, Near: near})
+						encoded, err := enc.Encode(pixels)
+						if err != nil {
+							t.Fatalf("Encode failed: %v", err)
+						}
+						if bytes.Contains(encoded[bytes.Index(encoded, []byte{0xFF, MarkerSOS})+2:len(encoded)-2], []byte{0xFF}) {
+							stuffed = true
+						}
+
+						img, err := refDecode(encoded)
+						if err != nil {
+							t.Fatalf("Decode failed: %v", err)
+						}
+						if img.width != width || img.height != height || img.components != layout.components || img.near != near {
+							t.Fatalf("Decoded %dx%dx%d NEAR %d", img.width, img.height, img.components, img.near)
+						}
+						for i, want := range pixels {
+							if got := img.pixels[i]; got < want-near || got > want+near {
+								t.Fatalf("Sample %d (x=%d y=%d): got %d, want %d ± %d", i,
+									i/layout.components%width, i/layout.components/width, got, want, near)
+							}
+						}
+					})
+				}
+			}
+		}
+	}
+
+	if !stuffed {
+		t.Error("No stream contained 0xFF in its scan data; bit stuffing untested")
+	}
+}
+
+// TestEncodeT87Example checks the encoder and the reference decoder against
+// the 4x4 lossless example of ITU-T T.87 Annex H.3.
+func TestEncodeT87Example(t *testing.T) {
+	pixels := []int{
+		0, 0, 90, 74,
+		68, 50, 43, 205,
+		64, 145, 145, 145,
+		100, 145, 145, 145,
+	}
+	want := []byte{
+		0xFF, 0xD8, 0xFF, 0xF7, 0x00, 0x0B, 0x08, 0x00, 0x04, 0x00, 0x04, 0x01, 0x01, 0x11, 0x00,
+		0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00,
+		0xC0, 0x00, 0x00, 0x6C, 0x80, 0x20, 0x8E, 0x01, 0xC0, 0x00, 0x00, 0x57, 0x40, 0x00, 0x00,
+		0x6E, 0xE6, 0x00, 0x00, 0x01, 0xBC, 0x18, 0x00, 0x00, 0x05, 0xD8, 0x00, 0x00, 0x91, 0x60,
+		0xFF, 0xD9,
+	}
+
+	encoded, err := NewEncoder(4, 4, 1, 8).Encode(pixels)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("Encoded\n% X\nwant\n% X", encoded, want)
+	}
+
+	img, err := refDecode(want)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	for i, v := range pixels {
+		if img.pixels[i] != v {
+			t.Fatalf("Decoded sample %d = %d, want %d", i, img.pixels[i], v)
+		}
+	}
+}
+
+// TestRunSegmentBoundaries round-trips lines whose runs end exactly on a
+// J-table segment boundary before the end of the line, for every run length
+// up to several segments and with RUNindex carried over between lines.
+func TestRunSegmentBoundaries(t *testing.T) {
+	const width = 48
+	for _, layout := range []struct{ components, ilv int }{{1, ILVNone}, {3, ILVLine}, {3, ILVSample}} {
+		for runLength := 1; runLength < width-1; runLength++ {
+			// Each line starts with a run of zeros (Ra = 0 at the start of
+			// the first line), interrupted by 200, then a shorter run
+			// reaching the end of the line
+			height := 4
+			pixels := make([]int, width*height*layout.components)
+			for y := 0; y < height; y++ {
+				for comp := 0; comp < layout.components; comp++ {
+					pixels[(y*width+runLength)*layout.components+comp] = 200
+				}
+			}
+
+			name := fmt.Sprintf("%dc/ilv%d/run%d", layout.components, layout.ilv, runLength)
+			encoded, err := NewEncoderWithOptions(width, height, layout.components, 8,
+				EncoderOptions{Interleave: layout.ilv}).Encode(pixels)
+			if err != nil {
+				t.Fatalf("%s: Encode failed: %v", name, err)
+			}
+			img, err := refDecode(encoded)
+			if err != nil {
+				t.Fatalf("%s: Decode failed: %v", name, err)
+			}
+			for i, want := range pixels {
+				if img.pixels[i] != want {
+					t.Fatalf("%s: sample %d = %d, want %d", name, i, img.pixels[i], want)
+				}
+			}
+		}
+	}
+}
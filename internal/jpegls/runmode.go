@@ -1,7 +1,7 @@
 package jpegls
 
 // RunModeEncoder handles run-length encoding for uniform regions.
-// When all gradients are zero, JPEG-LS switches to run mode which
+// When all gradients are within NEAR, JPEG-LS switches to run mode which
 // efficiently encodes sequences of identical (or near-identical) pixels.
 type RunModeEncoder struct {
 	cm     *ContextModel
@@ -16,50 +16,74 @@ func NewRunModeEncoder(cm *ContextModel, params *Params) *RunModeEncoder {
 	}
 }
 
-// EncodeRun encodes a run of samples starting at the given position.
-// Returns the number of samples consumed.
-//
-// Parameters:
-//   - bw: bit writer for output
-//   - pixels: row-major pixel data
-//   - x, y: current position
-//   - width: image width
-//   - refVal: the reference value (Ra) for run comparison
-func (rme *RunModeEncoder) EncodeRun(bw *BitWriter, pixels []int, x, y, width, refVal int) int {
-	// Count run length (how many pixels match refVal)
-	runLength := 0
-	remaining := width - x
-	startIdx := y*width + x
+// EncodeRun encodes a run of samples starting at (x, y) (ITU-T T.87 A.7).
+// Samples within NEAR of Ra extend the run and are reconstructed as Ra. A
+// run that stops before the end of the line is followed by its run
+// interruption sample. Returns the number of samples consumed.
+func (rme *RunModeEncoder) EncodeRun(bw *BitWriter, ng *NeighborGetter, x, y int) int {
+	ra, _, _, _ := ng.GetNeighbors(x, y)
 
-	for i := 0; i < remaining; i++ {
-		if rme.pixelsMatch(pixels[startIdx+i], refVal) {
-			runLength++
-		} else {
-			break
-		}
+	runLength := 0
+	for x+runLength < ng.width && rme.pixelsMatch(ng.Get(x+runLength, y), ra) {
+		ng.SetPixel(x+runLength, y, ra)
+		runLength++
 	}
 
-	// Encode the run
-	if runLength > 0 {
-		rme.encodeRunSegments(bw, runLength, remaining)
+	endOfLine := x+runLength == ng.width
+	rme.encodeRunSegments(bw, runLength, endOfLine)
+	if endOfLine {
+		return runLength
 	}
 
-	// Handle run interruption (if run didn't reach end of line)
-	if runLength < remaining {
-		// Get the interrupting sample
-		interruptingSample := pixels[startIdx+runLength]
+	// Run interruption sample (A.7.2)
+	ix := x + runLength
+	_, rb, _, _ := ng.GetNeighbors(ix, y)
+	ng.SetPixel(ix, y, rme.encodeRunInterruptionSample(bw, ng.Get(ix, y), ra, rb))
+	rme.cm.DecrementRunIndex()
+
+	return runLength + 1
+}
+
+// EncodeRunInterleaved encodes a run of pixels in a sample-interleaved scan,
+// where every component must stay within NEAR of its Ra for the run to
+// continue. The interruption samples of all components are coded with run
+// context 0 and Rb as the prediction. Returns the number of pixels consumed.
+func (rme *RunModeEncoder) EncodeRunInterleaved(bw *BitWriter, ngs []*NeighborGetter, x, y int) int {
+	ra := make([]int, len(ngs))
+	for comp, ng := range ngs {
+		ra[comp], _, _, _ = ng.GetNeighbors(x, y)
+	}
 
-		// Get context for run interruption
-		runIdx := rme.cm.GetRunIndex()
-		rb := rme.getRunInterruptionContext(x+runLength, y, width, pixels, refVal)
+	width := ngs[0].width
+	runLength := 0
+	for x+runLength < width && rme.pixelMatches(ngs, ra, x+runLength, y) {
+		for comp, ng := range ngs {
+			ng.SetPixel(x+runLength, y, ra[comp])
+		}
+		runLength++
+	}
 
-		// Encode the run interruption sample
-		rme.encodeRunInterruptionSample(bw, interruptingSample, refVal, rb, runIdx)
+	endOfLine := x+runLength == width
+	rme.encodeRunSegments(bw, runLength, endOfLine)
+	if endOfLine {
+		return runLength
+	}
 
-		return runLength + 1
+	ix := x + runLength
+	p := rme.params
+	for comp, ng := range ngs {
+		_, rb, _, _ := ng.GetNeighbors(ix, y)
+		sign := 1
+		if rb < ra[comp] {
+			sign = -1
+		}
+		errval := ComputePredictionError(ng.Get(ix, y), rb, sign, p.Near, p.Range)
+		rme.encodeInterruptionError(bw, rme.cm.GetRunContext(0), 0, errval)
+		ng.SetPixel(ix, y, ReconstructSample(rb, errval, sign, p.Near, p.MaxVal))
 	}
+	rme.cm.DecrementRunIndex()
 
-	return runLength
+	return runLength + 1
 }
 
 // pixelsMatch checks if a pixel matches the reference value.
@@ -76,177 +100,101 @@ func (rme *RunModeEncoder) pixelsMatch(pixel, refVal int) bool {
 	return diff <= rme.params.Near
 }
 
-// encodeRunSegments encodes run length using the J-table.
-// Run lengths are encoded in segments based on the current run index.
-func (rme *RunModeEncoder) encodeRunSegments(bw *BitWriter, runLength, remaining int) {
-	lineRemaining := remaining
-
-	// Encode complete segments
-	for runLength > 0 {
-		runIdx := rme.cm.GetRunIndex()
-		if runIdx >= len(JTable) {
-			runIdx = len(JTable) - 1
-		}
-
-		rk := JTable[runIdx].RK
-		segmentSize := 1 << rk
-
-		if runLength >= segmentSize {
-			// Complete segment: output 1 bit
-			bw.WriteBit(1)
-			runLength -= segmentSize
-			lineRemaining -= segmentSize
-
-			// Increment run index for next segment
-			rme.cm.IncrementRunIndex()
-
-			if runLength == 0 {
-				// End of line: no terminating segment.
-				if lineRemaining == 0 {
-					return
-				}
-
-				// Run ended on a segment boundary before end of line.
-				runIdx = rme.cm.GetRunIndex()
-				if runIdx >= len(JTable) {
-					runIdx = len(JTable) - 1
-				}
-				rk = JTable[runIdx].RK
-				bw.WriteBit(0)
-				if rk > 0 {
-					bw.WriteBits(0, rk)
-				}
-				rme.cm.ResetRunIndex()
-				return
-			}
-		} else {
-			// End of line with incomplete segment: no terminating segment.
-			if runLength == lineRemaining {
-				return
-			}
-
-			// Incomplete segment: output 0 bit followed by rk bits of count
-			bw.WriteBit(0)
-			if rk > 0 {
-				bw.WriteBits(runLength, rk)
-			}
-			runLength = 0
-
-			// Reset run index after incomplete segment
-			rme.cm.ResetRunIndex()
+// pixelMatches checks every component of the pixel at (x, y) against ra.
+func (rme *RunModeEncoder) pixelMatches(ngs []*NeighborGetter, ra []int, x, y int) bool {
+	for comp, ng := range ngs {
+		if !rme.pixelsMatch(ng.Get(x, y), ra[comp]) {
+			return false
 		}
 	}
+	return true
 }
 
-// getRunInterruptionContext computes Rb for run interruption.
-// Rb is the sample above the run interruption position.
-func (rme *RunModeEncoder) getRunInterruptionContext(x, y, width int, pixels []int, refVal int) int {
-	if y == 0 {
-		return refVal
+// encodeRunSegments encodes run length using the J-table (A.7.1.2).
+// Each complete segment of 1<<J[RUNindex] samples is coded as a 1 bit and
+// advances RUNindex. A run reaching the end of the line ends with one more
+// 1 bit if a partial segment is left; otherwise the run is terminated by a
+// 0 bit and the remaining length in J[RUNindex] bits, which may be zero
+// when the run ends on a segment boundary.
+func (rme *RunModeEncoder) encodeRunSegments(bw *BitWriter, runLength int, endOfLine bool) {
+	for {
+		rk := JTable[rme.cm.GetRunIndex()].RK
+		if runLength < 1<<rk {
+			break
+		}
+		bw.WriteBit(1)
+		runLength -= 1 << rk
+		rme.cm.IncrementRunIndex()
 	}
-	return pixels[(y-1)*width+x]
-}
 
-// encodeRunInterruptionSample encodes the sample that interrupted the run.
-func (rme *RunModeEncoder) encodeRunInterruptionSample(bw *BitWriter, sample, ra, rb, runIdx int) {
-	// Determine which run context to use (A.7.2 in ITU-T T.87)
-	// Context index: 0 if |Ra - Rb| <= NEAR, else 1
-	ctxIdx := 0
-	diff := ra - rb
-	if diff < 0 {
-		diff = -diff
-	}
-	if diff > rme.params.Near {
-		ctxIdx = 1
+	if endOfLine {
+		if runLength > 0 {
+			bw.WriteBit(1)
+		}
+		return
 	}
 
-	// Compute prediction (use Rb as predictor)
-	predicted := rb
+	bw.WriteBit(0)
+	bw.WriteBits(runLength, JTable[rme.cm.GetRunIndex()].RK)
+}
 
-	// Sign determination
-	sign := 1
-	if ra > rb || (ra == rb && rme.params.Near > 0) {
-		// No sign flip needed
-	} else if ra < rb {
+// encodeRunInterruptionSample encodes the sample that interrupted the run
+// and returns its reconstructed value (A.7.2).
+func (rme *RunModeEncoder) encodeRunInterruptionSample(bw *BitWriter, sample, ra, rb int) int {
+	p := rme.params
+
+	// RItype 1 when Ra and Rb are within NEAR: predict Ra. Otherwise predict
+	// Rb, with the sign of Rb - Ra.
+	riType, predicted, sign := 0, rb, 1
+	if iabs(ra-rb) <= p.Near {
+		riType, predicted = 1, ra
+	} else if ra > rb {
 		sign = -1
-		predicted = ra
 	}
 
-	// Get the run context
-	ctx := rme.cm.GetRunContext(ctxIdx)
-
-	// Compute prediction error
-	errval := sample - predicted
-	errval *= sign
-
-	// Modulo reduction
-	errval = ReduceError(errval, rme.params.Range)
+	errval := ComputePredictionError(sample, predicted, sign, p.Near, p.Range)
+	rme.encodeInterruptionError(bw, rme.cm.GetRunContext(riType), riType, errval)
 
-	// Map error for encoding
-	mapped := mapRunInterruptionError(errval, ra, rb)
-
-	// Compute k
-	k := ctx.ComputeK(LimitK)
+	return ReconstructSample(predicted, errval, sign, p.Near, p.MaxVal)
+}
 
-	// For run interruption samples, we use a modified encoding
-	// The map can start at 1 or 2 depending on context
-	tempA := ctx.A + (ctx.N >> 1)
+// encodeInterruptionError codes the error of a run interruption sample with
+// run context ctx and updates the context (A.7.2.1 - A.7.2.3).
+func (rme *RunModeEncoder) encodeInterruptionError(bw *BitWriter, ctx *Context, riType, errval int) {
+	p := rme.params
 
-	// Adjust k for run mode (A.7.2 in ITU-T T.87)
-	if runIdx > 0 {
-		k = max(0, k-1)
+	temp := ctx.A
+	if riType == 1 {
+		temp += ctx.N >> 1
+	}
+	k := 0
+	for ctx.N<<k < temp {
+		k++
 	}
 
-	// Encode using modified limit for run interruption (A.7.2.1)
-	// The limit is max(2, LIMIT - J[RUNindex] - 1)
-	rk := 0
-	if runIdx < len(JTable) {
-		rk = JTable[runIdx].RK
+	// The mapping favours the more frequent sign, tracked by Nn
+	mapBit := 0
+	if (k == 0 && errval > 0 && 2*ctx.Nn < ctx.N) ||
+		(errval < 0 && 2*ctx.Nn >= ctx.N) ||
+		(errval < 0 && k != 0) {
+		mapBit = 1
 	}
-	limit := max(2, rme.params.Limit-rk-1)
-	EncodeGolomb(bw, mapped, k, limit, rme.params.Qbpp)
+	mapped := 2*iabs(errval) - riType - mapBit
+
+	// The unary part is shortened by the bits already spent on the run
+	limit := p.Limit - JTable[rme.cm.GetRunIndex()].RK - 1
+	EncodeGolomb(bw, mapped, k, limit, p.Qbpp)
 
-	// Update statistics
 	if errval < 0 {
-		ctx.B++
+		ctx.Nn++
 	}
-	ctx.A += iabs(errval) - (tempA-ctx.A)/ctx.N
-
-	if ctx.N == rme.params.Reset {
+	ctx.A += (mapped + 1 - riType) >> 1
+	if ctx.N == p.Reset {
 		ctx.A >>= 1
 		ctx.N >>= 1
+		ctx.Nn >>= 1
 	}
 	ctx.N++
-
-	// Decrement run index
-	rme.cm.DecrementRunIndex()
-}
-
-// mapRunInterruptionError maps the error for a run interruption sample.
-// Per ITU-T T.87 A.7.2.1, the mapping depends on SIGN:
-//
-// If SIGN = 1 (Ra >= Rb):
-//
-//	errval >= 0: MErrval = 2 * errval
-//	errval < 0:  MErrval = 2 * |errval| - 1
-//
-// If SIGN = -1 (Ra < Rb):
-//
-//	errval > 0:  MErrval = 2 * |errval| - 1
-//	errval <= 0: MErrval = 2 * |errval|
-func mapRunInterruptionError(errval, ra, rb int) int {
-	if ra >= rb {
-		// SIGN = 1: standard mapping
-		if errval >= 0 {
-			return 2 * errval
-		}
-		return 2*(-errval) - 1
-	}
-	// SIGN = -1: inverted mapping
-	if errval > 0 {
-		return 2*errval - 1
-	}
-	return 2 * (-errval)
 }
 
 // iabs returns the absolute value of an integer.
@@ -258,7 +206,8 @@ func iabs(x int) int {
 }
 
 // DetectRunMode checks if the current position should use run mode.
-// Run mode is entered when all local gradients are zero.
+// Run mode is entered when all local gradients are zero (lossless coding;
+// see ContextModel.InRunMode for NEAR > 0).
 func DetectRunMode(g1, g2, g3 int) bool {
 	return g1 == 0 && g2 == 0 && g3 == 0
 }
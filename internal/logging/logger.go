@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the minimum severity a logger writes
+type Level int
+
+const (
+	LevelDebug Level = iota // Everything, including per-file details (-v)
+	LevelInfo               // Progress and status messages (default)
+	LevelWarn               // Warnings and errors only (-q)
+	LevelError              // Errors only
+)
+
+// Logger receives diagnostic messages from the anonymizer and its helpers
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// Default returns a logger writing Info and above to stdout.
+func Default() Logger {
+	return New(os.Stdout, LevelInfo)
+}
+
+// OrDefault returns l, or Default() if l is nil.
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// New returns a logger writing messages at or above level to w.
+func New(w io.Writer, level Level) Logger {
+	return NewFunc(func(s string) { io.WriteString(w, s) }, level)
+}
+
+// NewFunc returns a logger passing messages at or above level to output,
+// e.g. a GUI log panel.
+func NewFunc(output func(string), level Level) Logger {
+	return &funcLogger{output: output, level: level}
+}
+
+// Discard returns a logger that drops all messages.
+func Discard() Logger {
+	return NewFunc(func(string) {}, LevelError+1)
+}
+
+// funcLogger formats messages and hands them to an output function.
+// Warnings and errors get the "Warning: " and "Error: " prefixes used
+// throughout the tool; a trailing newline is added if missing.
+type funcLogger struct {
+	mu     sync.Mutex
+	output func(string)
+	level  Level
+}
+
+// Debugf logs per-file details shown with -v
+func (l *funcLogger) Debugf(format string, args ...any) {
+	l.logf(LevelDebug, "", format, args...)
+}
+
+// Infof logs progress and status messages
+func (l *funcLogger) Infof(format string, args ...any) {
+	l.logf(LevelInfo, "", format, args...)
+}
+
+// Warnf logs a problem that does not stop processing
+func (l *funcLogger) Warnf(format string, args ...any) {
+	l.logf(LevelWarn, "Warning: ", format, args...)
+}
+
+// Errorf logs a failure
+func (l *funcLogger) Errorf(format string, args ...any) {
+	l.logf(LevelError, "Error: ", format, args...)
+}
+
+func (l *funcLogger) logf(level Level, prefix, format string, args ...any) {
+	if level < l.level {
+		return
+	}
+
+	msg := prefix + fmt.Sprintf(format, args...)
+	if !strings.HasSuffix(msg, "\n") {
+		msg += "\n"
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.output(msg)
+}
@@ -0,0 +1,21 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var out strings.Builder
+	log := New(&out, LevelWarn)
+
+	log.Debugf("debug %d", 1)
+	log.Infof("info %d", 2)
+	log.Warnf("disk %s", "full")
+	log.Errorf("failed\n")
+
+	want := "Warning: disk full\nError: failed\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
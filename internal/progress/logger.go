@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"dicom-anonymizer/internal/logging"
 )
 
 // ErrorEntry represents an error log entry
 type ErrorEntry struct {
 	File      string
+	Category  string // Kind of failure, e.g. "parse" or "dcmtk-missing"
 	Error     string
 	Timestamp time.Time
 }
@@ -21,13 +26,21 @@ type ErrorLogger struct {
 	logFile string
 	errors  []ErrorEntry
 	file    *os.File
+	log     logging.Logger
 }
 
 // NewErrorLogger creates a new error logger.
 func NewErrorLogger(logFile string) (*ErrorLogger, error) {
+	return NewErrorLoggerWithLogger(logFile, nil)
+}
+
+// NewErrorLoggerWithLogger is NewErrorLogger with failures to write the
+// log file reported to log (nil = logging.Default()).
+func NewErrorLoggerWithLogger(logFile string, log logging.Logger) (*ErrorLogger, error) {
 	logger := &ErrorLogger{
 		logFile: logFile,
 		errors:  []ErrorEntry{},
+		log:     logging.OrDefault(log),
 	}
 
 	if logFile != "" {
@@ -48,24 +61,29 @@ func NewErrorLogger(logFile string) (*ErrorLogger, error) {
 	return logger, nil
 }
 
-// Log logs an error for a file.
-func (l *ErrorLogger) Log(filePath, errorMsg string) {
+// Log logs an error for a file. Lines in the log file read
+// "timestamp | category | file | message".
+func (l *ErrorLogger) Log(filePath, category, errorMsg string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	entry := ErrorEntry{
 		File:      filePath,
+		Category:  category,
 		Error:     errorMsg,
 		Timestamp: time.Now(),
 	}
 	l.errors = append(l.errors, entry)
 
 	if l.file != nil {
-		line := fmt.Sprintf("%s | %s | %s\n",
+		line := fmt.Sprintf("%s | %s | %s | %s\n",
 			entry.Timestamp.Format(time.RFC3339),
+			category,
 			filepath.Base(filePath),
 			errorMsg)
-		l.file.WriteString(line)
+		if _, err := l.file.WriteString(line); err != nil {
+			l.log.Warnf("Could not write error log: %v", err)
+		}
 	}
 }
 
@@ -77,7 +95,53 @@ func (l *ErrorLogger) Summary() string {
 	if len(l.errors) == 0 {
 		return "No errors"
 	}
-	return fmt.Sprintf("%d errors logged to %s", len(l.errors), l.logFile)
+	return fmt.Sprintf("%d errors logged to %s (%s)", len(l.errors), l.logFile, CategorySummary(l.errors))
+}
+
+// CategoryCount is the number of errors of one category
+type CategoryCount struct {
+	Category string
+	Count    int
+}
+
+// CountCategories counts entries per category, most frequent first
+func CountCategories(entries []ErrorEntry) []CategoryCount {
+	counts := map[string]int{}
+	for _, entry := range entries {
+		counts[entry.Category]++
+	}
+	result := make([]CategoryCount, 0, len(counts))
+	for category, count := range counts {
+		result = append(result, CategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Category < result[j].Category
+	})
+	return result
+}
+
+// CategorySummary formats CountCategories as e.g. "3 dcmtk-missing, 1 parse"
+func CategorySummary(entries []ErrorEntry) string {
+	var parts []string
+	for _, c := range CountCategories(entries) {
+		parts = append(parts, fmt.Sprintf("%d %s", c.Count, c.Category))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Entries returns a copy of the errors logged so far, in logging order.
+func (l *ErrorLogger) Entries() []ErrorEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ErrorEntry(nil), l.errors...)
+}
+
+// LogFile returns the path of the error log file.
+func (l *ErrorLogger) LogFile() string {
+	return l.logFile
 }
 
 // ErrorCount returns the number of logged errors.
@@ -87,6 +151,17 @@ func (l *ErrorLogger) ErrorCount() int {
 	return len(l.errors)
 }
 
+// Flush commits logged errors to disk.
+func (l *ErrorLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		return l.file.Sync()
+	}
+	return nil
+}
+
 // Close closes the log file.
 func (l *ErrorLogger) Close() error {
 	l.mu.Lock()
@@ -0,0 +1,35 @@
+package progress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorLoggerRecordsCategories(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "errors.log")
+	l, err := NewErrorLogger(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Log("/in/a.dcm", "parse", "not a DICOM file")
+	l.Log("/in/b.dcm", "dcmtk-missing", "dcmtk not installed")
+	l.Log("/in/c.dcm", "dcmtk-missing", "dcmtk not installed")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), " | parse | a.dcm | not a DICOM file\n") {
+		t.Errorf("log file = %q, want category | file | message", data)
+	}
+
+	want := "3 errors logged to " + logFile + " (2 dcmtk-missing, 1 parse)"
+	if got := l.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package progress
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+)
+
+// WorkSummary counts what a resumed run will do with a set of files
+type WorkSummary struct {
+	New     int // Never processed
+	Retry   int // Failed in an earlier run
+	Changed int // Processed, but modified since
+	Done    int // Processed and unchanged, skipped on resume
+}
+
+// Remaining returns the files of allFiles that are not yet successfully
+// processed (see IsProcessed), in their original order.
+func (t *Tracker) Remaining(allFiles []string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var remaining []string
+	for _, filePath := range allFiles {
+		if !t.isProcessed(filePath) {
+			remaining = append(remaining, filePath)
+		}
+	}
+	return remaining
+}
+
+// Summarize counts allFiles by what a resumed run will do with them.
+// Unchanged files are fingerprinted with the mode of their entry, so this
+// reads every processed file when content hashes are used.
+func (t *Tracker) Summarize(allFiles []string) WorkSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var summary WorkSummary
+	for _, filePath := range allFiles {
+		entry, ok := t.processed[filePath]
+		switch {
+		case !ok:
+			summary.New++
+		case entry.Status != StatusSuccess:
+			summary.Retry++
+		case t.isProcessed(filePath):
+			summary.Done++
+		default:
+			summary.Changed++
+		}
+	}
+	return summary
+}
+
+// ExportStatus writes one CSV row per recorded file, sorted by path, with
+// its status, output path, error and timestamp, preceded by a header row.
+func (t *Tracker) ExportStatus(w io.Writer) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	paths := make([]string, 0, len(t.processed))
+	for path := range t.processed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "status", "output", "error", "timestamp"}); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		entry := t.processed[path]
+		record := []string{path, string(entry.Status), entry.Output, entry.Error, entry.Timestamp}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
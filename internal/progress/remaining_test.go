@@ -0,0 +1,58 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTrackerRemaining(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[string]string)
+	for _, name := range []string{"done.dcm", "changed.dcm", "failed.dcm", "new.dcm"} {
+		files[name] = filepath.Join(dir, name)
+		if err := os.WriteFile(files[name], []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracker := NewTracker("", HashQuickStat)
+	tracker.MarkSuccess(files["done.dcm"], "out/done.dcm")
+	tracker.MarkSuccess(files["changed.dcm"], "out/changed.dcm")
+	tracker.MarkError(files["failed.dcm"], "could not read DICOM")
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(files["changed.dcm"], later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	all := []string{files["new.dcm"], files["done.dcm"], files["failed.dcm"], files["changed.dcm"]}
+	want := []string{files["new.dcm"], files["failed.dcm"], files["changed.dcm"]}
+	if got := tracker.Remaining(all); !reflect.DeepEqual(got, want) {
+		t.Errorf("Remaining() = %v, want %v", got, want)
+	}
+
+	if got, want := tracker.Summarize(all), (WorkSummary{New: 1, Retry: 1, Changed: 1, Done: 1}); got != want {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := tracker.ExportStatus(&buf); err != nil {
+		t.Fatalf("ExportStatus failed: %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 4 || records[0][0] != "path" {
+		t.Fatalf("ExportStatus wrote %v, want a header and 3 rows", records)
+	}
+	// Rows are sorted by path: changed, done, failed
+	if records[1][0] != files["changed.dcm"] || records[3][1] != string(StatusError) || records[3][3] != "could not read DICOM" {
+		t.Errorf("unexpected rows %v", records[1:])
+	}
+}
@@ -2,11 +2,19 @@ package progress
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"dicom-anonymizer/internal/fsutil"
+	"dicom-anonymizer/internal/logging"
 )
 
 // FileStatus represents the processing status of a file
@@ -17,10 +25,19 @@ const (
 	StatusError   FileStatus = "error"
 )
 
+// HashMode selects how a file's identity is fingerprinted for resume
+type HashMode string
+
+const (
+	HashQuickStat     HashMode = "quick"  // Size + modification time (fast)
+	HashSHA256Content HashMode = "sha256" // SHA-256 of the file contents
+)
+
 // FileEntry represents a processed file entry
 type FileEntry struct {
 	Status    FileStatus `json:"status"`
 	Hash      string     `json:"hash"`
+	HashMode  HashMode   `json:"hash_mode,omitempty"` // Empty = HashQuickStat
 	Output    string     `json:"output,omitempty"`
 	Error     string     `json:"error,omitempty"`
 	Timestamp string     `json:"timestamp"`
@@ -37,17 +54,71 @@ type TrackerData struct {
 	} `json:"summary"`
 }
 
+// Checkpoint controls how often the tracker writes progress to disk. The
+// zero value uses DefaultCheckpoint; Files: 1 saves after every file.
+type Checkpoint struct {
+	Files    int           // Save once this many files are pending (0 = only on the timer)
+	Interval time.Duration // Save this often while files are pending (0 = no timer)
+}
+
+// DefaultCheckpoint is used for the zero Checkpoint. Each save rewrites
+// the progress file and, through beforeSave, the mapping files, so saving
+// after every file makes a run quadratic in its number of files.
+var DefaultCheckpoint = Checkpoint{Files: 100, Interval: 30 * time.Second}
+
+// ParseCheckpoint parses a checkpoint interval: a file count such as "100"
+// or a duration such as "30s". The empty string returns the zero
+// Checkpoint, i.e. DefaultCheckpoint.
+func ParseCheckpoint(s string) (Checkpoint, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Checkpoint{}, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return Checkpoint{}, fmt.Errorf("checkpoint interval must be at least 1 file, got %d", n)
+		}
+		return Checkpoint{Files: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return Checkpoint{}, fmt.Errorf("invalid checkpoint interval %q (use a file count such as 100 or a duration such as 30s)", s)
+	}
+	return Checkpoint{Interval: d}, nil
+}
+
 // Tracker tracks processing progress for resumable runs.
 type Tracker struct {
 	mu           sync.Mutex
 	progressFile string
+	hashMode     HashMode
 	processed    map[string]*FileEntry
+	log          logging.Logger
+
+	checkpoint Checkpoint
+	pending    int    // Files marked since the last save
+	beforeSave func() // Runs before each save (nil = none)
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// NewTracker creates a new progress tracker. New entries are fingerprinted
+// with mode (empty = HashQuickStat).
+func NewTracker(progressFile string, mode HashMode) *Tracker {
+	return NewTrackerWithLogger(progressFile, mode, nil)
 }
 
-// NewTracker creates a new progress tracker.
-func NewTracker(progressFile string) *Tracker {
+// NewTrackerWithLogger is NewTracker with status messages sent to log
+// (nil = logging.Default()).
+func NewTrackerWithLogger(progressFile string, mode HashMode, log logging.Logger) *Tracker {
+	if mode == "" {
+		mode = HashQuickStat
+	}
+
 	t := &Tracker{
+		log:          logging.OrDefault(log),
 		progressFile: progressFile,
+		hashMode:     mode,
 		processed:    make(map[string]*FileEntry),
 	}
 
@@ -59,16 +130,21 @@ func NewTracker(progressFile string) *Tracker {
 }
 
 func (t *Tracker) load() {
-	data, err := os.ReadFile(t.progressFile)
-	if err != nil {
+	var trackerData TrackerData
+	recovered, err := fsutil.ReadWithBackup(t.progressFile, func(data []byte) error {
+		trackerData = TrackerData{}
+		return json.Unmarshal(data, &trackerData)
+	})
+	if os.IsNotExist(err) {
 		return // File doesn't exist, start fresh
 	}
-
-	var trackerData TrackerData
-	if err := json.Unmarshal(data, &trackerData); err != nil {
-		fmt.Printf("Warning: Could not load progress file: %v\n", err)
+	if err != nil {
+		t.log.Warnf("Could not load progress file: %v", err)
 		return
 	}
+	if recovered {
+		t.log.Warnf("Progress file %s is damaged; loaded the previous version from %s", t.progressFile, fsutil.BackupPath(t.progressFile))
+	}
 
 	t.processed = trackerData.Files
 	if t.processed == nil {
@@ -77,7 +153,7 @@ func (t *Tracker) load() {
 
 	successCount := t.countStatus(StatusSuccess)
 	errorCount := t.countStatus(StatusError)
-	fmt.Printf("Loaded progress: %d succeeded, %d failed\n", successCount, errorCount)
+	t.log.Infof("Loaded progress: %d succeeded, %d failed", successCount, errorCount)
 }
 
 func (t *Tracker) save() {
@@ -95,13 +171,83 @@ func (t *Tracker) save() {
 
 	data, err := json.MarshalIndent(trackerData, "", "  ")
 	if err != nil {
-		fmt.Printf("Warning: Could not marshal progress data: %v\n", err)
+		t.log.Warnf("Could not marshal progress data: %v", err)
+		return
+	}
+
+	if err := fsutil.WriteFileAtomic(t.progressFile, data, 0644); err != nil {
+		t.log.Warnf("Could not save progress: %v", err)
+	}
+}
+
+// SetCheckpoint changes how often progress is saved. beforeSave (may be
+// nil) runs before each save, e.g. to flush the mapping file first so the
+// progress file never records files whose patients are not saved yet. Call
+// Close when processing ends to stop the timer and save pending files.
+func (t *Tracker) SetCheckpoint(c Checkpoint, beforeSave func()) {
+	t.stopTimer()
+	if c == (Checkpoint{}) {
+		c = DefaultCheckpoint
+	}
+
+	t.mu.Lock()
+	t.checkpoint = c
+	t.beforeSave = beforeSave
+	t.mu.Unlock()
+
+	if c.Interval > 0 {
+		t.stop = make(chan struct{})
+		t.stopped = make(chan struct{})
+		go t.checkpointLoop(c.Interval, t.stop, t.stopped)
+	}
+}
+
+// checkpointLoop saves pending files every interval until stop is closed
+func (t *Tracker) checkpointLoop(interval time.Duration, stop <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.mu.Lock()
+			if t.pending > 0 {
+				t.flush()
+			}
+			t.mu.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stopTimer stops the checkpoint goroutine and waits for it to exit
+func (t *Tracker) stopTimer() {
+	if t.stop == nil {
 		return
 	}
+	close(t.stop)
+	<-t.stopped
+	t.stop, t.stopped = nil, nil
+}
+
+// markChanged records a marked file and saves now or at the next checkpoint
+func (t *Tracker) markChanged() {
+	t.pending++
+	c := t.checkpoint
+	if (c.Files == 0 && c.Interval == 0) || (c.Files > 0 && t.pending >= c.Files) {
+		t.flush()
+	}
+}
 
-	if err := os.WriteFile(t.progressFile, data, 0644); err != nil {
-		fmt.Printf("Warning: Could not save progress: %v\n", err)
+// flush runs the beforeSave hook and saves. Callers hold t.mu.
+func (t *Tracker) flush() {
+	if t.beforeSave != nil {
+		t.beforeSave()
 	}
+	t.save()
+	t.pending = 0
 }
 
 func (t *Tracker) countStatus(status FileStatus) int {
@@ -114,8 +260,31 @@ func (t *Tracker) countStatus(status FileStatus) int {
 	return count
 }
 
-// fileHash creates a quick hash based on file size and modification time
-func fileHash(filePath string) string {
+// fileHash fingerprints a file with the given mode
+func fileHash(filePath string, mode HashMode) string {
+	if mode == HashSHA256Content {
+		return contentHash(filePath)
+	}
+	return quickHash(filePath)
+}
+
+// contentHash hashes the full file contents
+func contentHash(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// quickHash creates a quick hash based on file size and modification time
+func quickHash(filePath string) string {
 	info, err := os.Stat(filePath)
 	if err != nil {
 		return ""
@@ -129,7 +298,11 @@ func fileHash(filePath string) string {
 func (t *Tracker) IsProcessed(filePath string) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.isProcessed(filePath)
+}
 
+// isProcessed is IsProcessed for callers holding t.mu
+func (t *Tracker) isProcessed(filePath string) bool {
 	entry, ok := t.processed[filePath]
 	if !ok {
 		return false
@@ -139,8 +312,13 @@ func (t *Tracker) IsProcessed(filePath string) bool {
 		return false
 	}
 
-	currentHash := fileHash(filePath)
-	return entry.Hash == currentHash
+	// Compare using the mode the entry was recorded with
+	mode := entry.HashMode
+	if mode == "" {
+		mode = HashQuickStat
+	}
+	currentHash := fileHash(filePath, mode)
+	return currentHash != "" && entry.Hash == currentHash
 }
 
 // MarkSuccess marks a file as successfully processed.
@@ -150,11 +328,12 @@ func (t *Tracker) MarkSuccess(filePath, outputPath string) {
 
 	t.processed[filePath] = &FileEntry{
 		Status:    StatusSuccess,
-		Hash:      fileHash(filePath),
+		Hash:      fileHash(filePath, t.hashMode),
+		HashMode:  t.hashMode,
 		Output:    outputPath,
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
-	t.save()
+	t.markChanged()
 }
 
 // MarkError marks a file as failed.
@@ -164,11 +343,30 @@ func (t *Tracker) MarkError(filePath, errorMsg string) {
 
 	t.processed[filePath] = &FileEntry{
 		Status:    StatusError,
-		Hash:      fileHash(filePath),
+		Hash:      fileHash(filePath, t.hashMode),
+		HashMode:  t.hashMode,
 		Error:     errorMsg,
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
-	t.save()
+	t.markChanged()
+}
+
+// Flush writes the current progress to disk.
+func (t *Tracker) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flush()
+}
+
+// Close stops the checkpoint timer and saves any pending files.
+func (t *Tracker) Close() {
+	t.stopTimer()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending > 0 {
+		t.flush()
+	}
 }
 
 // ClearFailed removes all failed entries for retry.
@@ -186,7 +384,7 @@ func (t *Tracker) ClearFailed() int {
 
 	if count > 0 {
 		t.save()
-		fmt.Printf("Cleared %d failed entries for retry\n", count)
+		t.log.Infof("Cleared %d failed entries for retry", count)
 	}
 
 	return count
@@ -198,3 +396,15 @@ func (t *Tracker) GetStats() (success, errors int) {
 	defer t.mu.Unlock()
 	return t.countStatus(StatusSuccess), t.countStatus(StatusError)
 }
+
+// Entry returns a copy of the recorded entry for filePath.
+func (t *Tracker) Entry(filePath string) (FileEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.processed[filePath]
+	if !ok {
+		return FileEntry{}, false
+	}
+	return *entry, true
+}
@@ -0,0 +1,142 @@
+package progress
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackerContentHash(t *testing.T) {
+	dir := t.TempDir()
+	progressFile := filepath.Join(dir, ".progress.json")
+	filePath := filepath.Join(dir, "a.dcm")
+	if err := os.WriteFile(filePath, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewTracker(progressFile, HashSHA256Content)
+	tracker.MarkSuccess(filePath, "out.dcm")
+
+	// Touching the file without changing it keeps it processed
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !NewTracker(progressFile, HashSHA256Content).IsProcessed(filePath) {
+		t.Errorf("touched but unchanged file should stay processed")
+	}
+
+	// Same-size in-place edit is detected
+	if err := os.WriteFile(filePath, []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if NewTracker(progressFile, HashQuickStat).IsProcessed(filePath) {
+		t.Errorf("modified file should be reprocessed even when the tracker uses quick mode")
+	}
+}
+
+func TestTrackerCheckpointFiles(t *testing.T) {
+	dir := t.TempDir()
+	progressFile := filepath.Join(dir, ".progress.json")
+	files := make([]string, 3)
+	for i := range files {
+		files[i] = filepath.Join(dir, fmt.Sprintf("%d.dcm", i))
+		if err := os.WriteFile(files[i], []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	flushed := 0
+	tracker := NewTracker(progressFile, HashQuickStat)
+	tracker.SetCheckpoint(Checkpoint{Files: 2}, func() { flushed++ })
+
+	tracker.MarkSuccess(files[0], "out0.dcm")
+	if NewTracker(progressFile, HashQuickStat).IsProcessed(files[0]) {
+		t.Errorf("progress saved before the checkpoint")
+	}
+	tracker.MarkSuccess(files[1], "out1.dcm")
+	if !NewTracker(progressFile, HashQuickStat).IsProcessed(files[1]) {
+		t.Errorf("progress not saved at the checkpoint")
+	}
+
+	tracker.MarkSuccess(files[2], "out2.dcm")
+	tracker.Close()
+	if !NewTracker(progressFile, HashQuickStat).IsProcessed(files[2]) {
+		t.Errorf("Close did not save pending files")
+	}
+	if flushed != 2 {
+		t.Errorf("beforeSave ran %d times, want 2", flushed)
+	}
+}
+
+func TestTrackerDefaultCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.dcm")
+	if err := os.WriteFile(filePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The zero Checkpoint does not flush the mappings after every file
+	flushed := 0
+	tracker := NewTracker(filepath.Join(dir, ".progress.json"), HashQuickStat)
+	tracker.SetCheckpoint(Checkpoint{}, func() { flushed++ })
+	for i := 0; i < 10; i++ {
+		tracker.MarkSuccess(filePath, "out.dcm")
+	}
+	if flushed != 0 {
+		t.Errorf("beforeSave ran %d times before the default checkpoint", flushed)
+	}
+	tracker.Close()
+	if flushed != 1 {
+		t.Errorf("beforeSave ran %d times, want 1 on Close", flushed)
+	}
+}
+
+func TestTrackerCheckpointInterval(t *testing.T) {
+	dir := t.TempDir()
+	progressFile := filepath.Join(dir, ".progress.json")
+	filePath := filepath.Join(dir, "a.dcm")
+	if err := os.WriteFile(filePath, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewTracker(progressFile, HashQuickStat)
+	tracker.SetCheckpoint(Checkpoint{Interval: 10 * time.Millisecond}, nil)
+	defer tracker.Close()
+
+	tracker.MarkSuccess(filePath, "out.dcm")
+	deadline := time.Now().Add(2 * time.Second)
+	for !NewTracker(progressFile, HashQuickStat).IsProcessed(filePath) {
+		if time.Now().After(deadline) {
+			t.Fatal("timer did not save progress")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Checkpoint
+	}{
+		{"", Checkpoint{}},
+		{"100", Checkpoint{Files: 100}},
+		{"30s", Checkpoint{Interval: 30 * time.Second}},
+	}
+	for _, tt := range tests {
+		got, err := ParseCheckpoint(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseCheckpoint(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"0", "-5s", "soon"} {
+		if _, err := ParseCheckpoint(in); err == nil {
+			t.Errorf("ParseCheckpoint(%q) succeeded, want error", in)
+		}
+	}
+}